@@ -24,7 +24,7 @@ type aggregator struct {
 	done chan struct{}
 
 	// list of queue names to check and aggregate.
-	queues []string
+	queues *queueNames
 
 	// Group configurations
 	gracePeriod time.Duration
@@ -69,9 +69,9 @@ func newAggregator(params aggregatorParams) *aggregator {
 	return &aggregator{
 		logger:      params.logger,
 		broker:      params.broker,
-		client:      &Client{broker: params.broker},
+		client:      &Client{broker: params.broker, logger: params.logger},
 		done:        make(chan struct{}),
-		queues:      params.queues,
+		queues:      newQueueNames(params.queues),
 		gracePeriod: params.gracePeriod,
 		maxDelay:    params.maxDelay,
 		maxSize:     params.maxSize,
@@ -130,7 +130,7 @@ func (a *aggregator) exec(t time.Time) {
 // 聚合任务
 func (a *aggregator) aggregate(t time.Time) {
 	defer func() { <-a.sema /* release token */ }()
-	for _, qname := range a.queues {
+	for _, qname := range a.queues.get() {
 		groups, err := a.broker.ListGroups(qname)
 		if err != nil {
 			a.logger.Errorf("Failed to list groups in queue: %q", qname)
@@ -157,7 +157,7 @@ func (a *aggregator) aggregate(t time.Time) {
 			}
 			tasks := make([]*Task, len(msgs))
 			for i, m := range msgs {
-				tasks[i] = NewTask(m.Type, m.Payload)
+				tasks[i] = NewTaskWithHeaders(m.Type, m.Payload, m.Headers)
 			}
 			aggregatedTask := a.ga.Aggregate(gname, tasks)
 			ctx, cancel := context.WithDeadline(context.Background(), deadline)
@@ -16,6 +16,8 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/memdb"
+	"github.com/hibiken/asynq/internal/rdb"
 )
 
 // Task represents a unit of work to be performed.
@@ -26,22 +28,41 @@ type Task struct {
 	// payload holds data needed to perform the task.
 	payload []byte
 
+	// headers holds arbitrary metadata associated with the task.
+	headers map[string]string
+
 	// opts holds options for the task.
 	opts []Option
 
 	// w is the ResultWriter for the task.
 	w *ResultWriter
+
+	// prevResult holds the result written by the previous task in the chain.
+	prevResult []byte
 }
 
 func (t *Task) Type() string    { return t.typename }
 func (t *Task) Payload() []byte { return t.payload }
 
+// Headers returns the headers of the task.
+//
+// Headers of a task created by NewTask do not include headers provided by Headers option;
+// those are merged into the task headers when the task is enqueued.
+func (t *Task) Headers() map[string]string { return t.headers }
+
 // ResultWriter returns a pointer to the ResultWriter associated with the task.
 //
 // Nil pointer is returned if called on a newly created task (i.e. task created by calling NewTask).
 // Only the tasks passed to Handler.ProcessTask have a valid ResultWriter pointer.
 func (t *Task) ResultWriter() *ResultWriter { return t.w }
 
+// PreviousResult returns the result written by the previous task in the chain the task belongs to.
+//
+// Nil is returned if the task is not part of a chain, is the first task in the chain,
+// or if the previous task did not write any result.
+// Only the tasks passed to Handler.ProcessTask have the previous result populated.
+func (t *Task) PreviousResult() []byte { return t.prevResult }
+
 // NewTask returns a new Task given a type name and payload data.
 // Options can be passed to configure task processing behavior.
 func NewTask(typename string, payload []byte, opts ...Option) *Task {
@@ -52,15 +73,41 @@ func NewTask(typename string, payload []byte, opts ...Option) *Task {
 	}
 }
 
-// newTask creates a task with the given typename, payload and ResultWriter.
-func newTask(typename string, payload []byte, w *ResultWriter) *Task {
+// NewTaskWithHeaders returns a new Task given a type name, payload data and headers.
+// Headers carry arbitrary metadata such as tenant IDs or trace context along with the task,
+// and are available to the Handler through Task.Headers.
+// Options can be passed to configure task processing behavior.
+func NewTaskWithHeaders(typename string, payload []byte, headers map[string]string, opts ...Option) *Task {
+	return &Task{
+		typename: typename,
+		payload:  payload,
+		headers:  copyHeaders(headers),
+		opts:     opts,
+	}
+}
+
+// newTask creates a task with the given typename, payload, headers and ResultWriter.
+func newTask(typename string, payload []byte, headers map[string]string, w *ResultWriter) *Task {
 	return &Task{
 		typename: typename,
 		payload:  payload,
+		headers:  headers,
 		w:        w,
 	}
 }
 
+// copyHeaders returns a copy of the given headers, nil if headers is empty.
+func copyHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(headers))
+	for k, v := range headers {
+		res[k] = v
+	}
+	return res
+}
+
 // A TaskInfo describes a task and its metadata.
 type TaskInfo struct {
 	// ID is the identifier of the task.
@@ -106,6 +153,29 @@ type TaskInfo struct {
 	// Empty string (default) indicates task does not belong to any groups, and no aggregation will be applied to the task.
 	Group string
 
+	// Dependencies holds the IDs of the tasks the task depends on.
+	//
+	// A task with dependencies stays in TaskStateWaiting until all the tasks it depends on complete.
+	Dependencies []string
+
+	// Headers holds the headers of the task, nil if the task has no headers.
+	Headers map[string]string
+
+	// FairnessKey is the fairness key of the task, empty string if not specified.
+	FairnessKey string
+
+	// Priority is the priority of the task within its queue, zero if not specified.
+	Priority int
+
+	// OrderingKey is the ordering key of the task, empty string if not specified.
+	//
+	// A task with an ordering key stays in TaskStateWaiting until the previous task with the same key is processed.
+	OrderingKey string
+
+	// ExpireAt is the time after which the task is archived without being processed
+	// if it has not started yet, zero value if not specified.
+	ExpireAt time.Time
+
 	// NextProcessAt is the time the task is scheduled to be processed,
 	// zero if not applicable.
 	NextProcessAt time.Time
@@ -128,6 +198,78 @@ type TaskInfo struct {
 	// Result holds the result data associated with the task.
 	// Use ResultWriter to write result data from the Handler.
 	Result []byte
+
+	// Attempts holds the latest attempts to process the task, oldest first.
+	// Up to 20 attempts are kept; nil if the task has not been processed yet.
+	//
+	// Attempts are recorded when a server finishes processing the task, or when the lease on the task
+	// expires because its server crashed or got cut off; the start time, duration and server of such
+	// an attempt are unknown and left zero. An attempt cut short by a graceful shutdown is not included.
+	Attempts []*TaskAttempt
+
+	// RetryPolicy is the retry policy the task was enqueued with, nil if none.
+	RetryPolicy *RetryPolicy
+}
+
+// Outcomes of an attempt to process a task, reported in TaskAttempt.Outcome.
+const (
+	// Indicates that the Handler processed the task successfully.
+	AttemptSucceeded = base.AttemptSucceeded
+
+	// Indicates that the Handler returned an error or panicked.
+	AttemptFailed = base.AttemptFailed
+
+	// Indicates that the processing was canceled, e.g. by Inspector.CancelProcessing.
+	AttemptCancelled = base.AttemptCancelled
+
+	// Indicates that the processing did not finish before the timeout or deadline of the task.
+	AttemptTimedOut = base.AttemptTimedOut
+
+	// Indicates that the server could not extend its lease on the task.
+	AttemptLeaseExpired = base.AttemptLeaseExpired
+
+	// Indicates that the Handler returned an error created by Snooze.
+	AttemptSnoozed = base.AttemptSnoozed
+)
+
+// TaskAttempt describes an attempt to process a task.
+type TaskAttempt struct {
+	// StartTime is the time the server started processing the task.
+	StartTime time.Time
+
+	// Duration is the time the attempt took.
+	Duration time.Duration
+
+	// ServerID, Host and PID identify the server which processed the task.
+	ServerID string
+	Host     string
+	PID      int
+
+	// Outcome is the outcome of the attempt, one of the Attempt* constants.
+	Outcome string
+
+	// ErrorMsg is the error message of the attempt, empty if the attempt succeeded.
+	ErrorMsg string
+
+	// Panic reports whether the error was caused by a panic in the Handler.
+	Panic bool
+}
+
+func newTaskAttempts(attempts []*base.TaskAttempt) []*TaskAttempt {
+	var res []*TaskAttempt
+	for _, a := range attempts {
+		res = append(res, &TaskAttempt{
+			StartTime: a.StartTime,
+			Duration:  a.Duration,
+			ServerID:  a.ServerID,
+			Host:      a.Host,
+			PID:       a.PID,
+			Outcome:   a.Outcome,
+			ErrorMsg:  a.ErrorMsg,
+			Panic:     a.Panic,
+		})
+	}
+	return res
 }
 
 // If t is non-zero, returns time converted from t as unix time in seconds.
@@ -149,6 +291,12 @@ func newTaskInfo(msg *base.TaskMessage, state base.TaskState, nextProcessAt time
 		Retried:       msg.Retried,
 		LastErr:       msg.ErrorMsg,
 		Group:         msg.GroupKey,
+		Dependencies:  msg.Dependencies,
+		Headers:       msg.Headers,
+		FairnessKey:   msg.FairnessKey,
+		Priority:      msg.Priority,
+		OrderingKey:   msg.OrderingKey,
+		ExpireAt:      fromUnixTimeOrZero(msg.ExpireAt),
 		Timeout:       time.Duration(msg.Timeout) * time.Second,
 		Deadline:      fromUnixTimeOrZero(msg.Deadline),
 		Retention:     time.Duration(msg.Retention) * time.Second,
@@ -156,6 +304,8 @@ func newTaskInfo(msg *base.TaskMessage, state base.TaskState, nextProcessAt time
 		LastFailedAt:  fromUnixTimeOrZero(msg.LastFailedAt),
 		CompletedAt:   fromUnixTimeOrZero(msg.CompletedAt),
 		Result:        result,
+		Attempts:      newTaskAttempts(msg.Attempts),
+		RetryPolicy:   newRetryPolicy(msg.RetryPolicy),
 	}
 
 	switch state {
@@ -173,6 +323,8 @@ func newTaskInfo(msg *base.TaskMessage, state base.TaskState, nextProcessAt time
 		info.State = TaskStateCompleted
 	case base.TaskStateAggregating:
 		info.State = TaskStateAggregating
+	case base.TaskStateWaiting:
+		info.State = TaskStateWaiting
 	default:
 		panic(fmt.Sprintf("internal error: unknown state: %d", state))
 	}
@@ -203,6 +355,9 @@ const (
 
 	// Indicates that the task is waiting in a group to be aggregated into one task.
 	TaskStateAggregating
+
+	// Indicates that the task is waiting for the tasks it depends on to complete.
+	TaskStateWaiting
 )
 
 func (s TaskState) String() string {
@@ -221,10 +376,109 @@ func (s TaskState) String() string {
 		return "completed"
 	case TaskStateAggregating:
 		return "aggregating"
+	case TaskStateWaiting:
+		return "waiting"
 	}
 	panic("asynq_learn: unknown task state")
 }
 
+// A ChainInfo describes a chain of tasks and its progress.
+type ChainInfo struct {
+	// ID is the identifier of the chain.
+	ID string
+
+	// Queue is the name of the queue in which the tasks of the chain belong.
+	Queue string
+
+	// State indicates the chain state.
+	State ChainState
+
+	// Steps holds the tasks of the chain in the order they are processed.
+	Steps []*ChainStep
+
+	// CurrentStep is the zero-based index of the step being processed.
+	// It equals len(Steps) once all steps are processed.
+	CurrentStep int
+
+	// LastErr describes why the chain cannot make progress.
+	// Empty unless State is ChainStateFailed.
+	LastErr string
+}
+
+// A ChainStep describes a task in a chain.
+type ChainStep struct {
+	// TaskID is the identifier of the task.
+	TaskID string
+
+	// Type is the type name of the task.
+	Type string
+
+	// Payload is the payload data of the task.
+	Payload []byte
+
+	// Result holds the result data written by the task.
+	// Nil if the task has not been processed yet or did not write any result.
+	Result []byte
+}
+
+func newChainInfo(info *base.ChainInfo) *ChainInfo {
+	c := ChainInfo{
+		ID:          info.ID,
+		Queue:       info.Queue,
+		CurrentStep: info.Current,
+	}
+	for i, msg := range info.Messages {
+		c.Steps = append(c.Steps, &ChainStep{
+			TaskID:  msg.ID,
+			Type:    msg.Type,
+			Payload: msg.Payload,
+			Result:  info.Results[i],
+		})
+	}
+	switch {
+	case info.Current >= len(info.Messages):
+		c.State = ChainStateCompleted
+	case info.Err != "":
+		c.State = ChainStateFailed
+		c.LastErr = info.Err
+	case info.CurrentState == base.TaskStateArchived:
+		c.State = ChainStateFailed
+		c.LastErr = info.Messages[info.Current].ErrorMsg
+	case info.CurrentState == 0:
+		c.State = ChainStateFailed
+		c.LastErr = "task for the current step was deleted"
+	default:
+		c.State = ChainStateActive
+	}
+	return &c
+}
+
+// ChainState denotes the state of a chain.
+type ChainState int
+
+const (
+	// Indicates that tasks in the chain are still being processed.
+	ChainStateActive ChainState = iota + 1
+
+	// Indicates that all tasks in the chain are processed successfully.
+	ChainStateCompleted
+
+	// Indicates that the chain cannot make progress since one of its tasks was archived or deleted.
+	ChainStateFailed
+)
+
+func (s ChainState) String() string {
+	switch s {
+	case ChainStateActive:
+		return "active"
+	case ChainStateCompleted:
+		return "completed"
+	case ChainStateFailed:
+		return "failed"
+	}
+	panic("asynq_learn: unknown chain state")
+}
+
 // RedisConnOpt is a discriminated union of types that represent Redis connection configuration option.
 //
 // RedisConnOpt represents a sum of following types:
@@ -433,6 +687,58 @@ func (opt RedisClusterClientOpt) MakeRedisClient() interface{} {
 	})
 }
 
+// InMemoryBroker is used to keep queues and tasks in process memory instead of redis.
+//
+// Pass the same InMemoryBroker to NewClientWithBroker, NewServerWithBroker and NewInspectorWithBroker
+// so that they share the queues. Data is lost when the process exits and cannot be shared across
+// processes, which makes InMemoryBroker suitable for tests and single process deployments.
+// Scheduler requires redis, so it does not support InMemoryBroker.
+type InMemoryBroker struct {
+	db *memdb.MemDB
+}
+
+// NewInMemoryBroker returns a new InMemoryBroker with no queues.
+func NewInMemoryBroker() *InMemoryBroker {
+	return &InMemoryBroker{db: memdb.NewMemDB()}
+}
+
+// BrokerOpt specifies the store which holds the queues and tasks.
+//
+// BrokerOpt represents a sum of following types:
+//
+//   - RedisClientOpt
+//   - RedisFailoverClientOpt
+//   - RedisClusterClientOpt
+//   - *InMemoryBroker
+type BrokerOpt interface {
+	// makeBroker returns the broker to use.
+	// It is unexported so that only the types in this package implement BrokerOpt.
+	makeBroker() broker
+}
+
+func (opt RedisClientOpt) makeBroker() broker         { return makeBroker(opt) }
+func (opt RedisFailoverClientOpt) makeBroker() broker { return makeBroker(opt) }
+func (opt RedisClusterClientOpt) makeBroker() broker  { return makeBroker(opt) }
+
+// makeBroker returns the in-memory store shared by all users of the broker.
+func (b *InMemoryBroker) makeBroker() broker { return b.db }
+
+// broker is the set of operations Client, Server and Inspector need from a store.
+type broker interface {
+	base.Broker
+	inspectorBroker
+}
+
+// makeBroker returns the broker for the given connection option.
+// It panics if the type of the connection option is not supported.
+func makeBroker(r RedisConnOpt) broker {
+	c, ok := r.MakeRedisClient().(redis.UniversalClient)
+	if !ok {
+		panic(fmt.Sprintf("asynq_learn: unsupported RedisConnOpt type %T", r))
+	}
+	return rdb.NewRDB(c)
+}
+
 // ParseRedisURI parses redis uri string and returns RedisConnOpt if uri is valid.
 // It returns a non-nil error if uri cannot be parsed.
 //
@@ -10,11 +10,10 @@ import (
 	"strings"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/errors"
-	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/hibiken/asynq/internal/log"
 )
 
 // A Client is responsible for scheduling tasks.
@@ -25,15 +24,18 @@ import (
 // Clients are safe for concurrent use by multiple goroutines.
 type Client struct {
 	broker base.Broker
+	logger *log.Logger
 }
 
 // NewClient returns a new Client instance given a redis connection option.
 func NewClient(r RedisConnOpt) *Client {
-	c, ok := r.MakeRedisClient().(redis.UniversalClient)
-	if !ok {
-		panic(fmt.Sprintf("asynq_learn: unsupported RedisConnOpt type %T", r))
-	}
-	return &Client{broker: rdb.NewRDB(c)}
+	return &Client{broker: makeBroker(r), logger: log.NewLogger(nil)}
+}
+
+// NewClientWithBroker returns a new Client instance given a broker option,
+// e.g. an InMemoryBroker.
+func NewClientWithBroker(b BrokerOpt) *Client {
+	return &Client{broker: b.makeBroker(), logger: log.NewLogger(nil)}
 }
 
 type OptionType int
@@ -49,6 +51,17 @@ const (
 	TaskIDOpt
 	RetentionOpt
 	GroupOpt
+	DependsOnOpt
+	HeadersOpt
+	FairnessKeyOpt
+	PriorityOpt
+	OrderingKeyOpt
+	ExpireAtOpt
+	ExpireInOpt
+	UniqueByOpt
+	DebounceOpt
+	RetryPolicyOpt
+	IgnoreMissingDependenciesOpt
 )
 
 // Option specifies the task processing behavior.
@@ -65,16 +78,32 @@ type Option interface {
 
 // Internal option representations.
 type (
-	retryOption     int
-	queueOption     string
-	taskIDOption    string
-	timeoutOption   time.Duration
-	deadlineOption  time.Time
-	uniqueOption    time.Duration
-	processAtOption time.Time
-	processInOption time.Duration
-	retentionOption time.Duration
-	groupOption     string
+	retryOption       int
+	queueOption       string
+	taskIDOption      string
+	timeoutOption     time.Duration
+	deadlineOption    time.Time
+	uniqueOption      time.Duration
+	processAtOption   time.Time
+	processInOption   time.Duration
+	retentionOption   time.Duration
+	groupOption       string
+	dependsOnOption   []string
+	missingDepsOption bool
+	headersOption     map[string]string
+	fairnessKeyOption string
+	priorityOption    int
+	orderingKeyOption string
+	expireAtOption    time.Time
+	expireInOption    time.Duration
+	uniqueByOption    struct {
+		key string
+		ttl time.Duration
+	}
+	debounceOption struct {
+		key    string
+		window time.Duration
+	}
 )
 
 // MaxRetry returns an option to specify the max number of times
@@ -153,6 +182,8 @@ func (t deadlineOption) Value() interface{} { return time.Time(t) }
 //   - Task Type
 //   - Task Payload
 //   - Queue Name
+//
+// Use UniqueBy to choose the uniqueness key explicitly.
 func Unique(ttl time.Duration) Option {
 	return uniqueOption(ttl)
 }
@@ -161,6 +192,43 @@ func (ttl uniqueOption) String() string     { return fmt.Sprintf("Unique(%v)", t
 func (ttl uniqueOption) Type() OptionType   { return UniqueOpt }
 func (ttl uniqueOption) Value() interface{} { return time.Duration(ttl) }
 
+// UniqueBy returns an option to enqueue a task only if no other task holds
+// the uniqueness lock with the given key in the same queue.
+// It behaves like Unique, except that the uniqueness is based on the key
+// given by the caller instead of the task type and payload.
+// ErrDuplicateTask error is returned when enqueueing a duplicate task.
+// TTL duration must be greater than or equal to 1 second.
+//
+// The lock can be looked up or released using Inspector.
+//
+// If there's a conflicting Unique option, the last option passed to Enqueue overrides the others.
+func UniqueBy(key string, ttl time.Duration) Option {
+	return uniqueByOption{key: key, ttl: ttl}
+}
+
+func (o uniqueByOption) String() string     { return fmt.Sprintf("UniqueBy(%q, %v)", o.key, o.ttl) }
+func (o uniqueByOption) Type() OptionType   { return UniqueByOpt }
+func (o uniqueByOption) Value() interface{} { return o.key }
+
+// Debounce returns an option to debounce the task with the given key.
+// The task is processed once the given window has passed since the last enqueue with the same key
+// in the same queue.
+//
+// While a task enqueued with the same key is still pending or scheduled, enqueueing another task
+// replaces the message of that task (e.g. its payload) and pushes its process time out to
+// now+window, instead of enqueueing a new task. The returned TaskInfo describes the surviving task.
+// This differs from Unique, which keeps the first task and returns ErrDuplicateTask.
+//
+// Debounce option cannot be combined with ProcessAt, ProcessIn, Unique, UniqueBy, Group, DependsOn,
+// FairnessKey, Priority, OrderingKey, ExpireAt or ExpireIn option.
+func Debounce(key string, window time.Duration) Option {
+	return debounceOption{key: key, window: window}
+}
+
+func (o debounceOption) String() string     { return fmt.Sprintf("Debounce(%q, %v)", o.key, o.window) }
+func (o debounceOption) Type() OptionType   { return DebounceOpt }
+func (o debounceOption) Value() interface{} { return o.key }
+
 // ProcessAt returns an option to specify when to process the given task.
 //
 // If there's a conflicting ProcessIn option, the last option passed to Enqueue overrides the others.
@@ -206,6 +274,248 @@ func (name groupOption) String() string     { return fmt.Sprintf("Group(%q)", st
 func (name groupOption) Type() OptionType   { return GroupOpt }
 func (name groupOption) Value() interface{} { return string(name) }
 
+// DependsOn returns an option to specify the IDs of the tasks the task depends on.
+// The task is kept in waiting state until all of those tasks complete successfully,
+// and is archived with the error message "dependency archived or deleted" if any of them
+// gets archived or deleted.
+// The tasks depended on must be in the same queue as the task.
+//
+// Enqueue returns an error wrapping ErrTaskNotFound if a task depended on does not exist,
+// and an error if a task depended on is already archived.
+func DependsOn(ids ...string) Option {
+	return dependsOnOption(append([]string(nil), ids...))
+}
+
+func (ids dependsOnOption) String() string {
+	quoted := make([]string, len(ids))
+	for i, id := range ids {
+		quoted[i] = fmt.Sprintf("%q", id)
+	}
+	return fmt.Sprintf("DependsOn(%s)", strings.Join(quoted, ", "))
+}
+func (ids dependsOnOption) Type() OptionType   { return DependsOnOpt }
+func (ids dependsOnOption) Value() interface{} { return append([]string(nil), ids...) }
+
+// IgnoreMissingDependencies returns an option to consider the tasks given to DependsOn which
+// do not exist as completed, instead of failing the enqueue with ErrTaskNotFound.
+// It is useful when the tasks depended on are processed without the Retention option,
+// since such a task is deleted once it completes.
+//
+// IgnoreMissingDependencies option has no effect without DependsOn option.
+func IgnoreMissingDependencies() Option {
+	return missingDepsOption(true)
+}
+
+func (b missingDepsOption) String() string     { return "IgnoreMissingDependencies()" }
+func (b missingDepsOption) Type() OptionType   { return IgnoreMissingDependenciesOpt }
+func (b missingDepsOption) Value() interface{} { return bool(b) }
+
+// Headers returns an option to specify headers of the task.
+// The given headers are merged into the headers of the task, overriding the values of the same keys.
+func Headers(headers map[string]string) Option {
+	return headersOption(copyHeaders(headers))
+}
+
+func (h headersOption) String() string     { return fmt.Sprintf("Headers(%v)", map[string]string(h)) }
+func (h headersOption) Type() OptionType   { return HeadersOpt }
+func (h headersOption) Value() interface{} { return copyHeaders(h) }
+
+// FairnessKey returns an option to specify the fairness key of the task, e.g. the ID of the tenant
+// which enqueued the task.
+// Pending tasks in a queue are dequeued in round-robin order of their fairness keys, so that a key
+// with a lot of tasks doesn't delay the tasks of other keys. Tasks without fairness key take turns
+// together as if they had the same key.
+// FairnessKey option cannot be combined with Group or DependsOn option.
+func FairnessKey(key string) Option {
+	return fairnessKeyOption(key)
+}
+
+func (key fairnessKeyOption) String() string     { return fmt.Sprintf("FairnessKey(%q)", string(key)) }
+func (key fairnessKeyOption) Type() OptionType   { return FairnessKeyOpt }
+func (key fairnessKeyOption) Value() interface{} { return string(key) }
+
+// Priority returns an option to specify the priority of the task within its queue.
+// Pending tasks with higher priority are dequeued before the ones with lower priority in the
+// same queue, and tasks with the same priority are dequeued in the order they became pending.
+// Tasks without Priority option have the default priority zero, which is the lowest.
+//
+// Priority option cannot be combined with Group or DependsOn option.
+func Priority(n int) Option {
+	return priorityOption(n)
+}
+
+func (n priorityOption) String() string     { return fmt.Sprintf("Priority(%d)", int(n)) }
+func (n priorityOption) Type() OptionType   { return PriorityOpt }
+func (n priorityOption) Value() interface{} { return int(n) }
+
+// OrderingKey returns an option to specify the ordering key of the task, e.g. the ID of the order
+// the task updates.
+// Tasks with the same ordering key in a queue are processed one at a time, in the order they were
+// enqueued, even if they are processed by different servers. A task waits until the previous task
+// with the same key is processed successfully or archived; while the previous task is retried,
+// the tasks after it keep waiting.
+//
+// OrderingKey option cannot be combined with ProcessAt, ProcessIn, Group, DependsOn, FairnessKey
+// or Priority option.
+func OrderingKey(key string) Option {
+	return orderingKeyOption(key)
+}
+
+func (key orderingKeyOption) String() string     { return fmt.Sprintf("OrderingKey(%q)", string(key)) }
+func (key orderingKeyOption) Type() OptionType   { return OrderingKeyOpt }
+func (key orderingKeyOption) Value() interface{} { return string(key) }
+
+// ExpireAt returns an option to specify the expiration time of the task.
+// If the task is still pending, scheduled or waiting to be retried when it expires,
+// the task is archived with "expired" as its error message and is never processed.
+// Unlike Deadline, expiration does not affect the task once its processing has started.
+//
+// If there's a conflicting ExpireIn option, the last option passed to Enqueue overrides the others.
+// ExpireAt option cannot be combined with Group, DependsOn or OrderingKey option.
+func ExpireAt(t time.Time) Option {
+	return expireAtOption(t)
+}
+
+func (t expireAtOption) String() string {
+	return fmt.Sprintf("ExpireAt(%v)", time.Time(t).Format(time.UnixDate))
+}
+func (t expireAtOption) Type() OptionType   { return ExpireAtOpt }
+func (t expireAtOption) Value() interface{} { return time.Time(t) }
+
+// ExpireIn returns an option to specify the expiration time of the task relative to the current time.
+//
+// See ExpireAt for details.
+// If there's a conflicting ExpireAt option, the last option passed to Enqueue overrides the others.
+func ExpireIn(d time.Duration) Option {
+	return expireInOption(d)
+}
+
+func (d expireInOption) String() string     { return fmt.Sprintf("ExpireIn(%v)", time.Duration(d)) }
+func (d expireInOption) Type() OptionType   { return ExpireInOpt }
+func (d expireInOption) Value() interface{} { return time.Duration(d) }
+
+// BackoffKind specifies how the retry delay of a task grows with the number of retries.
+type BackoffKind int
+
+const (
+	// BackoffExponential doubles the delay for each retry: BaseDelay, 2*BaseDelay, 4*BaseDelay, ...
+	BackoffExponential BackoffKind = base.BackoffExponential
+
+	// BackoffLinear increases the delay by BaseDelay for each retry: BaseDelay, 2*BaseDelay, 3*BaseDelay, ...
+	BackoffLinear BackoffKind = base.BackoffLinear
+
+	// BackoffFixed uses BaseDelay for every retry.
+	BackoffFixed BackoffKind = base.BackoffFixed
+)
+
+func (k BackoffKind) String() string {
+	switch k {
+	case BackoffExponential:
+		return "exponential"
+	case BackoffLinear:
+		return "linear"
+	case BackoffFixed:
+		return "fixed"
+	}
+	return fmt.Sprintf("BackoffKind(%d)", int(k))
+}
+
+// RetryPolicy specifies how a task is retried when processing fails.
+// A RetryPolicy is an Option, and it is stored with the task so that the servers
+// processing the task use it ahead of Config.RetryDelayFunc.
+//
+// The number of retries is still limited by the MaxRetry option.
+type RetryPolicy struct {
+	// Backoff specifies how the delay grows with the number of retries.
+	// The zero value is BackoffExponential.
+	Backoff BackoffKind
+
+	// BaseDelay is the delay before the first retry. It must be positive.
+	BaseDelay time.Duration
+
+	// MaxDelay is the upper bound of the delay before jitter is applied.
+	// Zero means no bound.
+	MaxDelay time.Duration
+
+	// Jitter is the fraction of the delay to randomize by, between 0 and 1.
+	// For example, with Jitter 0.2 a delay of 10s becomes a random delay between 8s and 12s.
+	Jitter float64
+
+	// MaxElapsed is the maximum time since the first attempt to process the task during
+	// which the task is retried. Once it has elapsed, a failed task is archived even if
+	// it has retries left. Zero means no limit.
+	MaxElapsed time.Duration
+
+	// NonRetryable lists the kinds of errors for which the task is archived without being
+	// retried. See WithErrorKind for how the kind of an error is determined.
+	NonRetryable []string
+}
+
+func (p RetryPolicy) String() string {
+	return fmt.Sprintf("RetryPolicy(%v, base=%v, max=%v, jitter=%v, maxElapsed=%v, nonRetryable=%v)",
+		p.Backoff, p.BaseDelay, p.MaxDelay, p.Jitter, p.MaxElapsed, p.NonRetryable)
+}
+func (p RetryPolicy) Type() OptionType   { return RetryPolicyOpt }
+func (p RetryPolicy) Value() interface{} { return p }
+
+func (p RetryPolicy) validate() error {
+	switch p.Backoff {
+	case BackoffExponential, BackoffLinear, BackoffFixed:
+	default:
+		return fmt.Errorf("unknown retry backoff kind: %v", p.Backoff)
+	}
+	if p.BaseDelay <= 0 {
+		return errors.New("retry base delay must be positive")
+	}
+	if p.MaxDelay < 0 {
+		return errors.New("retry max delay cannot be negative")
+	}
+	if p.MaxDelay > 0 && p.MaxDelay < p.BaseDelay {
+		return errors.New("retry max delay cannot be less than base delay")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return errors.New("retry jitter must be between 0 and 1")
+	}
+	if p.MaxElapsed < 0 {
+		return errors.New("retry max elapsed time cannot be negative")
+	}
+	for _, kind := range p.NonRetryable {
+		if isBlank(kind) {
+			return errors.New("non-retryable error kind cannot be empty")
+		}
+	}
+	return nil
+}
+
+func (p RetryPolicy) toBase() *base.RetryPolicy {
+	var kinds []string
+	if len(p.NonRetryable) > 0 {
+		kinds = append(kinds, p.NonRetryable...)
+	}
+	return &base.RetryPolicy{
+		Backoff:      int(p.Backoff),
+		BaseDelay:    p.BaseDelay,
+		MaxDelay:     p.MaxDelay,
+		Jitter:       p.Jitter,
+		MaxElapsed:   p.MaxElapsed,
+		NonRetryable: kinds,
+	}
+}
+
+func newRetryPolicy(p *base.RetryPolicy) *RetryPolicy {
+	if p == nil {
+		return nil
+	}
+	return &RetryPolicy{
+		Backoff:      BackoffKind(p.Backoff),
+		BaseDelay:    p.BaseDelay,
+		MaxDelay:     p.MaxDelay,
+		Jitter:       p.Jitter,
+		MaxElapsed:   p.MaxElapsed,
+		NonRetryable: p.NonRetryable,
+	}
+}
+
 // ErrDuplicateTask indicates that the given task could not be enqueued since it's a duplicate of another task.
 //
 // ErrDuplicateTask error only applies to tasks enqueued with a Unique option.
@@ -216,16 +526,31 @@ var ErrDuplicateTask = errors.New("task already exists")
 // ErrTaskIDConflict error only applies to tasks enqueued with a TaskID option.
 var ErrTaskIDConflict = errors.New("task ID conflicts with another task")
 
+// ErrTaskArchived indicates that the task enqueued with EnqueueAndWait was archived
+// instead of being processed successfully.
+var ErrTaskArchived = errors.New("task archived")
+
 type option struct {
-	retry     int
-	queue     string
-	taskID    string
-	timeout   time.Duration
-	deadline  time.Time
-	uniqueTTL time.Duration // 唯一锁的持有时间
-	processAt time.Time
-	retention time.Duration
-	group     string
+	retry          int
+	queue          string
+	taskID         string
+	timeout        time.Duration
+	deadline       time.Time
+	uniqueTTL      time.Duration // 唯一锁的持有时间
+	uniqueKey      string        // 调用方指定的唯一键
+	debounceKey    string
+	debounceWindow time.Duration
+	processAt      time.Time
+	retention      time.Duration
+	group          string
+	dependsOn      []string
+	ignoreMissing  bool
+	headers        map[string]string
+	fairness       string
+	priority       int
+	ordering       string
+	expireAt       time.Time
+	retryPolicy    *base.RetryPolicy
 }
 
 // composeOptions merges user provided options into the default options
@@ -267,10 +592,33 @@ func composeOptions(opts ...Option) (option, error) {
 				return option{}, errors.New("Unique TTL cannot be less than 1s")
 			}
 			res.uniqueTTL = ttl
+			res.uniqueKey = ""
+		case uniqueByOption:
+			if isBlank(opt.key) {
+				return option{}, errors.New("unique key cannot be empty")
+			}
+			if opt.ttl < 1*time.Second {
+				return option{}, errors.New("Unique TTL cannot be less than 1s")
+			}
+			res.uniqueTTL = opt.ttl
+			res.uniqueKey = opt.key
+		case debounceOption:
+			if isBlank(opt.key) {
+				return option{}, errors.New("debounce key cannot be empty")
+			}
+			if opt.window <= 0 {
+				return option{}, errors.New("debounce window must be positive")
+			}
+			res.debounceKey = opt.key
+			res.debounceWindow = opt.window
 		case processAtOption:
 			res.processAt = time.Time(opt)
 		case processInOption:
 			res.processAt = time.Now().Add(time.Duration(opt))
+		case expireAtOption:
+			res.expireAt = time.Time(opt)
+		case expireInOption:
+			res.expireAt = time.Now().Add(time.Duration(opt))
 		case retentionOption:
 			res.retention = time.Duration(opt)
 		case groupOption:
@@ -279,6 +627,52 @@ func composeOptions(opts ...Option) (option, error) {
 				return option{}, errors.New("group key cannot be empty")
 			}
 			res.group = key
+		case dependsOnOption:
+			res.dependsOn = nil
+			seen := make(map[string]bool)
+			for _, id := range opt {
+				if isBlank(id) {
+					return option{}, errors.New("dependency task ID cannot be empty")
+				}
+				if !seen[id] {
+					seen[id] = true
+					res.dependsOn = append(res.dependsOn, id)
+				}
+			}
+		case missingDepsOption:
+			res.ignoreMissing = bool(opt)
+		case fairnessKeyOption:
+			key := string(opt)
+			if isBlank(key) {
+				return option{}, errors.New("fairness key cannot be empty")
+			}
+			res.fairness = key
+		case priorityOption:
+			if opt < 0 {
+				return option{}, errors.New("priority cannot be negative")
+			}
+			res.priority = int(opt)
+		case orderingKeyOption:
+			key := string(opt)
+			if isBlank(key) {
+				return option{}, errors.New("ordering key cannot be empty")
+			}
+			res.ordering = key
+		case RetryPolicy:
+			if err := opt.validate(); err != nil {
+				return option{}, err
+			}
+			res.retryPolicy = opt.toBase()
+		case headersOption:
+			for k, v := range opt {
+				if isBlank(k) {
+					return option{}, errors.New("header key cannot be empty")
+				}
+				if res.headers == nil {
+					res.headers = make(map[string]string)
+				}
+				res.headers[k] = v
+			}
 		default:
 			// return res, errors.New("不存在的参数类型")
 			// ignore unexpected option
@@ -321,6 +715,9 @@ func (c *Client) Close() error {
 // By default, max retry is set to 25 and timeout is set to 30 minutes.
 //
 // If no ProcessAt or ProcessIn options are provided, the task will be pending immediately.
+// If DependsOn option is provided, the task will be waiting until all the tasks it depends on complete.
+// If OrderingKey option is provided, the task will be waiting until the previous task with the same key is processed.
+// If Debounce option is provided, the task may replace a pending or scheduled task with the same key.
 //
 // Enqueue uses context.Background internally; to specify the context, use EnqueueContext.
 // 排队将给定任务排队到队列。如果任务成功排队，则排队将返回 TaskInfo 和 nil 错误，否则返回非 nil 错误。参数 select 指定任务处理的行为。
@@ -341,6 +738,9 @@ func (c *Client) Enqueue(task *Task, opts ...Option) (*TaskInfo, error) {
 // By default, max retry is set to 25 and timeout is set to 30 minutes.
 //
 // If no ProcessAt or ProcessIn options are provided, the task will be pending immediately.
+// If DependsOn option is provided, the task will be waiting until all the tasks it depends on complete.
+// If OrderingKey option is provided, the task will be waiting until the previous task with the same key is processed.
+// If Debounce option is provided, the task may replace a pending or scheduled task with the same key.
 //
 // The first argument context applies to the enqueue operation. To specify task timeout and deadline, use Timeout and Deadline option instead.
 // EnqueueContext 将给定任务排队到队列。如果任务成功排队，则 EnqueueContext 返回 TaskInfo 和 nil 错误，否则返回非 nil 错误。
@@ -369,38 +769,35 @@ func (c *Client) EnqueueContext(ctx context.Context, task *Task, opts ...Option)
 	if err != nil {
 		return nil, err
 	}
-	deadline := noDeadline
-	if !opt.deadline.IsZero() {
-		deadline = opt.deadline
-	}
-	timeout := noTimeout
-	if opt.timeout != 0 {
-		timeout = opt.timeout
-	}
-	// 没有截止时间也没有设置超时时间则将超时时间设置为默认时间
-	if deadline.Equal(noDeadline) && timeout == noTimeout {
-		// If neither deadline nor timeout are set, use default timeout.
-		timeout = defaultTimeout // 30分钟超时
-	}
-	var uniqueKey string
-	if opt.uniqueTTL > 0 {
-		uniqueKey = base.UniqueKey(opt.queue, task.Type(), task.Payload())
-	}
-	msg := &base.TaskMessage{
-		ID:        opt.taskID,     // 唯一UUID
-		Type:      task.Type(),    // 类型值（键）
-		Payload:   task.Payload(), // 消息载体
-		Queue:     opt.queue,      // 队列名称
-		Retry:     opt.retry,      // 重试次数
-		Deadline:  deadline.Unix(),
-		Timeout:   int64(timeout.Seconds()), // 超时时间
-		UniqueKey: uniqueKey,                // 基于队列名称、任务类型、消息体生成的的md5唯一值
-		GroupKey:  opt.group,
-		Retention: int64(opt.retention.Seconds()), // 保留时间
-	}
 	now := time.Now()
+	if err := validateOptions(opt, enqueueSingle, now); err != nil {
+		return nil, err
+	}
+	msg := newTaskMessage(task, opt)
 	var state base.TaskState
-	if opt.processAt.After(now) { // 执行时间大于当前时间时，后续执行
+	if opt.debounceKey != "" {
+		opt.processAt = now.Add(opt.debounceWindow)
+		var id string
+		id, err = c.broker.Debounce(ctx, msg, opt.processAt)
+		if err == nil {
+			msg.ID = id
+		}
+		state = base.TaskStateScheduled
+	} else if opt.ordering != "" {
+		state, err = c.enqueueWaiting(ctx, msg, opt.uniqueTTL, opt.ignoreMissing)
+		if state == base.TaskStatePending {
+			opt.processAt = now
+		} else {
+			opt.processAt = time.Time{}
+		}
+	} else if len(opt.dependsOn) > 0 {
+		state, err = c.enqueueWaiting(ctx, msg, opt.uniqueTTL, opt.ignoreMissing)
+		if state == base.TaskStatePending {
+			opt.processAt = now
+		} else {
+			opt.processAt = time.Time{}
+		}
+	} else if opt.processAt.After(now) { // 执行时间大于当前时间时，后续执行
 		err = c.schedule(ctx, msg, opt.processAt, opt.uniqueTTL)
 		state = base.TaskStateScheduled
 	} else if opt.group != "" {
@@ -418,13 +815,438 @@ func (c *Client) EnqueueContext(ctx context.Context, task *Task, opts ...Option)
 		return nil, fmt.Errorf("%w", ErrDuplicateTask)
 	case errors.Is(err, errors.ErrTaskIdConflict): // 任务ID冲突
 		return nil, fmt.Errorf("%w", ErrTaskIDConflict)
+	case errors.IsTaskNotFound(err):
+		var e *errors.TaskNotFoundError
+		errors.As(err, &e)
+		return nil, fmt.Errorf("dependency %q: %w", e.ID, ErrTaskNotFound)
 	case err != nil:
 		return nil, err
 	}
+	if state == base.TaskStateScheduled {
+		c.recordEvent(base.TaskEventScheduled, msg)
+	} else {
+		c.recordEvent(base.TaskEventEnqueued, msg)
+	}
 	// 任务消息体 + 任务状态 + 任务执行时间
 	return newTaskInfo(msg, state, opt.processAt, nil), nil
 }
 
+// EnqueueAndWait enqueues the given task and blocks until the task is completed or archived.
+//
+// It is meant for callers which push work through a queue for isolation, yet need its result.
+// Retention option is required so that the result of the completed task can be read.
+// Group and Debounce options are not supported, so the task is never replaced by a debounced task.
+//
+// If the task is completed, EnqueueAndWait returns its TaskInfo, whose Result holds the data
+// written by the Handler using ResultWriter.
+// If the task is archived, EnqueueAndWait returns its TaskInfo along with an error wrapping
+// ErrTaskArchived which describes the final error of the task.
+// If the task is deleted (e.g. by Inspector.DeleteTask or Inspector.DeleteQueue) before it is completed
+// or archived, EnqueueAndWait returns an error wrapping ErrTaskNotFound.
+// If ctx is done before the task is completed or archived, EnqueueAndWait returns ctx.Err();
+// the task is left in the queue.
+func (c *Client) EnqueueAndWait(ctx context.Context, task *Task, opts ...Option) (*TaskInfo, error) {
+	if task == nil {
+		return nil, fmt.Errorf("task cannot be nil")
+	}
+	if strings.TrimSpace(task.Type()) == "" {
+		return nil, fmt.Errorf("task typename cannot be empty")
+	}
+	opt, err := composeOptions(append(task.opts, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateOptions(opt, enqueueSingle, time.Now()); err != nil {
+		return nil, err
+	}
+	switch {
+	case opt.retention <= 0:
+		return nil, fmt.Errorf("EnqueueAndWait requires Retention option")
+	case opt.group != "":
+		return nil, fmt.Errorf("Group option is not supported by EnqueueAndWait")
+	case opt.debounceKey != "":
+		return nil, fmt.Errorf("Debounce option is not supported by EnqueueAndWait")
+	}
+	// Subscribe before enqueueing the task so that the notification cannot be missed.
+	// Pin the task ID so that the subscribed task is the one enqueued.
+	sub, err := c.broker.SubscribeCompletion(opt.queue, opt.taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Close()
+	opts = append(opts[:len(opts):len(opts)], TaskID(opt.taskID))
+	if _, err := c.EnqueueContext(ctx, task, opts...); err != nil {
+		return nil, err
+	}
+	select {
+	case _, ok := <-sub.Channel():
+		if !ok {
+			return nil, fmt.Errorf("asynq_learn: completion subscription closed: %v", sub.Err())
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	res, err := c.broker.GetTaskInfo(opt.queue, opt.taskID)
+	switch {
+	case errors.IsTaskNotFound(err), errors.IsQueueNotFound(err):
+		// The task was deleted before it was completed or archived.
+		return nil, fmt.Errorf("asynq_learn: task id=%s deleted: %w", opt.taskID, ErrTaskNotFound)
+	case err != nil:
+		return nil, err
+	}
+	info := newTaskInfo(res.Message, res.State, res.NextProcessAt, res.Result)
+	if info.State == TaskStateArchived {
+		return info, fmt.Errorf("%w: %s", ErrTaskArchived, info.LastErr)
+	}
+	return info, nil
+}
+
+// EnqueueBatch enqueues the given tasks using a single round trip to redis.
+//
+// EnqueueBatch returns a TaskInfo and an error for each task, in the same order as the given tasks.
+// If a task is enqueued successfully, its TaskInfo is non-nil and its error is nil, otherwise
+// its TaskInfo is nil and its error describes why the task was not enqueued.
+// A failure of one task does not prevent other tasks in the batch from being enqueued.
+//
+// The given options are applied to each task, after the options provided to NewTask.
+// Tasks are either pending immediately or scheduled if ProcessAt or ProcessIn option is provided.
+// Group, DependsOn, OrderingKey and Debounce options are not supported in a batch.
+//
+// If a task is unique and its uniqueness lock cannot be acquired, its error is ErrDuplicateTask.
+// If a task ID is already used by another task in the queue, its error is ErrTaskIDConflict.
+//
+// The first argument context applies to the enqueue operation.
+func (c *Client) EnqueueBatch(ctx context.Context, tasks []*Task, opts ...Option) ([]*TaskInfo, []error) {
+	infos := make([]*TaskInfo, len(tasks))
+	errs := make([]error, len(tasks))
+	var (
+		batch      []*base.BatchMessage
+		batchInfos []*TaskInfo // TaskInfo to return if the message in batch is enqueued
+		idx        []int       // indices of the tasks in batch
+	)
+	for i, task := range tasks {
+		if task == nil {
+			errs[i] = fmt.Errorf("task cannot be nil")
+			continue
+		}
+		if strings.TrimSpace(task.Type()) == "" {
+			errs[i] = fmt.Errorf("task typename cannot be empty")
+			continue
+		}
+		opt, err := composeOptions(append(task.opts, opts...)...)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		now := time.Now()
+		if err := validateOptions(opt, enqueueBatch, now); err != nil {
+			errs[i] = err
+			continue
+		}
+		msg := newTaskMessage(task, opt)
+		m := &base.BatchMessage{Msg: msg}
+		state := base.TaskStatePending
+		if opt.processAt.After(now) {
+			m.ProcessAt = opt.processAt
+			state = base.TaskStateScheduled
+			if opt.uniqueTTL > 0 {
+				m.UniqueTTL = opt.processAt.Add(opt.uniqueTTL).Sub(now)
+			}
+		} else {
+			opt.processAt = now
+			m.UniqueTTL = opt.uniqueTTL
+		}
+		batch = append(batch, m)
+		batchInfos = append(batchInfos, newTaskInfo(msg, state, opt.processAt, nil))
+		idx = append(idx, i)
+	}
+	if len(batch) == 0 {
+		return infos, errs
+	}
+	batchErrs, err := c.broker.EnqueueBatch(ctx, batch)
+	for j, i := range idx {
+		switch {
+		case err != nil:
+			errs[i] = err
+		case errors.Is(batchErrs[j], errors.ErrDuplicateTask):
+			errs[i] = fmt.Errorf("%w", ErrDuplicateTask)
+		case errors.Is(batchErrs[j], errors.ErrTaskIdConflict):
+			errs[i] = fmt.Errorf("%w", ErrTaskIDConflict)
+		case batchErrs[j] != nil:
+			errs[i] = batchErrs[j]
+		default:
+			infos[i] = batchInfos[j]
+			if batch[j].ProcessAt.IsZero() {
+				c.recordEvent(base.TaskEventEnqueued, batch[j].Msg)
+			} else {
+				c.recordEvent(base.TaskEventScheduled, batch[j].Msg)
+			}
+		}
+	}
+	return infos, errs
+}
+
+// Chain enqueues the given tasks as a chain, in which each task is processed
+// only after the previous task is processed successfully.
+//
+// Chain returns ChainInfo and nil error if the chain is created successfully, otherwise returns a non-nil error.
+//
+// Only the first task is pending immediately. Once a task in the chain is processed
+// successfully, the next task is enqueued in the same operation that marks the task as done,
+// and its Handler can read the result written by the previous task using Task.PreviousResult.
+// If a task is archived, the chain stops and later tasks are never enqueued.
+//
+// Options provided to NewTask apply to each task. All tasks must be in the same queue, and
+// ProcessAt, ProcessIn, Unique, Group, DependsOn, FairnessKey, Priority, OrderingKey, ExpireAt,
+// ExpireIn and Debounce options are not supported for tasks in a chain.
+//
+// Chain uses context.Background internally; to specify the context, use ChainContext.
+func (c *Client) Chain(tasks ...*Task) (*ChainInfo, error) {
+	return c.ChainContext(context.Background(), tasks...)
+}
+
+// ChainContext enqueues the given tasks as a chain, in which each task is processed
+// only after the previous task is processed successfully.
+//
+// See Chain for details.
+//
+// The first argument context applies to the enqueue operation.
+func (c *Client) ChainContext(ctx context.Context, tasks ...*Task) (*ChainInfo, error) {
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("chain must have at least one task")
+	}
+	chainID := uuid.NewString()
+	msgs := make([]*base.TaskMessage, len(tasks))
+	seen := make(map[string]bool)
+	for i, task := range tasks {
+		if task == nil {
+			return nil, fmt.Errorf("task cannot be nil")
+		}
+		if strings.TrimSpace(task.Type()) == "" {
+			return nil, fmt.Errorf("task typename cannot be empty")
+		}
+		opt, err := composeOptions(task.opts...)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateOptions(opt, enqueueChain, time.Now()); err != nil {
+			return nil, err
+		}
+		switch {
+		case i > 0 && opt.queue != msgs[0].Queue:
+			return nil, fmt.Errorf("all tasks in a chain must be in the same queue")
+		case seen[opt.taskID]:
+			return nil, fmt.Errorf("%w: %q is used by more than one task in the chain", ErrTaskIDConflict, opt.taskID)
+		}
+		seen[opt.taskID] = true
+		msg := newTaskMessage(task, opt)
+		msg.ChainID = chainID
+		msg.ChainStep = i
+		msgs[i] = msg
+	}
+	err := c.broker.EnqueueChain(ctx, msgs)
+	switch {
+	case errors.Is(err, errors.ErrTaskIdConflict):
+		return nil, fmt.Errorf("%w", ErrTaskIDConflict)
+	case err != nil:
+		return nil, err
+	}
+	c.recordEvent(base.TaskEventEnqueued, msgs[0])
+	return newChainInfo(&base.ChainInfo{
+		ID:           chainID,
+		Queue:        msgs[0].Queue,
+		Messages:     msgs,
+		Results:      make([][]byte, len(msgs)),
+		CurrentState: base.TaskStatePending,
+	}), nil
+}
+
+// enqueueKind identifies the client operation enqueuing a task,
+// which determines the options the task supports.
+type enqueueKind int
+
+const (
+	enqueueSingle enqueueKind = iota // Enqueue
+	enqueueBatch                     // EnqueueBatch
+	enqueueChain                     // Chain
+)
+
+// validateOptions returns an error if the composed options are not supported by the given
+// kind of operation, or cannot be combined with each other.
+func validateOptions(opt option, kind enqueueKind, now time.Time) error {
+	switch kind {
+	case enqueueBatch:
+		switch {
+		case opt.group != "":
+			return fmt.Errorf("Group option is not supported in a batch")
+		case len(opt.dependsOn) > 0:
+			return fmt.Errorf("DependsOn option is not supported in a batch")
+		case opt.ordering != "":
+			return fmt.Errorf("OrderingKey option is not supported in a batch")
+		case opt.debounceKey != "":
+			return fmt.Errorf("Debounce option is not supported in a batch")
+		}
+	case enqueueChain:
+		switch {
+		case opt.processAt.After(now):
+			return fmt.Errorf("ProcessAt and ProcessIn options are not supported for tasks in a chain")
+		case opt.uniqueTTL > 0:
+			return fmt.Errorf("Unique option is not supported for tasks in a chain")
+		case opt.group != "":
+			return fmt.Errorf("Group option is not supported for tasks in a chain")
+		case len(opt.dependsOn) > 0:
+			return fmt.Errorf("DependsOn option is not supported for tasks in a chain")
+		case opt.fairness != "":
+			return fmt.Errorf("FairnessKey option is not supported for tasks in a chain")
+		case opt.priority > 0:
+			return fmt.Errorf("Priority option is not supported for tasks in a chain")
+		case opt.ordering != "":
+			return fmt.Errorf("OrderingKey option is not supported for tasks in a chain")
+		case !opt.expireAt.IsZero():
+			return fmt.Errorf("ExpireAt and ExpireIn options are not supported for tasks in a chain")
+		case opt.debounceKey != "":
+			return fmt.Errorf("Debounce option is not supported for tasks in a chain")
+		}
+	}
+	if err := validateExpiration(opt); err != nil {
+		return err
+	}
+	switch {
+	case opt.debounceKey != "":
+		// The process time of a debounced task is pushed out on every enqueue with the key,
+		// so it cannot be set explicitly, and the task must be a plain scheduled task.
+		switch {
+		case opt.processAt.After(now):
+			return fmt.Errorf("Debounce option cannot be combined with ProcessAt or ProcessIn option")
+		case opt.uniqueTTL > 0:
+			return fmt.Errorf("Debounce option cannot be combined with Unique or UniqueBy option")
+		case opt.group != "":
+			return fmt.Errorf("Debounce option cannot be combined with Group option")
+		case len(opt.dependsOn) > 0:
+			return fmt.Errorf("Debounce option cannot be combined with DependsOn option")
+		case opt.fairness != "":
+			return fmt.Errorf("Debounce option cannot be combined with FairnessKey option")
+		case opt.priority > 0:
+			return fmt.Errorf("Debounce option cannot be combined with Priority option")
+		case opt.ordering != "":
+			return fmt.Errorf("Debounce option cannot be combined with OrderingKey option")
+		case !opt.expireAt.IsZero():
+			return fmt.Errorf("Debounce option cannot be combined with ExpireAt or ExpireIn option")
+		}
+	case opt.ordering != "":
+		// Tasks with an ordering key become pending once the previous task with the key
+		// is processed, so they cannot be scheduled, aggregated or reordered.
+		switch {
+		case opt.processAt.After(now):
+			return fmt.Errorf("OrderingKey option cannot be combined with ProcessAt or ProcessIn option")
+		case opt.group != "":
+			return fmt.Errorf("OrderingKey option cannot be combined with Group option")
+		case len(opt.dependsOn) > 0:
+			return fmt.Errorf("OrderingKey option cannot be combined with DependsOn option")
+		case opt.fairness != "":
+			return fmt.Errorf("OrderingKey option cannot be combined with FairnessKey option")
+		case opt.priority > 0:
+			return fmt.Errorf("OrderingKey option cannot be combined with Priority option")
+		}
+	case len(opt.dependsOn) > 0:
+		// Tasks with dependencies become pending once all dependencies complete,
+		// so they cannot be scheduled or aggregated.
+		switch {
+		case opt.processAt.After(now):
+			return fmt.Errorf("DependsOn option cannot be combined with ProcessAt or ProcessIn option")
+		case opt.group != "":
+			return fmt.Errorf("DependsOn option cannot be combined with Group option")
+		case opt.fairness != "":
+			return fmt.Errorf("DependsOn option cannot be combined with FairnessKey option")
+		case opt.priority > 0:
+			return fmt.Errorf("DependsOn option cannot be combined with Priority option")
+		}
+	case opt.group != "" && !opt.processAt.After(now):
+		switch {
+		case opt.fairness != "":
+			return fmt.Errorf("Group option cannot be combined with FairnessKey option")
+		case opt.priority > 0:
+			return fmt.Errorf("Group option cannot be combined with Priority option")
+		}
+	}
+	return nil
+}
+
+// validateExpiration returns an error if the expiration time of the composed options
+// cannot be applied to the task.
+func validateExpiration(opt option) error {
+	if opt.expireAt.IsZero() {
+		return nil
+	}
+	switch {
+	case !opt.expireAt.After(opt.processAt):
+		return fmt.Errorf("expiration time must be after the time the task is processed")
+	case opt.group != "":
+		return fmt.Errorf("ExpireAt option cannot be combined with Group option")
+	case len(opt.dependsOn) > 0:
+		return fmt.Errorf("ExpireAt option cannot be combined with DependsOn option")
+	case opt.ordering != "":
+		return fmt.Errorf("ExpireAt option cannot be combined with OrderingKey option")
+	}
+	return nil
+}
+
+// newTaskMessage returns the task message for the given task using the composed options.
+func newTaskMessage(task *Task, opt option) *base.TaskMessage {
+	deadline := noDeadline
+	if !opt.deadline.IsZero() {
+		deadline = opt.deadline
+	}
+	timeout := noTimeout
+	if opt.timeout != 0 {
+		timeout = opt.timeout
+	}
+	// 没有截止时间也没有设置超时时间则将超时时间设置为默认时间
+	if deadline.Equal(noDeadline) && timeout == noTimeout {
+		// If neither deadline nor timeout are set, use default timeout.
+		timeout = defaultTimeout // 30分钟超时
+	}
+	var uniqueKey string
+	switch {
+	case opt.debounceKey != "":
+		uniqueKey = base.DebounceKey(opt.queue, opt.debounceKey)
+	case opt.uniqueKey != "":
+		uniqueKey = base.UniqueByKey(opt.queue, opt.uniqueKey)
+	case opt.uniqueTTL > 0:
+		uniqueKey = base.UniqueKey(opt.queue, task.Type(), task.Payload())
+	}
+	var expireAt int64
+	if !opt.expireAt.IsZero() {
+		expireAt = opt.expireAt.Unix()
+	}
+	headers := copyHeaders(task.headers)
+	for k, v := range opt.headers {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[k] = v
+	}
+	return &base.TaskMessage{
+		ID:           opt.taskID,     // 唯一UUID
+		Type:         task.Type(),    // 类型值（键）
+		Payload:      task.Payload(), // 消息载体
+		Queue:        opt.queue,      // 队列名称
+		Retry:        opt.retry,      // 重试次数
+		Deadline:     deadline.Unix(),
+		Timeout:      int64(timeout.Seconds()), // 超时时间
+		UniqueKey:    uniqueKey,                // 基于队列名称、任务类型、消息体生成的的md5唯一值
+		GroupKey:     opt.group,
+		Retention:    int64(opt.retention.Seconds()), // 保留时间
+		Dependencies: opt.dependsOn,
+		Headers:      headers,
+		FairnessKey:  opt.fairness,
+		Priority:     opt.priority,
+		OrderingKey:  opt.ordering,
+		ExpireAt:     expireAt,
+		RetryPolicy:  opt.retryPolicy,
+	}
+}
+
 func (c *Client) enqueue(ctx context.Context, msg *base.TaskMessage, uniqueTTL time.Duration) error {
 	if uniqueTTL > 0 {
 		// 锁定执行
@@ -433,6 +1255,13 @@ func (c *Client) enqueue(ctx context.Context, msg *base.TaskMessage, uniqueTTL t
 	return c.broker.Enqueue(ctx, msg)
 }
 
+func (c *Client) enqueueWaiting(ctx context.Context, msg *base.TaskMessage, uniqueTTL time.Duration, ignoreMissingDeps bool) (base.TaskState, error) {
+	if uniqueTTL > 0 {
+		return c.broker.EnqueueWaitingUnique(ctx, msg, uniqueTTL, ignoreMissingDeps)
+	}
+	return c.broker.EnqueueWaiting(ctx, msg, ignoreMissingDeps)
+}
+
 func (c *Client) schedule(ctx context.Context, msg *base.TaskMessage, t time.Time, uniqueTTL time.Duration) error {
 	if uniqueTTL > 0 {
 		ttl := t.Add(uniqueTTL).Sub(time.Now())
@@ -447,3 +1276,19 @@ func (c *Client) addToGroup(ctx context.Context, msg *base.TaskMessage, group st
 	}
 	return c.broker.AddToGroup(ctx, msg, group)
 }
+
+// recordEvent records the lifecycle event of the given task to the event stream.
+// The task is already enqueued at this point, so a failure to record the event is logged
+// instead of being returned.
+func (c *Client) recordEvent(kind base.TaskEventKind, msg *base.TaskMessage) {
+	err := c.broker.RecordEvent(&base.TaskEvent{
+		Kind:   kind,
+		TaskID: msg.ID,
+		Queue:  msg.Queue,
+		Type:   msg.Type,
+		Time:   time.Now(),
+	})
+	if err != nil {
+		c.logger.Warnf("Could not record %s event of task id=%s: %v", kind, msg.ID, err)
+	}
+}
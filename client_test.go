@@ -13,6 +13,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
 	h "github.com/hibiken/asynq/internal/testutil"
 )
 
@@ -103,7 +104,7 @@ func TestClientEnqueueWithProcessAtOption(t *testing.T) {
 							Timeout:  int64(defaultTimeout.Seconds()),
 							Deadline: noDeadline.Unix(),
 						},
-						Score: oneHourLater.Unix(),
+						Score: base.ProcessAtScore(oneHourLater),
 					},
 				},
 			},
@@ -581,7 +582,7 @@ func TestClientEnqueueWithGroupOption(t *testing.T) {
 							Deadline: noDeadline.Unix(),
 							GroupKey: "mygroup",
 						},
-						Score: now.Add(30 * time.Minute).Unix(),
+						Score: base.ProcessAtScore(now.Add(30 * time.Minute)),
 					},
 				},
 			},
@@ -623,7 +624,7 @@ func TestClientEnqueueWithGroupOption(t *testing.T) {
 
 		for qname, want := range tc.wantScheduled {
 			gotScheduled := h.GetScheduledEntries(t, r, qname)
-			if diff := cmp.Diff(want, gotScheduled, h.IgnoreIDOpt, cmpopts.EquateEmpty()); diff != "" {
+			if diff := cmp.Diff(want, gotScheduled, h.IgnoreIDOpt, cmpopts.EquateEmpty(), h.EquateZScoreApprox(1000)); diff != "" {
 				t.Errorf("%s;\nmismatch found in %q; (-want,+got)\n%s", tc.desc, base.ScheduledKey(qname), diff)
 			}
 		}
@@ -724,6 +725,469 @@ func TestClientEnqueueWithConflictingTaskID(t *testing.T) {
 	}
 }
 
+func TestClientEnqueueWithDependsOnOption(t *testing.T) {
+	r := setup(t)
+	client := NewClient(getRedisConnOpt(t))
+	defer client.Close()
+
+	if _, err := client.Enqueue(NewTask("parent", nil), TaskID("parent1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Enqueue(NewTask("parent", nil), TaskID("parent2")); err != nil {
+		t.Fatal(err)
+	}
+	task := NewTask("child", nil)
+
+	gotInfo, err := client.Enqueue(task, TaskID("child"), DependsOn("parent1", "parent2", "parent1"))
+	if err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	wantInfo := &TaskInfo{
+		ID:           "child",
+		Queue:        "default",
+		Type:         task.Type(),
+		Payload:      task.Payload(),
+		State:        TaskStateWaiting,
+		MaxRetry:     defaultMaxRetry,
+		Timeout:      defaultTimeout,
+		Dependencies: []string{"parent1", "parent2"},
+	}
+	if diff := cmp.Diff(wantInfo, gotInfo); diff != "" {
+		t.Errorf("Enqueue(task) returned %v, want %v; (-want,+got)\n%s", gotInfo, wantInfo, diff)
+	}
+	wantWaiting := []*base.TaskMessage{
+		{
+			ID:           "child",
+			Type:         task.Type(),
+			Payload:      task.Payload(),
+			Retry:        defaultMaxRetry,
+			Queue:        "default",
+			Timeout:      int64(defaultTimeout.Seconds()),
+			Deadline:     noDeadline.Unix(),
+			Dependencies: []string{"parent1", "parent2"},
+		},
+	}
+	if diff := cmp.Diff(wantWaiting, h.GetWaitingMessages(t, r, "default")); diff != "" {
+		t.Errorf("mismatch found in %q; (-want,+got)\n%s", base.WaitingKey("default"), diff)
+	}
+
+	if _, err := client.Enqueue(task, DependsOn("parent1"), ProcessIn(time.Hour)); err == nil {
+		t.Errorf("Enqueue with DependsOn and ProcessIn options did not return error")
+	}
+	if _, err := client.Enqueue(task, DependsOn("parent1"), Group("mygroup")); err == nil {
+		t.Errorf("Enqueue with DependsOn and Group options did not return error")
+	}
+
+	// A task depended on which does not exist is rejected, unless it is considered completed.
+	if _, err := client.Enqueue(task, DependsOn("parent1", "no-such-task")); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Enqueue with a missing dependency returned %v, want ErrTaskNotFound", err)
+	}
+	info, err := client.Enqueue(task, DependsOn("no-such-task"), IgnoreMissingDependencies())
+	if err != nil {
+		t.Fatalf("Enqueue with IgnoreMissingDependencies option returned error: %v", err)
+	}
+	if info.State != TaskStatePending {
+		t.Errorf("Enqueue with IgnoreMissingDependencies option returned state %v, want %v", info.State, TaskStatePending)
+	}
+}
+
+func TestClientEnqueueWithHeaders(t *testing.T) {
+	r := setup(t)
+	client := NewClient(getRedisConnOpt(t))
+	defer client.Close()
+
+	task := NewTaskWithHeaders("send_email", nil,
+		map[string]string{"tenant_id": "tenant1", "request_id": "req1"},
+		Headers(map[string]string{"trace_id": "trace1"}))
+	info, err := client.Enqueue(task, Headers(map[string]string{"request_id": "req2"}))
+	if err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	want := map[string]string{"tenant_id": "tenant1", "request_id": "req2", "trace_id": "trace1"}
+	if diff := cmp.Diff(want, info.Headers); diff != "" {
+		t.Errorf("Enqueue returned TaskInfo with Headers %v, want %v; (-want,+got)\n%s", info.Headers, want, diff)
+	}
+	pending := h.GetPendingMessages(t, r, "default")
+	if len(pending) != 1 {
+		t.Fatalf("%q has %d tasks, want 1", base.PendingKey("default"), len(pending))
+	}
+	if diff := cmp.Diff(want, pending[0].Headers); diff != "" {
+		t.Errorf("mismatch found in headers of the pending task; (-want,+got)\n%s", diff)
+	}
+	if got := task.Headers(); len(got) != 2 {
+		t.Errorf("Task.Headers() = %v, want headers given to NewTaskWithHeaders only", got)
+	}
+
+	if _, err := client.Enqueue(NewTask("foo", nil), Headers(map[string]string{" ": "value"})); err == nil {
+		t.Errorf("Enqueue with blank header key returned nil error")
+	}
+}
+
+func TestClientEnqueueWithFairnessKeyOption(t *testing.T) {
+	r := setup(t)
+	client := NewClient(getRedisConnOpt(t))
+	defer client.Close()
+
+	task := NewTask("send_email", nil)
+	gotInfo, err := client.Enqueue(task, FairnessKey("tenant1"))
+	if err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	if gotInfo.FairnessKey != "tenant1" || gotInfo.State != TaskStatePending {
+		t.Errorf("Enqueue returned %+v, want pending task with FairnessKey %q", gotInfo, "tenant1")
+	}
+	// A task with a fairness key is stored in the fairness list of its key.
+	ids := r.LRange(context.Background(), base.FairnessKey("default", "tenant1"), 0, -1).Val()
+	if len(ids) != 1 || ids[0] != gotInfo.ID {
+		t.Fatalf("%q has tasks %v, want [%s]", base.FairnessKey("default", "tenant1"), ids, gotInfo.ID)
+	}
+
+	if _, err := client.Enqueue(NewTask("parent", nil), TaskID("parent1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Enqueue(task, DependsOn("parent1"), FairnessKey("tenant1")); err == nil {
+		t.Errorf("Enqueue with DependsOn and FairnessKey options did not return error")
+	}
+	if _, err := client.Chain(NewTask("a", nil, FairnessKey("tenant1")), NewTask("b", nil)); err == nil {
+		t.Errorf("Chain with FairnessKey option did not return error")
+	}
+}
+
+func TestClientEnqueueWithPriorityOption(t *testing.T) {
+	r := setup(t)
+	client := NewClient(getRedisConnOpt(t))
+	defer client.Close()
+	inspector := NewInspector(getRedisConnOpt(t))
+	defer inspector.Close()
+
+	if _, err := client.Enqueue(NewTask("low", nil)); err != nil {
+		t.Fatal(err)
+	}
+	gotInfo, err := client.Enqueue(NewTask("high", nil), Priority(3))
+	if err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	if gotInfo.Priority != 3 || gotInfo.State != TaskStatePending {
+		t.Errorf("Enqueue returned %+v, want pending task with Priority 3", gotInfo)
+	}
+	tasks, err := inspector.ListPendingTasks("default")
+	if err != nil {
+		t.Fatalf("ListPendingTasks returned error: %v", err)
+	}
+	priorities := make(map[string]int)
+	for _, info := range tasks {
+		priorities[info.Type] = info.Priority
+	}
+	if diff := cmp.Diff(map[string]int{"low": 0, "high": 3}, priorities); diff != "" {
+		t.Errorf("ListPendingTasks returned unexpected priorities; (-want,+got)\n%s", diff)
+	}
+	msg, _, _, err := rdb.NewRDB(r).Dequeue("default")
+	if err != nil {
+		t.Fatalf("Dequeue returned error: %v", err)
+	}
+	if msg.Type != "high" {
+		t.Errorf("Dequeue returned %q task, want %q task", msg.Type, "high")
+	}
+
+	if _, err := client.Enqueue(NewTask("parent", nil), TaskID("parent1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Enqueue(NewTask("child", nil), DependsOn("parent1"), Priority(1)); err == nil {
+		t.Errorf("Enqueue with DependsOn and Priority options did not return error")
+	}
+	if _, err := client.Chain(NewTask("a", nil, Priority(1)), NewTask("b", nil)); err == nil {
+		t.Errorf("Chain with Priority option did not return error")
+	}
+}
+
+func TestClientEnqueueWithOrderingKeyOption(t *testing.T) {
+	setup(t)
+	client := NewClient(getRedisConnOpt(t))
+	defer client.Close()
+	inspector := NewInspector(getRedisConnOpt(t))
+	defer inspector.Close()
+
+	first, err := client.Enqueue(NewTask("update_order", []byte("1")), OrderingKey("order-42"))
+	if err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	if first.State != TaskStatePending || first.OrderingKey != "order-42" || first.NextProcessAt.IsZero() {
+		t.Errorf("Enqueue returned %+v, want pending task with OrderingKey %q", first, "order-42")
+	}
+	second, err := client.Enqueue(NewTask("update_order", []byte("2")), OrderingKey("order-42"))
+	if err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	if second.State != TaskStateWaiting || !second.NextProcessAt.IsZero() {
+		t.Errorf("Enqueue returned %+v, want waiting task", second)
+	}
+	other, err := client.Enqueue(NewTask("update_order", []byte("3")), OrderingKey("order-43"))
+	if err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	if other.State != TaskStatePending {
+		t.Errorf("Enqueue returned task in state %v, want %v", other.State, TaskStatePending)
+	}
+	tasks, err := inspector.ListWaitingTasks("default")
+	if err != nil {
+		t.Fatalf("ListWaitingTasks returned error: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != second.ID || tasks[0].OrderingKey != "order-42" {
+		t.Errorf("ListWaitingTasks returned %+v, want the second task with ordering key %q", tasks, "order-42")
+	}
+
+	infos, errs := client.EnqueueBatch(context.Background(), []*Task{NewTask("update_order", nil, OrderingKey("order-42"))})
+	if infos[0] != nil || errs[0] == nil {
+		t.Errorf("EnqueueBatch with OrderingKey option did not return error")
+	}
+	if _, err := client.Chain(NewTask("a", nil, OrderingKey("order-42")), NewTask("b", nil)); err == nil {
+		t.Errorf("Chain with OrderingKey option did not return error")
+	}
+}
+
+func TestClientEnqueueWithExpireOption(t *testing.T) {
+	r := setup(t)
+	client := NewClient(getRedisConnOpt(t))
+	defer client.Close()
+	inspector := NewInspector(getRedisConnOpt(t))
+	defer inspector.Close()
+
+	expireAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	tests := []struct {
+		desc string
+		opts []Option
+	}{
+		{desc: "pending task with ExpireAt", opts: []Option{ExpireAt(expireAt)}},
+		{desc: "scheduled task with ExpireAt", opts: []Option{ProcessIn(time.Minute), ExpireAt(expireAt)}},
+		{desc: "pending task with ExpireIn", opts: []Option{ExpireIn(time.Hour)}},
+	}
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		gotInfo, err := client.Enqueue(NewTask("warmup_cache", nil), tc.opts...)
+		if err != nil {
+			t.Errorf("%s; Enqueue returned error: %v", tc.desc, err)
+			continue
+		}
+		if gotInfo.ExpireAt.Sub(expireAt).Abs() > time.Second {
+			t.Errorf("%s; Enqueue returned ExpireAt %v, want %v", tc.desc, gotInfo.ExpireAt, expireAt)
+		}
+		info, err := inspector.GetTaskInfo("default", gotInfo.ID)
+		if err != nil {
+			t.Fatalf("%s; GetTaskInfo returned error: %v", tc.desc, err)
+		}
+		if !info.ExpireAt.Equal(gotInfo.ExpireAt) {
+			t.Errorf("%s; GetTaskInfo returned ExpireAt %v, want %v", tc.desc, info.ExpireAt, gotInfo.ExpireAt)
+		}
+		if n := r.ZCard(context.Background(), base.ExpiringKey("default")).Val(); n != 1 {
+			t.Errorf("%s; %q has %d tasks, want 1", tc.desc, base.ExpiringKey("default"), n)
+		}
+	}
+
+	if _, err := client.Chain(NewTask("a", nil, ExpireIn(time.Hour)), NewTask("b", nil)); err == nil {
+		t.Errorf("Chain with ExpireIn option did not return error")
+	}
+}
+
+func TestClientEnqueueWithRetryPolicyOption(t *testing.T) {
+	r := setup(t)
+	client := NewClient(getRedisConnOpt(t))
+	defer client.Close()
+	inspector := NewInspector(getRedisConnOpt(t))
+	defer inspector.Close()
+
+	policy := RetryPolicy{
+		Backoff:      BackoffLinear,
+		BaseDelay:    5 * time.Second,
+		MaxDelay:     time.Minute,
+		Jitter:       0.1,
+		MaxElapsed:   time.Hour,
+		NonRetryable: []string{"invalid_input"},
+	}
+	h.FlushDB(t, r)
+	gotInfo, err := client.Enqueue(NewTask("sync_account", nil), policy, MaxRetry(5))
+	if err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	if diff := cmp.Diff(&policy, gotInfo.RetryPolicy); diff != "" {
+		t.Errorf("Enqueue returned RetryPolicy %+v, want %+v; (-want,+got)\n%s", gotInfo.RetryPolicy, policy, diff)
+	}
+	if gotInfo.MaxRetry != 5 {
+		t.Errorf("Enqueue returned MaxRetry %d, want 5", gotInfo.MaxRetry)
+	}
+	info, err := inspector.GetTaskInfo("default", gotInfo.ID)
+	if err != nil {
+		t.Fatalf("GetTaskInfo returned error: %v", err)
+	}
+	if diff := cmp.Diff(&policy, info.RetryPolicy); diff != "" {
+		t.Errorf("GetTaskInfo returned RetryPolicy %+v, want %+v; (-want,+got)\n%s", info.RetryPolicy, policy, diff)
+	}
+
+	gotInfo, err = client.Enqueue(NewTask("sync_account", nil))
+	if err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	if gotInfo.RetryPolicy != nil {
+		t.Errorf("Enqueue without RetryPolicy returned RetryPolicy %+v, want nil", gotInfo.RetryPolicy)
+	}
+}
+
+func TestClientEnqueueBatch(t *testing.T) {
+	r := setup(t)
+	client := NewClient(getRedisConnOpt(t))
+	defer client.Close()
+
+	tasks := []*Task{
+		NewTask("a", nil, TaskID("a")),
+		NewTask("b", nil, TaskID("b"), ProcessIn(time.Hour)),
+		NewTask("c", []byte("payload"), TaskID("c"), Unique(time.Hour)),
+		NewTask("c", []byte("payload"), Unique(time.Hour)),
+		NewTask("d", nil, TaskID("a")),
+		NewTask("", nil),
+		NewTask("e", nil, Group("mygroup")),
+		nil,
+	}
+	infos, errs := client.EnqueueBatch(context.Background(), tasks, MaxRetry(5))
+	if len(infos) != len(tasks) || len(errs) != len(tasks) {
+		t.Fatalf("EnqueueBatch returned %d infos and %d errors, want %d each", len(infos), len(errs), len(tasks))
+	}
+
+	wantStates := map[int]TaskState{0: TaskStatePending, 1: TaskStateScheduled, 2: TaskStatePending}
+	for i := range tasks {
+		state, ok := wantStates[i]
+		if !ok {
+			if errs[i] == nil || infos[i] != nil {
+				t.Errorf("EnqueueBatch returned (%v, %v) for task %d, want an error", infos[i], errs[i], i)
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Errorf("EnqueueBatch returned error for task %d: %v", i, errs[i])
+			continue
+		}
+		if infos[i].State != state || infos[i].Queue != "default" || infos[i].MaxRetry != 5 {
+			t.Errorf("EnqueueBatch returned %+v for task %d, want state %v in queue %q with MaxRetry 5", infos[i], i, state, "default")
+		}
+	}
+	if !errors.Is(errs[3], ErrDuplicateTask) {
+		t.Errorf("EnqueueBatch returned %v for duplicate task, want ErrDuplicateTask", errs[3])
+	}
+	if !errors.Is(errs[4], ErrTaskIDConflict) {
+		t.Errorf("EnqueueBatch returned %v for conflicting task ID, want ErrTaskIDConflict", errs[4])
+	}
+
+	var gotPending []string
+	for _, msg := range h.GetPendingMessages(t, r, "default") {
+		gotPending = append(gotPending, msg.ID)
+	}
+	if diff := cmp.Diff([]string{"a", "c"}, gotPending, cmpopts.SortSlices(func(x, y string) bool { return x < y })); diff != "" {
+		t.Errorf("mismatch found in %q; (-want,+got)\n%s", base.PendingKey("default"), diff)
+	}
+	if n := len(h.GetScheduledMessages(t, r, "default")); n != 1 {
+		t.Errorf("%q has %d tasks, want 1", base.ScheduledKey("default"), n)
+	}
+}
+
+func TestClientChain(t *testing.T) {
+	r := setup(t)
+	client := NewClient(getRedisConnOpt(t))
+	defer client.Close()
+
+	t1 := NewTask("step1", []byte("a"), TaskID("step1"))
+	t2 := NewTask("step2", []byte("b"), TaskID("step2"), MaxRetry(3))
+	info, err := client.Chain(t1, t2)
+	if err != nil {
+		t.Fatalf("Chain returned error: %v", err)
+	}
+	want := &ChainInfo{
+		ID:    info.ID,
+		Queue: "default",
+		State: ChainStateActive,
+		Steps: []*ChainStep{
+			{TaskID: "step1", Type: "step1", Payload: []byte("a")},
+			{TaskID: "step2", Type: "step2", Payload: []byte("b")},
+		},
+	}
+	if diff := cmp.Diff(want, info); diff != "" {
+		t.Errorf("Chain returned %v, want %v; (-want,+got)\n%s", info, want, diff)
+	}
+	wantPending := []*base.TaskMessage{
+		{
+			ID:       "step1",
+			Type:     "step1",
+			Payload:  []byte("a"),
+			Retry:    defaultMaxRetry,
+			Queue:    "default",
+			Timeout:  int64(defaultTimeout.Seconds()),
+			Deadline: noDeadline.Unix(),
+			ChainID:  info.ID,
+		},
+	}
+	if diff := cmp.Diff(wantPending, h.GetPendingMessages(t, r, "default")); diff != "" {
+		t.Errorf("mismatch found in %q; (-want,+got)\n%s", base.PendingKey("default"), diff)
+	}
+
+	tests := []struct {
+		desc  string
+		tasks []*Task
+	}{
+		{
+			desc:  "With no tasks",
+			tasks: nil,
+		},
+		{
+			desc:  "With tasks in different queues",
+			tasks: []*Task{NewTask("foo", nil), NewTask("bar", nil, Queue("custom"))},
+		},
+		{
+			desc:  "With ProcessIn option",
+			tasks: []*Task{NewTask("foo", nil), NewTask("bar", nil, ProcessIn(time.Hour))},
+		},
+		{
+			desc:  "With duplicate task IDs",
+			tasks: []*Task{NewTask("foo", nil, TaskID("dup")), NewTask("bar", nil, TaskID("dup"))},
+		},
+		{
+			desc:  "With task ID conflicting with existing task",
+			tasks: []*Task{NewTask("foo", nil), NewTask("bar", nil, TaskID("step1"))},
+		},
+	}
+	for _, tc := range tests {
+		if _, err := client.Chain(tc.tasks...); err == nil {
+			t.Errorf("%s; Chain did not return non-nil error", tc.desc)
+		}
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		desc    string
+		kind    enqueueKind
+		opts    []Option
+		wantErr bool
+	}{
+		{"Enqueue with Debounce", enqueueSingle, []Option{Debounce("key", time.Minute)}, false},
+		{"Enqueue with Debounce and Priority", enqueueSingle, []Option{Debounce("key", time.Minute), Priority(1)}, true},
+		{"Enqueue with OrderingKey and ProcessIn", enqueueSingle, []Option{OrderingKey("key"), ProcessIn(time.Hour)}, true},
+		{"Enqueue with DependsOn and FairnessKey", enqueueSingle, []Option{DependsOn("id"), FairnessKey("key")}, true},
+		{"Enqueue with Group and Priority", enqueueSingle, []Option{Group("key"), Priority(1)}, true},
+		{"EnqueueBatch with ProcessIn", enqueueBatch, []Option{ProcessIn(time.Hour)}, false},
+		{"EnqueueBatch with Debounce", enqueueBatch, []Option{Debounce("key", time.Minute)}, true},
+		{"EnqueueBatch with past ExpireAt", enqueueBatch, []Option{ExpireAt(time.Now().Add(-time.Hour))}, true},
+		{"Chain with Retention", enqueueChain, []Option{Retention(time.Hour)}, false},
+		{"Chain with Debounce", enqueueChain, []Option{Debounce("key", time.Minute)}, true},
+		{"Chain with Unique", enqueueChain, []Option{Unique(time.Hour)}, true},
+	}
+	for _, tc := range tests {
+		opt, err := composeOptions(tc.opts...)
+		if err != nil {
+			t.Fatalf("%s: composeOptions returned error: %v", tc.desc, err)
+		}
+		if err := validateOptions(opt, tc.kind, time.Now()); (err != nil) != tc.wantErr {
+			t.Errorf("%s: validateOptions returned %v, want error: %t", tc.desc, err, tc.wantErr)
+		}
+	}
+}
+
 func TestClientEnqueueWithProcessInOption(t *testing.T) {
 	r := setup(t)
 	client := NewClient(getRedisConnOpt(t))
@@ -773,7 +1237,7 @@ func TestClientEnqueueWithProcessInOption(t *testing.T) {
 							Timeout:  int64(defaultTimeout.Seconds()),
 							Deadline: noDeadline.Unix(),
 						},
-						Score: time.Now().Add(time.Hour).Unix(),
+						Score: base.ProcessAtScore(time.Now().Add(time.Hour)),
 					},
 				},
 			},
@@ -840,7 +1304,7 @@ func TestClientEnqueueWithProcessInOption(t *testing.T) {
 		}
 		for qname, want := range tc.wantScheduled {
 			gotScheduled := h.GetScheduledEntries(t, r, qname)
-			if diff := cmp.Diff(want, gotScheduled, h.IgnoreIDOpt, cmpopts.EquateEmpty()); diff != "" {
+			if diff := cmp.Diff(want, gotScheduled, h.IgnoreIDOpt, cmpopts.EquateEmpty(), h.EquateZScoreApprox(1000)); diff != "" {
 				t.Errorf("%s;\nmismatch found in %q; (-want,+got)\n%s", tc.desc, base.ScheduledKey(qname), diff)
 			}
 		}
@@ -896,6 +1360,121 @@ func TestClientEnqueueError(t *testing.T) {
 			task: NewTask("foo", nil),
 			opts: []Option{Unique(300 * time.Millisecond)},
 		},
+		{
+			desc: "With blank dependency ID",
+			task: NewTask("foo", nil),
+			opts: []Option{DependsOn("  ")},
+		},
+		{
+			desc: "With blank fairness key",
+			task: NewTask("foo", nil),
+			opts: []Option{FairnessKey("  ")},
+		},
+		{
+			desc: "With fairness key and group",
+			task: NewTask("foo", nil),
+			opts: []Option{FairnessKey("tenant1"), Group("mygroup")},
+		},
+		{
+			desc: "With negative priority",
+			task: NewTask("foo", nil),
+			opts: []Option{Priority(-1)},
+		},
+		{
+			desc: "With priority and group",
+			task: NewTask("foo", nil),
+			opts: []Option{Priority(1), Group("mygroup")},
+		},
+		{
+			desc: "With blank ordering key",
+			task: NewTask("foo", nil),
+			opts: []Option{OrderingKey(" ")},
+		},
+		{
+			desc: "With ordering key and process in",
+			task: NewTask("foo", nil),
+			opts: []Option{OrderingKey("order-1"), ProcessIn(time.Hour)},
+		},
+		{
+			desc: "With ordering key and fairness key",
+			task: NewTask("foo", nil),
+			opts: []Option{OrderingKey("order-1"), FairnessKey("tenant1")},
+		},
+		{
+			desc: "With expiration time in the past",
+			task: NewTask("foo", nil),
+			opts: []Option{ExpireAt(time.Now().Add(-time.Minute))},
+		},
+		{
+			desc: "With expiration time before process time",
+			task: NewTask("foo", nil),
+			opts: []Option{ProcessIn(time.Hour), ExpireIn(time.Minute)},
+		},
+		{
+			desc: "With expiration time and group",
+			task: NewTask("foo", nil),
+			opts: []Option{ExpireIn(time.Hour), Group("mygroup")},
+		},
+		{
+			desc: "With blank unique key",
+			task: NewTask("foo", nil),
+			opts: []Option{UniqueBy(" ", time.Hour)},
+		},
+		{
+			desc: "With unique key and TTL less than 1s",
+			task: NewTask("foo", nil),
+			opts: []Option{UniqueBy("key", 300*time.Millisecond)},
+		},
+		{
+			desc: "With blank debounce key",
+			task: NewTask("foo", nil),
+			opts: []Option{Debounce(" ", time.Minute)},
+		},
+		{
+			desc: "With non-positive debounce window",
+			task: NewTask("foo", nil),
+			opts: []Option{Debounce("key", 0)},
+		},
+		{
+			desc: "With debounce key and process in",
+			task: NewTask("foo", nil),
+			opts: []Option{Debounce("key", time.Minute), ProcessIn(time.Hour)},
+		},
+		{
+			desc: "With debounce key and unique",
+			task: NewTask("foo", nil),
+			opts: []Option{Debounce("key", time.Minute), Unique(time.Hour)},
+		},
+		{
+			desc: "With non-positive retry base delay",
+			task: NewTask("foo", nil),
+			opts: []Option{RetryPolicy{Backoff: BackoffFixed}},
+		},
+		{
+			desc: "With retry max delay less than base delay",
+			task: NewTask("foo", nil),
+			opts: []Option{RetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Second}},
+		},
+		{
+			desc: "With retry jitter out of range",
+			task: NewTask("foo", nil),
+			opts: []Option{RetryPolicy{BaseDelay: time.Second, Jitter: 1.5}},
+		},
+		{
+			desc: "With negative retry max elapsed time",
+			task: NewTask("foo", nil),
+			opts: []Option{RetryPolicy{BaseDelay: time.Second, MaxElapsed: -time.Hour}},
+		},
+		{
+			desc: "With unknown retry backoff kind",
+			task: NewTask("foo", nil),
+			opts: []Option{RetryPolicy{Backoff: BackoffKind(7), BaseDelay: time.Second}},
+		},
+		{
+			desc: "With blank non-retryable error kind",
+			task: NewTask("foo", nil),
+			opts: []Option{RetryPolicy{BaseDelay: time.Second, NonRetryable: []string{""}}},
+		},
 	}
 
 	for _, tc := range tests {
@@ -1085,6 +1664,81 @@ func TestClientEnqueueUnique(t *testing.T) {
 	}
 }
 
+func TestClientEnqueueWithUniqueByOption(t *testing.T) {
+	r := setup(t)
+	c := NewClient(getRedisConnOpt(t))
+	defer c.Close()
+
+	// Tasks with different payloads are duplicates if they share the same key.
+	t1 := NewTask("email", h.JSON(map[string]interface{}{"user_id": 123, "seq": 1}))
+	t2 := NewTask("email", h.JSON(map[string]interface{}{"user_id": 123, "seq": 2}))
+
+	info, err := c.Enqueue(t1, UniqueBy("user:123", time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base.UniqueByKey(base.DefaultQueueName, "user:123")
+	if got := r.Get(context.Background(), key).Val(); got != info.ID {
+		t.Errorf("uniqueness lock %q is held by %q, want %q", key, got, info.ID)
+	}
+	gotTTL := r.TTL(context.Background(), key).Val()
+	if !cmp.Equal(time.Hour.Seconds(), gotTTL.Seconds(), cmpopts.EquateApprox(0, 1)) {
+		t.Errorf("TTL = %v, want %v", gotTTL, time.Hour)
+	}
+
+	if _, err := c.Enqueue(t2, UniqueBy("user:123", time.Hour)); !errors.Is(err, ErrDuplicateTask) {
+		t.Errorf("Enqueueing duplicate task returned %v, want ErrDuplicateTask", err)
+	}
+	if _, err := c.Enqueue(t2, UniqueBy("user:456", time.Hour)); err != nil {
+		t.Errorf("Enqueueing task with another key returned error: %v", err)
+	}
+}
+
+func TestClientEnqueueWithDebounceOption(t *testing.T) {
+	r := setup(t)
+	c := NewClient(getRedisConnOpt(t))
+	defer c.Close()
+
+	first, err := c.Enqueue(NewTask("reindex", []byte("v1")), Debounce("user:123", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Enqueue(NewTask("reindex", []byte("v2")), Debounce("user:123", time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID != first.ID {
+		t.Errorf("second Enqueue returned task ID %q, want %q", second.ID, first.ID)
+	}
+	if second.State != TaskStateScheduled {
+		t.Errorf("second Enqueue returned state %v, want %v", second.State, TaskStateScheduled)
+	}
+	if got := string(second.Payload); got != "v2" {
+		t.Errorf("second Enqueue returned payload %q, want %q", got, "v2")
+	}
+
+	entries := h.GetScheduledEntries(t, r, base.DefaultQueueName)
+	if len(entries) != 1 {
+		t.Fatalf("%q has %d tasks, want 1", base.ScheduledKey(base.DefaultQueueName), len(entries))
+	}
+	if got := string(entries[0].Message.Payload); got != "v2" {
+		t.Errorf("scheduled task has payload %q, want %q", got, "v2")
+	}
+	gotProcessAt := base.ProcessAtFromScore(entries[0].Score)
+	if !cmp.Equal(time.Now().Add(time.Hour), gotProcessAt, cmpopts.EquateApproxTime(2*time.Second)) {
+		t.Errorf("scheduled task is processed at %v, want %v", gotProcessAt, time.Now().Add(time.Hour))
+	}
+
+	// Tasks with other debounce keys are not affected.
+	other, err := c.Enqueue(NewTask("reindex", []byte("v1")), Debounce("user:456", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == first.ID {
+		t.Errorf("Enqueue with another debounce key returned task ID %q of the first task", other.ID)
+	}
+}
+
 func TestClientEnqueueUniqueWithProcessInOption(t *testing.T) {
 	r := setup(t)
 	c := NewClient(getRedisConnOpt(t))
@@ -14,6 +14,9 @@ import (
 
 // A forwarder is responsible for moving scheduled and retry tasks to pending state
 // so that the tasks get processed by the workers.
+//
+// The forwarder wakes up when the earliest scheduled or retry task becomes due,
+// and it polls at least every avgInterval.
 type forwarder struct {
 	logger *log.Logger
 	broker base.Broker
@@ -22,7 +25,10 @@ type forwarder struct {
 	done chan struct{}
 
 	// list of queue names to check and enqueue.
-	queues []string
+	queues *queueNames
+
+	// queuesChanged receives a value when the list of queues is replaced.
+	queuesChanged chan struct{}
 
 	// poll interval on average
 	avgInterval time.Duration
@@ -37,11 +43,12 @@ type forwarderParams struct {
 
 func newForwarder(params forwarderParams) *forwarder {
 	return &forwarder{
-		logger:      params.logger,
-		broker:      params.broker,
-		done:        make(chan struct{}),
-		queues:      params.queues,
-		avgInterval: params.interval,
+		logger:        params.logger,
+		broker:        params.broker,
+		done:          make(chan struct{}),
+		queues:        newQueueNames(params.queues),
+		queuesChanged: make(chan struct{}, 1),
+		avgInterval:   params.interval,
 	}
 }
 
@@ -51,27 +58,118 @@ func (f *forwarder) shutdown() {
 	f.done <- struct{}{}
 }
 
+// setQueues replaces the list of queues to check.
+// The forwarder resubscribes to the notifications and checks the queues right away.
+func (f *forwarder) setQueues(qnames []string) {
+	f.queues.set(qnames)
+	select {
+	case f.queuesChanged <- struct{}{}:
+	default:
+		// The forwarder has a pending change already.
+	}
+}
+
 // start starts the "forwarder" goroutine.
 func (f *forwarder) start(wg *sync.WaitGroup) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		timer := time.NewTimer(f.avgInterval)
+		// Subscription to the notifications of newly scheduled tasks, so that
+		// the forwarder wakes up in time for a task due before the next poll.
+		// It is nil while unsubscribed, and subscribing is retried on each poll.
+		sub := f.subscribe()
+		defer func() {
+			if sub != nil {
+				sub.Close()
+			}
+		}()
+		deadline := time.Now().Add(f.avgInterval)
+		if next, ok := f.nextProcessAt(); ok && next.Before(deadline) {
+			deadline = next
+		}
+		timer := time.NewTimer(time.Until(deadline))
 		for {
+			var notifications <-chan string
+			if sub != nil {
+				notifications = sub.Channel()
+			}
 			select {
 			case <-f.done:
 				f.logger.Debug("Forwarder done")
 				return
 			case <-timer.C:
-				f.exec()
-				timer.Reset(f.avgInterval)
+				if sub == nil {
+					sub = f.subscribe()
+				}
+				deadline = time.Now().Add(f.exec())
+				timer.Reset(time.Until(deadline))
+			case <-f.queuesChanged:
+				if sub != nil {
+					sub.Close()
+				}
+				sub = f.subscribe()
+				if !timer.Stop() {
+					<-timer.C
+				}
+				deadline = time.Now()
+				timer.Reset(0)
+			case _, ok := <-notifications:
+				if !ok {
+					f.logger.Errorf("schedule subscription closed: %v", sub.Err())
+					sub.Close()
+					sub = nil
+					continue
+				}
+				next, ok := f.nextProcessAt()
+				if !ok || !next.Before(deadline) {
+					continue
+				}
+				if !timer.Stop() {
+					<-timer.C
+				}
+				deadline = next
+				timer.Reset(time.Until(deadline))
 			}
 		}
 	}()
 }
 
-func (f *forwarder) exec() {
-	if err := f.broker.ForwardIfReady(f.queues...); err != nil {
+// exec forwards the tasks ready to be processed and returns the duration
+// to wait before the next run.
+func (f *forwarder) exec() time.Duration {
+	if err := f.broker.ForwardIfReady(f.queues.get()...); err != nil {
 		f.logger.Errorf("Failed to forward scheduled tasks: %v", err)
+		return f.avgInterval
+	}
+	next, ok := f.nextProcessAt()
+	if !ok {
+		return f.avgInterval
+	}
+	if d := time.Until(next); d < f.avgInterval {
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+	return f.avgInterval
+}
+
+// nextProcessAt returns the time at which the earliest scheduled or retry task
+// becomes due. It reports false if there is no such task or the time is unknown.
+func (f *forwarder) nextProcessAt() (time.Time, bool) {
+	next, err := f.broker.NextProcessAt(f.queues.get()...)
+	if err != nil {
+		f.logger.Errorf("Failed to get the next process time of scheduled tasks: %v", err)
+		return time.Time{}, false
+	}
+	return next, !next.IsZero()
+}
+
+func (f *forwarder) subscribe() base.WakeupSubscription {
+	sub, err := f.broker.SubscribeSchedule(f.queues.get()...)
+	if err != nil {
+		f.logger.Errorf("cannot subscribe to schedule channels: %v", err)
+		return nil
 	}
+	return sub
 }
@@ -5,6 +5,7 @@
 package asynq_learn
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
@@ -135,3 +136,42 @@ func TestForwarder(t *testing.T) {
 		}
 	}
 }
+
+func TestForwarderWakesUpAtProcessAt(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	rdbClient := rdb.NewRDB(r)
+	const pollInterval = 5 * time.Second
+	s := newForwarder(forwarderParams{
+		logger:   testLogger,
+		broker:   rdbClient,
+		queues:   []string{"default"},
+		interval: pollInterval,
+	})
+	var wg sync.WaitGroup
+	s.start(&wg)
+	defer func() {
+		s.shutdown()
+		wg.Wait()
+	}()
+	// Let the forwarder subscribe to the schedule notifications.
+	time.Sleep(100 * time.Millisecond)
+
+	msg := h.NewTaskMessage("send_email", nil)
+	processAt := time.Now().Add(200 * time.Millisecond)
+	if err := rdbClient.Schedule(context.Background(), msg, processAt); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(pollInterval / 2)
+	for time.Now().Before(deadline) {
+		if pending := h.GetPendingMessages(t, r, "default"); len(pending) == 1 {
+			if now := time.Now(); now.Before(processAt) {
+				t.Errorf("task was forwarded at %v, before its process time %v", now, processAt)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("task scheduled to be processed at %v was not forwarded by %v", processAt, deadline)
+}
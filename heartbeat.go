@@ -30,13 +30,21 @@ type heartbeater struct {
 	interval time.Duration
 
 	// following fields are initialized at construction time and are immutable.
-	host           string
-	pid            int
-	serverID       string
+	host     string
+	pid      int
+	serverID string
+
+	// configMu guards the following fields, which are updated when
+	// the server is reconfigured at runtime.
+	configMu       sync.Mutex
 	concurrency    int
 	queues         map[string]int
 	strictPriority bool
 
+	// configChanged receives a value when the configuration is updated,
+	// so that the change is written without waiting for the next heartbeat.
+	configChanged chan struct{}
+
 	// following fields are mutable and should be accessed only by the
 	// heartbeater goroutine. In other words, confine these variables
 	// to this goroutine only.
@@ -82,6 +90,7 @@ func newHeartbeater(params heartbeaterParams) *heartbeater {
 		concurrency:    params.concurrency,
 		queues:         params.queues,
 		strictPriority: params.strictPriority,
+		configChanged:  make(chan struct{}, 1),
 
 		state:    params.state,
 		workers:  make(map[string]*workerInfo),
@@ -96,6 +105,20 @@ func (h *heartbeater) shutdown() {
 	h.done <- struct{}{}
 }
 
+// setConfig updates the configuration written in the server info.
+func (h *heartbeater) setConfig(concurrency int, queues map[string]int, strictPriority bool) {
+	h.configMu.Lock()
+	h.concurrency = concurrency
+	h.queues = queues
+	h.strictPriority = strictPriority
+	h.configMu.Unlock()
+	select {
+	case h.configChanged <- struct{}{}:
+	default:
+		// The heartbeater has a pending update already.
+	}
+}
+
 // A workerInfo holds an active worker information.
 type workerInfo struct {
 	// the task message the worker is processing.
@@ -133,6 +156,9 @@ func (h *heartbeater) start(wg *sync.WaitGroup) {
 				h.logger.Info("5秒的timer")
 				timer.Reset(h.interval)
 
+			case <-h.configChanged:
+				h.beat()
+
 			case w := <-h.starting:
 				h.logger.Info("心跳中任务开始的传递", w)
 				h.workers[w.msg.ID] = w
@@ -151,6 +177,7 @@ func (h *heartbeater) beat() {
 	h.state.mu.Lock()
 	srvStatus := h.state.value.String()
 	h.state.mu.Unlock()
+	h.configMu.Lock()
 	// 当前server 的一些信息
 	info := base.ServerInfo{
 		Host:              h.host,
@@ -163,6 +190,7 @@ func (h *heartbeater) beat() {
 		Started:           h.started,
 		ActiveWorkerCount: len(h.workers),
 	}
+	h.configMu.Unlock()
 
 	var ws []*base.WorkerInfo
 	idsByQueue := make(map[string][]string)
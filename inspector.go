@@ -5,6 +5,7 @@
 package asynq_learn
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
@@ -14,22 +15,82 @@ import (
 	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/errors"
 	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/hibiken/asynq/internal/timeutil"
 )
 
 // Inspector is a client interface to inspect and mutate the state of
 // queues and tasks.
 type Inspector struct {
-	rdb *rdb.RDB
+	rdb inspectorBroker
+}
+
+// inspectorBroker is the set of operations Inspector needs from a store.
+type inspectorBroker interface {
+	Close() error
+	SetClock(c timeutil.Clock)
+	AllQueues() ([]string, error)
+	CurrentStats(qname string) (*rdb.Stats, error)
+	HistoricalStats(qname string, n int) ([]*rdb.DailyStats, error)
+	GroupStats(qname string) ([]*rdb.GroupStat, error)
+	FairnessKeyStats(qname string) ([]*rdb.FairnessKeyStat, error)
+	RemoveQueue(qname string, force bool) error
+	GetTaskInfo(qname, id string) (*base.TaskInfo, error)
+	GetChainInfo(qname, chainID string) (*base.ChainInfo, error)
+	GetUniqueLock(qname, key string) (*rdb.UniqueLock, error)
+	ReleaseUniqueLock(qname, key string) error
+	ListPending(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error)
+	ListActive(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error)
+	ListAggregating(qname, gname string, pgn rdb.Pagination) ([]*base.TaskInfo, error)
+	ListScheduled(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error)
+	ListRetry(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error)
+	ListArchived(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error)
+	ListCompleted(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error)
+	ListWaiting(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error)
+	ListLeaseExpired(cutoff time.Time, qnames ...string) ([]*base.TaskMessage, error)
+	DeleteTask(qname, id string) error
+	DeleteAllPendingTasks(qname string) (int64, error)
+	DeleteAllScheduledTasks(qname string) (int64, error)
+	DeleteAllRetryTasks(qname string) (int64, error)
+	DeleteAllArchivedTasks(qname string) (int64, error)
+	DeleteAllCompletedTasks(qname string) (int64, error)
+	DeleteAllAggregatingTasks(qname, gname string) (int64, error)
+	RunTask(qname, id string) error
+	RunAllScheduledTasks(qname string) (int64, error)
+	RunAllRetryTasks(qname string) (int64, error)
+	RunAllArchivedTasks(qname string) (int64, error)
+	RunAllAggregatingTasks(qname, gname string) (int64, error)
+	ArchiveTask(qname, id string) error
+	ArchiveAllPendingTasks(qname string) (int64, error)
+	ArchiveAllScheduledTasks(qname string) (int64, error)
+	ArchiveAllRetryTasks(qname string) (int64, error)
+	ArchiveAllAggregatingTasks(qname, gname string) (int64, error)
+	PublishCancelation(id string) error
+	PublishServerConfig(serverID string, update *base.ServerConfigUpdate) (int, error)
+	Pause(qname string) error
+	Unpause(qname string) error
+	ListServers() ([]*base.ServerInfo, error)
+	ListWorkers() ([]*base.WorkerInfo, error)
+	ListSchedulerEntries() ([]*base.SchedulerEntry, error)
+	ListSchedulerEnqueueEvents(entryID string, pgn rdb.Pagination) ([]*base.SchedulerEnqueueEvent, error)
+	ClusterKeySlot(qname string) (int64, error)
+	ClusterNodes(qname string) ([]redis.ClusterNode, error)
+	RecordEvent(ev *base.TaskEvent) error
+	LastEventID() (string, error)
+	ReadEvents(ctx context.Context, after string, count int, block time.Duration) ([]*base.TaskEvent, error)
 }
 
 // New returns a new instance of Inspector.
 func NewInspector(r RedisConnOpt) *Inspector {
-	c, ok := r.MakeRedisClient().(redis.UniversalClient)
-	if !ok {
-		panic(fmt.Sprintf("inspeq: unsupported RedisConnOpt type %T", r))
+	return &Inspector{
+		rdb: makeBroker(r),
 	}
+}
+
+// NewInspectorWithBroker returns a new instance of Inspector given a broker option,
+// e.g. an InMemoryBroker.
+func NewInspectorWithBroker(b BrokerOpt) *Inspector {
 	return &Inspector{
-		rdb: rdb.NewRDB(c),
+		rdb: b.makeBroker(),
 	}
 }
 
@@ -68,6 +129,39 @@ type GroupInfo struct {
 	Size int
 }
 
+// ListFairnessKeys returns the fairness keys of the pending tasks in the given queue,
+// along with the number of pending tasks of each key, sorted by key.
+// Pending tasks without fairness key are not included.
+func (i *Inspector) ListFairnessKeys(queue string) ([]*FairnessKeyInfo, error) {
+	if err := base.ValidateQueueName(queue); err != nil {
+		return nil, fmt.Errorf("asynq_learn: %v", err)
+	}
+	stats, err := i.rdb.FairnessKeyStats(queue)
+	switch {
+	case errors.IsQueueNotFound(err):
+		return nil, fmt.Errorf("asynq_learn: %w", ErrQueueNotFound)
+	case err != nil:
+		return nil, fmt.Errorf("asynq_learn: %v", err)
+	}
+	var res []*FairnessKeyInfo
+	for _, s := range stats {
+		res = append(res, &FairnessKeyInfo{
+			Key:     s.Key,
+			Pending: s.Pending,
+		})
+	}
+	return res, nil
+}
+
+// FairnessKeyInfo represents the pending tasks with a fairness key at a certain time.
+type FairnessKeyInfo struct {
+	// Fairness key of the tasks.
+	Key string
+
+	// Pending is the number of pending tasks with the fairness key.
+	Pending int
+}
+
 // QueueInfo represents a state of a queue at a certain time.
 type QueueInfo struct {
 	// Name of the queue.
@@ -81,7 +175,7 @@ type QueueInfo struct {
 	Latency time.Duration
 
 	// Size is the total number of tasks in the queue.
-	// The value is the sum of Pending, Active, Scheduled, Retry, Aggregating and Archived.
+	// The value is the sum of Pending, Active, Scheduled, Retry, Aggregating, Waiting and Archived.
 	Size int
 
 	// Groups is the total number of groups in the queue.
@@ -101,6 +195,8 @@ type QueueInfo struct {
 	Completed int
 	// Number of aggregating tasks.
 	Aggregating int
+	// Number of tasks waiting for their dependencies to complete.
+	Waiting int
 
 	// Total number of tasks being processed within the given date (counter resets daily).
 	// The number includes both succeeded and failed tasks.
@@ -143,6 +239,7 @@ func (i *Inspector) GetQueueInfo(queue string) (*QueueInfo, error) {
 		Archived:       stats.Archived,
 		Completed:      stats.Completed,
 		Aggregating:    stats.Aggregating,
+		Waiting:        stats.Waiting,
 		Processed:      stats.Processed,
 		Failed:         stats.Failed,
 		ProcessedTotal: stats.ProcessedTotal,
@@ -195,6 +292,15 @@ var (
 
 	// ErrTaskNotFound indicates that the specified task cannot be found in the queue.
 	ErrTaskNotFound = errors.New("task not found")
+
+	// ErrChainNotFound indicates that the specified chain cannot be found in the queue.
+	ErrChainNotFound = errors.New("chain not found")
+
+	// ErrUniqueLockNotFound indicates that the specified uniqueness lock cannot be found in the queue.
+	ErrUniqueLockNotFound = errors.New("uniqueness lock not found")
+
+	// ErrServerNotFound indicates that no running server with the specified ID received the request.
+	ErrServerNotFound = errors.New("server not found")
 )
 
 // DeleteQueue removes the specified queue.
@@ -235,6 +341,82 @@ func (i *Inspector) GetTaskInfo(queue, id string) (*TaskInfo, error) {
 	return newTaskInfo(info.Message, info.State, info.NextProcessAt, info.Result), nil
 }
 
+// GetChainInfo retrieves chain information given a chain id and queue name.
+//
+// Information about a chain is kept for a week after all of its tasks are processed.
+//
+// Returns an error wrapping ErrQueueNotFound if a queue with the given name doesn't exist.
+// Returns an error wrapping ErrChainNotFound if a chain with the given id doesn't exist in the queue.
+func (i *Inspector) GetChainInfo(queue, id string) (*ChainInfo, error) {
+	info, err := i.rdb.GetChainInfo(queue, id)
+	switch {
+	case errors.IsQueueNotFound(err):
+		return nil, fmt.Errorf("asynq_learn: %w", ErrQueueNotFound)
+	case errors.IsChainNotFound(err):
+		return nil, fmt.Errorf("asynq_learn: %w", ErrChainNotFound)
+	case err != nil:
+		return nil, fmt.Errorf("asynq_learn: %v", err)
+	}
+	return newChainInfo(info), nil
+}
+
+// UniqueLockInfo describes a uniqueness lock acquired with the UniqueBy option.
+type UniqueLockInfo struct {
+	// Queue is the name of the queue the lock belongs to.
+	Queue string
+
+	// Key is the uniqueness key given to the UniqueBy option.
+	Key string
+
+	// TaskID is the ID of the task holding the lock.
+	TaskID string
+
+	// ExpiresAt is the time when the lock expires.
+	// Zero value (i.e. time.Time{}) if the lock has no expiration.
+	ExpiresAt time.Time
+}
+
+// GetUniqueLock retrieves the uniqueness lock acquired with UniqueBy option given a key and queue name.
+//
+// Returns an error wrapping ErrQueueNotFound if a queue with the given name doesn't exist.
+// Returns an error wrapping ErrUniqueLockNotFound if no lock with the given key is held in the queue.
+func (i *Inspector) GetUniqueLock(queue, key string) (*UniqueLockInfo, error) {
+	lock, err := i.rdb.GetUniqueLock(queue, key)
+	switch {
+	case errors.IsQueueNotFound(err):
+		return nil, fmt.Errorf("asynq_learn: %w", ErrQueueNotFound)
+	case errors.IsUniqueLockNotFound(err):
+		return nil, fmt.Errorf("asynq_learn: %w", ErrUniqueLockNotFound)
+	case err != nil:
+		return nil, fmt.Errorf("asynq_learn: %v", err)
+	}
+	return &UniqueLockInfo{
+		Queue:     queue,
+		Key:       key,
+		TaskID:    lock.TaskID,
+		ExpiresAt: lock.ExpiresAt,
+	}, nil
+}
+
+// ReleaseUniqueLock releases the uniqueness lock acquired with UniqueBy option given a key and queue name,
+// so that another task with the same key can be enqueued before the lock expires.
+// The task holding the lock is not affected.
+//
+// Returns an error wrapping ErrQueueNotFound if a queue with the given name doesn't exist.
+// Returns an error wrapping ErrUniqueLockNotFound if no lock with the given key is held in the queue.
+func (i *Inspector) ReleaseUniqueLock(queue, key string) error {
+	err := i.rdb.ReleaseUniqueLock(queue, key)
+	switch {
+	case errors.IsQueueNotFound(err):
+		return fmt.Errorf("asynq_learn: %w", ErrQueueNotFound)
+	case errors.IsUniqueLockNotFound(err):
+		return fmt.Errorf("asynq_learn: %w", ErrUniqueLockNotFound)
+	case err != nil:
+		return fmt.Errorf("asynq_learn: %v", err)
+	}
+	return nil
+}
+
 // ListOption specifies behavior of list operation.
 type ListOption interface{}
 
@@ -508,6 +690,35 @@ func (i *Inspector) ListCompletedTasks(queue string, opts ...ListOption) ([]*Tas
 	return tasks, nil
 }
 
+// ListWaitingTasks retrieves tasks waiting for their dependencies to complete from the specified queue.
+// Tasks are sorted by the time they were enqueued in ascending order.
+//
+// By default, it retrieves the first 30 tasks.
+func (i *Inspector) ListWaitingTasks(queue string, opts ...ListOption) ([]*TaskInfo, error) {
+	if err := base.ValidateQueueName(queue); err != nil {
+		return nil, fmt.Errorf("asynq_learn: %v", err)
+	}
+	opt := composeListOptions(opts...)
+	pgn := rdb.Pagination{Size: opt.pageSize, Page: opt.pageNum - 1}
+	infos, err := i.rdb.ListWaiting(queue, pgn)
+	switch {
+	case errors.IsQueueNotFound(err):
+		return nil, fmt.Errorf("asynq_learn: %w", ErrQueueNotFound)
+	case err != nil:
+		return nil, fmt.Errorf("asynq_learn: %v", err)
+	}
+	var tasks []*TaskInfo
+	for _, i := range infos {
+		tasks = append(tasks, newTaskInfo(
+			i.Message,
+			i.State,
+			i.NextProcessAt,
+			i.Result,
+		))
+	}
+	return tasks, nil
+}
+
 // DeleteAllPendingTasks deletes all pending tasks from the specified queue,
 // and reports the number tasks deleted.
 func (i *Inspector) DeleteAllPendingTasks(queue string) (int, error) {
@@ -579,6 +790,7 @@ func (i *Inspector) DeleteTask(queue, id string) error {
 	if err := base.ValidateQueueName(queue); err != nil {
 		return fmt.Errorf("asynq_learn: %v", err)
 	}
+	typename := i.taskType(queue, id)
 	err := i.rdb.DeleteTask(queue, id)
 	switch {
 	case errors.IsQueueNotFound(err):
@@ -588,6 +800,7 @@ func (i *Inspector) DeleteTask(queue, id string) error {
 	case err != nil:
 		return fmt.Errorf("asynq_learn: %v", err)
 	}
+	i.recordEvent(base.TaskEventDeleted, queue, id, typename)
 	return nil
 
 }
@@ -706,6 +919,7 @@ func (i *Inspector) ArchiveTask(queue, id string) error {
 	if err := base.ValidateQueueName(queue); err != nil {
 		return fmt.Errorf("asynq_learn: err")
 	}
+	typename := i.taskType(queue, id)
 	err := i.rdb.ArchiveTask(queue, id)
 	switch {
 	case errors.IsQueueNotFound(err):
@@ -715,9 +929,32 @@ func (i *Inspector) ArchiveTask(queue, id string) error {
 	case err != nil:
 		return fmt.Errorf("asynq_learn: %v", err)
 	}
+	i.recordEvent(base.TaskEventArchived, queue, id, typename)
 	return nil
 }
 
+// taskType returns the type of the task with the given id in the queue,
+// or empty string if the task cannot be read.
+func (i *Inspector) taskType(queue, id string) string {
+	info, err := i.rdb.GetTaskInfo(queue, id)
+	if err != nil {
+		return ""
+	}
+	return info.Message.Type
+}
+
+// recordEvent records the lifecycle event of the given task to the event stream.
+// The task is already deleted or archived at this point, so a failure to record the event is ignored.
+func (i *Inspector) recordEvent(kind base.TaskEventKind, queue, id, typename string) {
+	i.rdb.RecordEvent(&base.TaskEvent{
+		Kind:   kind,
+		TaskID: id,
+		Queue:  queue,
+		Type:   typename,
+		Time:   time.Now(),
+	})
+}
+
 // CancelProcessing sends a signal to cancel processing of the task
 // given a task id. CancelProcessing is best-effort, which means that it does not
 // guarantee that the task with the given id will be canceled. The return
@@ -746,6 +983,37 @@ func (i *Inspector) UnpauseQueue(queue string) error {
 	return i.rdb.Unpause(queue)
 }
 
+// SetServerQueues changes the queues processed by the running server with the given ID,
+// along with their priorities and the strict priority mode, without redeploying the server.
+//
+// The change is delivered to the server over redis and applied as Server.UpdateConfig does.
+// It is not persisted, so the server goes back to its Config when it is restarted.
+// If no running server with the given ID receives the change, SetServerQueues returns ErrServerNotFound.
+func (i *Inspector) SetServerQueues(serverID string, queues map[string]int, strictPriority bool) error {
+	if len(queues) == 0 {
+		return fmt.Errorf("asynq_learn: queues must not be empty")
+	}
+	for qname, p := range queues {
+		if err := base.ValidateQueueName(qname); err != nil {
+			return err
+		}
+		if p <= 0 {
+			return fmt.Errorf("asynq_learn: priority of queue %q must be positive, got %d", qname, p)
+		}
+	}
+	n, err := i.rdb.PublishServerConfig(serverID, &base.ServerConfigUpdate{
+		Queues:         queues,
+		StrictPriority: &strictPriority,
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: id=%q", ErrServerNotFound, serverID)
+	}
+	return nil
+}
+
 // Servers return a list of running servers' information.
 func (i *Inspector) Servers() ([]*ServerInfo, error) {
 	servers, err := i.rdb.ListServers()
@@ -1011,3 +1279,179 @@ func (i *Inspector) ListSchedulerEnqueueEvents(entryID string, opts ...ListOptio
 	}
 	return events, nil
 }
+
+// TaskEventKind denotes the kind of a task lifecycle event.
+type TaskEventKind string
+
+const (
+	// Indicates that the task was enqueued to be processed immediately,
+	// or to wait for its dependencies, group or ordering key.
+	TaskEventEnqueued TaskEventKind = "enqueued"
+
+	// Indicates that the task was scheduled to be processed in the future.
+	TaskEventScheduled TaskEventKind = "scheduled"
+
+	// Indicates that a server started processing the task.
+	TaskEventStarted TaskEventKind = "started"
+
+	// Indicates that the task was processed successfully.
+	TaskEventSucceeded TaskEventKind = "succeeded"
+
+	// Indicates that the processing of the task failed.
+	TaskEventFailed TaskEventKind = "failed"
+
+	// Indicates that the task was scheduled to be retried.
+	TaskEventRetried TaskEventKind = "retried"
+
+	// Indicates that the task stopped waiting and became pending, since the tasks
+	// it depends on completed or the previous task with its ordering key finished.
+	TaskEventReleased TaskEventKind = "released"
+
+	// Indicates that the task was archived by a server, e.g. once it expired or a task it
+	// depends on was archived, or by Inspector.ArchiveTask and Inspector.ArchiveAll*Tasks.
+	TaskEventArchived TaskEventKind = "archived"
+
+	// Indicates that the task was deleted by Inspector.DeleteTask or Inspector.DeleteAll*Tasks.
+	TaskEventDeleted TaskEventKind = "deleted"
+
+	// Indicates that the processing of the task was canceled, e.g. by Inspector.CancelProcessing.
+	// It takes the place of TaskEventFailed, and is followed by TaskEventRetried or TaskEventArchived.
+	TaskEventCancelled TaskEventKind = "cancelled"
+)
+
+// TaskEvent describes a change in the lifecycle of a task.
+type TaskEvent struct {
+	// ID of the event in the event stream.
+	// Events are ordered by their IDs, and the ID can be used as EventFilter.After
+	// to resume reading the events.
+	ID string
+
+	// Kind of the event.
+	Kind TaskEventKind
+
+	// ID, queue name and type name of the task.
+	TaskID string
+	Queue  string
+	Type   string
+
+	// ID of the server which processed the task.
+	// Empty if the event was not recorded by a server, e.g. TaskEventEnqueued.
+	ServerID string
+
+	// Time the event occurred.
+	Time time.Time
+}
+
+// EventFilter specifies which events are delivered by Inspector.SubscribeEvents.
+// An empty field matches all events.
+type EventFilter struct {
+	// Queues delivers only the events of the tasks in the given queues.
+	Queues []string
+
+	// Kinds delivers only the events of the given kinds.
+	Kinds []TaskEventKind
+
+	// Types delivers only the events of the tasks of the given types.
+	Types []string
+
+	// After delivers only the events recorded after the event with the given ID.
+	// Use "0" to deliver all events kept in the stream.
+	//
+	// By default, only the events recorded after the call to SubscribeEvents are delivered.
+	After string
+}
+
+func (f *EventFilter) match(ev *base.TaskEvent) bool {
+	if len(f.Queues) > 0 && !containsString(f.Queues, ev.Queue) {
+		return false
+	}
+	if len(f.Types) > 0 && !containsString(f.Types, ev.Type) {
+		return false
+	}
+	if len(f.Kinds) == 0 {
+		return true
+	}
+	for _, k := range f.Kinds {
+		if string(k) == string(ev.Kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// eventReadBatchSize is the maximum number of events read from the event stream at a time.
+const eventReadBatchSize = 100
+
+// SubscribeEvents returns a channel which delivers the task lifecycle events matching the filter,
+// in the order they were recorded. The channel is closed when ctx is done.
+//
+// The events are kept in a capped stream, which holds the latest 10,000 events approximately,
+// so a subscriber which falls too far behind misses the events dropped from the stream.
+// The stream is best-effort: events are recorded after the state of the task has changed,
+// so an event is lost if recording it fails, e.g. when the connection to redis is lost.
+// Errors reading the stream are retried until ctx is done.
+func (i *Inspector) SubscribeEvents(ctx context.Context, filter EventFilter) (<-chan *TaskEvent, error) {
+	after := filter.After
+	if after == "" {
+		id, err := i.rdb.LastEventID()
+		if err != nil {
+			return nil, fmt.Errorf("asynq_learn: %v", err)
+		}
+		after = id
+	}
+	// Read without blocking to report an invalid filter before returning.
+	events, err := i.rdb.ReadEvents(ctx, after, eventReadBatchSize, -1)
+	if err != nil {
+		return nil, fmt.Errorf("asynq_learn: %v", err)
+	}
+	ch := make(chan *TaskEvent)
+	go func() {
+		defer close(ch)
+		for {
+			for _, ev := range events {
+				after = ev.ID
+				if !filter.match(ev) {
+					continue
+				}
+				select {
+				case ch <- newTaskEvent(ev):
+				case <-ctx.Done():
+					return
+				}
+			}
+			events, err = i.rdb.ReadEvents(ctx, after, eventReadBatchSize, time.Second)
+			if err != nil {
+				select {
+				case <-time.After(time.Second):
+				case <-ctx.Done():
+					return
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func newTaskEvent(ev *base.TaskEvent) *TaskEvent {
+	return &TaskEvent{
+		ID:       ev.ID,
+		Kind:     TaskEventKind(ev.Kind),
+		TaskID:   ev.TaskID,
+		Queue:    ev.Queue,
+		Type:     ev.Type,
+		ServerID: ev.ServerID,
+		Time:     ev.Time,
+	}
+}
@@ -484,6 +484,9 @@ func TestInspectorGetTaskInfo(t *testing.T) {
 	fiveMinsFromNow := now.Add(5 * time.Minute)
 	oneHourFromNow := now.Add(1 * time.Hour)
 	twoHoursAgo := now.Add(-2 * time.Hour)
+	m3.Attempts = []*base.TaskAttempt{
+		{StartTime: twoHoursAgo, Duration: time.Second, ServerID: "server1", Host: "host1", PID: 1234, Outcome: base.AttemptFailed, ErrorMsg: "panic: oops", Panic: true},
+	}
 
 	fixtures := struct {
 		active    map[string][]*base.TaskMessage
@@ -577,6 +580,10 @@ func TestInspectorGetTaskInfo(t *testing.T) {
 		},
 	}
 
+	tests[2].want.Attempts = []*TaskAttempt{
+		{StartTime: twoHoursAgo, Duration: time.Second, ServerID: "server1", Host: "host1", PID: 1234, Outcome: AttemptFailed, ErrorMsg: "panic: oops", Panic: true},
+	}
+
 	inspector := NewInspector(getRedisConnOpt(t))
 	for _, tc := range tests {
 		got, err := inspector.GetTaskInfo(tc.qname, tc.id)
@@ -1121,6 +1128,40 @@ func TestInspectorListCompletedTasks(t *testing.T) {
 	}
 }
 
+func TestInspectorListWaitingTasks(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	client := NewClient(getRedisConnOpt(t))
+	defer client.Close()
+	inspector := NewInspector(getRedisConnOpt(t))
+
+	if _, err := client.Enqueue(NewTask("parent", nil), TaskID("parent")); err != nil {
+		t.Fatal(err)
+	}
+	child, err := client.Enqueue(NewTask("child", nil), TaskID("child"), DependsOn("parent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := inspector.ListWaitingTasks("default")
+	if err != nil {
+		t.Fatalf("ListWaitingTasks(%q) returned error: %v", "default", err)
+	}
+	want := []*TaskInfo{child}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(TaskInfo{})); diff != "" {
+		t.Errorf("ListWaitingTasks(%q) = %v, want %v; (-want,+got)\n%s", "default", got, want, diff)
+	}
+
+	info, err := inspector.GetQueueInfo("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Waiting != 1 || info.Pending != 1 || info.Size != 2 {
+		t.Errorf("GetQueueInfo returned Waiting=%d Pending=%d Size=%d, want 1, 1, 2",
+			info.Waiting, info.Pending, info.Size)
+	}
+}
+
 func TestInspectorListAggregatingTasks(t *testing.T) {
 	r := setup(t)
 	defer r.Close()
@@ -3523,3 +3564,140 @@ func TestInspectorGroups(t *testing.T) {
 		})
 	}
 }
+
+func TestInspectorListFairnessKeys(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	redisConnOpt := getRedisConnOpt(t)
+	inspector := NewInspector(redisConnOpt)
+	defer inspector.Close()
+	client := NewClient(redisConnOpt)
+	defer client.Close()
+
+	for _, key := range []string{"tenant2", "tenant1", "tenant2", ""} {
+		opts := []Option{Queue("custom")}
+		if key != "" {
+			opts = append(opts, FairnessKey(key))
+		}
+		if _, err := client.Enqueue(NewTask("send_email", nil), opts...); err != nil {
+			t.Fatalf("could not enqueue a task: %v", err)
+		}
+	}
+
+	got, err := inspector.ListFairnessKeys("custom")
+	if err != nil {
+		t.Fatalf("ListFairnessKeys returned error: %v", err)
+	}
+	want := []*FairnessKeyInfo{
+		{Key: "tenant1", Pending: 1},
+		{Key: "tenant2", Pending: 2},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ListFairnessKeys = %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+
+	if _, err := inspector.ListFairnessKeys("nonexistent"); !errors.Is(err, ErrQueueNotFound) {
+		t.Errorf("ListFairnessKeys for nonexistent queue returned %v, want %v", err, ErrQueueNotFound)
+	}
+}
+
+func TestInspectorGetAndReleaseUniqueLock(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	redisConnOpt := getRedisConnOpt(t)
+	inspector := NewInspector(redisConnOpt)
+	defer inspector.Close()
+	client := NewClient(redisConnOpt)
+	defer client.Close()
+
+	info, err := client.Enqueue(NewTask("send_email", nil), Queue("custom"), UniqueBy("user:123", time.Hour))
+	if err != nil {
+		t.Fatalf("could not enqueue a task: %v", err)
+	}
+
+	got, err := inspector.GetUniqueLock("custom", "user:123")
+	if err != nil {
+		t.Fatalf("GetUniqueLock returned error: %v", err)
+	}
+	want := &UniqueLockInfo{Queue: "custom", Key: "user:123", TaskID: info.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if diff := cmp.Diff(want, got, cmpopts.EquateApproxTime(2*time.Second)); diff != "" {
+		t.Errorf("GetUniqueLock = %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+
+	if err := inspector.ReleaseUniqueLock("custom", "user:123"); err != nil {
+		t.Fatalf("ReleaseUniqueLock returned error: %v", err)
+	}
+	if _, err := inspector.GetUniqueLock("custom", "user:123"); !errors.Is(err, ErrUniqueLockNotFound) {
+		t.Errorf("GetUniqueLock after release returned %v, want %v", err, ErrUniqueLockNotFound)
+	}
+	if err := inspector.ReleaseUniqueLock("custom", "user:123"); !errors.Is(err, ErrUniqueLockNotFound) {
+		t.Errorf("ReleaseUniqueLock after release returned %v, want %v", err, ErrUniqueLockNotFound)
+	}
+	if _, err := inspector.GetUniqueLock("nonexistent", "user:123"); !errors.Is(err, ErrQueueNotFound) {
+		t.Errorf("GetUniqueLock for nonexistent queue returned %v, want %v", err, ErrQueueNotFound)
+	}
+
+	// Another task with the same key can be enqueued once the lock is released.
+	if _, err := client.Enqueue(NewTask("send_email", nil), Queue("custom"), UniqueBy("user:123", time.Hour)); err != nil {
+		t.Errorf("Enqueue after releasing the lock returned error: %v", err)
+	}
+}
+
+func TestInspectorSetServerQueues(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	redisConnOpt := getRedisConnOpt(t)
+	inspector := NewInspector(redisConnOpt)
+	defer inspector.Close()
+	client := NewClient(redisConnOpt)
+	defer client.Close()
+	srv := NewServer(redisConnOpt, Config{
+		Queues:   map[string]int{"default": 1},
+		LogLevel: testLogLevel,
+	})
+	processed := make(chan string, 1)
+	handler := func(ctx context.Context, task *Task) error {
+		processed <- string(task.Payload())
+		return nil
+	}
+	if err := srv.Start(HandlerFunc(handler)); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+
+	var serverID string
+	for deadline := time.Now().Add(2 * time.Second); serverID == "" && time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		servers, err := inspector.Servers()
+		if err != nil {
+			t.Fatalf("Servers() returned error: %v", err)
+		}
+		if len(servers) == 1 {
+			serverID = servers[0].ID
+		}
+	}
+	if serverID == "" {
+		t.Fatal("server did not write its server info")
+	}
+
+	if err := inspector.SetServerQueues(uuid.NewString(), map[string]int{"low": 1}, false); !errors.Is(err, ErrServerNotFound) {
+		t.Errorf("SetServerQueues with unknown server ID returned %v, want %v", err, ErrServerNotFound)
+	}
+	if err := inspector.SetServerQueues(serverID, map[string]int{"low": 0}, false); err == nil {
+		t.Errorf("SetServerQueues with non-positive priority returned nil, want error")
+	}
+	if err := inspector.SetServerQueues(serverID, map[string]int{"low": 1, "default": 2}, true); err != nil {
+		t.Fatalf("SetServerQueues returned error: %v", err)
+	}
+
+	if _, err := client.Enqueue(NewTask("send_email", []byte("low")), Queue("low")); err != nil {
+		t.Fatalf("could not enqueue a task: %v", err)
+	}
+	select {
+	case got := <-processed:
+		if got != "low" {
+			t.Errorf("processed task with payload %q, want %q", got, "low")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("task in the queue set with SetServerQueues was not processed")
+	}
+}
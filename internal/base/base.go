@@ -14,7 +14,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/hibiken/asynq/internal/errors"
 	pb "github.com/hibiken/asynq/internal/proto"
@@ -38,6 +37,7 @@ const (
 	AllSchedulers = "asynq_learn:schedulers" // ZSET
 	AllQueues     = "asynq_learn:queues"     // SET 全局集合
 	CancelChannel = "asynq_learn:cancel"     // PubSub channel
+	AllEvents     = "asynq_learn:events"     // STREAM
 )
 
 // TaskState denotes the state of a task.
@@ -51,6 +51,7 @@ const (
 	TaskStateArchived
 	TaskStateCompleted
 	TaskStateAggregating // describes a state where task is waiting in a group to be aggregated 描述任务在组中等待聚合的状态
+	TaskStateWaiting     // describes a state where task is waiting for its dependencies to complete
 )
 
 func (s TaskState) String() string {
@@ -69,6 +70,8 @@ func (s TaskState) String() string {
 		return "completed"
 	case TaskStateAggregating:
 		return "aggregating"
+	case TaskStateWaiting:
+		return "waiting"
 	}
 	panic(fmt.Sprintf("internal error: unknown task state %d", s))
 }
@@ -89,6 +92,8 @@ func TaskStateFromString(s string) (TaskState, error) {
 		return TaskStateCompleted, nil
 	case "aggregating":
 		return TaskStateAggregating, nil
+	case "waiting":
+		return TaskStateWaiting, nil
 	}
 	return 0, errors.E(errors.FailedPrecondition, fmt.Sprintf("%q is not supported task state", s))
 }
@@ -151,6 +156,53 @@ func CompletedKey(qname string) string {
 	return fmt.Sprintf("%scompleted", QueueKeyPrefix(qname))
 }
 
+// WaitingKey returns a redis key for the tasks waiting for their dependencies.
+func WaitingKey(qname string) string {
+	return fmt.Sprintf("%swaiting", QueueKeyPrefix(qname))
+}
+
+// BlockedKey returns a redis key for the waiting tasks which depend on
+// a task that was archived or deleted, and are to be archived by the janitor.
+func BlockedKey(qname string) string {
+	return fmt.Sprintf("%sblocked", QueueKeyPrefix(qname))
+}
+
+// ExpiringKey returns a redis key for the tasks with an expiration time,
+// scored by the expiration time in Unix time.
+func ExpiringKey(qname string) string {
+	return fmt.Sprintf("%sexpiring", QueueKeyPrefix(qname))
+}
+
+// ChainKey returns a redis key for the chain with the given id.
+func ChainKey(qname, chainID string) string {
+	return fmt.Sprintf("%schain:%s", QueueKeyPrefix(qname), chainID)
+}
+
+// RateLimitKey returns a redis key for the token bucket used to rate limit the given queue.
+func RateLimitKey(qname string) string {
+	return fmt.Sprintf("%sratelimit", QueueKeyPrefix(qname))
+}
+
+// WakeupChannel returns a redis pubsub channel which is notified when tasks are added to the pending list of the given queue.
+func WakeupChannel(qname string) string {
+	return fmt.Sprintf("%swakeup", QueueKeyPrefix(qname))
+}
+
+// ScheduleChannel returns a redis pubsub channel which is notified when tasks are added to the scheduled or retry set of the given queue.
+func ScheduleChannel(qname string) string {
+	return fmt.Sprintf("%sschedule_wakeup", QueueKeyPrefix(qname))
+}
+
+// CompletionChannelPrefix returns a prefix for the completion channels of the given queue.
+func CompletionChannelPrefix(qname string) string {
+	return fmt.Sprintf("%scompletion:", QueueKeyPrefix(qname))
+}
+
+// CompletionChannel returns a redis pubsub channel which is notified when the given task is completed or archived.
+func CompletionChannel(qname, id string) string {
+	return CompletionChannelPrefix(qname) + id
+}
+
 // PausedKey returns a redis key to indicate that the given queue is paused.
 func PausedKey(qname string) string {
 	return fmt.Sprintf("%spaused", QueueKeyPrefix(qname))
@@ -181,6 +233,11 @@ func ServerInfoKey(hostname string, pid int, serverID string) string {
 	return fmt.Sprintf("asynq_learn:servers:{%s:%d:%s}", hostname, pid, serverID)
 }
 
+// ServerConfigChannel returns a redis pubsub channel to publish configuration updates to the given server.
+func ServerConfigChannel(serverID string) string {
+	return fmt.Sprintf("asynq_learn:servers:{%s}:config", serverID)
+}
+
 // WorkersKey returns a redis key for the workers given hostname, pid, and server ID.
 func WorkersKey(hostname string, pid int, serverID string) string {
 	return fmt.Sprintf("asynq_learn:workers:{%s:%d:%s}", hostname, pid, serverID)
@@ -205,6 +262,16 @@ func UniqueKey(qname, tasktype string, payload []byte) string {
 	return fmt.Sprintf("%sunique:%s:%s", QueueKeyPrefix(qname), tasktype, hex.EncodeToString(checksum[:]))
 }
 
+// UniqueByKey returns a redis key for the uniqueness lock with the given key chosen by the caller.
+func UniqueByKey(qname, key string) string {
+	return fmt.Sprintf("%sunique_by:%s", QueueKeyPrefix(qname), key)
+}
+
+// DebounceKey returns a redis key which holds the ID of the task debounced with the given key.
+func DebounceKey(qname, key string) string {
+	return fmt.Sprintf("%sdebounce:%s", QueueKeyPrefix(qname), key)
+}
+
 // GroupKeyPrefix returns a prefix for group key.
 func GroupKeyPrefix(qname string) string {
 	return fmt.Sprintf("%sg:", QueueKeyPrefix(qname))
@@ -225,6 +292,55 @@ func AllGroups(qname string) string {
 	return fmt.Sprintf("%sgroups", QueueKeyPrefix(qname))
 }
 
+// FairnessKeysKey returns a redis key used to store the fairness keys of the pending tasks
+// in a given queue, in the order they are served.
+func FairnessKeysKey(qname string) string {
+	return fmt.Sprintf("%sfairness_keys", QueueKeyPrefix(qname))
+}
+
+// FairnessKeyPrefix returns a prefix for fairness key.
+func FairnessKeyPrefix(qname string) string {
+	return fmt.Sprintf("%sfairness:", QueueKeyPrefix(qname))
+}
+
+// FairnessKey returns a redis key used to list the pending tasks with the given fairness key.
+func FairnessKey(qname, fkey string) string {
+	return fmt.Sprintf("%s%s", FairnessKeyPrefix(qname), fkey)
+}
+
+// PrioritiesKey returns a redis key used to store the priorities of the pending tasks
+// in a given queue.
+func PrioritiesKey(qname string) string {
+	return fmt.Sprintf("%spriorities", QueueKeyPrefix(qname))
+}
+
+// PriorityKeyPrefix returns a prefix for priority key.
+func PriorityKeyPrefix(qname string) string {
+	return fmt.Sprintf("%spriority:", QueueKeyPrefix(qname))
+}
+
+// PriorityKey returns a redis key used to list the pending tasks with the given priority.
+func PriorityKey(qname string, priority int) string {
+	return fmt.Sprintf("%s%d", PriorityKeyPrefix(qname), priority)
+}
+
+// AllOrderingKeys returns a redis key used to store the ordering keys of the unfinished tasks
+// in a given queue.
+func AllOrderingKeys(qname string) string {
+	return fmt.Sprintf("%sordering_keys", QueueKeyPrefix(qname))
+}
+
+// OrderingKeyPrefix returns a prefix for ordering key.
+func OrderingKeyPrefix(qname string) string {
+	return fmt.Sprintf("%sordering:", QueueKeyPrefix(qname))
+}
+
+// OrderingKey returns a redis key used to list the unfinished tasks with the given ordering key
+// in the order they are processed.
+func OrderingKey(qname, okey string) string {
+	return fmt.Sprintf("%s%s", OrderingKeyPrefix(qname), okey)
+}
+
 // AllAggregationSets returns a redis key used to store all aggregation sets (set of tasks staged to be aggregated)
 // in a given queue.
 func AllAggregationSets(qname string) string {
@@ -294,52 +410,276 @@ type TaskMessage struct {
 	//
 	// Use zero to indicate no value.
 	CompletedAt int64
+
+	// Dependencies holds the IDs of the tasks in the same queue which need to
+	// complete before this task can be processed.
+	//
+	// Empty slice indicates that the task has no dependencies.
+	Dependencies []string
+
+	// ChainID is the ID of the chain the task belongs to.
+	//
+	// Empty string indicates that the task is not part of a chain.
+	ChainID string
+
+	// ChainStep is the zero-based position of the task in its chain.
+	ChainStep int
+
+	// Headers holds arbitrary metadata associated with the task.
+	//
+	// Nil map indicates that the task has no headers.
+	Headers map[string]string
+
+	// FairnessKey is the key used to share processing fairly among the tasks in the queue.
+	// Pending tasks of different fairness keys are dequeued in round-robin order.
+	//
+	// Empty string indicates that the task has no fairness key.
+	FairnessKey string
+
+	// Priority is the priority of the task within the queue.
+	// Pending tasks with higher priority are dequeued before the ones with lower priority.
+	//
+	// Zero indicates that the task has the default priority, which is the lowest.
+	Priority int
+
+	// OrderingKey is the key of the tasks to be processed one at a time, in the order they are enqueued.
+	// A task with an ordering key stays waiting until the previous task with the key is done.
+	//
+	// Empty string indicates that the task has no ordering key.
+	OrderingKey string
+
+	// ExpireAt is the expiration time of the task in Unix time,
+	// the number of seconds elapsed since January 1, 1970 UTC.
+	// A pending, scheduled or retry task is archived without being processed once it expires.
+	//
+	// Use zero to indicate that the task never expires.
+	ExpireAt int64
+
+	// Attempts holds the latest attempts to process the task, oldest first.
+	// At most MaxTaskAttempts attempts are kept.
+	Attempts []*TaskAttempt
+
+	// RetryPolicy controls how the task is retried when processing fails.
+	//
+	// nil indicates that the server's RetryDelayFunc is used.
+	RetryPolicy *RetryPolicy
+
+	// FirstAttemptedAt is the time the first attempt to process the task started in Unix time,
+	// the number of seconds elapsed since January 1, 1970 UTC.
+	//
+	// Use zero to indicate that the task has not been processed yet.
+	FirstAttemptedAt int64
+}
+
+// Backoff kinds of a retry policy.
+const (
+	BackoffExponential = iota
+	BackoffLinear
+	BackoffFixed
+)
+
+// RetryPolicy holds the retry settings of a task.
+type RetryPolicy struct {
+	// Backoff is the backoff kind, one of the Backoff* constants.
+	Backoff int
+
+	// BaseDelay is the delay before the first retry.
+	BaseDelay time.Duration
+
+	// MaxDelay is the upper bound of the delay, zero means no bound.
+	MaxDelay time.Duration
+
+	// Jitter is the fraction of the delay to randomize by, between 0 and 1.
+	Jitter float64
+
+	// MaxElapsed is the maximum time since the first attempt during which the task is retried,
+	// zero means no limit.
+	MaxElapsed time.Duration
+
+	// NonRetryable lists the kinds of errors for which the task is archived without being retried.
+	NonRetryable []string
+}
+
+// MaxTaskAttempts is the maximum number of attempts kept in a task message.
+const MaxTaskAttempts = 20
+
+// Outcomes of an attempt to process a task.
+const (
+	AttemptSucceeded    = "succeeded"
+	AttemptFailed       = "failed"
+	AttemptCancelled    = "cancelled"
+	AttemptTimedOut     = "timed_out"
+	AttemptLeaseExpired = "lease_expired"
+	AttemptSnoozed      = "snoozed"
+)
+
+// TaskAttempt holds information about an attempt to process a task.
+type TaskAttempt struct {
+	StartTime time.Time
+	Duration  time.Duration
+
+	// Server which processed the task.
+	ServerID string
+	Host     string
+	PID      int
+
+	// Outcome of the attempt, one of the Attempt* constants.
+	Outcome string
+
+	// ErrorMsg is the error message of the attempt, empty if the attempt succeeded.
+	ErrorMsg string
+
+	// Panic reports whether the error was caused by a panic in the handler.
+	Panic bool
+}
+
+// AddAttempt appends the attempt to msg.Attempts, dropping the oldest attempts
+// so that at most MaxTaskAttempts attempts are kept.
+// The slice is copied so that the messages sharing it are not affected.
+func (msg *TaskMessage) AddAttempt(a *TaskAttempt) {
+	attempts := append(msg.Attempts[:len(msg.Attempts):len(msg.Attempts)], a)
+	if len(attempts) > MaxTaskAttempts {
+		attempts = attempts[len(attempts)-MaxTaskAttempts:]
+	}
+	msg.Attempts = attempts
 }
 
+// ExpiredErrMsg is the error message of the tasks archived because they expired
+// before being processed.
+const ExpiredErrMsg = "expired"
+
+// BlockedErrMsg is the error message of the waiting tasks archived because
+// a task they depend on was archived or deleted.
+const BlockedErrMsg = "dependency archived or deleted"
+
 // EncodeMessage marshals the given task message and returns an encoded bytes.
 func EncodeMessage(msg *TaskMessage) ([]byte, error) {
 	if msg == nil {
 		return nil, fmt.Errorf("cannot encode nil message")
 	}
+	var attempts []*pb.TaskAttempt
+	for _, a := range msg.Attempts {
+		started, err := ptypes.TimestampProto(a.StartTime)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, &pb.TaskAttempt{
+			StartTime: started,
+			Duration:  a.Duration.Nanoseconds(),
+			ServerId:  a.ServerID,
+			Host:      a.Host,
+			Pid:       int32(a.PID),
+			Outcome:   a.Outcome,
+			ErrorMsg:  a.ErrorMsg,
+			Panic:     a.Panic,
+		})
+	}
 	return proto.Marshal(&pb.TaskMessage{
-		Type:         msg.Type,
-		Payload:      msg.Payload,
-		Id:           msg.ID,
-		Queue:        msg.Queue,
-		Retry:        int32(msg.Retry),
-		Retried:      int32(msg.Retried),
-		ErrorMsg:     msg.ErrorMsg,
-		LastFailedAt: msg.LastFailedAt,
-		Timeout:      msg.Timeout,
-		Deadline:     msg.Deadline,
-		UniqueKey:    msg.UniqueKey,
-		GroupKey:     msg.GroupKey,
-		Retention:    msg.Retention,
-		CompletedAt:  msg.CompletedAt,
+		Type:             msg.Type,
+		Payload:          msg.Payload,
+		Id:               msg.ID,
+		Queue:            msg.Queue,
+		Retry:            int32(msg.Retry),
+		Retried:          int32(msg.Retried),
+		ErrorMsg:         msg.ErrorMsg,
+		LastFailedAt:     msg.LastFailedAt,
+		Timeout:          msg.Timeout,
+		Deadline:         msg.Deadline,
+		UniqueKey:        msg.UniqueKey,
+		GroupKey:         msg.GroupKey,
+		Retention:        msg.Retention,
+		CompletedAt:      msg.CompletedAt,
+		Dependencies:     msg.Dependencies,
+		ChainId:          msg.ChainID,
+		ChainStep:        int32(msg.ChainStep),
+		Headers:          msg.Headers,
+		FairnessKey:      msg.FairnessKey,
+		Priority:         int32(msg.Priority),
+		OrderingKey:      msg.OrderingKey,
+		ExpireAt:         msg.ExpireAt,
+		Attempts:         attempts,
+		RetryPolicy:      retryPolicyToProto(msg.RetryPolicy),
+		FirstAttemptedAt: msg.FirstAttemptedAt,
 	})
 }
 
+func retryPolicyToProto(p *RetryPolicy) *pb.RetryPolicy {
+	if p == nil {
+		return nil
+	}
+	return &pb.RetryPolicy{
+		Backoff:      int32(p.Backoff),
+		BaseDelay:    p.BaseDelay.Nanoseconds(),
+		MaxDelay:     p.MaxDelay.Nanoseconds(),
+		Jitter:       p.Jitter,
+		MaxElapsed:   p.MaxElapsed.Nanoseconds(),
+		NonRetryable: p.NonRetryable,
+	}
+}
+
+func retryPolicyFromProto(p *pb.RetryPolicy) *RetryPolicy {
+	if p == nil {
+		return nil
+	}
+	return &RetryPolicy{
+		Backoff:      int(p.GetBackoff()),
+		BaseDelay:    time.Duration(p.GetBaseDelay()),
+		MaxDelay:     time.Duration(p.GetMaxDelay()),
+		Jitter:       p.GetJitter(),
+		MaxElapsed:   time.Duration(p.GetMaxElapsed()),
+		NonRetryable: p.GetNonRetryable(),
+	}
+}
+
 // DecodeMessage unmarshals the given bytes and returns a decoded task message.
 func DecodeMessage(data []byte) (*TaskMessage, error) {
 	var pbmsg pb.TaskMessage
 	if err := proto.Unmarshal(data, &pbmsg); err != nil {
 		return nil, err
 	}
+	var attempts []*TaskAttempt
+	for _, a := range pbmsg.GetAttempts() {
+		started, err := ptypes.Timestamp(a.GetStartTime())
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, &TaskAttempt{
+			StartTime: started,
+			Duration:  time.Duration(a.GetDuration()),
+			ServerID:  a.GetServerId(),
+			Host:      a.GetHost(),
+			PID:       int(a.GetPid()),
+			Outcome:   a.GetOutcome(),
+			ErrorMsg:  a.GetErrorMsg(),
+			Panic:     a.GetPanic(),
+		})
+	}
 	return &TaskMessage{
-		Type:         pbmsg.GetType(),
-		Payload:      pbmsg.GetPayload(),
-		ID:           pbmsg.GetId(),
-		Queue:        pbmsg.GetQueue(),
-		Retry:        int(pbmsg.GetRetry()),
-		Retried:      int(pbmsg.GetRetried()),
-		ErrorMsg:     pbmsg.GetErrorMsg(),
-		LastFailedAt: pbmsg.GetLastFailedAt(),
-		Timeout:      pbmsg.GetTimeout(),
-		Deadline:     pbmsg.GetDeadline(),
-		UniqueKey:    pbmsg.GetUniqueKey(),
-		GroupKey:     pbmsg.GetGroupKey(),
-		Retention:    pbmsg.GetRetention(),
-		CompletedAt:  pbmsg.GetCompletedAt(),
+		Type:             pbmsg.GetType(),
+		Payload:          pbmsg.GetPayload(),
+		ID:               pbmsg.GetId(),
+		Queue:            pbmsg.GetQueue(),
+		Retry:            int(pbmsg.GetRetry()),
+		Retried:          int(pbmsg.GetRetried()),
+		ErrorMsg:         pbmsg.GetErrorMsg(),
+		LastFailedAt:     pbmsg.GetLastFailedAt(),
+		Timeout:          pbmsg.GetTimeout(),
+		Deadline:         pbmsg.GetDeadline(),
+		UniqueKey:        pbmsg.GetUniqueKey(),
+		GroupKey:         pbmsg.GetGroupKey(),
+		Retention:        pbmsg.GetRetention(),
+		CompletedAt:      pbmsg.GetCompletedAt(),
+		Dependencies:     pbmsg.GetDependencies(),
+		ChainID:          pbmsg.GetChainId(),
+		ChainStep:        int(pbmsg.GetChainStep()),
+		Headers:          pbmsg.GetHeaders(),
+		FairnessKey:      pbmsg.GetFairnessKey(),
+		Priority:         int(pbmsg.GetPriority()),
+		OrderingKey:      pbmsg.GetOrderingKey(),
+		ExpireAt:         pbmsg.GetExpireAt(),
+		Attempts:         attempts,
+		RetryPolicy:      retryPolicyFromProto(pbmsg.GetRetryPolicy()),
+		FirstAttemptedAt: pbmsg.GetFirstAttemptedAt(),
 	}, nil
 }
 
@@ -351,12 +691,76 @@ type TaskInfo struct {
 	Result        []byte
 }
 
+// BatchMessage is a task message to be enqueued as part of a batch.
+type BatchMessage struct {
+	Msg *TaskMessage
+
+	// ProcessAt is the time the task should be processed.
+	// Zero value indicates that the task should be pending immediately.
+	ProcessAt time.Time
+
+	// UniqueTTL is the TTL of the uniqueness lock keyed by Msg.UniqueKey.
+	// Zero value indicates that no uniqueness lock is acquired.
+	UniqueTTL time.Duration
+}
+
+// ChainInfo describes a chain of tasks and its progress.
+type ChainInfo struct {
+	ID    string
+	Queue string
+
+	// Messages holds the task messages of the steps in order.
+	Messages []*TaskMessage
+
+	// Results holds the result written by each completed step, nil if the step wrote no result.
+	Results [][]byte
+
+	// Current is the index of the step being processed.
+	// It equals len(Messages) once all steps have completed.
+	Current int
+
+	// CurrentState is the state of the task for the current step.
+	// Zero value indicates that the task does not exist (e.g. it was deleted)
+	// or that the chain has completed.
+	CurrentState TaskState
+
+	// Err is the reason why the chain cannot make progress, empty if none.
+	Err string
+}
+
+// RateLimit holds the parameters of the token bucket used to rate limit a queue.
+type RateLimit struct {
+	Limit float64 // number of tokens added to the bucket per second
+	Burst int     // maximum number of tokens in the bucket
+}
+
 // Z represents sorted set member.
 type Z struct {
 	Message *TaskMessage
 	Score   int64
 }
 
+// MinProcessAtScore is the smallest score of a task in the scheduled or retry set
+// written in milliseconds. Scores written by older versions in seconds are smaller.
+const MinProcessAtScore = 1e11
+
+// ProcessAtScore returns the score of a task in the scheduled or retry set
+// which is to be processed at t, i.e. Unix time in milliseconds.
+func ProcessAtScore(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+// ProcessAtFromScore returns the time at which a task in the scheduled or retry set
+// is to be processed given its score.
+//
+// Scores less than MinProcessAtScore were written by older versions in Unix time in seconds.
+func ProcessAtFromScore(score int64) time.Time {
+	if score < MinProcessAtScore {
+		return time.Unix(score, 0)
+	}
+	return time.UnixMilli(score)
+}
+
 // ServerInfo holds information about a running server.
 type ServerInfo struct {
 	Host              string
@@ -396,6 +800,56 @@ func EncodeServerInfo(info *ServerInfo) ([]byte, error) {
 	})
 }
 
+// ServerConfigUpdate holds changes to the runtime configuration of a running server.
+type ServerConfigUpdate struct {
+	// Concurrency is the new maximum number of concurrency, zero means unchanged.
+	Concurrency int
+	// Queues is the new list of queue names with their priorities, empty means unchanged.
+	Queues map[string]int
+	// StrictPriority is the new strict priority mode, nil means unchanged.
+	StrictPriority *bool
+}
+
+// EncodeServerConfigUpdate marshals the given ServerConfigUpdate and returns an encoded bytes.
+func EncodeServerConfigUpdate(update *ServerConfigUpdate) ([]byte, error) {
+	if update == nil {
+		return nil, fmt.Errorf("cannot encode nil server config update")
+	}
+	queues := make(map[string]int32)
+	for q, p := range update.Queues {
+		queues[q] = int32(p)
+	}
+	pbmsg := &pb.ServerConfigUpdate{
+		Concurrency: int32(update.Concurrency),
+		Queues:      queues,
+	}
+	if update.StrictPriority != nil {
+		pbmsg.StrictPriority = *update.StrictPriority
+		pbmsg.SetStrictPriority = true
+	}
+	return proto.Marshal(pbmsg)
+}
+
+// DecodeServerConfigUpdate decodes the given bytes into ServerConfigUpdate.
+func DecodeServerConfigUpdate(b []byte) (*ServerConfigUpdate, error) {
+	var pbmsg pb.ServerConfigUpdate
+	if err := proto.Unmarshal(b, &pbmsg); err != nil {
+		return nil, err
+	}
+	update := &ServerConfigUpdate{Concurrency: int(pbmsg.GetConcurrency())}
+	if len(pbmsg.GetQueues()) > 0 {
+		update.Queues = make(map[string]int)
+		for q, p := range pbmsg.GetQueues() {
+			update.Queues[q] = int(p)
+		}
+	}
+	if pbmsg.GetSetStrictPriority() {
+		strict := pbmsg.GetStrictPriority()
+		update.StrictPriority = &strict
+	}
+	return update, nil
+}
+
 // DecodeServerInfo decodes the given bytes into ServerInfo.
 func DecodeServerInfo(b []byte) (*ServerInfo, error) {
 	var pbmsg pb.ServerInfo
@@ -710,6 +1164,105 @@ func (l *Lease) IsValid() bool {
 	return l.expireAt.After(now) || l.expireAt.Equal(now)
 }
 
+// TaskEventKind denotes the kind of a task lifecycle event.
+type TaskEventKind string
+
+const (
+	TaskEventEnqueued  TaskEventKind = "enqueued"
+	TaskEventScheduled TaskEventKind = "scheduled"
+	TaskEventStarted   TaskEventKind = "started"
+	TaskEventSucceeded TaskEventKind = "succeeded"
+	TaskEventFailed    TaskEventKind = "failed"
+	TaskEventRetried   TaskEventKind = "retried"
+	TaskEventReleased  TaskEventKind = "released"
+	TaskEventArchived  TaskEventKind = "archived"
+	TaskEventDeleted   TaskEventKind = "deleted"
+	TaskEventCancelled TaskEventKind = "cancelled"
+)
+
+// TaskEvent is an entry of the task lifecycle event stream (see AllEvents).
+//
+// The stream is best-effort: an event is recorded after the state of the task has changed,
+// in a separate command, so an event may be lost if recording it fails.
+// Events of the transitions made by a broker operation itself, e.g. the tasks released
+// by Broker.Done or archived by Broker.ArchiveExpiredTasks, are recorded by the broker.
+type TaskEvent struct {
+	// ID is the ID of the entry in the stream, assigned when the event is recorded.
+	ID string
+
+	Kind   TaskEventKind
+	TaskID string
+	Queue  string
+	Type   string
+
+	// ServerID is the ID of the server which processed the task,
+	// empty if the event was not recorded by a server.
+	ServerID string
+
+	Time time.Time
+}
+
+// CancelationSubscription is a subscription to cancelation messages published
+// with Broker.PublishCancelation.
+type CancelationSubscription interface {
+	// Channel returns a channel which delivers the IDs of tasks to be canceled.
+	// The channel is closed when the subscription is closed or becomes broken.
+	Channel() <-chan string
+
+	// Err returns the error which broke the subscription, if any.
+	// It should be called after the channel is closed.
+	Err() error
+
+	// Close unsubscribes from the cancelation messages and closes the channel.
+	Close() error
+}
+
+// WakeupSubscription is a subscription to the notifications published
+// when tasks are added to queues (see WakeupChannel and ScheduleChannel).
+type WakeupSubscription interface {
+	// Channel returns a channel which delivers the names of the queues to which tasks were added.
+	// Notifications may be coalesced or dropped while the receiver is busy.
+	// The channel is closed when the subscription is closed or becomes broken.
+	Channel() <-chan string
+
+	// Err returns the error which broke the subscription, if any.
+	// It should be called after the channel is closed.
+	Err() error
+
+	// Close unsubscribes from the notifications and closes the channel.
+	Close() error
+}
+
+// CompletionSubscription is a subscription to the notification published
+// when a task is completed or archived (see CompletionChannel).
+type CompletionSubscription interface {
+	// Channel returns a channel which delivers the ID of the task once it is completed or archived.
+	// The channel is closed when the subscription is closed or becomes broken.
+	Channel() <-chan string
+
+	// Err returns the error which broke the subscription, if any.
+	// It should be called after the channel is closed.
+	Err() error
+
+	// Close unsubscribes from the notification and closes the channel.
+	Close() error
+}
+
+// ServerConfigSubscription is a subscription to the configuration updates published
+// with Broker.PublishServerConfig.
+type ServerConfigSubscription interface {
+	// Channel returns a channel which delivers the configuration updates.
+	// The channel is closed when the subscription is closed or becomes broken.
+	Channel() <-chan *ServerConfigUpdate
+
+	// Err returns the error which broke the subscription, if any.
+	// It should be called after the channel is closed.
+	Err() error
+
+	// Close unsubscribes from the configuration updates and closes the channel.
+	Close() error
+}
+
 // Broker is a message broker that supports operations to manage task queues.
 //
 // See rdb.RDB as a reference implementation.
@@ -718,7 +1271,10 @@ type Broker interface {
 	Close() error
 	Enqueue(ctx context.Context, msg *TaskMessage) error
 	EnqueueUnique(ctx context.Context, msg *TaskMessage, ttl time.Duration) error
-	Dequeue(qnames ...string) (*TaskMessage, time.Time, error)
+	// Dequeue returns the dequeued message, its lease expiration time and the time it became pending.
+	Dequeue(qnames ...string) (*TaskMessage, time.Time, time.Time, error)
+	// DequeueExcept is like Dequeue but leaves the tasks of the given types in the queues.
+	DequeueExcept(excludedTypes []string, qnames ...string) (*TaskMessage, time.Time, time.Time, error)
 	Done(ctx context.Context, msg *TaskMessage) error
 	MarkAsComplete(ctx context.Context, msg *TaskMessage) error
 	Requeue(ctx context.Context, msg *TaskMessage) error
@@ -728,6 +1284,11 @@ type Broker interface {
 	Archive(ctx context.Context, msg *TaskMessage, errMsg string) error
 	ForwardIfReady(qnames ...string) error
 
+	// EnqueueBatch enqueues the given messages and returns an error for each message,
+	// nil if the message was enqueued. The second return value reports a failure
+	// which prevented the whole batch from being enqueued.
+	EnqueueBatch(ctx context.Context, msgs []*BatchMessage) ([]error, error)
+
 	// Group aggregation related methods
 	AddToGroup(ctx context.Context, msg *TaskMessage, gname string) error
 	AddToGroupUnique(ctx context.Context, msg *TaskMessage, groupKey string, ttl time.Duration) error
@@ -737,9 +1298,42 @@ type Broker interface {
 	DeleteAggregationSet(ctx context.Context, qname, gname, aggregationSetID string) error
 	ReclaimStaleAggregationSets(qname string) error
 
+	// Task dependency related methods
+	EnqueueWaiting(ctx context.Context, msg *TaskMessage, ignoreMissingDeps bool) (TaskState, error)
+	EnqueueWaitingUnique(ctx context.Context, msg *TaskMessage, ttl time.Duration, ignoreMissingDeps bool) (TaskState, error)
+	ArchiveBlockedTasks(qname string) error
+
+	// Ordering key related methods
+	ReleaseOrderingKeys(qname string) error
+
+	// Task expiration related methods
+	ArchiveExpiredTasks(qname string) error
+
+	// Debounce schedules the task to be processed at processAt, unless the task holding
+	// msg.UniqueKey is still pending or scheduled, in which case that task's message is
+	// replaced and its process time is pushed out to processAt.
+	// It returns the ID of the surviving task.
+	Debounce(ctx context.Context, msg *TaskMessage, processAt time.Time) (string, error)
+
+	// Task chain related methods
+	EnqueueChain(ctx context.Context, msgs []*TaskMessage) error
+	ReadChainResult(qname, chainID string, step int) ([]byte, error)
+
 	// Task retention related method
 	DeleteExpiredCompletedTasks(qname string) error
 
+	// Rate limiting related methods
+	//
+	// TakeRateLimitTokens atomically takes a token from the token bucket of each of the
+	// given queues which has one, and returns the duration until a token is available for
+	// the others; a zero duration means a token was taken.
+	// The bucket of a queue is refilled at the rate of Limit tokens per second up to Burst tokens.
+	//
+	// RefundRateLimitTokens puts back a token taken from the bucket of each of the given queues,
+	// e.g. when no task was dequeued from the queue.
+	TakeRateLimitTokens(limits map[string]RateLimit) (map[string]time.Duration, error)
+	RefundRateLimitTokens(limits map[string]RateLimit) error
+
 	// Lease related methods
 	ListLeaseExpired(cutoff time.Time, qnames ...string) ([]*TaskMessage, error)
 	ExtendLease(qname string, ids ...string) (time.Time, error)
@@ -749,8 +1343,34 @@ type Broker interface {
 	ClearServerState(host string, pid int, serverID string) error
 
 	// Cancelation related methods
-	CancelationPubSub() (*redis.PubSub, error) // TODO: Need to decouple from redis to support other brokers
+	SubscribeCancelation() (CancelationSubscription, error)
 	PublishCancelation(id string) error
+	// Server configuration related methods
+	SubscribeServerConfig(serverID string) (ServerConfigSubscription, error)
+	// PublishServerConfig publishes the configuration update to the given server
+	// and returns the number of subscribers which received it.
+	PublishServerConfig(serverID string, update *ServerConfigUpdate) (int, error)
+	// SubscribeWakeup subscribes to the notifications published when tasks are enqueued
+	// to the pending list of the given queues.
+	SubscribeWakeup(qnames ...string) (WakeupSubscription, error)
+	// SubscribeSchedule subscribes to the notifications published when tasks are added
+	// to the scheduled or retry set of the given queues.
+	SubscribeSchedule(qnames ...string) (WakeupSubscription, error)
+	// SubscribeCompletion subscribes to the notification published when the given task
+	// is completed or archived.
+	SubscribeCompletion(qname, id string) (CompletionSubscription, error)
+	// GetTaskInfo returns the task and its state, e.g. to read the result of a completed task.
+	GetTaskInfo(qname, id string) (*TaskInfo, error)
+	// NextProcessAt returns the earliest time at which a scheduled or retry task of the
+	// given queues is to be processed, or zero time if there is no such task.
+	NextProcessAt(qnames ...string) (time.Time, error)
+	// AllQueues returns the names of all known queues.
+	AllQueues() ([]string, error)
+	// RecordEvent appends the task lifecycle event to the event stream,
+	// dropping the oldest events once the stream is full.
+	// Callers record the events of the transitions they request, e.g. enqueueing a task;
+	// the events of the tasks moved by the broker operations are recorded by the broker.
+	RecordEvent(ev *TaskEvent) error
 
 	WriteResult(qname, id string, data []byte) (n int, err error)
 }
@@ -157,6 +157,128 @@ func TestCompletedKey(t *testing.T) {
 	}
 }
 
+func TestWaitingKey(t *testing.T) {
+	tests := []struct {
+		qname string
+		want  string
+	}{
+		{"default", "asynq_learn:{default}:waiting"},
+		{"custom", "asynq_learn:{custom}:waiting"},
+	}
+
+	for _, tc := range tests {
+		got := WaitingKey(tc.qname)
+		if got != tc.want {
+			t.Errorf("WaitingKey(%q) = %q, want %q", tc.qname, got, tc.want)
+		}
+	}
+}
+
+func TestBlockedKey(t *testing.T) {
+	tests := []struct {
+		qname string
+		want  string
+	}{
+		{"default", "asynq_learn:{default}:blocked"},
+		{"custom", "asynq_learn:{custom}:blocked"},
+	}
+
+	for _, tc := range tests {
+		got := BlockedKey(tc.qname)
+		if got != tc.want {
+			t.Errorf("BlockedKey(%q) = %q, want %q", tc.qname, got, tc.want)
+		}
+	}
+}
+
+func TestChainKey(t *testing.T) {
+	tests := []struct {
+		qname   string
+		chainID string
+		want    string
+	}{
+		{"default", "chain1", "asynq_learn:{default}:chain:chain1"},
+		{"custom", "chain2", "asynq_learn:{custom}:chain:chain2"},
+	}
+
+	for _, tc := range tests {
+		got := ChainKey(tc.qname, tc.chainID)
+		if got != tc.want {
+			t.Errorf("ChainKey(%q, %q) = %q, want %q", tc.qname, tc.chainID, got, tc.want)
+		}
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	tests := []struct {
+		qname string
+		want  string
+	}{
+		{"default", "asynq_learn:{default}:ratelimit"},
+		{"custom", "asynq_learn:{custom}:ratelimit"},
+	}
+
+	for _, tc := range tests {
+		got := RateLimitKey(tc.qname)
+		if got != tc.want {
+			t.Errorf("RateLimitKey(%q) = %q, want %q", tc.qname, got, tc.want)
+		}
+	}
+}
+
+func TestWakeupChannel(t *testing.T) {
+	tests := []struct {
+		qname string
+		want  string
+	}{
+		{"default", "asynq_learn:{default}:wakeup"},
+		{"custom", "asynq_learn:{custom}:wakeup"},
+	}
+
+	for _, tc := range tests {
+		got := WakeupChannel(tc.qname)
+		if got != tc.want {
+			t.Errorf("WakeupChannel(%q) = %q, want %q", tc.qname, got, tc.want)
+		}
+	}
+}
+
+func TestScheduleChannel(t *testing.T) {
+	tests := []struct {
+		qname string
+		want  string
+	}{
+		{"default", "asynq_learn:{default}:schedule_wakeup"},
+		{"custom", "asynq_learn:{custom}:schedule_wakeup"},
+	}
+
+	for _, tc := range tests {
+		got := ScheduleChannel(tc.qname)
+		if got != tc.want {
+			t.Errorf("ScheduleChannel(%q) = %q, want %q", tc.qname, got, tc.want)
+		}
+	}
+}
+
+func TestProcessAtFromScore(t *testing.T) {
+	now := time.Now().Truncate(time.Millisecond)
+	tests := []struct {
+		desc  string
+		score int64
+		want  time.Time
+	}{
+		{"millisecond score", ProcessAtScore(now), now},
+		{"legacy second score", now.Unix(), time.Unix(now.Unix(), 0)},
+	}
+
+	for _, tc := range tests {
+		got := ProcessAtFromScore(tc.score)
+		if !got.Equal(tc.want) {
+			t.Errorf("%s: ProcessAtFromScore(%d) = %v, want %v", tc.desc, tc.score, got, tc.want)
+		}
+	}
+}
+
 func TestPausedKey(t *testing.T) {
 	tests := []struct {
 		qname string
@@ -266,6 +388,23 @@ func TestServerInfoKey(t *testing.T) {
 	}
 }
 
+func TestServerConfigChannel(t *testing.T) {
+	tests := []struct {
+		serverID string
+		want     string
+	}{
+		{"abc123", "asynq_learn:servers:{abc123}:config"},
+		{"f06f3c5e-4c5e-4b44-b8f5-0e0c8a8d6b1a", "asynq_learn:servers:{f06f3c5e-4c5e-4b44-b8f5-0e0c8a8d6b1a}:config"},
+	}
+
+	for _, tc := range tests {
+		got := ServerConfigChannel(tc.serverID)
+		if got != tc.want {
+			t.Errorf("ServerConfigChannel(%q) = %q, want %q", tc.serverID, got, tc.want)
+		}
+	}
+}
+
 func TestWorkersKey(t *testing.T) {
 	tests := []struct {
 		hostname string
@@ -395,6 +534,146 @@ func TestUniqueKey(t *testing.T) {
 	}
 }
 
+func TestFairnessKey(t *testing.T) {
+	tests := []struct {
+		qname string
+		fkey  string
+		want  string
+	}{
+		{
+			qname: "default",
+			fkey:  "tenant1",
+			want:  "asynq_learn:{default}:fairness:tenant1",
+		},
+		{
+			qname: "custom",
+			fkey:  "",
+			want:  "asynq_learn:{custom}:fairness:",
+		},
+	}
+
+	for _, tc := range tests {
+		got := FairnessKey(tc.qname, tc.fkey)
+		if got != tc.want {
+			t.Errorf("FairnessKey(%q, %q) = %q, want %q", tc.qname, tc.fkey, got, tc.want)
+		}
+	}
+	if got, want := FairnessKeysKey("default"), "asynq_learn:{default}:fairness_keys"; got != want {
+		t.Errorf("FairnessKeysKey(%q) = %q, want %q", "default", got, want)
+	}
+}
+
+func TestPriorityKey(t *testing.T) {
+	tests := []struct {
+		qname    string
+		priority int
+		want     string
+	}{
+		{
+			qname:    "default",
+			priority: 1,
+			want:     "asynq_learn:{default}:priority:1",
+		},
+		{
+			qname:    "custom",
+			priority: 10,
+			want:     "asynq_learn:{custom}:priority:10",
+		},
+	}
+
+	for _, tc := range tests {
+		got := PriorityKey(tc.qname, tc.priority)
+		if got != tc.want {
+			t.Errorf("PriorityKey(%q, %d) = %q, want %q", tc.qname, tc.priority, got, tc.want)
+		}
+	}
+	if got, want := PrioritiesKey("default"), "asynq_learn:{default}:priorities"; got != want {
+		t.Errorf("PrioritiesKey(%q) = %q, want %q", "default", got, want)
+	}
+}
+
+func TestOrderingKey(t *testing.T) {
+	tests := []struct {
+		qname string
+		okey  string
+		want  string
+	}{
+		{
+			qname: "default",
+			okey:  "order-42",
+			want:  "asynq_learn:{default}:ordering:order-42",
+		},
+		{
+			qname: "custom",
+			okey:  "user:1",
+			want:  "asynq_learn:{custom}:ordering:user:1",
+		},
+	}
+
+	for _, tc := range tests {
+		got := OrderingKey(tc.qname, tc.okey)
+		if got != tc.want {
+			t.Errorf("OrderingKey(%q, %q) = %q, want %q", tc.qname, tc.okey, got, tc.want)
+		}
+	}
+	if got, want := AllOrderingKeys("default"), "asynq_learn:{default}:ordering_keys"; got != want {
+		t.Errorf("AllOrderingKeys(%q) = %q, want %q", "default", got, want)
+	}
+}
+
+func TestUniqueByKey(t *testing.T) {
+	tests := []struct {
+		qname string
+		key   string
+		want  string
+	}{
+		{"default", "user:123", "asynq_learn:{default}:unique_by:user:123"},
+		{"custom", "report", "asynq_learn:{custom}:unique_by:report"},
+	}
+
+	for _, tc := range tests {
+		got := UniqueByKey(tc.qname, tc.key)
+		if got != tc.want {
+			t.Errorf("UniqueByKey(%q, %q) = %q, want %q", tc.qname, tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestDebounceKey(t *testing.T) {
+	tests := []struct {
+		qname string
+		key   string
+		want  string
+	}{
+		{"default", "user:123", "asynq_learn:{default}:debounce:user:123"},
+		{"custom", "reindex", "asynq_learn:{custom}:debounce:reindex"},
+	}
+
+	for _, tc := range tests {
+		got := DebounceKey(tc.qname, tc.key)
+		if got != tc.want {
+			t.Errorf("DebounceKey(%q, %q) = %q, want %q", tc.qname, tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestExpiringKey(t *testing.T) {
+	tests := []struct {
+		qname string
+		want  string
+	}{
+		{"default", "asynq_learn:{default}:expiring"},
+		{"custom", "asynq_learn:{custom}:expiring"},
+	}
+
+	for _, tc := range tests {
+		got := ExpiringKey(tc.qname)
+		if got != tc.want {
+			t.Errorf("ExpiringKey(%q) = %q, want %q", tc.qname, got, tc.want)
+		}
+	}
+}
+
 func TestGroupKey(t *testing.T) {
 	tests := []struct {
 		qname string
@@ -528,6 +807,192 @@ func TestMessageEncoding(t *testing.T) {
 				Retention: 3600,
 			},
 		},
+		{
+			in: &TaskMessage{
+				Type:         "task2",
+				ID:           id,
+				Queue:        "default",
+				Retry:        10,
+				Timeout:      1800,
+				Dependencies: []string{"parent1", "parent2"},
+			},
+			out: &TaskMessage{
+				Type:         "task2",
+				ID:           id,
+				Queue:        "default",
+				Retry:        10,
+				Timeout:      1800,
+				Dependencies: []string{"parent1", "parent2"},
+			},
+		},
+		{
+			in: &TaskMessage{
+				Type:      "task3",
+				ID:        id,
+				Queue:     "default",
+				Retry:     10,
+				Timeout:   1800,
+				ChainID:   "chain1",
+				ChainStep: 2,
+			},
+			out: &TaskMessage{
+				Type:      "task3",
+				ID:        id,
+				Queue:     "default",
+				Retry:     10,
+				Timeout:   1800,
+				ChainID:   "chain1",
+				ChainStep: 2,
+			},
+		},
+		{
+			in: &TaskMessage{
+				Type:    "task4",
+				ID:      id,
+				Queue:   "default",
+				Retry:   10,
+				Timeout: 1800,
+				Headers: map[string]string{"tenant_id": "tenant1", "request_id": "req1"},
+			},
+			out: &TaskMessage{
+				Type:    "task4",
+				ID:      id,
+				Queue:   "default",
+				Retry:   10,
+				Timeout: 1800,
+				Headers: map[string]string{"tenant_id": "tenant1", "request_id": "req1"},
+			},
+		},
+		{
+			in: &TaskMessage{
+				Type:        "task5",
+				ID:          id,
+				Queue:       "default",
+				Retry:       10,
+				Timeout:     1800,
+				FairnessKey: "tenant1",
+			},
+			out: &TaskMessage{
+				Type:        "task5",
+				ID:          id,
+				Queue:       "default",
+				Retry:       10,
+				Timeout:     1800,
+				FairnessKey: "tenant1",
+			},
+		},
+		{
+			in: &TaskMessage{
+				Type:     "task6",
+				ID:       id,
+				Queue:    "default",
+				Retry:    10,
+				Timeout:  1800,
+				Priority: 5,
+			},
+			out: &TaskMessage{
+				Type:     "task6",
+				ID:       id,
+				Queue:    "default",
+				Retry:    10,
+				Timeout:  1800,
+				Priority: 5,
+			},
+		},
+		{
+			in: &TaskMessage{
+				Type:        "task7",
+				ID:          id,
+				Queue:       "default",
+				Retry:       10,
+				Timeout:     1800,
+				OrderingKey: "order-42",
+			},
+			out: &TaskMessage{
+				Type:        "task7",
+				ID:          id,
+				Queue:       "default",
+				Retry:       10,
+				Timeout:     1800,
+				OrderingKey: "order-42",
+			},
+		},
+		{
+			in: &TaskMessage{
+				Type:     "task8",
+				ID:       id,
+				Queue:    "default",
+				Retry:    10,
+				Timeout:  1800,
+				ExpireAt: 1700000000,
+			},
+			out: &TaskMessage{
+				Type:     "task8",
+				ID:       id,
+				Queue:    "default",
+				Retry:    10,
+				Timeout:  1800,
+				ExpireAt: 1700000000,
+			},
+		},
+		{
+			in: &TaskMessage{
+				Type:    "task9",
+				ID:      id,
+				Queue:   "default",
+				Retry:   10,
+				Timeout: 1800,
+				Attempts: []*TaskAttempt{
+					{StartTime: time.Unix(1700000000, 0), Duration: 2 * time.Second, ServerID: "srv1", Host: "host1", PID: 1234, Outcome: AttemptFailed, ErrorMsg: "panic: oops", Panic: true},
+					{StartTime: time.Unix(1700000100, 0), Duration: time.Second, ServerID: "srv2", Host: "host2", PID: 5678, Outcome: AttemptSucceeded},
+				},
+			},
+			out: &TaskMessage{
+				Type:    "task9",
+				ID:      id,
+				Queue:   "default",
+				Retry:   10,
+				Timeout: 1800,
+				Attempts: []*TaskAttempt{
+					{StartTime: time.Unix(1700000000, 0), Duration: 2 * time.Second, ServerID: "srv1", Host: "host1", PID: 1234, Outcome: AttemptFailed, ErrorMsg: "panic: oops", Panic: true},
+					{StartTime: time.Unix(1700000100, 0), Duration: time.Second, ServerID: "srv2", Host: "host2", PID: 5678, Outcome: AttemptSucceeded},
+				},
+			},
+		},
+		{
+			in: &TaskMessage{
+				Type:    "task10",
+				ID:      id,
+				Queue:   "default",
+				Retry:   10,
+				Timeout: 1800,
+				RetryPolicy: &RetryPolicy{
+					Backoff:      BackoffLinear,
+					BaseDelay:    5 * time.Second,
+					MaxDelay:     time.Minute,
+					Jitter:       0.2,
+					MaxElapsed:   time.Hour,
+					NonRetryable: []string{"invalid_input", "not_found"},
+				},
+				FirstAttemptedAt: 1700000000,
+			},
+			out: &TaskMessage{
+				Type:    "task10",
+				ID:      id,
+				Queue:   "default",
+				Retry:   10,
+				Timeout: 1800,
+				RetryPolicy: &RetryPolicy{
+					Backoff:      BackoffLinear,
+					BaseDelay:    5 * time.Second,
+					MaxDelay:     time.Minute,
+					Jitter:       0.2,
+					MaxElapsed:   time.Hour,
+					NonRetryable: []string{"invalid_input", "not_found"},
+				},
+				FirstAttemptedAt: 1700000000,
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -548,6 +1013,29 @@ func TestMessageEncoding(t *testing.T) {
 	}
 }
 
+func TestAddAttempt(t *testing.T) {
+	// The messages share the backing array, which has room for another attempt.
+	shared := append(make([]*TaskAttempt, 0, 2), &TaskAttempt{Outcome: AttemptFailed})
+	msg := &TaskMessage{Attempts: shared}
+	other := &TaskMessage{Attempts: shared}
+	msg.AddAttempt(&TaskAttempt{Outcome: AttemptSucceeded})
+	other.AddAttempt(&TaskAttempt{Outcome: AttemptCancelled})
+	if got := msg.Attempts[1].Outcome; got != AttemptSucceeded {
+		t.Errorf("AddAttempt overwrote the attempt of a message sharing the slice; got outcome %q, want %q", got, AttemptSucceeded)
+	}
+
+	msg = &TaskMessage{}
+	for i := 0; i < MaxTaskAttempts+5; i++ {
+		msg.AddAttempt(&TaskAttempt{PID: i})
+	}
+	if len(msg.Attempts) != MaxTaskAttempts {
+		t.Fatalf("len(Attempts) = %d, want %d", len(msg.Attempts), MaxTaskAttempts)
+	}
+	if first, last := msg.Attempts[0].PID, msg.Attempts[MaxTaskAttempts-1].PID; first != 5 || last != MaxTaskAttempts+4 {
+		t.Errorf("Attempts range from PID %d to %d, want %d to %d", first, last, 5, MaxTaskAttempts+4)
+	}
+}
+
 func TestServerInfoEncoding(t *testing.T) {
 	tests := []struct {
 		info ServerInfo
@@ -585,6 +1073,41 @@ func TestServerInfoEncoding(t *testing.T) {
 	}
 }
 
+func TestServerConfigUpdateEncoding(t *testing.T) {
+	strict := false
+	tests := []struct {
+		update ServerConfigUpdate
+	}{
+		{
+			update: ServerConfigUpdate{
+				Concurrency:    10,
+				Queues:         map[string]int{"default": 1, "critical": 2},
+				StrictPriority: &strict,
+			},
+		},
+		{
+			update: ServerConfigUpdate{Concurrency: 5},
+		},
+	}
+
+	for _, tc := range tests {
+		encoded, err := EncodeServerConfigUpdate(&tc.update)
+		if err != nil {
+			t.Errorf("EncodeServerConfigUpdate(update) returned error: %v", err)
+			continue
+		}
+		decoded, err := DecodeServerConfigUpdate(encoded)
+		if err != nil {
+			t.Errorf("DecodeServerConfigUpdate(encoded) returned error: %v", err)
+			continue
+		}
+		if diff := cmp.Diff(&tc.update, decoded); diff != "" {
+			t.Errorf("Decoded ServerConfigUpdate == %+v, want %+v;(-want,+got)\n%s",
+				decoded, tc.update, diff)
+		}
+	}
+}
+
 func TestWorkerInfoEncoding(t *testing.T) {
 	tests := []struct {
 		info WorkerInfo
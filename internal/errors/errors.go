@@ -193,6 +193,40 @@ func IsTaskNotFound(err error) bool {
 	return As(err, &target)
 }
 
+// ChainNotFoundError indicates that a chain with the given ID does not exist
+// in the given queue.
+type ChainNotFoundError struct {
+	Queue string // queue name
+	ID    string // chain id
+}
+
+func (e *ChainNotFoundError) Error() string {
+	return fmt.Sprintf("cannot find chain with id=%s in queue %q", e.ID, e.Queue)
+}
+
+// IsChainNotFound reports whether any error in err's chain is of type ChainNotFoundError.
+func IsChainNotFound(err error) bool {
+	var target *ChainNotFoundError
+	return As(err, &target)
+}
+
+// UniqueLockNotFoundError indicates that no task holds the uniqueness lock with the given key
+// in the given queue.
+type UniqueLockNotFoundError struct {
+	Queue string // queue name
+	Key   string // uniqueness key
+}
+
+func (e *UniqueLockNotFoundError) Error() string {
+	return fmt.Sprintf("cannot find uniqueness lock with key=%s in queue %q", e.Key, e.Queue)
+}
+
+// IsUniqueLockNotFound reports whether any error in err's chain is of type UniqueLockNotFoundError.
+func IsUniqueLockNotFound(err error) bool {
+	var target *UniqueLockNotFoundError
+	return As(err, &target)
+}
+
 // QueueNotFoundError indicates that a queue with the given name does not exist.
 type QueueNotFoundError struct {
 	Queue string // queue name
@@ -131,6 +131,24 @@ func TestErrorPredicates(t *testing.T) {
 			err:  E(Op("rdb.ArchiveTask"), NotFound, &QueueNotFoundError{Queue: "default"}),
 			want: true,
 		},
+		{
+			desc: "IsChainNotFound should detect presence of ChainNotFoundError in err's chain",
+			fn:   IsChainNotFound,
+			err:  E(Op("rdb.GetChainInfo"), NotFound, &ChainNotFoundError{Queue: "default", ID: "chain1"}),
+			want: true,
+		},
+		{
+			desc: "IsUniqueLockNotFound should detect presence of UniqueLockNotFoundError in err's chain",
+			fn:   IsUniqueLockNotFound,
+			err:  E(Op("rdb.GetUniqueLock"), NotFound, &UniqueLockNotFoundError{Queue: "default", Key: "user:123"}),
+			want: true,
+		},
+		{
+			desc: "IsUniqueLockNotFound should detect absence of UniqueLockNotFoundError in err's chain",
+			fn:   IsUniqueLockNotFound,
+			err:  E(Op("rdb.GetUniqueLock"), NotFound, &QueueNotFoundError{Queue: "default"}),
+			want: false,
+		},
 	}
 
 	for _, tc := range tests {
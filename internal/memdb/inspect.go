@@ -0,0 +1,843 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/errors"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+// AllQueues returns a list of all queue names.
+func (db *MemDB) AllQueues() ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var qnames []string
+	for qname := range db.allQueues {
+		qnames = append(qnames, qname)
+	}
+	sort.Strings(qnames)
+	return qnames, nil
+}
+
+// CurrentStats returns a current state of the queues.
+func (db *MemDB) CurrentStats(qname string) (*rdb.Stats, error) {
+	var op errors.Op = "memdb.CurrentStats"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return nil, errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	now := db.clock.Now()
+	day := now.UTC().Format("2006-01-02")
+	stats := &rdb.Stats{
+		Queue:          qname,
+		Paused:         q.paused,
+		Groups:         len(q.groups),
+		Pending:        len(q.pending),
+		Active:         len(q.active),
+		Scheduled:      q.scheduled.len(),
+		Waiting:        q.waiting.len(),
+		Retry:          q.retry.len(),
+		Archived:       q.archived.len(),
+		Completed:      q.completed.len(),
+		Processed:      q.processed[day],
+		Failed:         q.failed[day],
+		ProcessedTotal: int(q.processedTotal),
+		FailedTotal:    int(q.failedTotal),
+		Timestamp:      now,
+	}
+	for _, g := range q.groups {
+		stats.Aggregating += g.len()
+	}
+	stats.Size = stats.Pending + stats.Active + stats.Scheduled + stats.Retry +
+		stats.Archived + stats.Completed + stats.Aggregating + stats.Waiting
+	if len(q.pending) > 0 {
+		if t, ok := q.tasks[q.pending[0]]; ok && t.pendingSince != 0 {
+			stats.Latency = now.Sub(time.Unix(0, t.pendingSince))
+		}
+	}
+	for _, t := range q.tasks {
+		stats.MemoryUsage += int64(len(t.msg) + len(t.result))
+	}
+	return stats, nil
+}
+
+// HistoricalStats returns a list of stats from the last n days for the given queue.
+func (db *MemDB) HistoricalStats(qname string, n int) ([]*rdb.DailyStats, error) {
+	var op errors.Op = "memdb.HistoricalStats"
+	if n < 1 {
+		return nil, errors.E(op, errors.FailedPrecondition, "the number of days must be positive")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return nil, errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	const day = 24 * time.Hour
+	now := db.clock.Now().UTC()
+	var stats []*rdb.DailyStats
+	for i := 0; i < n; i++ {
+		ts := now.Add(-time.Duration(i) * day)
+		key := ts.Format("2006-01-02")
+		stats = append(stats, &rdb.DailyStats{
+			Queue:     qname,
+			Processed: q.processed[key],
+			Failed:    q.failed[key],
+			Time:      ts,
+		})
+	}
+	return stats, nil
+}
+
+// GetTaskInfo returns a TaskInfo describing the task from the given queue.
+func (db *MemDB) GetTaskInfo(qname, id string) (*base.TaskInfo, error) {
+	var op errors.Op = "memdb.GetTaskInfo"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return nil, errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	if _, ok := q.tasks[id]; !ok {
+		return nil, errors.E(op, errors.NotFound, &errors.TaskNotFoundError{Queue: qname, ID: id})
+	}
+	info, err := db.taskInfo(q, id)
+	if err != nil {
+		return nil, errors.E(op, errors.Internal, "could not decode task message")
+	}
+	return info, nil
+}
+
+// GetUniqueLock returns the uniqueness lock with the given key from the given queue.
+func (db *MemDB) GetUniqueLock(qname, key string) (*rdb.UniqueLock, error) {
+	var op errors.Op = "memdb.GetUniqueLock"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return nil, errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	l, ok := db.uniqueLocks[base.UniqueByKey(qname, key)]
+	if !ok || !db.clock.Now().Before(l.expireAt) {
+		return nil, errors.E(op, errors.NotFound, &errors.UniqueLockNotFoundError{Queue: qname, Key: key})
+	}
+	return &rdb.UniqueLock{TaskID: l.id, ExpiresAt: l.expireAt}, nil
+}
+
+// ReleaseUniqueLock deletes the uniqueness lock with the given key from the given queue.
+func (db *MemDB) ReleaseUniqueLock(qname, key string) error {
+	var op errors.Op = "memdb.ReleaseUniqueLock"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	ukey := base.UniqueByKey(qname, key)
+	l, ok := db.uniqueLocks[ukey]
+	if !ok || !db.clock.Now().Before(l.expireAt) {
+		return errors.E(op, errors.NotFound, &errors.UniqueLockNotFoundError{Queue: qname, Key: key})
+	}
+	delete(db.uniqueLocks, ukey)
+	return nil
+}
+
+// LastEventID returns the ID of the latest event in the event stream,
+// or "0-0" if the stream is empty.
+func (db *MemDB) LastEventID() (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return fmt.Sprintf("%d-0", db.lastEventSeq), nil
+}
+
+// ReadEvents returns up to count events recorded after the event with the given ID.
+// If there is no such event, it waits up to the given duration for one to be recorded,
+// and returns an empty slice if none is. A negative duration returns without waiting.
+func (db *MemDB) ReadEvents(ctx context.Context, after string, count int, block time.Duration) ([]*base.TaskEvent, error) {
+	var op errors.Op = "memdb.ReadEvents"
+	seq, err := strconv.ParseInt(strings.SplitN(after, "-", 2)[0], 10, 64)
+	if err != nil {
+		return nil, errors.E(op, errors.FailedPrecondition, fmt.Sprintf("invalid event ID %q", after))
+	}
+	timer := time.NewTimer(block)
+	defer timer.Stop()
+	for {
+		db.mu.Lock()
+		var events []*base.TaskEvent
+		// Events are stored in order, with sequence numbers ending at db.lastEventSeq.
+		start := len(db.events) - int(db.lastEventSeq-seq)
+		if start < 0 {
+			start = 0
+		} else if start > len(db.events) {
+			start = len(db.events)
+		}
+		for _, ev := range db.events[start:] {
+			if count > 0 && len(events) == count {
+				break
+			}
+			e := *ev
+			events = append(events, &e)
+		}
+		ch := db.eventsCh
+		db.mu.Unlock()
+		if len(events) > 0 {
+			return events, nil
+		}
+		select {
+		case <-ch:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// GetChainInfo returns a ChainInfo describing the chain from the given queue.
+func (db *MemDB) GetChainInfo(qname, chainID string) (*base.ChainInfo, error) {
+	var op errors.Op = "memdb.GetChainInfo"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return nil, errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	c, ok := db.getChain(q, chainID)
+	if !ok {
+		return nil, errors.E(op, errors.NotFound, &errors.ChainNotFoundError{Queue: qname, ID: chainID})
+	}
+	info := &base.ChainInfo{
+		ID:       chainID,
+		Queue:    qname,
+		Messages: make([]*base.TaskMessage, len(c.msgs)),
+		Results:  make([][]byte, len(c.results)),
+		Current:  c.current,
+		Err:      c.err,
+	}
+	for i, encoded := range c.msgs {
+		msg, err := base.DecodeMessage(encoded)
+		if err != nil {
+			return nil, errors.E(op, errors.Internal, "could not decode task message")
+		}
+		info.Messages[i] = msg
+		if c.results[i] != nil {
+			info.Results[i] = append([]byte(nil), c.results[i]...)
+		}
+	}
+	if c.current < len(c.ids) {
+		if t, ok := q.tasks[c.ids[c.current]]; ok {
+			info.CurrentState = t.state
+			// Use the stored message of the current step since it reflects processing attempts.
+			msg, err := base.DecodeMessage(t.msg)
+			if err != nil {
+				return nil, errors.E(op, errors.Internal, "could not decode task message")
+			}
+			info.Messages[c.current] = msg
+		}
+	}
+	return info, nil
+}
+
+// taskInfo returns a TaskInfo describing the task with the given id.
+// Caller must hold db.mu.
+func (db *MemDB) taskInfo(q *queue, id string) (*base.TaskInfo, error) {
+	t := q.tasks[id]
+	msg, err := base.DecodeMessage(t.msg)
+	if err != nil {
+		return nil, err
+	}
+	var nextProcessAt time.Time
+	switch t.state {
+	case base.TaskStatePending:
+		nextProcessAt = db.clock.Now()
+	case base.TaskStateScheduled, base.TaskStateRetry:
+		if score, ok := q.zsetFor(t.state).score(id); ok {
+			nextProcessAt = base.ProcessAtFromScore(score)
+		}
+	}
+	var result []byte
+	if len(t.result) > 0 {
+		result = append([]byte(nil), t.result...)
+	}
+	return &base.TaskInfo{
+		Message:       msg,
+		State:         t.state,
+		NextProcessAt: nextProcessAt,
+		Result:        result,
+	}, nil
+}
+
+// GroupStats returns a list of groups in the given queue with their sizes.
+func (db *MemDB) GroupStats(qname string) ([]*rdb.GroupStat, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, ok := db.queues[qname]
+	if !ok {
+		return nil, nil
+	}
+	var stats []*rdb.GroupStat
+	for gname, g := range q.groups {
+		stats = append(stats, &rdb.GroupStat{Group: gname, Size: g.len()})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Group < stats[j].Group })
+	return stats, nil
+}
+
+// FairnessKeyStats returns the fairness keys of the pending tasks in the given queue,
+// along with the number of pending tasks of each key, sorted by key.
+func (db *MemDB) FairnessKeyStats(qname string) ([]*rdb.FairnessKeyStat, error) {
+	var op errors.Op = "memdb.FairnessKeyStats"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return nil, errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	counts := make(map[string]int)
+	for _, id := range q.pending {
+		if fkey := q.tasks[id].fairnessKey; fkey != "" {
+			counts[fkey]++
+		}
+	}
+	var stats []*rdb.FairnessKeyStat
+	for fkey, n := range counts {
+		stats = append(stats, &rdb.FairnessKeyStat{Key: fkey, Pending: n})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Key < stats[j].Key })
+	return stats, nil
+}
+
+// paginate returns the page of ids specified by pgn.
+func paginate(ids []string, pgn rdb.Pagination) []string {
+	start := pgn.Size * pgn.Page
+	if start >= len(ids) {
+		return nil
+	}
+	stop := start + pgn.Size
+	if stop > len(ids) {
+		stop = len(ids)
+	}
+	return ids[start:stop]
+}
+
+// listTasks returns a list of TaskInfo for the page of ids specified by pgn.
+// Caller must hold db.mu.
+func (db *MemDB) listTasks(q *queue, ids []string, pgn rdb.Pagination) []*base.TaskInfo {
+	var infos []*base.TaskInfo
+	for _, id := range paginate(ids, pgn) {
+		if _, ok := q.tasks[id]; !ok {
+			continue
+		}
+		info, err := db.taskInfo(q, id)
+		if err != nil {
+			continue // bad data, ignore and continue
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// list returns the page of tasks held by the list selected by fn.
+func (db *MemDB) list(op errors.Op, qname string, pgn rdb.Pagination, fn func(q *queue) []string) ([]*base.TaskInfo, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return nil, errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	return db.listTasks(q, fn(q), pgn), nil
+}
+
+// ListPending returns pending tasks that are ready to be processed.
+func (db *MemDB) ListPending(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error) {
+	return db.list("memdb.ListPending", qname, pgn, func(q *queue) []string { return q.pending })
+}
+
+// ListActive returns all tasks that are currently being processed for the given queue.
+func (db *MemDB) ListActive(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error) {
+	return db.list("memdb.ListActive", qname, pgn, func(q *queue) []string { return q.active })
+}
+
+// ListScheduled returns all tasks from the given queue that are scheduled
+// to be processed in the future.
+func (db *MemDB) ListScheduled(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error) {
+	return db.list("memdb.ListScheduled", qname, pgn, func(q *queue) []string { return q.scheduled.members() })
+}
+
+// ListWaiting returns all tasks from the given queue that are waiting
+// for their dependencies to complete.
+func (db *MemDB) ListWaiting(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error) {
+	return db.list("memdb.ListWaiting", qname, pgn, func(q *queue) []string { return q.waiting.members() })
+}
+
+// ListRetry returns all tasks from the given queue that have failed before
+// and willl be retried in the future.
+func (db *MemDB) ListRetry(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error) {
+	return db.list("memdb.ListRetry", qname, pgn, func(q *queue) []string { return q.retry.members() })
+}
+
+// ListArchived returns all tasks from the given queue that have exhausted its retry limit.
+func (db *MemDB) ListArchived(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error) {
+	return db.list("memdb.ListArchived", qname, pgn, func(q *queue) []string { return q.archived.members() })
+}
+
+// ListCompleted returns all tasks from the given queue that have completed successfully.
+func (db *MemDB) ListCompleted(qname string, pgn rdb.Pagination) ([]*base.TaskInfo, error) {
+	return db.list("memdb.ListCompleted", qname, pgn, func(q *queue) []string { return q.completed.members() })
+}
+
+// ListAggregating returns all tasks from the given group.
+func (db *MemDB) ListAggregating(qname, gname string, pgn rdb.Pagination) ([]*base.TaskInfo, error) {
+	return db.list("memdb.ListAggregating", qname, pgn, func(q *queue) []string {
+		if g, ok := q.groups[gname]; ok {
+			return g.members()
+		}
+		return nil
+	})
+}
+
+// moveToPending moves the tasks with the given ids to the pending list.
+// Caller must hold db.mu.
+func (db *MemDB) moveToPending(q *queue, ids []string) {
+	now := db.clock.Now().UnixNano()
+	for _, id := range ids {
+		t, ok := q.tasks[id]
+		if !ok {
+			continue
+		}
+		t.state = base.TaskStatePending
+		t.pendingSince = now
+		q.pending = append(q.pending, id)
+	}
+}
+
+// runAll moves all tasks in the given state to pending state.
+func (db *MemDB) runAll(op errors.Op, qname string, state base.TaskState) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return 0, errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	z := q.zsetFor(state)
+	ids := z.members()
+	for _, id := range ids {
+		z.remove(id)
+	}
+	db.moveToPending(q, ids)
+	return int64(len(ids)), nil
+}
+
+// RunAllScheduledTasks enqueues all scheduled tasks from the given queue
+// and returns the number of tasks enqueued.
+// If a queue with the given name doesn't exist, it returns QueueNotFoundError.
+func (db *MemDB) RunAllScheduledTasks(qname string) (int64, error) {
+	return db.runAll("memdb.RunAllScheduledTasks", qname, base.TaskStateScheduled)
+}
+
+// RunAllRetryTasks enqueues all retry tasks from the given queue
+// and returns the number of tasks enqueued.
+// If a queue with the given name doesn't exist, it returns QueueNotFoundError.
+func (db *MemDB) RunAllRetryTasks(qname string) (int64, error) {
+	return db.runAll("memdb.RunAllRetryTasks", qname, base.TaskStateRetry)
+}
+
+// RunAllArchivedTasks enqueues all archived tasks from the given queue
+// and returns the number of tasks enqueued.
+// If a queue with the given name doesn't exist, it returns QueueNotFoundError.
+func (db *MemDB) RunAllArchivedTasks(qname string) (int64, error) {
+	return db.runAll("memdb.RunAllArchivedTasks", qname, base.TaskStateArchived)
+}
+
+// RunAllAggregatingTasks schedules all tasks from the given queue to run
+// and returns the number of tasks scheduled to run.
+// If a queue with the given name doesn't exist, it returns QueueNotFoundError.
+func (db *MemDB) RunAllAggregatingTasks(qname, gname string) (int64, error) {
+	var op errors.Op = "memdb.RunAllAggregatingTasks"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return 0, errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	g, ok := q.groups[gname]
+	if !ok {
+		return 0, nil
+	}
+	ids := g.members()
+	delete(q.groups, gname)
+	db.moveToPending(q, ids)
+	return int64(len(ids)), nil
+}
+
+// removeTask removes the task from the list or set which holds the task in its current state.
+// Caller must hold db.mu.
+func (db *MemDB) removeTask(q *queue, id string, t *task) {
+	switch t.state {
+	case base.TaskStatePending:
+		q.pending, _ = removeID(q.pending, id)
+	case base.TaskStateAggregating:
+		q.removeFromGroup(t.group, id)
+	default:
+		if z := q.zsetFor(t.state); z != nil {
+			z.remove(id)
+		}
+	}
+}
+
+// RunTask finds a task that matches the id from the given queue and updates it to pending state.
+// It returns nil if it successfully updated the task.
+//
+// If a queue with the given name doesn't exist, it returns QueueNotFoundError.
+// If a task with the given id doesn't exist in the queue, it returns TaskNotFoundError
+// If a task is in active or pending state it returns non-nil error with Code FailedPrecondition.
+func (db *MemDB) RunTask(qname, id string) error {
+	var op errors.Op = "memdb.RunTask"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	t, ok := q.tasks[id]
+	if !ok {
+		return errors.E(op, errors.NotFound, &errors.TaskNotFoundError{Queue: qname, ID: id})
+	}
+	switch t.state {
+	case base.TaskStateActive:
+		return errors.E(op, errors.FailedPrecondition, "task is already running")
+	case base.TaskStatePending:
+		return errors.E(op, errors.FailedPrecondition, "task is already in pending state")
+	}
+	db.removeTask(q, id, t)
+	db.moveToPending(q, []string{id})
+	return nil
+}
+
+// archiveAll moves all tasks in the given state to archived state.
+func (db *MemDB) archiveAll(op errors.Op, qname string, fn func(q *queue) []string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return 0, errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	ids := fn(q)
+	now := db.clock.Now()
+	for _, id := range ids {
+		t, ok := q.tasks[id]
+		if !ok {
+			continue
+		}
+		db.removeTask(q, id, t)
+		t.state = base.TaskStateArchived
+		q.addToArchive(id, now)
+		db.recordEvent(base.TaskEventArchived, qname, id, t)
+	}
+	return int64(len(ids)), nil
+}
+
+// ArchiveAllRetryTasks archives all retry tasks from the given queue and
+// returns the number of tasks that were moved.
+// If a queue with the given name doesn't exist, it returns QueueNotFoundError.
+func (db *MemDB) ArchiveAllRetryTasks(qname string) (int64, error) {
+	return db.archiveAll("memdb.ArchiveAllRetryTasks", qname, func(q *queue) []string { return q.retry.members() })
+}
+
+// ArchiveAllScheduledTasks archives all scheduled tasks from the given queue and
+// returns the number of tasks that were moved.
+// If a queue with the given name doesn't exist, it returns QueueNotFoundError.
+func (db *MemDB) ArchiveAllScheduledTasks(qname string) (int64, error) {
+	return db.archiveAll("memdb.ArchiveAllScheduledTasks", qname, func(q *queue) []string { return q.scheduled.members() })
+}
+
+// ArchiveAllAggregatingTasks archives all aggregating tasks from the given group
+// and returns the number of tasks archived.
+// If a queue with the given name doesn't exist, it returns QueueNotFoundError.
+func (db *MemDB) ArchiveAllAggregatingTasks(qname, gname string) (int64, error) {
+	return db.archiveAll("memdb.ArchiveAllAggregatingTasks", qname, func(q *queue) []string {
+		if g, ok := q.groups[gname]; ok {
+			return g.members()
+		}
+		return nil
+	})
+}
+
+// ArchiveAllPendingTasks archives all pending tasks from the given queue and
+// returns the number of tasks moved.
+// If a queue with the given name doesn't exist, it returns QueueNotFoundError.
+func (db *MemDB) ArchiveAllPendingTasks(qname string) (int64, error) {
+	return db.archiveAll("memdb.ArchiveAllPendingTasks", qname, func(q *queue) []string {
+		return append([]string(nil), q.pending...)
+	})
+}
+
+// ArchiveTask finds a task that matches the id from the given queue and archives it.
+// It returns nil if it successfully archived the task.
+//
+// If a queue with the given name doesn't exist, it returns QueueNotFoundError.
+// If a task with the given id doesn't exist in the queue, it returns TaskNotFoundError
+// If a task is already archived, it returns TaskAlreadyArchivedError.
+// If a task is in active state it returns non-nil error with FailedPrecondition code.
+func (db *MemDB) ArchiveTask(qname, id string) error {
+	var op errors.Op = "memdb.ArchiveTask"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	t, ok := q.tasks[id]
+	if !ok {
+		return errors.E(op, errors.NotFound, &errors.TaskNotFoundError{Queue: qname, ID: id})
+	}
+	switch t.state {
+	case base.TaskStateActive:
+		return errors.E(op, errors.FailedPrecondition, "cannot archive task in active state. use CancelProcessing instead.")
+	case base.TaskStateArchived:
+		return errors.E(op, errors.FailedPrecondition, &errors.TaskAlreadyArchivedError{Queue: qname, ID: id})
+	}
+	db.removeTask(q, id, t)
+	t.state = base.TaskStateArchived
+	q.addToArchive(id, db.clock.Now())
+	db.notify(base.CompletionChannel(qname, id))
+	return nil
+}
+
+// deleteTasks deletes the tasks with the given ids along with the uniqueness locks they hold,
+// and notifies the completion subscribers of the deleted tasks.
+// Caller must hold db.mu.
+func (db *MemDB) deleteTasks(q *queue, qname string, ids []string) int64 {
+	var n int64
+	for _, id := range ids {
+		t, ok := q.tasks[id]
+		if !ok {
+			continue
+		}
+		db.removeTask(q, id, t)
+		db.releaseUniqueLock(t.uniqueKey, id)
+		delete(q.tasks, id)
+		db.notify(base.CompletionChannel(qname, id))
+		n++
+	}
+	return n
+}
+
+// DeleteTask finds a task that matches the id from the given queue and deletes it.
+// It returns nil if it successfully archived the task.
+//
+// If a queue with the given name doesn't exist, it returns QueueNotFoundError.
+// If a task with the given id doesn't exist in the queue, it returns TaskNotFoundError
+// If a task is in active state it returns non-nil error with Code FailedPrecondition.
+func (db *MemDB) DeleteTask(qname, id string) error {
+	var op errors.Op = "memdb.DeleteTask"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	t, ok := q.tasks[id]
+	if !ok {
+		return errors.E(op, errors.NotFound, &errors.TaskNotFoundError{Queue: qname, ID: id})
+	}
+	if t.state == base.TaskStateActive {
+		return errors.E(op, errors.FailedPrecondition, "cannot delete task in active state. use CancelProcessing instead.")
+	}
+	db.deleteTasks(q, qname, []string{id})
+	return nil
+}
+
+// deleteAll deletes all tasks selected by fn.
+func (db *MemDB) deleteAll(op errors.Op, qname string, fn func(q *queue) []string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return 0, errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	ids := fn(q)
+	for _, id := range ids {
+		if t, ok := q.tasks[id]; ok {
+			db.recordEvent(base.TaskEventDeleted, qname, id, t)
+		}
+	}
+	return db.deleteTasks(q, qname, ids), nil
+}
+
+// DeleteAllArchivedTasks deletes all archived tasks from the given queue
+// and returns the number of tasks deleted.
+func (db *MemDB) DeleteAllArchivedTasks(qname string) (int64, error) {
+	return db.deleteAll("memdb.DeleteAllArchivedTasks", qname, func(q *queue) []string { return q.archived.members() })
+}
+
+// DeleteAllRetryTasks deletes all retry tasks from the given queue
+// and returns the number of tasks deleted.
+func (db *MemDB) DeleteAllRetryTasks(qname string) (int64, error) {
+	return db.deleteAll("memdb.DeleteAllRetryTasks", qname, func(q *queue) []string { return q.retry.members() })
+}
+
+// DeleteAllScheduledTasks deletes all scheduled tasks from the given queue
+// and returns the number of tasks deleted.
+func (db *MemDB) DeleteAllScheduledTasks(qname string) (int64, error) {
+	return db.deleteAll("memdb.DeleteAllScheduledTasks", qname, func(q *queue) []string { return q.scheduled.members() })
+}
+
+// DeleteAllCompletedTasks deletes all completed tasks from the given queue
+// and returns the number of tasks deleted.
+func (db *MemDB) DeleteAllCompletedTasks(qname string) (int64, error) {
+	return db.deleteAll("memdb.DeleteAllCompletedTasks", qname, func(q *queue) []string { return q.completed.members() })
+}
+
+// DeleteAllAggregatingTasks deletes all aggregating tasks from the given group
+// and returns the number of tasks deleted.
+func (db *MemDB) DeleteAllAggregatingTasks(qname, gname string) (int64, error) {
+	return db.deleteAll("memdb.DeleteAllAggregatingTasks", qname, func(q *queue) []string {
+		if g, ok := q.groups[gname]; ok {
+			return g.members()
+		}
+		return nil
+	})
+}
+
+// DeleteAllPendingTasks deletes all pending tasks from the given queue
+// and returns the number of tasks deleted.
+func (db *MemDB) DeleteAllPendingTasks(qname string) (int64, error) {
+	return db.deleteAll("memdb.DeleteAllPendingTasks", qname, func(q *queue) []string {
+		return append([]string(nil), q.pending...)
+	})
+}
+
+// RemoveQueue removes the specified queue.
+//
+// If force is set to true, it will remove the queue regardless
+// as long as no tasks are active for the queue.
+// If force is set to false, it will only remove the queue if
+// the queue is empty.
+func (db *MemDB) RemoveQueue(qname string, force bool) error {
+	var op errors.Op = "memdb.RemoveQueue"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.queueExists(qname) {
+		return errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	q := db.getQueue(qname)
+	if force {
+		if len(q.active) > 0 {
+			return errors.E(op, errors.FailedPrecondition, "cannot remove queue with active tasks")
+		}
+	} else {
+		if len(q.pending) > 0 || len(q.active) > 0 || q.scheduled.len() > 0 || q.retry.len() > 0 || q.archived.len() > 0 ||
+			q.waiting.len() > 0 {
+			return errors.E(op, errors.NotFound, &errors.QueueNotEmptyError{Queue: qname})
+		}
+	}
+	delete(db.queues, qname)
+	delete(db.allQueues, qname)
+	for id := range q.tasks {
+		db.notify(base.CompletionChannel(qname, id))
+	}
+	return nil
+}
+
+// ListServers returns the list of server info.
+func (db *MemDB) ListServers() ([]*base.ServerInfo, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.removeExpiredServers()
+	var servers []*base.ServerInfo
+	for _, s := range db.servers {
+		info, err := base.DecodeServerInfo(s.info)
+		if err != nil {
+			continue // skip bad data
+		}
+		servers = append(servers, info)
+	}
+	return servers, nil
+}
+
+// ListWorkers returns the list of worker stats.
+func (db *MemDB) ListWorkers() ([]*base.WorkerInfo, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.removeExpiredServers()
+	var workers []*base.WorkerInfo
+	for _, s := range db.servers {
+		for _, data := range s.workers {
+			w, err := base.DecodeWorkerInfo(data)
+			if err != nil {
+				continue // skip bad data
+			}
+			workers = append(workers, w)
+		}
+	}
+	return workers, nil
+}
+
+// removeExpiredServers removes stale server state data.
+// Caller must hold db.mu.
+func (db *MemDB) removeExpiredServers() {
+	now := db.clock.Now()
+	for key, s := range db.servers {
+		if !now.Before(s.expireAt) {
+			delete(db.servers, key)
+		}
+	}
+}
+
+// ListSchedulerEntries returns the list of scheduler entries.
+// Schedulers are not supported by MemDB, so the list is always empty.
+func (db *MemDB) ListSchedulerEntries() ([]*base.SchedulerEntry, error) {
+	return nil, nil
+}
+
+// ListSchedulerEnqueueEvents returns the list of scheduler enqueue events.
+// Schedulers are not supported by MemDB, so the list is always empty.
+func (db *MemDB) ListSchedulerEnqueueEvents(entryID string, pgn rdb.Pagination) ([]*base.SchedulerEnqueueEvent, error) {
+	return nil, nil
+}
+
+// Pause pauses processing of tasks from the given queue.
+func (db *MemDB) Pause(qname string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q := db.getQueue(qname)
+	if q.paused {
+		return fmt.Errorf("queue %q is already paused", qname)
+	}
+	q.paused = true
+	return nil
+}
+
+// Unpause resumes processing of tasks from the given queue.
+func (db *MemDB) Unpause(qname string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q := db.getQueue(qname)
+	if !q.paused {
+		return fmt.Errorf("queue %q is not paused", qname)
+	}
+	q.paused = false
+	return nil
+}
+
+// ClusterKeySlot is not supported by MemDB.
+func (db *MemDB) ClusterKeySlot(qname string) (int64, error) {
+	return 0, fmt.Errorf("cluster is not supported by in-memory broker")
+}
+
+// ClusterNodes is not supported by MemDB.
+func (db *MemDB) ClusterNodes(qname string) ([]redis.ClusterNode, error) {
+	return nil, fmt.Errorf("cluster is not supported by in-memory broker")
+}
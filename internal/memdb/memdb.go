@@ -0,0 +1,1852 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// Package memdb implements a broker which keeps all queues and tasks in process memory.
+//
+// MemDB mirrors the semantics of package rdb so that it can be used in place of redis
+// in tests and in single process deployments. Data is not persisted and is not shared
+// across processes.
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/errors"
+	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/hibiken/asynq/internal/timeutil"
+)
+
+const (
+	maxArchiveSize           = 10000 // maximum number of tasks in archive
+	archivedExpirationInDays = 90    // number of days before an archived task gets deleted permanently
+)
+
+// chainExpiration is the duration a chain is kept after all of its steps complete.
+const chainExpiration = 7 * 24 * time.Hour // 7 days
+
+// Task aggregation should finish within this timeout.
+// Otherwise an aggregation set should be reclaimed by the recoverer.
+const aggregationTimeout = 2 * time.Minute
+
+// MemDB is an in-memory implementation of the broker.
+// MemDB is safe for concurrent use by multiple goroutines.
+type MemDB struct {
+	mu    sync.Mutex
+	clock timeutil.Clock
+
+	// allQueues holds the names of all known queues.
+	allQueues map[string]struct{}
+	// queues maps a queue name to its data.
+	queues map[string]*queue
+	// uniqueLocks maps a uniqueness key to the lock holder.
+	uniqueLocks map[string]*uniqueLock
+	// debounceKeys maps a debounce key to the ID of the task holding it.
+	debounceKeys map[string]string
+	// servers maps a server key to its state.
+	servers map[string]*serverState
+	// subscribers holds all open cancelation subscriptions.
+	subscribers map[*cancelationSubscription]struct{}
+	// wakeupSubscribers holds all open wakeup subscriptions.
+	wakeupSubscribers map[*wakeupSubscription]struct{}
+	// configSubscribers holds all open server config subscriptions.
+	configSubscribers map[*serverConfigSubscription]struct{}
+	// rateLimits maps a queue name to its rate limit token bucket.
+	rateLimits map[string]*tokenBucket
+	// events holds the task lifecycle events, oldest first.
+	events []*base.TaskEvent
+	// lastEventSeq is the sequence number of the latest recorded event.
+	lastEventSeq int64
+	// eventsCh is closed, and replaced, when an event is recorded.
+	eventsCh chan struct{}
+}
+
+// Make sure MemDB implements Broker interface at compile time.
+var _ base.Broker = (*MemDB)(nil)
+
+// NewMemDB returns a new instance of MemDB.
+func NewMemDB() *MemDB {
+	return &MemDB{
+		clock:             timeutil.NewRealClock(),
+		allQueues:         make(map[string]struct{}),
+		queues:            make(map[string]*queue),
+		uniqueLocks:       make(map[string]*uniqueLock),
+		debounceKeys:      make(map[string]string),
+		servers:           make(map[string]*serverState),
+		subscribers:       make(map[*cancelationSubscription]struct{}),
+		wakeupSubscribers: make(map[*wakeupSubscription]struct{}),
+		configSubscribers: make(map[*serverConfigSubscription]struct{}),
+		rateLimits:        make(map[string]*tokenBucket),
+		eventsCh:          make(chan struct{}),
+	}
+}
+
+// SetClock sets the clock used by MemDB to the given clock.
+//
+// Use this function to set the clock to SimulatedClock in tests.
+func (db *MemDB) SetClock(c timeutil.Clock) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.clock = c
+}
+
+// Close is a no-op; the data is kept so that other users of the MemDB
+// (e.g. a Client and a Server sharing the same instance) can keep using it.
+func (db *MemDB) Close() error {
+	return nil
+}
+
+// Ping always succeeds since there is no connection to check.
+func (db *MemDB) Ping() error {
+	return nil
+}
+
+// task holds a task message and the metadata stored along with it.
+type task struct {
+	msg          []byte // encoded task message
+	state        base.TaskState
+	pendingSince int64 // unix time in nsec; only meaningful for pending tasks
+	uniqueKey    string
+	group        string
+	fairnessKey  string
+	priority     int
+	orderingKey  string
+	expireAt     int64 // unix time in seconds; zero if the task never expires
+	result       []byte
+
+	// deps holds IDs of the dependencies a waiting task is still waiting for.
+	deps map[string]struct{}
+	// dependents holds IDs of the waiting tasks which depend on this task.
+	dependents map[string]struct{}
+
+	chainID   string // empty if the task is not part of a chain
+	chainStep int
+}
+
+// chain holds the steps of a task chain and its progress.
+type chain struct {
+	ids      []string
+	msgs     [][]byte // encoded task messages of the steps
+	results  [][]byte // results written by the completed steps
+	current  int      // index of the step being processed
+	err      string
+	expireAt int64 // unix time in seconds; zero until all steps complete
+}
+
+// aggregationSet is a set of tasks which are being aggregated.
+type aggregationSet struct {
+	group    string
+	tasks    *zset
+	deadline int64 // unix time in seconds
+}
+
+// queue holds all the data for a queue.
+type queue struct {
+	tasks map[string]*task
+
+	// pending holds IDs of pending tasks, the head of the slice is dequeued first.
+	pending []string
+	// active holds IDs of active tasks in the order they were dequeued.
+	active []string
+
+	lease     *zset
+	scheduled *zset
+	waiting   *zset
+	retry     *zset
+	archived  *zset
+	completed *zset
+
+	// groups maps a group name to the tasks in the group.
+	// A group is removed once it becomes empty.
+	groups map[string]*zset
+	// aggregationSets maps an aggregation set key to the aggregation set.
+	aggregationSets map[string]*aggregationSet
+	// chains maps a chain ID to the chain.
+	chains map[string]*chain
+
+	paused bool
+
+	// fairnessServed maps a fairness key to the sequence number of the last dequeue
+	// which served the key, so that the least recently served key is served next.
+	fairnessServed map[string]uint64
+	fairnessSeq    uint64
+
+	// ordering maps an ordering key to the IDs of the unfinished tasks with the key,
+	// in the order they are processed. Only the first task may be pending or active.
+	ordering map[string][]string
+
+	// processed and failed are daily counters keyed by date (yyyy-mm-dd).
+	processed      map[string]int
+	failed         map[string]int
+	processedTotal int64
+	failedTotal    int64
+}
+
+func newQueue() *queue {
+	return &queue{
+		tasks:           make(map[string]*task),
+		lease:           newZSet(),
+		scheduled:       newZSet(),
+		waiting:         newZSet(),
+		retry:           newZSet(),
+		archived:        newZSet(),
+		completed:       newZSet(),
+		groups:          make(map[string]*zset),
+		aggregationSets: make(map[string]*aggregationSet),
+		chains:          make(map[string]*chain),
+		fairnessServed:  make(map[string]uint64),
+		ordering:        make(map[string][]string),
+		processed:       make(map[string]int),
+		failed:          make(map[string]int),
+	}
+}
+
+// zsetFor returns the sorted set which holds tasks in the given state, or nil if the
+// state is not backed by a sorted set.
+func (q *queue) zsetFor(state base.TaskState) *zset {
+	switch state {
+	case base.TaskStateScheduled:
+		return q.scheduled
+	case base.TaskStateWaiting:
+		return q.waiting
+	case base.TaskStateRetry:
+		return q.retry
+	case base.TaskStateArchived:
+		return q.archived
+	case base.TaskStateCompleted:
+		return q.completed
+	}
+	return nil
+}
+
+// removeFromGroup removes the task from its group and drops the group once it's empty.
+func (q *queue) removeFromGroup(gname, id string) bool {
+	g, ok := q.groups[gname]
+	if !ok || !g.remove(id) {
+		return false
+	}
+	if g.len() == 0 {
+		delete(q.groups, gname)
+	}
+	return true
+}
+
+// addToArchive adds the task to the archive and trims the archive by timestamp and set size.
+func (q *queue) addToArchive(taskID string, now time.Time) {
+	q.archived.add(taskID, now.Unix())
+	cutoff := now.AddDate(0, 0, -archivedExpirationInDays).Unix()
+	for _, id := range q.archived.rangeByScore(cutoff) {
+		q.archived.remove(id)
+		delete(q.tasks, id)
+	}
+	if n := q.archived.len() - maxArchiveSize; n > 0 {
+		for _, id := range q.archived.members()[:n] {
+			q.archived.remove(id)
+			delete(q.tasks, id)
+		}
+	}
+}
+
+// recordProcessed increments the processed counters and, if failed is true, the failed counters.
+func (q *queue) recordProcessed(now time.Time, failed bool) {
+	day := now.UTC().Format("2006-01-02")
+	q.processed[day]++
+	if failed {
+		q.failed[day]++
+	}
+	// Reset the total counters once they hit the max int64 value.
+	if q.processedTotal == math.MaxInt64 {
+		q.processedTotal = 1
+		if failed {
+			q.failedTotal = 1
+		}
+		return
+	}
+	q.processedTotal++
+	if failed {
+		q.failedTotal++
+	}
+}
+
+// uniqueLock is a uniqueness lock held by a task.
+type uniqueLock struct {
+	id       string
+	expireAt time.Time
+}
+
+// serverState holds the state data written by a server.
+type serverState struct {
+	info     []byte
+	workers  map[string][]byte
+	expireAt time.Time
+}
+
+// zset is a set of task IDs ordered by score.
+type zset struct {
+	scores map[string]int64
+}
+
+func newZSet() *zset {
+	return &zset{scores: make(map[string]int64)}
+}
+
+func (z *zset) add(id string, score int64) {
+	z.scores[id] = score
+}
+
+func (z *zset) remove(id string) bool {
+	if _, ok := z.scores[id]; !ok {
+		return false
+	}
+	delete(z.scores, id)
+	return true
+}
+
+func (z *zset) score(id string) (int64, bool) {
+	s, ok := z.scores[id]
+	return s, ok
+}
+
+func (z *zset) len() int {
+	return len(z.scores)
+}
+
+// members returns all IDs in the set ordered by score, ties are broken by ID.
+func (z *zset) members() []string {
+	ids := make([]string, 0, len(z.scores))
+	for id := range z.scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		si, sj := z.scores[ids[i]], z.scores[ids[j]]
+		if si != sj {
+			return si < sj
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// rangeByScore returns IDs with a score less than or equal to max, ordered by score.
+func (z *zset) rangeByScore(max int64) []string {
+	var ids []string
+	for _, id := range z.members() {
+		if z.scores[id] > max {
+			break
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func removeID(ids []string, id string) ([]string, bool) {
+	for i, x := range ids {
+		if x == id {
+			return append(ids[:i], ids[i+1:]...), true
+		}
+	}
+	return ids, false
+}
+
+// getQueue returns the data for the given queue, creating it if necessary.
+// Caller must hold db.mu.
+func (db *MemDB) getQueue(qname string) *queue {
+	q, ok := db.queues[qname]
+	if !ok {
+		q = newQueue()
+		db.queues[qname] = q
+	}
+	return q
+}
+
+// Reports whether a queue with the given name exists.
+// Caller must hold db.mu.
+func (db *MemDB) queueExists(qname string) bool {
+	_, ok := db.allQueues[qname]
+	return ok
+}
+
+// acquireUniqueLock acquires the uniqueness lock for the task if the lock is not held.
+// Caller must hold db.mu.
+func (db *MemDB) acquireUniqueLock(key, id string, ttl time.Duration) bool {
+	if l, ok := db.uniqueLocks[key]; ok && db.clock.Now().Before(l.expireAt) {
+		return false
+	}
+	db.uniqueLocks[key] = &uniqueLock{id: id, expireAt: db.clock.Now().Add(time.Duration(int(ttl.Seconds())) * time.Second)}
+	return true
+}
+
+// releaseUniqueLock deletes the uniqueness lock or the debounce key if it is held by the task.
+// Caller must hold db.mu.
+func (db *MemDB) releaseUniqueLock(key, id string) {
+	if key == "" {
+		return
+	}
+	if l, ok := db.uniqueLocks[key]; ok && l.id == id {
+		delete(db.uniqueLocks, key)
+	}
+	if db.debounceKeys[key] == id {
+		delete(db.debounceKeys, key)
+	}
+}
+
+// addTask stores the task in the given queue.
+// It returns false if a task with the same ID already exists.
+// Caller must hold db.mu.
+func (db *MemDB) addTask(q *queue, id string, t *task) bool {
+	if _, ok := q.tasks[id]; ok {
+		return false
+	}
+	q.tasks[id] = t
+	return true
+}
+
+// Enqueue adds the given task to the pending list of the queue.
+func (db *MemDB) Enqueue(ctx context.Context, msg *base.TaskMessage) error {
+	var op errors.Op = "memdb.Enqueue"
+	encoded, err := base.EncodeMessage(msg)
+	if err != nil {
+		return errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.allQueues[msg.Queue] = struct{}{}
+	q := db.getQueue(msg.Queue)
+	t := &task{
+		msg:          encoded,
+		state:        base.TaskStatePending,
+		pendingSince: db.clock.Now().UnixNano(),
+		fairnessKey:  msg.FairnessKey,
+		priority:     msg.Priority,
+		expireAt:     msg.ExpireAt,
+	}
+	if !db.addTask(q, msg.ID, t) {
+		return errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+	}
+	q.pending = append(q.pending, msg.ID)
+	db.notify(base.WakeupChannel(msg.Queue))
+	return nil
+}
+
+// EnqueueUnique inserts the given task if the task's uniqueness lock can be acquired.
+// It returns ErrDuplicateTask if the lock cannot be acquired.
+func (db *MemDB) EnqueueUnique(ctx context.Context, msg *base.TaskMessage, ttl time.Duration) error {
+	var op errors.Op = "memdb.EnqueueUnique"
+	encoded, err := base.EncodeMessage(msg)
+	if err != nil {
+		return errors.E(op, errors.Internal, "cannot encode task message: %v", err)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.allQueues[msg.Queue] = struct{}{}
+	if !db.acquireUniqueLock(msg.UniqueKey, msg.ID, ttl) {
+		return errors.E(op, errors.AlreadyExists, errors.ErrDuplicateTask)
+	}
+	q := db.getQueue(msg.Queue)
+	t := &task{
+		msg:          encoded,
+		state:        base.TaskStatePending,
+		pendingSince: db.clock.Now().UnixNano(),
+		uniqueKey:    msg.UniqueKey,
+		fairnessKey:  msg.FairnessKey,
+		priority:     msg.Priority,
+		expireAt:     msg.ExpireAt,
+	}
+	if !db.addTask(q, msg.ID, t) {
+		return errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+	}
+	q.pending = append(q.pending, msg.ID)
+	db.notify(base.WakeupChannel(msg.Queue))
+	return nil
+}
+
+// Dequeue queries given queues in order and pops a task message
+// off a queue if one exists and returns the message, its lease expiration time
+// and the time the task became pending (zero if unknown).
+// Dequeue skips a queue if the queue is paused.
+// Tasks with higher priority are popped first. Among the tasks with the default priority,
+// if a queue has tasks with fairness keys, the fairness keys take turns in round-robin order.
+// If all queues are empty, ErrNoProcessableTask error is returned.
+func (db *MemDB) Dequeue(qnames ...string) (msg *base.TaskMessage, leaseExpirationTime, pendingSince time.Time, err error) {
+	return db.dequeue("memdb.Dequeue", nil, qnames)
+}
+
+// DequeueExcept is like Dequeue, but it leaves the tasks of the excluded types in the queues
+// and pops the first task of another type instead, in the same order as Dequeue.
+func (db *MemDB) DequeueExcept(excludedTypes []string, qnames ...string) (msg *base.TaskMessage, leaseExpirationTime, pendingSince time.Time, err error) {
+	return db.dequeue("memdb.DequeueExcept", excludedTypes, qnames)
+}
+
+func (db *MemDB) dequeue(op errors.Op, excludedTypes []string, qnames []string) (msg *base.TaskMessage, leaseExpirationTime, pendingSince time.Time, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, qname := range qnames {
+		q, ok := db.queues[qname]
+		if !ok || q.paused {
+			continue
+		}
+		i := q.nextPending(excludedTypes)
+		if i < 0 {
+			continue
+		}
+		id := q.pending[i]
+		q.pending = append(q.pending[:i:i], q.pending[i+1:]...)
+		t := q.tasks[id]
+		t.state = base.TaskStateActive
+		if t.pendingSince != 0 {
+			pendingSince = time.Unix(0, t.pendingSince)
+		}
+		t.pendingSince = 0
+		q.active = append(q.active, id)
+		leaseExpirationTime = db.clock.Now().Add(rdb.LeaseDuration)
+		q.lease.add(id, leaseExpirationTime.Unix())
+		if msg, err = base.DecodeMessage(t.msg); err != nil {
+			return nil, time.Time{}, time.Time{}, errors.E(op, errors.Internal, fmt.Sprintf("cannot decode message: %v", err))
+		}
+		return msg, leaseExpirationTime, pendingSince, nil
+	}
+	return nil, time.Time{}, time.Time{}, errors.E(op, errors.NotFound, errors.ErrNoProcessableTask)
+}
+
+// nextPending returns the index of the pending task to dequeue next, or -1 if there is none.
+// Tasks of the excluded types are skipped.
+// If some pending tasks have priority, it returns the oldest task of the highest priority.
+// Otherwise, if the pending tasks have fairness keys, it returns the oldest task of the least
+// recently served key, in which tasks without fairness key share the empty key.
+func (q *queue) nextPending(excludedTypes []string) int {
+	keys := make(map[string]bool) // fairness keys of the pending tasks
+	var candidates []int
+	for i, id := range q.pending {
+		keys[q.tasks[id].fairnessKey] = true
+		if len(excludedTypes) == 0 || !isExcludedType(q.tasks[id].msg, excludedTypes) {
+			candidates = append(candidates, i)
+		}
+	}
+	for fkey := range q.fairnessServed {
+		if !keys[fkey] {
+			delete(q.fairnessServed, fkey)
+		}
+	}
+	if len(candidates) == 0 {
+		return -1
+	}
+	highest := -1
+	for _, i := range candidates {
+		if p := q.tasks[q.pending[i]].priority; p > 0 && (highest == -1 || p > q.tasks[q.pending[highest]].priority) {
+			highest = i
+		}
+	}
+	if highest >= 0 {
+		return highest
+	}
+	oldest := make(map[string]int) // fairness key -> index of the oldest pending task
+	for _, i := range candidates {
+		fkey := q.tasks[q.pending[i]].fairnessKey
+		if _, ok := oldest[fkey]; !ok {
+			oldest[fkey] = i
+		}
+	}
+	if len(keys) == 1 {
+		return candidates[0]
+	}
+	next := -1
+	var nextKey string
+	for fkey, i := range oldest {
+		if next == -1 || q.fairnessServed[fkey] < q.fairnessServed[nextKey] ||
+			(q.fairnessServed[fkey] == q.fairnessServed[nextKey] && i < next) {
+			next, nextKey = i, fkey
+		}
+	}
+	q.fairnessSeq++
+	q.fairnessServed[nextKey] = q.fairnessSeq
+	return next
+}
+
+// isExcludedType reports whether the encoded message is a task of one of the excluded types.
+func isExcludedType(encoded []byte, excludedTypes []string) bool {
+	msg, err := base.DecodeMessage(encoded)
+	if err != nil {
+		return false
+	}
+	for _, typename := range excludedTypes {
+		if msg.Type == typename {
+			return true
+		}
+	}
+	return false
+}
+
+// removeActive removes the task from the active list and the lease set.
+// Caller must hold db.mu.
+func (db *MemDB) removeActive(op errors.Op, qname, id string) (*queue, error) {
+	q, ok := db.queues[qname]
+	if !ok {
+		return nil, errors.E(op, errors.NotFound, &errors.TaskNotFoundError{Queue: qname, ID: id})
+	}
+	var found bool
+	if q.active, found = removeID(q.active, id); !found {
+		return nil, errors.E(op, errors.NotFound, &errors.TaskNotFoundError{Queue: qname, ID: id})
+	}
+	if !q.lease.remove(id) {
+		return nil, errors.E(op, errors.NotFound, &errors.TaskNotFoundError{Queue: qname, ID: id})
+	}
+	if _, ok := q.tasks[id]; !ok {
+		return nil, errors.E(op, errors.NotFound, &errors.TaskNotFoundError{Queue: qname, ID: id})
+	}
+	return q, nil
+}
+
+// Done removes the task from active queue and deletes the task.
+// It removes a uniqueness lock acquired by the task, if any.
+func (db *MemDB) Done(ctx context.Context, msg *base.TaskMessage) error {
+	var op errors.Op = "memdb.Done"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, err := db.removeActive(op, msg.Queue, msg.ID)
+	if err != nil {
+		return err
+	}
+	db.releaseCompleted(q, msg)
+	delete(q.tasks, msg.ID)
+	q.recordProcessed(db.clock.Now(), false)
+	db.releaseUniqueLock(msg.UniqueKey, msg.ID)
+	return nil
+}
+
+// MarkAsComplete removes the task from active queue to mark the task as completed.
+// It removes a uniqueness lock acquired by the task, if any.
+func (db *MemDB) MarkAsComplete(ctx context.Context, msg *base.TaskMessage) error {
+	var op errors.Op = "memdb.MarkAsComplete"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := db.clock.Now()
+	msg.CompletedAt = now.Unix()
+	encoded, err := base.EncodeMessage(msg)
+	if err != nil {
+		return errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+	}
+	q, err := db.removeActive(op, msg.Queue, msg.ID)
+	if err != nil {
+		return err
+	}
+	db.releaseCompleted(q, msg)
+	t := q.tasks[msg.ID]
+	t.msg = encoded
+	t.state = base.TaskStateCompleted
+	q.completed.add(msg.ID, now.Unix()+msg.Retention)
+	q.recordProcessed(now, false)
+	db.releaseUniqueLock(msg.UniqueKey, msg.ID)
+	db.notify(base.CompletionChannel(msg.Queue, msg.ID))
+	return nil
+}
+
+// Requeue moves the task from active queue to the specified queue.
+func (db *MemDB) Requeue(ctx context.Context, msg *base.TaskMessage) error {
+	var op errors.Op = "memdb.Requeue"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, err := db.removeActive(op, msg.Queue, msg.ID)
+	if err != nil {
+		return err
+	}
+	t := q.tasks[msg.ID]
+	t.state = base.TaskStatePending
+	t.pendingSince = db.clock.Now().UnixNano()
+	// Push to the head of the queue so that the task is dequeued next.
+	q.pending = append([]string{msg.ID}, q.pending...)
+	return nil
+}
+
+// AddToGroup adds the task to the group in the queue.
+func (db *MemDB) AddToGroup(ctx context.Context, msg *base.TaskMessage, groupKey string) error {
+	var op errors.Op = "memdb.AddToGroup"
+	encoded, err := base.EncodeMessage(msg)
+	if err != nil {
+		return errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.allQueues[msg.Queue] = struct{}{}
+	q := db.getQueue(msg.Queue)
+	if !db.addTask(q, msg.ID, &task{msg: encoded, state: base.TaskStateAggregating, group: groupKey}) {
+		return errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+	}
+	db.addToGroup(q, groupKey, msg.ID)
+	return nil
+}
+
+// AddToGroupUnique adds the task to the group in the queue if the task's uniqueness lock can be acquired.
+// It returns ErrDuplicateTask if the lock cannot be acquired.
+func (db *MemDB) AddToGroupUnique(ctx context.Context, msg *base.TaskMessage, groupKey string, ttl time.Duration) error {
+	var op errors.Op = "memdb.AddToGroupUnique"
+	encoded, err := base.EncodeMessage(msg)
+	if err != nil {
+		return errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.allQueues[msg.Queue] = struct{}{}
+	uniqueKey := base.UniqueKey(msg.Queue, msg.Type, msg.Payload)
+	if !db.acquireUniqueLock(uniqueKey, msg.ID, ttl) {
+		return errors.E(op, errors.AlreadyExists, errors.ErrDuplicateTask)
+	}
+	q := db.getQueue(msg.Queue)
+	t := &task{msg: encoded, state: base.TaskStateAggregating, group: groupKey, uniqueKey: uniqueKey}
+	if !db.addTask(q, msg.ID, t) {
+		return errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+	}
+	db.addToGroup(q, groupKey, msg.ID)
+	return nil
+}
+
+// addToGroup adds the task ID to the group.
+// Caller must hold db.mu.
+func (db *MemDB) addToGroup(q *queue, gname, id string) {
+	g, ok := q.groups[gname]
+	if !ok {
+		g = newZSet()
+		q.groups[gname] = g
+	}
+	g.add(id, db.clock.Now().Unix())
+}
+
+// Schedule adds the task to the scheduled set to be processed in the future.
+func (db *MemDB) Schedule(ctx context.Context, msg *base.TaskMessage, processAt time.Time) error {
+	var op errors.Op = "memdb.Schedule"
+	encoded, err := base.EncodeMessage(msg)
+	if err != nil {
+		return errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.allQueues[msg.Queue] = struct{}{}
+	q := db.getQueue(msg.Queue)
+	if !db.addTask(q, msg.ID, &task{msg: encoded, state: base.TaskStateScheduled, fairnessKey: msg.FairnessKey, priority: msg.Priority, expireAt: msg.ExpireAt}) {
+		return errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+	}
+	q.scheduled.add(msg.ID, base.ProcessAtScore(processAt))
+	db.notify(base.ScheduleChannel(msg.Queue))
+	return nil
+}
+
+// ScheduleUnique adds the task to the backlog queue to be processed in the future if the uniqueness lock can be acquired.
+// It returns ErrDuplicateTask if the lock cannot be acquired.
+func (db *MemDB) ScheduleUnique(ctx context.Context, msg *base.TaskMessage, processAt time.Time, ttl time.Duration) error {
+	var op errors.Op = "memdb.ScheduleUnique"
+	encoded, err := base.EncodeMessage(msg)
+	if err != nil {
+		return errors.E(op, errors.Internal, fmt.Sprintf("cannot encode task message: %v", err))
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.allQueues[msg.Queue] = struct{}{}
+	if !db.acquireUniqueLock(msg.UniqueKey, msg.ID, ttl) {
+		return errors.E(op, errors.AlreadyExists, errors.ErrDuplicateTask)
+	}
+	q := db.getQueue(msg.Queue)
+	if !db.addTask(q, msg.ID, &task{msg: encoded, state: base.TaskStateScheduled, uniqueKey: msg.UniqueKey, fairnessKey: msg.FairnessKey, priority: msg.Priority, expireAt: msg.ExpireAt}) {
+		return errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+	}
+	q.scheduled.add(msg.ID, base.ProcessAtScore(processAt))
+	db.notify(base.ScheduleChannel(msg.Queue))
+	return nil
+}
+
+// Debounce schedules the task to be processed at processAt, unless the task holding the
+// debounce key (i.e. msg.UniqueKey) is still pending or scheduled, in which case that task's
+// message is replaced with msg and its process time is pushed out to processAt.
+// It returns the ID of the surviving task.
+func (db *MemDB) Debounce(ctx context.Context, msg *base.TaskMessage, processAt time.Time) (string, error) {
+	var op errors.Op = "memdb.Debounce"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.allQueues[msg.Queue] = struct{}{}
+	q := db.getQueue(msg.Queue)
+	if id, ok := db.debounceKeys[msg.UniqueKey]; ok {
+		if t, ok := q.tasks[id]; ok && (t.state == base.TaskStatePending || t.state == base.TaskStateScheduled) {
+			m := *msg
+			m.ID = id
+			encoded, err := base.EncodeMessage(&m)
+			if err != nil {
+				return "", errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+			}
+			db.removeTask(q, id, t)
+			t.msg = encoded
+			t.state = base.TaskStateScheduled
+			t.pendingSince = 0
+			q.scheduled.add(id, base.ProcessAtScore(processAt))
+			db.notify(base.ScheduleChannel(msg.Queue))
+			return id, nil
+		}
+	}
+	encoded, err := base.EncodeMessage(msg)
+	if err != nil {
+		return "", errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+	}
+	if !db.addTask(q, msg.ID, &task{msg: encoded, state: base.TaskStateScheduled, uniqueKey: msg.UniqueKey}) {
+		return "", errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+	}
+	q.scheduled.add(msg.ID, base.ProcessAtScore(processAt))
+	db.debounceKeys[msg.UniqueKey] = msg.ID
+	db.notify(base.ScheduleChannel(msg.Queue))
+	return msg.ID, nil
+}
+
+// EnqueueBatch enqueues each of the given messages, and returns an error
+// for each message, nil if the message was enqueued.
+// A message is scheduled if its ProcessAt is set, otherwise it is added to the pending list.
+func (db *MemDB) EnqueueBatch(ctx context.Context, msgs []*base.BatchMessage) ([]error, error) {
+	errs := make([]error, len(msgs))
+	for i, m := range msgs {
+		switch {
+		case m.ProcessAt.IsZero() && m.UniqueTTL > 0:
+			errs[i] = db.EnqueueUnique(ctx, m.Msg, m.UniqueTTL)
+		case m.ProcessAt.IsZero():
+			errs[i] = db.Enqueue(ctx, m.Msg)
+		case m.UniqueTTL > 0:
+			errs[i] = db.ScheduleUnique(ctx, m.Msg, m.ProcessAt, m.UniqueTTL)
+		default:
+			errs[i] = db.Schedule(ctx, m.Msg, m.ProcessAt)
+		}
+	}
+	return errs, nil
+}
+
+// EnqueueWaiting adds the given task to the waiting set of the queue, where the task stays
+// until all of its dependencies complete. If all dependencies have already completed,
+// the task is added to the pending list instead.
+// It returns the state the task was put in.
+//
+// It returns an error wrapping TaskNotFoundError if a dependency does not exist, unless ignoreMissingDeps
+// is true, in which case the dependency is considered completed since a task processed without retention
+// is deleted once it completes.
+func (db *MemDB) EnqueueWaiting(ctx context.Context, msg *base.TaskMessage, ignoreMissingDeps bool) (base.TaskState, error) {
+	return db.enqueueWaiting("memdb.EnqueueWaiting", msg, 0, ignoreMissingDeps)
+}
+
+// EnqueueWaitingUnique adds the given task to the waiting set of the queue if the task's
+// uniqueness lock can be acquired. See EnqueueWaiting for how dependencies are handled.
+// It returns ErrDuplicateTask if the lock cannot be acquired.
+func (db *MemDB) EnqueueWaitingUnique(ctx context.Context, msg *base.TaskMessage, ttl time.Duration, ignoreMissingDeps bool) (base.TaskState, error) {
+	return db.enqueueWaiting("memdb.EnqueueWaitingUnique", msg, ttl, ignoreMissingDeps)
+}
+
+// enqueueWaiting implements EnqueueWaiting and EnqueueWaitingUnique.
+// A uniqueness lock is acquired only if ttl is positive.
+func (db *MemDB) enqueueWaiting(op errors.Op, msg *base.TaskMessage, ttl time.Duration, ignoreMissingDeps bool) (base.TaskState, error) {
+	encoded, err := base.EncodeMessage(msg)
+	if err != nil {
+		return 0, errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.allQueues[msg.Queue] = struct{}{}
+	q := db.getQueue(msg.Queue)
+	if _, ok := q.tasks[msg.ID]; ok {
+		return 0, errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+	}
+	deps := make(map[string]struct{})
+	for _, id := range msg.Dependencies {
+		parent, ok := q.tasks[id]
+		if !ok {
+			if !ignoreMissingDeps {
+				return 0, errors.E(op, errors.NotFound, &errors.TaskNotFoundError{Queue: msg.Queue, ID: id})
+			}
+			continue
+		}
+		switch parent.state {
+		case base.TaskStateArchived:
+			return 0, errors.E(op, errors.FailedPrecondition, &errors.TaskAlreadyArchivedError{Queue: msg.Queue, ID: id})
+		case base.TaskStateCompleted:
+			continue
+		}
+		deps[id] = struct{}{}
+	}
+	t := &task{msg: encoded, orderingKey: msg.OrderingKey}
+	if ttl > 0 {
+		if !db.acquireUniqueLock(msg.UniqueKey, msg.ID, ttl) {
+			return 0, errors.E(op, errors.AlreadyExists, errors.ErrDuplicateTask)
+		}
+		t.uniqueKey = msg.UniqueKey
+	}
+	now := db.clock.Now()
+	turn := true
+	if msg.OrderingKey != "" {
+		q.ordering[msg.OrderingKey] = append(q.ordering[msg.OrderingKey], msg.ID)
+		turn = len(q.ordering[msg.OrderingKey]) == 1
+	}
+	if len(deps) == 0 && turn {
+		t.state = base.TaskStatePending
+		t.pendingSince = now.UnixNano()
+		q.tasks[msg.ID] = t
+		q.pending = append(q.pending, msg.ID)
+		return base.TaskStatePending, nil
+	}
+	t.state = base.TaskStateWaiting
+	if len(deps) > 0 {
+		t.deps = deps
+	}
+	q.tasks[msg.ID] = t
+	for id := range deps {
+		parent := q.tasks[id]
+		if parent.dependents == nil {
+			parent.dependents = make(map[string]struct{})
+		}
+		parent.dependents[msg.ID] = struct{}{}
+	}
+	q.waiting.add(msg.ID, now.Unix())
+	return base.TaskStateWaiting, nil
+}
+
+// releaseCompleted moves the tasks waiting for the given completed task to the pending list,
+// and notifies the wakeup subscribers of the queue if any task was moved.
+// Caller must hold db.mu.
+func (db *MemDB) releaseCompleted(q *queue, msg *base.TaskMessage) {
+	n := db.releaseDependents(q, msg.Queue, msg.ID)
+	n += db.releaseOrdering(q, msg.Queue, q.tasks[msg.ID].orderingKey, msg.ID)
+	n += db.advanceChain(q, msg.Queue, msg.ID)
+	if n > 0 {
+		db.notify(base.WakeupChannel(msg.Queue))
+	}
+}
+
+// releaseDependents marks the task with the given id as completed for all the tasks
+// waiting for it, and moves the waiting tasks with no remaining dependencies to the pending list.
+// It records the released events of the moved tasks, and returns the number of tasks moved.
+// Caller must hold db.mu.
+func (db *MemDB) releaseDependents(q *queue, qname, id string) int {
+	t, ok := q.tasks[id]
+	if !ok {
+		return 0
+	}
+	n := 0
+	for childID := range t.dependents {
+		child, ok := q.tasks[childID]
+		if !ok || child.state != base.TaskStateWaiting {
+			continue
+		}
+		delete(child.deps, id)
+		if len(child.deps) > 0 {
+			continue
+		}
+		q.waiting.remove(childID)
+		child.deps = nil
+		child.state = base.TaskStatePending
+		child.pendingSince = db.clock.Now().UnixNano()
+		q.pending = append(q.pending, childID)
+		db.recordEvent(base.TaskEventReleased, qname, childID, child)
+		n++
+	}
+	t.dependents = nil
+	return n
+}
+
+// releaseOrdering removes the task with the given id from the tasks with the ordering key,
+// and moves the next task with the key to the pending list.
+// Tasks which no longer exist, or are archived or completed, are skipped.
+// It records the released event of the moved task, and returns the number of tasks moved.
+// Caller must hold db.mu.
+func (db *MemDB) releaseOrdering(q *queue, qname, okey, id string) int {
+	if okey == "" {
+		return 0
+	}
+	n := 0
+	ids := q.ordering[okey]
+	for i, tid := range ids {
+		if tid == id {
+			ids = append(ids[:i:i], ids[i+1:]...)
+			break
+		}
+	}
+	for len(ids) > 0 {
+		t, ok := q.tasks[ids[0]]
+		if ok && t.state == base.TaskStateWaiting {
+			if len(t.deps) == 0 {
+				q.waiting.remove(ids[0])
+				t.state = base.TaskStatePending
+				t.pendingSince = db.clock.Now().UnixNano()
+				q.pending = append(q.pending, ids[0])
+				db.recordEvent(base.TaskEventReleased, qname, ids[0], t)
+				n++
+			}
+			break
+		}
+		if ok && t.state != base.TaskStateArchived && t.state != base.TaskStateCompleted {
+			break
+		}
+		ids = ids[1:]
+	}
+	if len(ids) == 0 {
+		delete(q.ordering, okey)
+		return n
+	}
+	q.ordering[okey] = ids
+	return n
+}
+
+// ReleaseOrderingKeys moves the next task of each ordering key in the given queue to the
+// pending list, if the previous task with the key no longer exists or is archived.
+func (db *MemDB) ReleaseOrderingKeys(qname string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, ok := db.queues[qname]
+	if !ok {
+		return nil
+	}
+	n := 0
+	for okey := range q.ordering {
+		n += db.releaseOrdering(q, qname, okey, "")
+	}
+	if n > 0 {
+		db.notify(base.WakeupChannel(qname))
+	}
+	return nil
+}
+
+// ArchiveExpiredTasks archives the pending, scheduled and retry tasks in the given queue whose
+// expiration time has passed, with base.ExpiredErrMsg as their error message.
+func (db *MemDB) ArchiveExpiredTasks(qname string) error {
+	var op errors.Op = "memdb.ArchiveExpiredTasks"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, ok := db.queues[qname]
+	if !ok {
+		return nil
+	}
+	now := db.clock.Now()
+	for id, t := range q.tasks {
+		if t.expireAt == 0 || t.expireAt > now.Unix() {
+			continue
+		}
+		switch t.state {
+		case base.TaskStatePending, base.TaskStateScheduled, base.TaskStateRetry:
+		default:
+			continue
+		}
+		msg, err := base.DecodeMessage(t.msg)
+		if err != nil {
+			return errors.E(op, errors.Internal, fmt.Sprintf("cannot decode message: %v", err))
+		}
+		msg.ErrorMsg = base.ExpiredErrMsg
+		msg.LastFailedAt = now.Unix()
+		encoded, err := base.EncodeMessage(msg)
+		if err != nil {
+			return errors.E(op, errors.Internal, fmt.Sprintf("cannot encode message: %v", err))
+		}
+		db.removeTask(q, id, t)
+		t.msg = encoded
+		t.state = base.TaskStateArchived
+		q.addToArchive(id, now)
+		db.notify(base.CompletionChannel(qname, id))
+		db.recordEvent(base.TaskEventArchived, qname, id, t)
+	}
+	return nil
+}
+
+// ArchiveBlockedTasks archives the waiting tasks in the given queue which depend
+// on a task that was archived or deleted, since such tasks can never become pending.
+// The tasks are archived with base.BlockedErrMsg as their error message.
+func (db *MemDB) ArchiveBlockedTasks(qname string) error {
+	var op errors.Op = "memdb.ArchiveBlockedTasks"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, ok := db.queues[qname]
+	if !ok {
+		return nil
+	}
+	now := db.clock.Now()
+	// Archiving a task blocks the tasks waiting for it in turn,
+	// so repeat until no more tasks are archived.
+	for archived := true; archived; {
+		archived = false
+		for _, id := range q.waiting.members() {
+			t, ok := q.tasks[id]
+			if !ok || !q.isBlocked(t) {
+				continue
+			}
+			msg, err := base.DecodeMessage(t.msg)
+			if err != nil {
+				return errors.E(op, errors.Internal, fmt.Sprintf("cannot decode message: %v", err))
+			}
+			msg.ErrorMsg = base.BlockedErrMsg
+			msg.LastFailedAt = now.Unix()
+			encoded, err := base.EncodeMessage(msg)
+			if err != nil {
+				return errors.E(op, errors.Internal, fmt.Sprintf("cannot encode message: %v", err))
+			}
+			q.waiting.remove(id)
+			t.msg = encoded
+			t.deps = nil
+			t.state = base.TaskStateArchived
+			q.addToArchive(id, now)
+			db.notify(base.CompletionChannel(qname, id))
+			db.recordEvent(base.TaskEventArchived, qname, id, t)
+			archived = true
+		}
+	}
+	return nil
+}
+
+// isBlocked reports whether the waiting task depends on a task that was archived or deleted.
+func (q *queue) isBlocked(t *task) bool {
+	for dep := range t.deps {
+		parent, ok := q.tasks[dep]
+		if !ok || parent.state == base.TaskStateArchived {
+			return true
+		}
+	}
+	return false
+}
+
+// EnqueueChain stores the given task messages as the steps of a chain and
+// adds the first step to the pending list of the queue.
+// Each of the following steps is added to the pending list when the previous step completes.
+//
+// All messages must belong to the same queue and share the same ChainID.
+func (db *MemDB) EnqueueChain(ctx context.Context, msgs []*base.TaskMessage) error {
+	var op errors.Op = "memdb.EnqueueChain"
+	if len(msgs) == 0 {
+		return errors.E(op, errors.FailedPrecondition, "chain must have at least one step")
+	}
+	c := &chain{results: make([][]byte, len(msgs))}
+	for _, msg := range msgs {
+		encoded, err := base.EncodeMessage(msg)
+		if err != nil {
+			return errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+		}
+		c.ids = append(c.ids, msg.ID)
+		c.msgs = append(c.msgs, encoded)
+	}
+	qname, chainID := msgs[0].Queue, msgs[0].ChainID
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.allQueues[qname] = struct{}{}
+	q := db.getQueue(qname)
+	if _, ok := db.getChain(q, chainID); ok {
+		return errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+	}
+	for _, id := range c.ids {
+		if _, ok := q.tasks[id]; ok {
+			return errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+		}
+	}
+	q.chains[chainID] = c
+	q.tasks[c.ids[0]] = &task{
+		msg:          c.msgs[0],
+		state:        base.TaskStatePending,
+		pendingSince: db.clock.Now().UnixNano(),
+		chainID:      chainID,
+	}
+	q.pending = append(q.pending, c.ids[0])
+	return nil
+}
+
+// getChain returns the chain with the given id, deleting it first if it has expired.
+// Caller must hold db.mu.
+func (db *MemDB) getChain(q *queue, chainID string) (*chain, bool) {
+	c, ok := q.chains[chainID]
+	if ok && c.expireAt != 0 && c.expireAt <= db.clock.Now().Unix() {
+		delete(q.chains, chainID)
+		return nil, false
+	}
+	return c, ok
+}
+
+// advanceChain records the result of the task with the given id in its chain
+// and moves the next step of the chain to the pending list.
+// It records the enqueued event of the next step, and returns the number of tasks moved.
+// Caller must hold db.mu.
+func (db *MemDB) advanceChain(q *queue, qname, id string) int {
+	t, ok := q.tasks[id]
+	if !ok || t.chainID == "" {
+		return 0
+	}
+	c, ok := db.getChain(q, t.chainID)
+	if !ok {
+		return 0
+	}
+	c.results[t.chainStep] = t.result
+	next := t.chainStep + 1
+	now := db.clock.Now()
+	if next == len(c.ids) {
+		c.current = next
+		c.expireAt = now.Add(chainExpiration).Unix()
+		return 0
+	}
+	if _, ok := q.tasks[c.ids[next]]; ok {
+		c.err = "task ID conflicts with another task"
+		return 0
+	}
+	q.tasks[c.ids[next]] = &task{
+		msg:          c.msgs[next],
+		state:        base.TaskStatePending,
+		pendingSince: now.UnixNano(),
+		chainID:      t.chainID,
+		chainStep:    next,
+	}
+	q.pending = append(q.pending, c.ids[next])
+	c.current = next
+	db.recordEvent(base.TaskEventEnqueued, qname, c.ids[next], q.tasks[c.ids[next]])
+	return 1
+}
+
+// ReadChainResult returns the result written by the given step of the chain.
+// It returns nil if the step has not completed yet or did not write any result.
+func (db *MemDB) ReadChainResult(qname, chainID string, step int) ([]byte, error) {
+	var op errors.Op = "memdb.ReadChainResult"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, ok := db.queues[qname]
+	if !ok {
+		return nil, errors.E(op, errors.NotFound, &errors.ChainNotFoundError{Queue: qname, ID: chainID})
+	}
+	c, ok := db.getChain(q, chainID)
+	if !ok {
+		return nil, errors.E(op, errors.NotFound, &errors.ChainNotFoundError{Queue: qname, ID: chainID})
+	}
+	if step < 0 || step >= len(c.results) {
+		return nil, nil
+	}
+	return c.results[step], nil
+}
+
+// Retry moves the task from active to retry queue.
+// It also annotates the message with the given error message and
+// if isFailure is true increments the retried counter.
+func (db *MemDB) Retry(ctx context.Context, msg *base.TaskMessage, processAt time.Time, errMsg string, isFailure bool) error {
+	var op errors.Op = "memdb.Retry"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := db.clock.Now()
+	modified := *msg
+	if isFailure {
+		modified.Retried++
+	}
+	modified.ErrorMsg = errMsg
+	modified.LastFailedAt = now.Unix()
+	encoded, err := base.EncodeMessage(&modified)
+	if err != nil {
+		return errors.E(op, errors.Internal, fmt.Sprintf("cannot encode message: %v", err))
+	}
+	q, err := db.removeActive(op, msg.Queue, msg.ID)
+	if err != nil {
+		return err
+	}
+	t := q.tasks[msg.ID]
+	t.msg = encoded
+	t.state = base.TaskStateRetry
+	q.retry.add(msg.ID, base.ProcessAtScore(processAt))
+	db.notify(base.ScheduleChannel(msg.Queue))
+	if isFailure {
+		q.recordProcessed(now, true)
+	}
+	return nil
+}
+
+// Archive sends the given task to archive, attaching the error message to the task.
+// It also trims the archive by timestamp and set size.
+func (db *MemDB) Archive(ctx context.Context, msg *base.TaskMessage, errMsg string) error {
+	var op errors.Op = "memdb.Archive"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := db.clock.Now()
+	modified := *msg
+	modified.ErrorMsg = errMsg
+	modified.LastFailedAt = now.Unix()
+	encoded, err := base.EncodeMessage(&modified)
+	if err != nil {
+		return errors.E(op, errors.Internal, fmt.Sprintf("cannot encode message: %v", err))
+	}
+	q, err := db.removeActive(op, msg.Queue, msg.ID)
+	if err != nil {
+		return err
+	}
+	t := q.tasks[msg.ID]
+	t.msg = encoded
+	t.state = base.TaskStateArchived
+	q.addToArchive(msg.ID, now)
+	q.recordProcessed(now, true)
+	if db.releaseOrdering(q, msg.Queue, t.orderingKey, msg.ID) > 0 {
+		db.notify(base.WakeupChannel(msg.Queue))
+	}
+	db.notify(base.CompletionChannel(msg.Queue, msg.ID))
+	return nil
+}
+
+// ForwardIfReady checks scheduled and retry sets of the given queues
+// and move any tasks that are ready to be processed to the pending set.
+func (db *MemDB) ForwardIfReady(qnames ...string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := db.clock.Now()
+	for _, qname := range qnames {
+		q, ok := db.queues[qname]
+		if !ok {
+			continue
+		}
+		for _, delayed := range []*zset{q.scheduled, q.retry} {
+			for _, id := range delayed.rangeByScore(base.ProcessAtScore(now)) {
+				delayed.remove(id)
+				t := q.tasks[id]
+				if t.group != "" {
+					t.state = base.TaskStateAggregating
+					db.addToGroup(q, t.group, id)
+				} else {
+					t.state = base.TaskStatePending
+					t.pendingSince = now.UnixNano()
+					q.pending = append(q.pending, id)
+					db.notify(base.WakeupChannel(qname))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// NextProcessAt returns the earliest time at which a scheduled or retry task
+// of the given queues is to be processed, or zero time if there is no such task.
+func (db *MemDB) NextProcessAt(qnames ...string) (time.Time, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var next time.Time
+	for _, qname := range qnames {
+		q, ok := db.queues[qname]
+		if !ok {
+			continue
+		}
+		for _, delayed := range []*zset{q.scheduled, q.retry} {
+			for _, score := range delayed.scores {
+				if t := base.ProcessAtFromScore(score); next.IsZero() || t.Before(next) {
+					next = t
+				}
+			}
+		}
+	}
+	return next, nil
+}
+
+// ListGroups returns a list of all known groups in the given queue.
+func (db *MemDB) ListGroups(qname string) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, ok := db.queues[qname]
+	if !ok {
+		return nil, nil
+	}
+	var groups []string
+	for gname := range q.groups {
+		groups = append(groups, gname)
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// AggregationCheck checks the group identified by the given queue and group name to see if the tasks in the
+// group are ready to be aggregated. If so, it moves the tasks to be aggregated to a aggregation set and returns
+// the set ID. If not, it returns an empty string for the set ID.
+// The time for gracePeriod and maxDelay is computed relative to the time t.
+func (db *MemDB) AggregationCheck(qname, gname string, t time.Time, gracePeriod, maxDelay time.Duration, maxSize int) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, ok := db.queues[qname]
+	if !ok {
+		return "", nil
+	}
+	g, ok := q.groups[gname]
+	if !ok || g.len() == 0 {
+		return "", nil
+	}
+	ids := g.members()
+	ready := maxSize != 0 && len(ids) >= maxSize
+	if !ready && maxDelay != 0 {
+		oldest, _ := g.score(ids[0])
+		ready = oldest <= t.Unix()-int64(maxDelay.Seconds())
+	}
+	if !ready {
+		latest, _ := g.score(ids[len(ids)-1])
+		ready = latest <= t.Unix()-int64(gracePeriod.Seconds())
+	}
+	if !ready {
+		return "", nil
+	}
+	if maxSize != 0 && len(ids) > maxSize {
+		ids = ids[:maxSize]
+	}
+	setID := uuid.NewString()
+	set := &aggregationSet{
+		group:    gname,
+		tasks:    newZSet(),
+		deadline: db.clock.Now().Add(aggregationTimeout).Unix(),
+	}
+	for _, id := range ids {
+		score, _ := g.score(id)
+		set.tasks.add(id, score)
+		q.removeFromGroup(gname, id)
+	}
+	q.aggregationSets[base.AggregationSetKey(qname, gname, setID)] = set
+	return setID, nil
+}
+
+// ReadAggregationSet retrieves members of an aggregation set and returns a list of tasks in the set and
+// the deadline for aggregating those tasks.
+func (db *MemDB) ReadAggregationSet(qname, gname, setID string) ([]*base.TaskMessage, time.Time, error) {
+	var op errors.Op = "memdb.ReadAggregationSet"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, ok := db.queues[qname]
+	if !ok {
+		return nil, time.Time{}, errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	set, ok := q.aggregationSets[base.AggregationSetKey(qname, gname, setID)]
+	if !ok {
+		return nil, time.Time{}, errors.E(op, errors.NotFound, fmt.Sprintf("aggregation set %q not found", setID))
+	}
+	var msgs []*base.TaskMessage
+	for _, id := range set.tasks.members() {
+		t, ok := q.tasks[id]
+		if !ok {
+			continue
+		}
+		msg, err := base.DecodeMessage(t.msg)
+		if err != nil {
+			return nil, time.Time{}, errors.E(op, errors.Internal, fmt.Sprintf("cannot decode message: %v", err))
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, time.Unix(set.deadline, 0), nil
+}
+
+// DeleteAggregationSet deletes the aggregation set and its members identified by the parameters.
+func (db *MemDB) DeleteAggregationSet(ctx context.Context, qname, gname, setID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, ok := db.queues[qname]
+	if !ok {
+		return nil
+	}
+	key := base.AggregationSetKey(qname, gname, setID)
+	if set, ok := q.aggregationSets[key]; ok {
+		for id := range set.tasks.scores {
+			delete(q.tasks, id)
+		}
+		delete(q.aggregationSets, key)
+	}
+	return nil
+}
+
+// ReclaimStaleAggregationSets checks for any stale aggregation sets in the given queue, and
+// reclaim tasks in the stale aggregation set by putting them back in the group.
+func (db *MemDB) ReclaimStaleAggregationSets(qname string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, ok := db.queues[qname]
+	if !ok {
+		return nil
+	}
+	now := db.clock.Now().Unix()
+	for key, set := range q.aggregationSets {
+		if set.deadline > now {
+			continue
+		}
+		g, ok := q.groups[set.group]
+		if !ok {
+			g = newZSet()
+			q.groups[set.group] = g
+		}
+		for id, score := range set.tasks.scores {
+			g.add(id, score)
+		}
+		delete(q.aggregationSets, key)
+	}
+	return nil
+}
+
+// DeleteExpiredCompletedTasks checks for any expired tasks in the given queue's completed set,
+// and delete all expired tasks.
+func (db *MemDB) DeleteExpiredCompletedTasks(qname string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, ok := db.queues[qname]
+	if !ok {
+		return nil
+	}
+	for _, id := range q.completed.rangeByScore(db.clock.Now().Unix()) {
+		q.completed.remove(id)
+		delete(q.tasks, id)
+	}
+	return nil
+}
+
+// tokenBucket holds the state of a rate limit token bucket.
+type tokenBucket struct {
+	tokens float64
+	ts     time.Time // time of the last refill
+}
+
+// refill adds the tokens accumulated since the last refill to the bucket.
+func (b *tokenBucket) refill(now time.Time, limit float64, burst int) {
+	if now.After(b.ts) {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.ts).Seconds()*limit)
+		b.ts = now
+	}
+}
+
+// TakeRateLimitTokens takes a token from the token bucket of each of the given queues
+// which has one, and returns the duration until a token is available for the others.
+// A zero duration means a token was taken from the bucket of the queue.
+func (db *MemDB) TakeRateLimitTokens(limits map[string]base.RateLimit) (map[string]time.Duration, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := db.clock.Now()
+	res := make(map[string]time.Duration, len(limits))
+	for qname, rl := range limits {
+		b, ok := db.rateLimits[qname]
+		if !ok {
+			b = &tokenBucket{tokens: float64(rl.Burst), ts: now}
+			db.rateLimits[qname] = b
+		}
+		b.refill(now, rl.Limit, rl.Burst)
+		if b.tokens < 1 {
+			wait := time.Duration(math.Ceil((1 - b.tokens) * 1000 / rl.Limit))
+			res[qname] = wait * time.Millisecond
+			continue
+		}
+		b.tokens--
+		res[qname] = 0
+	}
+	return res, nil
+}
+
+// RefundRateLimitTokens puts back a token taken from the token bucket of each of the given
+// queues. The bucket never holds more than the burst size.
+func (db *MemDB) RefundRateLimitTokens(limits map[string]base.RateLimit) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for qname, rl := range limits {
+		if b, ok := db.rateLimits[qname]; ok {
+			b.tokens = math.Min(float64(rl.Burst), b.tokens+1)
+		}
+	}
+	return nil
+}
+
+// ListLeaseExpired returns a list of task messages with an expired lease from the given queues.
+func (db *MemDB) ListLeaseExpired(cutoff time.Time, qnames ...string) ([]*base.TaskMessage, error) {
+	var op errors.Op = "memdb.ListLeaseExpired"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var msgs []*base.TaskMessage
+	for _, qname := range qnames {
+		q, ok := db.queues[qname]
+		if !ok {
+			continue
+		}
+		for _, id := range q.lease.rangeByScore(cutoff.Unix()) {
+			t, ok := q.tasks[id]
+			if !ok {
+				continue
+			}
+			msg, err := base.DecodeMessage(t.msg)
+			if err != nil {
+				return nil, errors.E(op, errors.Internal, fmt.Sprintf("cannot decode message: %v", err))
+			}
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+// ExtendLease extends the lease for the given tasks by LeaseDuration (30s).
+// It returns a new expiration time if the operation was successful.
+func (db *MemDB) ExtendLease(qname string, ids ...string) (expirationTime time.Time, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	expireAt := db.clock.Now().Add(rdb.LeaseDuration)
+	q, ok := db.queues[qname]
+	if !ok {
+		return expireAt, nil
+	}
+	for _, id := range ids {
+		// Only update elements that already exist.
+		if _, ok := q.lease.score(id); ok {
+			q.lease.add(id, expireAt.Unix())
+		}
+	}
+	return expireAt, nil
+}
+
+// WriteServerState writes server state data with expiration set to the value ttl.
+func (db *MemDB) WriteServerState(info *base.ServerInfo, workers []*base.WorkerInfo, ttl time.Duration) error {
+	var op errors.Op = "memdb.WriteServerState"
+	bytes, err := base.EncodeServerInfo(info)
+	if err != nil {
+		return errors.E(op, errors.Internal, fmt.Sprintf("cannot encode server info: %v", err))
+	}
+	ws := make(map[string][]byte)
+	for _, w := range workers {
+		bytes, err := base.EncodeWorkerInfo(w)
+		if err != nil {
+			continue // skip bad data
+		}
+		ws[w.ID] = bytes
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.servers[base.ServerInfoKey(info.Host, info.PID, info.ServerID)] = &serverState{
+		info:     bytes,
+		workers:  ws,
+		expireAt: db.clock.Now().Add(ttl),
+	}
+	return nil
+}
+
+// ClearServerState deletes server state data.
+func (db *MemDB) ClearServerState(host string, pid int, serverID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.servers, base.ServerInfoKey(host, pid, serverID))
+	return nil
+}
+
+// cancelationBufferSize is the number of undelivered cancelation messages
+// kept per subscription. Messages published while the buffer is full are dropped,
+// the same way redis drops messages for slow pubsub clients.
+const cancelationBufferSize = 100
+
+// SubscribeCancelation subscribes to cancelation messages.
+func (db *MemDB) SubscribeCancelation() (base.CancelationSubscription, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	sub := &cancelationSubscription{db: db, ch: make(chan string, cancelationBufferSize)}
+	db.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// PublishCancelation publishes a cancelation message to all subscribers.
+// The message is dropped for subscribers whose buffer is full.
+func (db *MemDB) PublishCancelation(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for sub := range db.subscribers {
+		select {
+		case sub.ch <- id:
+		default:
+		}
+	}
+	return nil
+}
+
+// cancelationSubscription is a subscription to cancelation messages published to MemDB.
+type cancelationSubscription struct {
+	db *MemDB
+	ch chan string
+}
+
+func (s *cancelationSubscription) Channel() <-chan string {
+	return s.ch
+}
+
+// Err always returns nil since an in-memory subscription cannot fail.
+func (s *cancelationSubscription) Err() error {
+	return nil
+}
+
+func (s *cancelationSubscription) Close() error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.subscribers[s]; ok {
+		delete(s.db.subscribers, s)
+		close(s.ch)
+	}
+	return nil
+}
+
+// SubscribeServerConfig subscribes to the configuration updates published to the given server.
+func (db *MemDB) SubscribeServerConfig(serverID string) (base.ServerConfigSubscription, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	sub := &serverConfigSubscription{db: db, serverID: serverID, ch: make(chan *base.ServerConfigUpdate, cancelationBufferSize)}
+	db.configSubscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// PublishServerConfig publishes the configuration update to the subscribers of the given server.
+// It returns the number of subscribers which received the update.
+func (db *MemDB) PublishServerConfig(serverID string, update *base.ServerConfigUpdate) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	n := 0
+	for sub := range db.configSubscribers {
+		if sub.serverID != serverID {
+			continue
+		}
+		select {
+		case sub.ch <- update:
+			n++
+		default:
+		}
+	}
+	return n, nil
+}
+
+// serverConfigSubscription is a subscription to the configuration updates published to MemDB.
+type serverConfigSubscription struct {
+	db       *MemDB
+	serverID string
+	ch       chan *base.ServerConfigUpdate
+}
+
+func (s *serverConfigSubscription) Channel() <-chan *base.ServerConfigUpdate {
+	return s.ch
+}
+
+// Err always returns nil since an in-memory subscription cannot fail.
+func (s *serverConfigSubscription) Err() error {
+	return nil
+}
+
+func (s *serverConfigSubscription) Close() error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.configSubscribers[s]; ok {
+		delete(s.db.configSubscribers, s)
+		close(s.ch)
+	}
+	return nil
+}
+
+// SubscribeWakeup subscribes to the notifications of tasks added to the pending list of the given queues.
+func (db *MemDB) SubscribeWakeup(qnames ...string) (base.WakeupSubscription, error) {
+	return db.subscribeQueues(base.WakeupChannel, qnames), nil
+}
+
+// SubscribeSchedule subscribes to the notifications of tasks added to the scheduled or retry set of the given queues.
+func (db *MemDB) SubscribeSchedule(qnames ...string) (base.WakeupSubscription, error) {
+	return db.subscribeQueues(base.ScheduleChannel, qnames), nil
+}
+
+func (db *MemDB) subscribeQueues(channelFn func(qname string) string, qnames []string) *wakeupSubscription {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	sub := &wakeupSubscription{db: db, queueOf: make(map[string]string), ch: make(chan string, 1)}
+	for _, qname := range qnames {
+		sub.queueOf[channelFn(qname)] = qname
+	}
+	db.wakeupSubscribers[sub] = struct{}{}
+	return sub
+}
+
+// SubscribeCompletion subscribes to the notification of the given task being completed or archived.
+func (db *MemDB) SubscribeCompletion(qname, id string) (base.CompletionSubscription, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	sub := &wakeupSubscription{db: db, queueOf: map[string]string{base.CompletionChannel(qname, id): id}, ch: make(chan string, 1)}
+	db.wakeupSubscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// maxTaskEvents is the maximum number of events kept in the task event stream.
+const maxTaskEvents = 10000
+
+// RecordEvent appends the task lifecycle event to the event stream and sets ev.ID
+// to the ID of the stream entry, dropping the oldest event once the stream is full.
+func (db *MemDB) RecordEvent(ev *base.TaskEvent) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.appendEvent(ev)
+	return nil
+}
+
+// recordEvent records the lifecycle event of the given task, whose state was changed
+// by an operation of the broker itself (see base.TaskEvent).
+// Caller must hold db.mu.
+func (db *MemDB) recordEvent(kind base.TaskEventKind, qname, id string, t *task) {
+	ev := &base.TaskEvent{Kind: kind, TaskID: id, Queue: qname, Time: db.clock.Now()}
+	if msg, err := base.DecodeMessage(t.msg); err == nil {
+		ev.Type = msg.Type
+	}
+	db.appendEvent(ev)
+}
+
+// appendEvent appends the event to the event stream and sets ev.ID to the ID of the stream entry.
+// Caller must hold db.mu.
+func (db *MemDB) appendEvent(ev *base.TaskEvent) {
+	db.lastEventSeq++
+	ev.ID = fmt.Sprintf("%d-0", db.lastEventSeq)
+	e := *ev
+	db.events = append(db.events, &e)
+	if len(db.events) > maxTaskEvents {
+		db.events = db.events[len(db.events)-maxTaskEvents:]
+	}
+	close(db.eventsCh)
+	db.eventsCh = make(chan struct{})
+}
+
+// notify notifies the subscribers of the given channel (see base.WakeupChannel, base.ScheduleChannel
+// and base.CompletionChannel).
+// The notification is dropped for subscribers which have not received the previous one yet.
+// Caller must hold db.mu.
+func (db *MemDB) notify(channel string) {
+	for sub := range db.wakeupSubscribers {
+		qname, ok := sub.queueOf[channel]
+		if !ok {
+			continue
+		}
+		select {
+		case sub.ch <- qname:
+		default:
+		}
+	}
+}
+
+// wakeupSubscription is a subscription to the wakeup notifications of MemDB.
+type wakeupSubscription struct {
+	db *MemDB
+	// queueOf maps a subscribed channel to the value delivered for it,
+	// i.e. its queue name, or the task ID for a completion channel.
+	queueOf map[string]string
+	ch      chan string
+}
+
+func (s *wakeupSubscription) Channel() <-chan string {
+	return s.ch
+}
+
+// Err always returns nil since an in-memory subscription cannot fail.
+func (s *wakeupSubscription) Err() error {
+	return nil
+}
+
+func (s *wakeupSubscription) Close() error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.wakeupSubscribers[s]; ok {
+		delete(s.db.wakeupSubscribers, s)
+		close(s.ch)
+	}
+	return nil
+}
+
+// WriteResult writes the given result data for the specified task.
+func (db *MemDB) WriteResult(qname, taskID string, data []byte) (int, error) {
+	var op errors.Op = "memdb.WriteResult"
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, ok := db.queues[qname]
+	if !ok {
+		return 0, errors.E(op, errors.NotFound, &errors.TaskNotFoundError{Queue: qname, ID: taskID})
+	}
+	t, ok := q.tasks[taskID]
+	if !ok {
+		return 0, errors.E(op, errors.NotFound, &errors.TaskNotFoundError{Queue: qname, ID: taskID})
+	}
+	t.result = append([]byte(nil), data...)
+	return len(data), nil
+}
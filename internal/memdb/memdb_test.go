@@ -0,0 +1,1039 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package memdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/errors"
+	"github.com/hibiken/asynq/internal/rdb"
+	h "github.com/hibiken/asynq/internal/testutil"
+	"github.com/hibiken/asynq/internal/timeutil"
+)
+
+func TestEnqueueDequeueDone(t *testing.T) {
+	now := time.Now()
+	db := NewMemDB()
+	db.SetClock(timeutil.NewSimulatedClock(now))
+	ctx := context.Background()
+	t1 := h.NewTaskMessage("send_email", nil)
+	t2 := h.NewTaskMessage("send_email", nil)
+	for _, msg := range []*base.TaskMessage{t1, t2} {
+		if err := db.Enqueue(ctx, msg); err != nil {
+			t.Fatalf("Enqueue(%v) returned error: %v", msg, err)
+		}
+	}
+	if err := db.Enqueue(ctx, t1); !errors.Is(err, errors.ErrTaskIdConflict) {
+		t.Errorf("Enqueue with duplicate ID returned %v, want ErrTaskIdConflict", err)
+	}
+
+	got, _, pendingSince, err := db.Dequeue(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("Dequeue returned error: %v", err)
+	}
+	if diff := cmp.Diff(t1, got); diff != "" {
+		t.Errorf("Dequeue returned %v, want %v; (-want,+got)\n%s", got, t1, diff)
+	}
+	if !pendingSince.Equal(now) {
+		t.Errorf("Dequeue returned pending since %v, want %v", pendingSince, now)
+	}
+	if err := db.Done(ctx, got); err != nil {
+		t.Fatalf("Done returned error: %v", err)
+	}
+	if err := db.Done(ctx, got); !errors.IsTaskNotFound(err) {
+		t.Errorf("second Done returned %v, want TaskNotFoundError", err)
+	}
+
+	stats, err := db.CurrentStats(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("CurrentStats returned error: %v", err)
+	}
+	if stats.Pending != 1 || stats.Active != 0 || stats.Processed != 1 {
+		t.Errorf("CurrentStats returned Pending=%d Active=%d Processed=%d, want 1, 0, 1",
+			stats.Pending, stats.Active, stats.Processed)
+	}
+}
+
+func TestDequeueSkipsPausedQueue(t *testing.T) {
+	db := NewMemDB()
+	msg := h.NewTaskMessageWithQueue("send_email", nil, "critical")
+	if err := db.Enqueue(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Pause("critical"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := db.Dequeue("critical"); !errors.Is(err, errors.ErrNoProcessableTask) {
+		t.Errorf("Dequeue from paused queue returned %v, want ErrNoProcessableTask", err)
+	}
+	if err := db.Unpause("critical"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := db.Dequeue("critical"); err != nil {
+		t.Errorf("Dequeue returned error: %v", err)
+	}
+}
+
+func TestDequeueExcept(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	t1 := h.NewTaskMessage("send_email", nil)
+	t2 := h.NewTaskMessage("export_csv", nil)
+	t3 := h.NewTaskMessage("send_email", nil)
+	for _, msg := range []*base.TaskMessage{t1, t2, t3} {
+		if err := db.Enqueue(ctx, msg); err != nil {
+			t.Fatalf("Enqueue(%v) returned error: %v", msg, err)
+		}
+	}
+
+	got, _, _, err := db.DequeueExcept([]string{"send_email"}, base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("DequeueExcept returned error: %v", err)
+	}
+	if diff := cmp.Diff(t2, got); diff != "" {
+		t.Errorf("DequeueExcept returned %v, want %v; (-want,+got)\n%s", got, t2, diff)
+	}
+	if _, _, _, err := db.DequeueExcept([]string{"send_email"}, base.DefaultQueueName); !errors.Is(err, errors.ErrNoProcessableTask) {
+		t.Errorf("DequeueExcept with only excluded tasks returned %v, want ErrNoProcessableTask", err)
+	}
+	// Tasks of the excluded type are left in the queue in order.
+	for _, want := range []*base.TaskMessage{t1, t3} {
+		got, _, _, err := db.Dequeue(base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("Dequeue returned error: %v", err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Dequeue returned %v, want %v; (-want,+got)\n%s", got, want, diff)
+		}
+	}
+}
+
+func TestDequeueExceptByPriority(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	t1 := h.NewTaskMessage("send_email", nil)
+	t1.Priority = 10
+	t2 := h.NewTaskMessage("export_csv", nil)
+	t3 := h.NewTaskMessage("export_csv", nil)
+	t3.Priority = 5
+	for _, msg := range []*base.TaskMessage{t1, t2, t3} {
+		if err := db.Enqueue(ctx, msg); err != nil {
+			t.Fatalf("Enqueue(%v) returned error: %v", msg, err)
+		}
+	}
+
+	// Tasks of the other types are dequeued in order of priority as well.
+	for _, want := range []*base.TaskMessage{t3, t2} {
+		got, _, _, err := db.DequeueExcept([]string{"send_email"}, base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("DequeueExcept returned error: %v", err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("DequeueExcept returned %v, want %v; (-want,+got)\n%s", got, want, diff)
+		}
+	}
+}
+
+func TestDequeueRoundRobinAcrossFairnessKeys(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	newMsg := func(fkey string) *base.TaskMessage {
+		msg := h.NewTaskMessage("send_email", nil)
+		msg.FairnessKey = fkey
+		return msg
+	}
+	a1, a2, a3 := newMsg("a"), newMsg("a"), newMsg("a")
+	b1 := newMsg("b")
+	u1 := newMsg("")
+	for _, msg := range []*base.TaskMessage{a1, a2, a3, b1, u1} {
+		if err := db.Enqueue(ctx, msg); err != nil {
+			t.Fatalf("Enqueue(%v) returned error: %v", msg, err)
+		}
+	}
+
+	stats, err := db.FairnessKeyStats(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("FairnessKeyStats returned error: %v", err)
+	}
+	wantStats := []*rdb.FairnessKeyStat{{Key: "a", Pending: 3}, {Key: "b", Pending: 1}}
+	if diff := cmp.Diff(wantStats, stats); diff != "" {
+		t.Errorf("FairnessKeyStats returned %v, want %v; (-want,+got)\n%s", stats, wantStats, diff)
+	}
+
+	for _, want := range []*base.TaskMessage{a1, b1, u1, a2, a3} {
+		got, _, _, err := db.Dequeue(base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("Dequeue returned error: %v", err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Dequeue returned %v, want %v; (-want,+got)\n%s", got, want, diff)
+		}
+	}
+	if _, err := db.FairnessKeyStats("nonexistent"); !errors.IsQueueNotFound(err) {
+		t.Errorf("FairnessKeyStats(%q) returned %v, want QueueNotFoundError", "nonexistent", err)
+	}
+}
+
+func TestDequeueByPriority(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	newMsg := func(priority int) *base.TaskMessage {
+		msg := h.NewTaskMessage("send_email", nil)
+		msg.Priority = priority
+		return msg
+	}
+	low, high1, mid, high2 := newMsg(0), newMsg(10), newMsg(5), newMsg(10)
+	for _, msg := range []*base.TaskMessage{low, high1, mid, high2} {
+		if err := db.Enqueue(ctx, msg); err != nil {
+			t.Fatalf("Enqueue(%v) returned error: %v", msg, err)
+		}
+	}
+
+	for _, want := range []*base.TaskMessage{high1, high2, mid, low} {
+		got, _, _, err := db.Dequeue(base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("Dequeue returned error: %v", err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Dequeue returned %v, want %v; (-want,+got)\n%s", got, want, diff)
+		}
+	}
+}
+
+func TestRetryAndForward(t *testing.T) {
+	now := time.Now()
+	clock := timeutil.NewSimulatedClock(now)
+	db := NewMemDB()
+	db.SetClock(clock)
+	ctx := context.Background()
+	msg := h.NewTaskMessage("send_email", nil)
+	if err := db.Enqueue(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := db.Dequeue(base.DefaultQueueName); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Retry(ctx, msg, now.Add(time.Minute), "oops", true); err != nil {
+		t.Fatalf("Retry returned error: %v", err)
+	}
+
+	info, err := db.GetTaskInfo(base.DefaultQueueName, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State != base.TaskStateRetry || info.Message.Retried != 1 || info.Message.ErrorMsg != "oops" {
+		t.Errorf("GetTaskInfo returned state=%v retried=%d error=%q, want retry, 1, %q",
+			info.State, info.Message.Retried, info.Message.ErrorMsg, "oops")
+	}
+
+	if err := db.ForwardIfReady(base.DefaultQueueName); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := db.Dequeue(base.DefaultQueueName); !errors.Is(err, errors.ErrNoProcessableTask) {
+		t.Errorf("Dequeue before retry time returned %v, want ErrNoProcessableTask", err)
+	}
+
+	clock.AdvanceTime(time.Minute)
+	if err := db.ForwardIfReady(base.DefaultQueueName); err != nil {
+		t.Fatal(err)
+	}
+	got, _, _, err := db.Dequeue(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("Dequeue after retry time returned error: %v", err)
+	}
+	if got.ID != msg.ID {
+		t.Errorf("Dequeue returned task %q, want %q", got.ID, msg.ID)
+	}
+}
+
+func TestEnqueueUnique(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	msg := h.NewTaskMessage("send_email", nil)
+	msg.UniqueKey = base.UniqueKey(msg.Queue, msg.Type, msg.Payload)
+	if err := db.EnqueueUnique(ctx, msg, time.Hour); err != nil {
+		t.Fatalf("EnqueueUnique returned error: %v", err)
+	}
+	dup := h.NewTaskMessage("send_email", nil)
+	dup.UniqueKey = msg.UniqueKey
+	if err := db.EnqueueUnique(ctx, dup, time.Hour); !errors.Is(err, errors.ErrDuplicateTask) {
+		t.Errorf("EnqueueUnique with duplicate task returned %v, want ErrDuplicateTask", err)
+	}
+	if err := db.DeleteTask(msg.Queue, msg.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EnqueueUnique(ctx, dup, time.Hour); err != nil {
+		t.Errorf("EnqueueUnique after deleting lock holder returned error: %v", err)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	now := time.Now()
+	newMsg := func(payload string) *base.TaskMessage {
+		msg := h.NewTaskMessage("reindex", []byte(payload))
+		msg.UniqueKey = base.DebounceKey(msg.Queue, "user:123")
+		return msg
+	}
+	m1 := newMsg("v1")
+	if id, err := db.Debounce(ctx, m1, now.Add(time.Minute)); err != nil || id != m1.ID {
+		t.Fatalf("Debounce = %q, %v; want %q, nil", id, err, m1.ID)
+	}
+	m2 := newMsg("v2")
+	if id, err := db.Debounce(ctx, m2, now.Add(2*time.Minute)); err != nil || id != m1.ID {
+		t.Fatalf("Debounce = %q, %v; want %q, nil", id, err, m1.ID)
+	}
+	info, err := db.GetTaskInfo(base.DefaultQueueName, m1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(info.Message.Payload); got != "v2" {
+		t.Errorf("payload of the debounced task = %q, want %q", got, "v2")
+	}
+	if want := time.UnixMilli(now.Add(2 * time.Minute).UnixMilli()); !info.NextProcessAt.Equal(want) {
+		t.Errorf("NextProcessAt of the debounced task = %v, want %v", info.NextProcessAt, want)
+	}
+	if err := db.DeleteTask(base.DefaultQueueName, m1.ID); err != nil {
+		t.Fatal(err)
+	}
+	m3 := newMsg("v3")
+	if id, err := db.Debounce(ctx, m3, now.Add(time.Minute)); err != nil || id != m3.ID {
+		t.Errorf("Debounce after deleting the task = %q, %v; want %q, nil", id, err, m3.ID)
+	}
+}
+
+func TestEnqueueBatch(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	m1 := h.NewTaskMessage("task1", nil)
+	m2 := h.NewTaskMessage("task2", nil)
+	m2.UniqueKey = base.UniqueKey(m2.Queue, m2.Type, m2.Payload)
+	m3 := h.NewTaskMessage("task2", nil)
+	m3.UniqueKey = m2.UniqueKey
+	m4 := h.NewTaskMessage("task4", nil)
+	m4.ID = m1.ID
+	m5 := h.NewTaskMessage("task5", nil)
+	errs, err := db.EnqueueBatch(ctx, []*base.BatchMessage{
+		{Msg: m1},
+		{Msg: m2, UniqueTTL: time.Hour},
+		{Msg: m3, UniqueTTL: time.Hour},
+		{Msg: m4},
+		{Msg: m5, ProcessAt: time.Now().Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueBatch returned error: %v", err)
+	}
+	for i, want := range []error{nil, nil, errors.ErrDuplicateTask, errors.ErrTaskIdConflict, nil} {
+		if (want == nil && errs[i] != nil) || (want != nil && !errors.Is(errs[i], want)) {
+			t.Errorf("errs[%d] = %v, want %v", i, errs[i], want)
+		}
+	}
+	for _, tc := range []struct {
+		id   string
+		want base.TaskState
+	}{
+		{m1.ID, base.TaskStatePending},
+		{m2.ID, base.TaskStatePending},
+		{m5.ID, base.TaskStateScheduled},
+	} {
+		info, err := db.GetTaskInfo(base.DefaultQueueName, tc.id)
+		if err != nil {
+			t.Fatalf("GetTaskInfo(%q) returned error: %v", tc.id, err)
+		}
+		if info.State != tc.want {
+			t.Errorf("task %q is in state %v, want %v", tc.id, info.State, tc.want)
+		}
+	}
+}
+
+func TestAggregationCheck(t *testing.T) {
+	now := time.Now()
+	db := NewMemDB()
+	db.SetClock(timeutil.NewSimulatedClock(now))
+	ctx := context.Background()
+	msgs := []*base.TaskMessage{
+		h.NewTaskMessageBuilder().SetType("task1").SetGroup("mygroup").Build(),
+		h.NewTaskMessageBuilder().SetType("task2").SetGroup("mygroup").Build(),
+		h.NewTaskMessageBuilder().SetType("task3").SetGroup("mygroup").Build(),
+	}
+	for _, msg := range msgs {
+		if err := db.AddToGroup(ctx, msg, "mygroup"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setID, err := db.AggregationCheck(base.DefaultQueueName, "mygroup", now, time.Minute, 0, 2)
+	if err != nil {
+		t.Fatalf("AggregationCheck returned error: %v", err)
+	}
+	if setID == "" {
+		t.Fatal("AggregationCheck did not create an aggregation set")
+	}
+	got, _, err := db.ReadAggregationSet(base.DefaultQueueName, "mygroup", setID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Errorf("ReadAggregationSet returned %d tasks, want 2", len(got))
+	}
+	groups, err := db.ListGroups(base.DefaultQueueName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"mygroup"}, groups); diff != "" {
+		t.Errorf("ListGroups returned %v; (-want,+got)\n%s", groups, diff)
+	}
+	if err := db.DeleteAggregationSet(ctx, base.DefaultQueueName, "mygroup", setID); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range got {
+		if _, err := db.GetTaskInfo(base.DefaultQueueName, msg.ID); !errors.IsTaskNotFound(err) {
+			t.Errorf("GetTaskInfo(%q) returned %v, want TaskNotFoundError", msg.ID, err)
+		}
+	}
+}
+
+func TestArchiveAndRunTask(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	msg := h.NewTaskMessage("send_email", nil)
+	if err := db.Schedule(ctx, msg, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ArchiveTask(msg.Queue, msg.ID); err != nil {
+		t.Fatalf("ArchiveTask returned error: %v", err)
+	}
+	if err := db.ArchiveTask(msg.Queue, msg.ID); !errors.IsTaskAlreadyArchived(err) {
+		t.Errorf("second ArchiveTask returned %v, want TaskAlreadyArchivedError", err)
+	}
+	if err := db.RunTask(msg.Queue, msg.ID); err != nil {
+		t.Fatalf("RunTask returned error: %v", err)
+	}
+	pending, err := db.ListPending(msg.Queue, rdb.Pagination{Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Message.ID != msg.ID {
+		t.Errorf("ListPending returned %v, want task %q", pending, msg.ID)
+	}
+	if err := db.RemoveQueue(msg.Queue, false); !errors.IsQueueNotEmpty(err) {
+		t.Errorf("RemoveQueue returned %v, want QueueNotEmptyError", err)
+	}
+	if err := db.RemoveQueue(msg.Queue, true); err != nil {
+		t.Errorf("RemoveQueue with force returned error: %v", err)
+	}
+}
+
+func TestSubscribeCancelation(t *testing.T) {
+	db := NewMemDB()
+	sub, err := db.SubscribeCancelation()
+	if err != nil {
+		t.Fatalf("SubscribeCancelation returned error: %v", err)
+	}
+	for _, id := range []string{"id1", "id2"} {
+		if err := db.PublishCancelation(id); err != nil {
+			t.Fatalf("PublishCancelation(%q) returned error: %v", id, err)
+		}
+	}
+	var got []string
+	for i := 0; i < 2; i++ {
+		got = append(got, <-sub.Channel())
+	}
+	if diff := cmp.Diff([]string{"id1", "id2"}, got); diff != "" {
+		t.Errorf("subscription received %v; (-want,+got)\n%s", got, diff)
+	}
+
+	if err := sub.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if _, ok := <-sub.Channel(); ok {
+		t.Error("channel is open after Close, want closed")
+	}
+	// Publishing after the only subscriber is gone should not block or panic.
+	if err := db.PublishCancelation("id3"); err != nil {
+		t.Errorf("PublishCancelation after Close returned error: %v", err)
+	}
+}
+
+func TestPublishServerConfig(t *testing.T) {
+	db := NewMemDB()
+	sub, err := db.SubscribeServerConfig("server1")
+	if err != nil {
+		t.Fatalf("SubscribeServerConfig returned error: %v", err)
+	}
+	update := &base.ServerConfigUpdate{Concurrency: 4, Queues: map[string]int{"default": 1}}
+	if n, err := db.PublishServerConfig("server2", update); err != nil || n != 0 {
+		t.Errorf("PublishServerConfig to another server = %d, %v; want 0, nil", n, err)
+	}
+	if n, err := db.PublishServerConfig("server1", update); err != nil || n != 1 {
+		t.Errorf("PublishServerConfig = %d, %v; want 1, nil", n, err)
+	}
+	select {
+	case got := <-sub.Channel():
+		if diff := cmp.Diff(update, got); diff != "" {
+			t.Errorf("received server config update %+v; (-want,+got)\n%s", got, diff)
+		}
+	default:
+		t.Error("received no server config update")
+	}
+
+	if err := sub.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if n, err := db.PublishServerConfig("server1", update); err != nil || n != 0 {
+		t.Errorf("PublishServerConfig after Close = %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestEnqueueWaiting(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	parent1 := h.NewTaskMessage("parent", nil)
+	parent2 := h.NewTaskMessage("parent", nil)
+	for _, msg := range []*base.TaskMessage{parent1, parent2} {
+		if err := db.Enqueue(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	child := h.NewTaskMessage("child", nil)
+	child.Dependencies = []string{parent1.ID, parent2.ID}
+	state, err := db.EnqueueWaiting(ctx, child, false)
+	if err != nil {
+		t.Fatalf("EnqueueWaiting returned error: %v", err)
+	}
+	if state != base.TaskStateWaiting {
+		t.Errorf("EnqueueWaiting returned state %v, want %v", state, base.TaskStateWaiting)
+	}
+	// A dependency which does not exist is rejected, unless it is considered completed.
+	orphan := h.NewTaskMessageWithQueue("child", nil, "orphans")
+	orphan.Dependencies = []string{"no-such-id"}
+	if _, err := db.EnqueueWaiting(ctx, orphan, false); !errors.IsTaskNotFound(err) {
+		t.Errorf("EnqueueWaiting with missing dependency returned %v, want TaskNotFoundError", err)
+	}
+	if state, err := db.EnqueueWaiting(ctx, orphan, true); err != nil || state != base.TaskStatePending {
+		t.Errorf("EnqueueWaiting ignoring missing dependency returned %v, %v; want %v, nil", state, err, base.TaskStatePending)
+	}
+
+	// The child becomes pending only after both parents complete,
+	// regardless of whether the parents are retained or not.
+	tests := []struct {
+		markAsComplete bool
+		wantWaiting    int
+	}{
+		{markAsComplete: false, wantWaiting: 1},
+		{markAsComplete: true, wantWaiting: 0},
+	}
+	for _, tc := range tests {
+		msg, _, _, err := db.Dequeue(base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("Dequeue returned error: %v", err)
+		}
+		if tc.markAsComplete {
+			err = db.MarkAsComplete(ctx, msg)
+		} else {
+			err = db.Done(ctx, msg)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats, err := db.CurrentStats(base.DefaultQueueName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Waiting != tc.wantWaiting || stats.Pending != 1 {
+			t.Errorf("after completing %q: Waiting=%d Pending=%d, want %d, 1",
+				msg.ID, stats.Waiting, stats.Pending, tc.wantWaiting)
+		}
+	}
+	got, _, _, err := db.Dequeue(base.DefaultQueueName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != child.ID {
+		t.Errorf("Dequeue returned task %q, want %q", got.ID, child.ID)
+	}
+}
+
+func TestOrderingKey(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	var msgs []*base.TaskMessage
+	for i := 0; i < 4; i++ {
+		msg := h.NewTaskMessage("update_order", nil)
+		msg.OrderingKey = "order-1"
+		msgs = append(msgs, msg)
+		state, err := db.EnqueueWaiting(ctx, msg, false)
+		if err != nil {
+			t.Fatalf("EnqueueWaiting returned error: %v", err)
+		}
+		if want := base.TaskStateWaiting; i > 0 && state != want {
+			t.Errorf("EnqueueWaiting returned state %v for task %d, want %v", state, i, want)
+		}
+	}
+	// Only one task with the key is pending at a time.
+	msg, _, _, err := db.Dequeue(base.DefaultQueueName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != msgs[0].ID {
+		t.Fatalf("Dequeue returned task %q, want %q", msg.ID, msgs[0].ID)
+	}
+	if _, _, _, err := db.Dequeue(base.DefaultQueueName); !errors.Is(err, errors.ErrNoProcessableTask) {
+		t.Errorf("Dequeue returned %v while the task with the ordering key is active, want ErrNoProcessableTask", err)
+	}
+	if err := db.Done(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	msg, _, _, err = db.Dequeue(base.DefaultQueueName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != msgs[1].ID {
+		t.Fatalf("Dequeue returned task %q, want %q", msg.ID, msgs[1].ID)
+	}
+	if err := db.Archive(ctx, msg, "error"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A deleted task is skipped by ReleaseOrderingKeys.
+	if err := db.DeleteTask(base.DefaultQueueName, msgs[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReleaseOrderingKeys(base.DefaultQueueName); err != nil {
+		t.Fatalf("ReleaseOrderingKeys returned error: %v", err)
+	}
+	msg, _, _, err = db.Dequeue(base.DefaultQueueName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != msgs[3].ID {
+		t.Errorf("Dequeue returned task %q, want %q", msg.ID, msgs[3].ID)
+	}
+}
+
+func TestArchiveExpiredTasks(t *testing.T) {
+	now := time.Now()
+	clock := timeutil.NewSimulatedClock(now)
+	db := NewMemDB()
+	db.SetClock(clock)
+	ctx := context.Background()
+	expired := h.NewTaskMessage("warmup_cache", nil)
+	expired.ExpireAt = now.Add(10 * time.Second).Unix()
+	scheduled := h.NewTaskMessage("warmup_cache", nil)
+	scheduled.ExpireAt = now.Add(10 * time.Second).Unix()
+	fresh := h.NewTaskMessage("warmup_cache", nil)
+	fresh.ExpireAt = now.Add(time.Hour).Unix()
+	for _, msg := range []*base.TaskMessage{expired, fresh} {
+		if err := db.Enqueue(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Schedule(ctx, scheduled, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.AdvanceTime(time.Minute)
+	if err := db.ArchiveExpiredTasks(base.DefaultQueueName); err != nil {
+		t.Fatalf("ArchiveExpiredTasks returned error: %v", err)
+	}
+	for _, msg := range []*base.TaskMessage{expired, scheduled} {
+		info, err := db.GetTaskInfo(base.DefaultQueueName, msg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.State != base.TaskStateArchived || info.Message.ErrorMsg != base.ExpiredErrMsg {
+			t.Errorf("GetTaskInfo returned state=%v error=%q, want archived, %q",
+				info.State, info.Message.ErrorMsg, base.ExpiredErrMsg)
+		}
+	}
+	got, _, _, err := db.Dequeue(base.DefaultQueueName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != fresh.ID {
+		t.Errorf("Dequeue returned task %q, want %q", got.ID, fresh.ID)
+	}
+}
+
+func TestArchiveBlockedTasks(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	parent := h.NewTaskMessage("parent", nil)
+	if err := db.Enqueue(ctx, parent); err != nil {
+		t.Fatal(err)
+	}
+	child := h.NewTaskMessage("child", nil)
+	child.Dependencies = []string{parent.ID}
+	grandchild := h.NewTaskMessage("grandchild", nil)
+	grandchild.Dependencies = []string{child.ID}
+	for _, msg := range []*base.TaskMessage{child, grandchild} {
+		if _, err := db.EnqueueWaiting(ctx, msg, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.ArchiveTask(base.DefaultQueueName, parent.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ArchiveBlockedTasks(base.DefaultQueueName); err != nil {
+		t.Fatalf("ArchiveBlockedTasks returned error: %v", err)
+	}
+	for _, msg := range []*base.TaskMessage{child, grandchild} {
+		info, err := db.GetTaskInfo(base.DefaultQueueName, msg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.State != base.TaskStateArchived {
+			t.Errorf("task %q state = %v, want %v", msg.Type, info.State, base.TaskStateArchived)
+		}
+		if info.Message.ErrorMsg != base.BlockedErrMsg {
+			t.Errorf("task %q error message = %q, want %q", msg.Type, info.Message.ErrorMsg, base.BlockedErrMsg)
+		}
+	}
+	waiting, err := db.ListWaiting(base.DefaultQueueName, rdb.Pagination{Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(waiting) != 0 {
+		t.Errorf("ListWaiting returned %d tasks, want 0", len(waiting))
+	}
+}
+
+func TestEnqueueChain(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	var msgs []*base.TaskMessage
+	for i := 0; i < 2; i++ {
+		msg := h.NewTaskMessage("step", nil)
+		msg.ChainID = "chain1"
+		msg.ChainStep = i
+		msgs = append(msgs, msg)
+	}
+	if err := db.EnqueueChain(ctx, msgs); err != nil {
+		t.Fatalf("EnqueueChain returned error: %v", err)
+	}
+	if err := db.EnqueueChain(ctx, msgs); !errors.Is(err, errors.ErrTaskIdConflict) {
+		t.Errorf("EnqueueChain with existing chain returned %v, want ErrTaskIdConflict", err)
+	}
+
+	for i, want := range msgs {
+		msg, _, _, err := db.Dequeue(base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("step %d: Dequeue returned error: %v", i, err)
+		}
+		if diff := cmp.Diff(want, msg); diff != "" {
+			t.Errorf("step %d: Dequeue returned %v, want %v; (-want,+got)\n%s", i, msg, want, diff)
+		}
+		if _, err := db.WriteResult(base.DefaultQueueName, msg.ID, []byte("done")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Done(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.ReadChainResult(base.DefaultQueueName, "chain1", i)
+		if err != nil {
+			t.Fatalf("step %d: ReadChainResult returned error: %v", i, err)
+		}
+		if string(got) != "done" {
+			t.Errorf("step %d: ReadChainResult returned %q, want %q", i, got, "done")
+		}
+	}
+
+	info, err := db.GetChainInfo(base.DefaultQueueName, "chain1")
+	if err != nil {
+		t.Fatalf("GetChainInfo returned error: %v", err)
+	}
+	if info.Current != len(msgs) {
+		t.Errorf("GetChainInfo returned Current=%d, want %d", info.Current, len(msgs))
+	}
+	if _, err := db.GetChainInfo(base.DefaultQueueName, "no-such-chain"); !errors.IsChainNotFound(err) {
+		t.Errorf("GetChainInfo with unknown chain returned %v, want ChainNotFoundError", err)
+	}
+}
+
+func TestRateLimitToken(t *testing.T) {
+	db := NewMemDB()
+	now := time.Now()
+	clock := timeutil.NewSimulatedClock(now)
+	db.SetClock(clock)
+
+	limits := map[string]base.RateLimit{"emails": {Limit: 1, Burst: 2}}
+	steps := []struct {
+		advance  time.Duration // time to advance the clock by before taking a token
+		wantWait time.Duration
+		refund   bool // whether to refund a token after taking one
+	}{
+		{0, 0, false},
+		{0, 0, false},
+		{0, time.Second, false},
+		{500 * time.Millisecond, 500 * time.Millisecond, false},
+		{500 * time.Millisecond, 0, true}, // no task dequeued
+		{0, 0, false},
+		{0, time.Second, false},
+		{10 * time.Second, 0, true}, // bucket is refilled up to burst
+		{0, 0, false},
+		{0, 0, false},
+		{0, time.Second, false},
+	}
+	for i, s := range steps {
+		clock.AdvanceTime(s.advance)
+		waits, err := db.TakeRateLimitTokens(limits)
+		if err != nil {
+			t.Fatalf("step %d: TakeRateLimitTokens returned error: %v", i, err)
+		}
+		if got, ok := waits["emails"]; !ok || got != s.wantWait {
+			t.Errorf("step %d: TakeRateLimitTokens returned wait of %v, want %v", i, got, s.wantWait)
+		}
+		if !s.refund {
+			continue
+		}
+		if err := db.RefundRateLimitTokens(limits); err != nil {
+			t.Fatalf("step %d: RefundRateLimitTokens returned error: %v", i, err)
+		}
+	}
+}
+
+func TestSubscribeWakeup(t *testing.T) {
+	db := NewMemDB()
+	sub, err := db.SubscribeWakeup(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("SubscribeWakeup returned error: %v", err)
+	}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := db.Enqueue(ctx, h.NewTaskMessage("send_email", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Enqueue(ctx, h.NewTaskMessageWithQueue("send_email", nil, "low")); err != nil {
+		t.Fatal(err)
+	}
+	// Notifications of the queue are coalesced while the subscriber is busy.
+	select {
+	case got := <-sub.Channel():
+		if got != base.DefaultQueueName {
+			t.Errorf("received wakeup of queue %q, want %q", got, base.DefaultQueueName)
+		}
+	default:
+		t.Fatalf("received no wakeup, want a wakeup of queue %q", base.DefaultQueueName)
+	}
+	select {
+	case got := <-sub.Channel():
+		t.Errorf("received another wakeup of queue %q, want none", got)
+	default:
+	}
+
+	// Completing a task notifies the queue of the tasks moved to the pending list.
+	parent := h.NewTaskMessage("parent", nil)
+	if err := db.Enqueue(ctx, parent); err != nil {
+		t.Fatal(err)
+	}
+	child := h.NewTaskMessage("child", nil)
+	child.Dependencies = []string{parent.ID}
+	if _, err := db.EnqueueWaiting(ctx, child, false); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		msg, _, _, err := db.Dequeue(base.DefaultQueueName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.ID == parent.ID {
+			break
+		}
+	}
+	<-sub.Channel()
+	if err := db.Done(ctx, parent); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-sub.Channel():
+		if got != base.DefaultQueueName {
+			t.Errorf("Done: received wakeup of queue %q, want %q", got, base.DefaultQueueName)
+		}
+	default:
+		t.Errorf("Done: received no wakeup, want a wakeup of queue %q", base.DefaultQueueName)
+	}
+
+	if err := sub.Close(); err != nil {
+		t.Errorf("Close returned error: %v", err)
+	}
+	if _, ok := <-sub.Channel(); ok {
+		t.Errorf("channel is open after Close, want it closed")
+	}
+}
+
+func TestNextProcessAt(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Millisecond)
+
+	if got, err := db.NextProcessAt(base.DefaultQueueName); err != nil || !got.IsZero() {
+		t.Errorf("NextProcessAt() = %v, %v; want zero time", got, err)
+	}
+
+	if err := db.Schedule(ctx, h.NewTaskMessage("send_email", nil), now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Schedule(ctx, h.NewTaskMessage("send_email", nil), now.Add(200*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Schedule(ctx, h.NewTaskMessageWithQueue("send_email", nil, "low"), now.Add(time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.NextProcessAt(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("NextProcessAt returned error: %v", err)
+	}
+	if want := now.Add(200 * time.Millisecond); !got.Equal(want) {
+		t.Errorf("NextProcessAt() = %v, want %v", got, want)
+	}
+}
+
+func TestSubscribeSchedule(t *testing.T) {
+	db := NewMemDB()
+	sub, err := db.SubscribeSchedule(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("SubscribeSchedule returned error: %v", err)
+	}
+	defer sub.Close()
+	ctx := context.Background()
+	if err := db.Enqueue(ctx, h.NewTaskMessage("send_email", nil)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-sub.Channel():
+		t.Errorf("received notification of queue %q for a pending task, want none", got)
+	default:
+	}
+	if err := db.Schedule(ctx, h.NewTaskMessage("send_email", nil), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-sub.Channel():
+		if got != base.DefaultQueueName {
+			t.Errorf("received notification of queue %q, want %q", got, base.DefaultQueueName)
+		}
+	default:
+		t.Errorf("received no notification, want a notification of queue %q", base.DefaultQueueName)
+	}
+}
+
+func TestGetAndReleaseUniqueLock(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	msg := h.NewTaskMessage("send_email", nil)
+	msg.UniqueKey = base.UniqueByKey(msg.Queue, "user:123")
+	if err := db.EnqueueUnique(ctx, msg, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := db.GetUniqueLock(msg.Queue, "user:123")
+	if err != nil {
+		t.Fatalf("GetUniqueLock returned error: %v", err)
+	}
+	if lock.TaskID != msg.ID {
+		t.Errorf("GetUniqueLock returned TaskID=%q, want %q", lock.TaskID, msg.ID)
+	}
+	if err := db.ReleaseUniqueLock(msg.Queue, "user:123"); err != nil {
+		t.Fatalf("ReleaseUniqueLock returned error: %v", err)
+	}
+	if _, err := db.GetUniqueLock(msg.Queue, "user:123"); !errors.IsUniqueLockNotFound(err) {
+		t.Errorf("GetUniqueLock after release returned %v, want UniqueLockNotFoundError", err)
+	}
+	dup := h.NewTaskMessage("send_email", nil)
+	dup.UniqueKey = msg.UniqueKey
+	if err := db.EnqueueUnique(ctx, dup, time.Hour); err != nil {
+		t.Errorf("EnqueueUnique after releasing lock returned error: %v", err)
+	}
+}
+
+func TestRecordAndReadEvents(t *testing.T) {
+	db := NewMemDB()
+	ctx := context.Background()
+	last, err := db.LastEventID()
+	if err != nil {
+		t.Fatalf("LastEventID returned error: %v", err)
+	}
+	ev := &base.TaskEvent{Kind: base.TaskEventSucceeded, TaskID: "t1", Queue: "default", Type: "send_email", ServerID: "srv1", Time: time.Now()}
+
+	done := make(chan []*base.TaskEvent)
+	go func() {
+		got, err := db.ReadEvents(ctx, last, 10, 5*time.Second)
+		if err != nil {
+			t.Errorf("ReadEvents returned error: %v", err)
+		}
+		done <- got
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := db.RecordEvent(ev); err != nil {
+		t.Fatalf("RecordEvent returned error: %v", err)
+	}
+	select {
+	case got := <-done:
+		if diff := cmp.Diff([]*base.TaskEvent{ev}, got); diff != "" {
+			t.Errorf("ReadEvents = %v, want %v; (-want,+got)\n%s", got, ev, diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReadEvents did not return after an event was recorded")
+	}
+	got, err := db.ReadEvents(ctx, ev.ID, 10, -1)
+	if err != nil || len(got) != 0 {
+		t.Errorf("ReadEvents after the last event = %v, %v; want none", got, err)
+	}
+	if _, err := db.ReadEvents(ctx, "invalid", 10, -1); err == nil {
+		t.Errorf("ReadEvents with an invalid ID returned no error")
+	}
+}
+
+func TestEventsOfBrokerOperations(t *testing.T) {
+	db := NewMemDB()
+	now := time.Now()
+	db.SetClock(timeutil.NewSimulatedClock(now))
+	ctx := context.Background()
+
+	parent := h.NewTaskMessage("parent", nil)
+	if err := db.Enqueue(ctx, parent); err != nil {
+		t.Fatal(err)
+	}
+	child := h.NewTaskMessage("child", nil)
+	child.Dependencies = []string{parent.ID}
+	if _, err := db.EnqueueWaiting(ctx, child, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := db.Dequeue(base.DefaultQueueName); err != nil {
+		t.Fatal(err)
+	}
+	last, err := db.LastEventID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Done(ctx, parent); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ArchiveAllPendingTasks(base.DefaultQueueName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DeleteAllArchivedTasks(base.DefaultQueueName); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.ReadEvents(ctx, last, 10, -1)
+	if err != nil {
+		t.Fatalf("ReadEvents returned error: %v", err)
+	}
+	var want []*base.TaskEvent
+	for _, kind := range []base.TaskEventKind{base.TaskEventReleased, base.TaskEventArchived, base.TaskEventDeleted} {
+		want = append(want, &base.TaskEvent{Kind: kind, TaskID: child.ID, Queue: base.DefaultQueueName, Type: child.Type, Time: now})
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(base.TaskEvent{}, "ID")); diff != "" {
+		t.Errorf("ReadEvents = %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+}
@@ -113,7 +113,7 @@ func BenchmarkDequeueSingleQueue(b *testing.B) {
 		}
 		b.StartTimer()
 
-		if _, _, err := r.Dequeue(base.DefaultQueueName); err != nil {
+		if _, _, _, err := r.Dequeue(base.DefaultQueueName); err != nil {
 			b.Fatalf("Dequeue failed: %v", err)
 		}
 	}
@@ -139,7 +139,7 @@ func BenchmarkDequeueMultipleQueues(b *testing.B) {
 		}
 		b.StartTimer()
 
-		if _, _, err := r.Dequeue(qnames...); err != nil {
+		if _, _, _, err := r.Dequeue(qnames...); err != nil {
 			b.Fatalf("Dequeue failed: %v", err)
 		}
 	}
@@ -7,6 +7,7 @@ package rdb
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -46,6 +47,7 @@ type Stats struct {
 	Archived    int
 	Completed   int
 	Aggregating int
+	Waiting     int
 
 	// Number of tasks processed within the current date.
 	// The number includes both succeeded and failed tasks.
@@ -89,12 +91,38 @@ type DailyStats struct {
 // KEYS[10] -> asynq_learn:<qname>:failed
 // KEYS[11] -> asynq_learn:<qname>:paused
 // KEYS[12] -> asynq_learn:<qname>:groups
+// KEYS[13] -> asynq_learn:<qname>:waiting
+// KEYS[14] -> asynq_learn:<qname>:fairness_keys
+// KEYS[15] -> asynq_learn:<qname>:priorities
 // --------
 // ARGV[1] -> task key prefix
 // ARGV[2] -> group key prefix
+// ARGV[3] -> fairness key prefix
+// ARGV[4] -> priority key prefix
 var currentStatsCmd = redis.NewScript(`
 local res = {}
-local pendingTaskCount = redis.call("LLEN", KEYS[1])
+local pending_lists = {KEYS[1]}
+for _, fkey in ipairs(redis.call("LRANGE", KEYS[14], 0, -1)) do
+	if fkey ~= "" then
+		table.insert(pending_lists, ARGV[3] .. fkey)
+	end
+end
+for _, priority in ipairs(redis.call("ZRANGE", KEYS[15], 0, -1)) do
+	table.insert(pending_lists, ARGV[4] .. priority)
+end
+local pendingTaskCount = 0
+local oldestPendingSince = nil
+for _, list in ipairs(pending_lists) do
+	local n = redis.call("LLEN", list)
+	if n > 0 then
+		pendingTaskCount = pendingTaskCount + n
+		local id = redis.call("LRANGE", list, -1, -1)[1]
+		local since = redis.call("HGET", ARGV[1] .. id, "pending_since")
+		if since and (not oldestPendingSince or tonumber(since) < tonumber(oldestPendingSince)) then
+			oldestPendingSince = since
+		end
+	end
+end
 table.insert(res, KEYS[1])
 table.insert(res, pendingTaskCount)
 table.insert(res, KEYS[2])
@@ -107,6 +135,8 @@ table.insert(res, KEYS[5])
 table.insert(res, redis.call("ZCARD", KEYS[5]))
 table.insert(res, KEYS[6])
 table.insert(res, redis.call("ZCARD", KEYS[6]))
+table.insert(res, KEYS[13])
+table.insert(res, redis.call("ZCARD", KEYS[13]))
 for i=7,10 do
     local count = 0
 	local n = redis.call("GET", KEYS[i])
@@ -119,12 +149,7 @@ end
 table.insert(res, KEYS[11])
 table.insert(res, redis.call("EXISTS", KEYS[11]))
 table.insert(res, "oldest_pending_since")
-if pendingTaskCount > 0 then
-	local id = redis.call("LRANGE", KEYS[1], -1, -1)[1]
-	table.insert(res, redis.call("HGET", ARGV[1] .. id, "pending_since"))
-else
-	table.insert(res, 0)
-end
+table.insert(res, oldestPendingSince or 0)
 local group_names = redis.call("SMEMBERS", KEYS[12])
 table.insert(res, "group_size")
 table.insert(res, table.getn(group_names))
@@ -160,10 +185,15 @@ func (r *RDB) CurrentStats(qname string) (*Stats, error) {
 		base.FailedTotalKey(qname),
 		base.PausedKey(qname),
 		base.AllGroups(qname),
+		base.WaitingKey(qname),
+		base.FairnessKeysKey(qname),
+		base.PrioritiesKey(qname),
 	}
 	argv := []interface{}{
 		base.TaskKeyPrefix(qname),
 		base.GroupKeyPrefix(qname),
+		base.FairnessKeyPrefix(qname),
+		base.PriorityKeyPrefix(qname),
 	}
 	res, err := currentStatsCmd.Run(context.Background(), r.client, keys, argv...).Result()
 	if err != nil {
@@ -200,6 +230,9 @@ func (r *RDB) CurrentStats(qname string) (*Stats, error) {
 		case base.CompletedKey(qname):
 			stats.Completed = val
 			size += val
+		case base.WaitingKey(qname):
+			stats.Waiting = val
+			size += val
 		case base.ProcessedKey(qname, now):
 			stats.Processed = val
 		case base.FailedKey(qname, now):
@@ -456,14 +489,14 @@ func (r *RDB) checkQueueExists(qname string) error {
 // Input:
 // KEYS[1] -> task key (asynq_learn:{<qname>}:t:<taskid>)
 // ARGV[1] -> task id
-// ARGV[2] -> current time in Unix time (seconds)
+// ARGV[2] -> current time in Unix time (msec)
 // ARGV[3] -> queue key prefix (asynq_learn:{<qname>}:)
 //
 // Output:
 // Tuple of {msg, state, nextProcessAt, result}
 // msg: encoded task message
 // state: string describing the state of the task
-// nextProcessAt: unix time in seconds, zero if not applicable.
+// nextProcessAt: score of the task (see base.ProcessAtFromScore), zero if not applicable.
 // result: result data associated with the task
 //
 // If the task key doesn't exist, it returns error with a message "NOT FOUND"
@@ -490,7 +523,7 @@ func (r *RDB) GetTaskInfo(qname, id string) (*base.TaskInfo, error) {
 	keys := []string{base.TaskKey(qname, id)}
 	argv := []interface{}{
 		id,
-		r.clock.Now().Unix(),
+		base.ProcessAtScore(r.clock.Now()),
 		base.QueueKeyPrefix(qname),
 	}
 	res, err := getTaskInfoCmd.Run(context.Background(), r.client, keys, argv...).Result()
@@ -515,7 +548,7 @@ func (r *RDB) GetTaskInfo(qname, id string) (*base.TaskInfo, error) {
 	if err != nil {
 		return nil, errors.E(op, errors.Internal, "unexpected value returned from Lua script")
 	}
-	processAtUnix, err := cast.ToInt64E(vals[2])
+	processAtScore, err := cast.ToInt64E(vals[2])
 	if err != nil {
 		return nil, errors.E(op, errors.Internal, "unexpected value returned from Lua script")
 	}
@@ -532,8 +565,8 @@ func (r *RDB) GetTaskInfo(qname, id string) (*base.TaskInfo, error) {
 		return nil, errors.E(op, errors.CanonicalCode(err), err)
 	}
 	var nextProcessAt time.Time
-	if processAtUnix != 0 {
-		nextProcessAt = time.Unix(processAtUnix, 0)
+	if processAtScore != 0 {
+		nextProcessAt = base.ProcessAtFromScore(processAtScore)
 	}
 	var result []byte
 	if len(resultStr) > 0 {
@@ -547,6 +580,218 @@ func (r *RDB) GetTaskInfo(qname, id string) (*base.TaskInfo, error) {
 	}, nil
 }
 
+// Input:
+// KEYS[1] -> chain key (asynq_learn:{<qname>}:chain:<chain_id>)
+// ARGV[1] -> task key prefix (asynq_learn:{<qname>}:t:)
+//
+// Output:
+// Tuple of {fields, state, msg}
+// fields: field-value pairs of the chain hash
+// state: state of the task for the current step, false if the task doesn't exist
+// msg: encoded task message of the task for the current step, false if the task doesn't exist
+//
+// If the chain key doesn't exist, it returns error with a message "NOT FOUND"
+var getChainInfoCmd = redis.NewScript(`
+local fields = redis.call("HGETALL", KEYS[1])
+if table.getn(fields) == 0 then
+	return redis.error_reply("NOT FOUND")
+end
+local current = redis.call("HGET", KEYS[1], "current")
+local id = redis.call("HGET", KEYS[1], "id:" .. current)
+if not id then
+	return {fields, false, false}
+end
+local state, msg = unpack(redis.call("HMGET", ARGV[1] .. id, "state", "msg"))
+return {fields, state, msg}
+`)
+
+// GetChainInfo returns a ChainInfo describing the chain from the given queue.
+func (r *RDB) GetChainInfo(qname, chainID string) (*base.ChainInfo, error) {
+	var op errors.Op = "rdb.GetChainInfo"
+	if err := r.checkQueueExists(qname); err != nil {
+		return nil, errors.E(op, errors.CanonicalCode(err), err)
+	}
+	keys := []string{base.ChainKey(qname, chainID)}
+	argv := []interface{}{base.TaskKeyPrefix(qname)}
+	res, err := getChainInfoCmd.Run(context.Background(), r.client, keys, argv...).Result()
+	if err != nil {
+		if err.Error() == "NOT FOUND" {
+			return nil, errors.E(op, errors.NotFound, &errors.ChainNotFoundError{Queue: qname, ID: chainID})
+		}
+		return nil, errors.E(op, errors.Unknown, err)
+	}
+	vals, err := cast.ToSliceE(res)
+	if err != nil || len(vals) != 3 {
+		return nil, errors.E(op, errors.Internal, "unexpected value returned from Lua script")
+	}
+	kvs, err := cast.ToStringSliceE(vals[0])
+	if err != nil {
+		return nil, errors.E(op, errors.Internal, "unexpected value returned from Lua script")
+	}
+	fields := make(map[string]string)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		fields[kvs[i]] = kvs[i+1]
+	}
+	size, current := cast.ToInt(fields["size"]), cast.ToInt(fields["current"])
+	info := &base.ChainInfo{
+		ID:       chainID,
+		Queue:    qname,
+		Messages: make([]*base.TaskMessage, size),
+		Results:  make([][]byte, size),
+		Current:  current,
+		Err:      fields["err"],
+	}
+	for i := 0; i < size; i++ {
+		msg, err := base.DecodeMessage([]byte(fields[fmt.Sprintf("msg:%d", i)]))
+		if err != nil {
+			return nil, errors.E(op, errors.Internal, "could not decode task message")
+		}
+		info.Messages[i] = msg
+		if res, ok := fields[fmt.Sprintf("result:%d", i)]; ok {
+			info.Results[i] = []byte(res)
+		}
+	}
+	if vals[1] != nil {
+		state, err := base.TaskStateFromString(cast.ToString(vals[1]))
+		if err != nil {
+			return nil, errors.E(op, errors.CanonicalCode(err), err)
+		}
+		info.CurrentState = state
+	}
+	if vals[2] != nil && current < size {
+		// Use the stored message of the current step since it reflects processing attempts.
+		msg, err := base.DecodeMessage([]byte(cast.ToString(vals[2])))
+		if err != nil {
+			return nil, errors.E(op, errors.Internal, "could not decode task message")
+		}
+		info.Messages[current] = msg
+	}
+	return info, nil
+}
+
+// UniqueLock describes a uniqueness lock held by a task.
+type UniqueLock struct {
+	// ID of the task holding the lock.
+	TaskID string
+	// Time when the lock expires.
+	ExpiresAt time.Time
+}
+
+// Input:
+// KEYS[1] -> unique key (asynq_learn:{<qname>}:unique_by:<key>)
+//
+// Output:
+// Tuple of {task_id, ttl}
+// task_id: ID of the task holding the lock
+// ttl: remaining time to live of the lock in milliseconds
+//
+// If the unique key doesn't exist, it returns error with a message "NOT FOUND"
+var getUniqueLockCmd = redis.NewScript(`
+local id = redis.call("GET", KEYS[1])
+if not id then
+	return redis.error_reply("NOT FOUND")
+end
+return {id, redis.call("PTTL", KEYS[1])}
+`)
+
+// GetUniqueLock returns the uniqueness lock with the given key from the given queue.
+// The key is the one given to the UniqueBy option.
+func (r *RDB) GetUniqueLock(qname, key string) (*UniqueLock, error) {
+	var op errors.Op = "rdb.GetUniqueLock"
+	if err := r.checkQueueExists(qname); err != nil {
+		return nil, errors.E(op, errors.CanonicalCode(err), err)
+	}
+	res, err := getUniqueLockCmd.Run(context.Background(), r.client, []string{base.UniqueByKey(qname, key)}).Result()
+	if err != nil {
+		if err.Error() == "NOT FOUND" {
+			return nil, errors.E(op, errors.NotFound, &errors.UniqueLockNotFoundError{Queue: qname, Key: key})
+		}
+		return nil, errors.E(op, errors.Unknown, err)
+	}
+	vals, err := cast.ToSliceE(res)
+	if err != nil || len(vals) != 2 {
+		return nil, errors.E(op, errors.Internal, "unexpected value returned from Lua script")
+	}
+	id, err := cast.ToStringE(vals[0])
+	if err != nil {
+		return nil, errors.E(op, errors.Internal, "unexpected value returned from Lua script")
+	}
+	ttl, err := cast.ToInt64E(vals[1])
+	if err != nil {
+		return nil, errors.E(op, errors.Internal, "unexpected value returned from Lua script")
+	}
+	lock := &UniqueLock{TaskID: id}
+	if ttl >= 0 {
+		lock.ExpiresAt = r.clock.Now().Add(time.Duration(ttl) * time.Millisecond)
+	}
+	return lock, nil
+}
+
+// ReleaseUniqueLock deletes the uniqueness lock with the given key from the given queue,
+// so that another task with the same key can be enqueued.
+// The task holding the lock is left untouched.
+func (r *RDB) ReleaseUniqueLock(qname, key string) error {
+	var op errors.Op = "rdb.ReleaseUniqueLock"
+	if err := r.checkQueueExists(qname); err != nil {
+		return errors.E(op, errors.CanonicalCode(err), err)
+	}
+	n, err := r.client.Del(context.Background(), base.UniqueByKey(qname, key)).Result()
+	if err != nil {
+		return errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "del", Err: err})
+	}
+	if n == 0 {
+		return errors.E(op, errors.NotFound, &errors.UniqueLockNotFoundError{Queue: qname, Key: key})
+	}
+	return nil
+}
+
+// LastEventID returns the ID of the latest event in the event stream,
+// or "0-0" if the stream is empty.
+func (r *RDB) LastEventID() (string, error) {
+	var op errors.Op = "rdb.LastEventID"
+	msgs, err := r.client.XRevRangeN(context.Background(), base.AllEvents, "+", "-", 1).Result()
+	if err != nil {
+		return "", errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "xrevrange", Err: err})
+	}
+	if len(msgs) == 0 {
+		return "0-0", nil
+	}
+	return msgs[0].ID, nil
+}
+
+// ReadEvents returns up to count events recorded after the event with the given ID.
+// If there is no such event, it waits up to the given duration for one to be recorded,
+// and returns an empty slice if none is. A negative duration returns without waiting.
+func (r *RDB) ReadEvents(ctx context.Context, after string, count int, block time.Duration) ([]*base.TaskEvent, error) {
+	var op errors.Op = "rdb.ReadEvents"
+	streams, err := r.client.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{base.AllEvents, after},
+		Count:   int64(count),
+		Block:   block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "xread", Err: err})
+	}
+	var events []*base.TaskEvent
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			events = append(events, &base.TaskEvent{
+				ID:       msg.ID,
+				Kind:     base.TaskEventKind(cast.ToString(msg.Values["kind"])),
+				TaskID:   cast.ToString(msg.Values["task_id"]),
+				Queue:    cast.ToString(msg.Values["queue"]),
+				Type:     cast.ToString(msg.Values["type"]),
+				ServerID: cast.ToString(msg.Values["server_id"]),
+				Time:     time.Unix(0, cast.ToInt64(msg.Values["time"])),
+			})
+		}
+	}
+	return events, nil
+}
+
 type GroupStat struct {
 	// Name of the group.
 	Group string
@@ -597,6 +842,63 @@ func (r *RDB) GroupStats(qname string) ([]*GroupStat, error) {
 	return stats, nil
 }
 
+// FairnessKeyStat holds the number of pending tasks with a fairness key.
+type FairnessKeyStat struct {
+	// Fairness key of the tasks.
+	Key string
+
+	// Number of pending tasks with the fairness key.
+	Pending int
+}
+
+// KEYS[1] -> asynq_learn:{<qname>}:fairness_keys
+// -------
+// ARGV[1] -> fairness key prefix
+//
+// Output:
+// list of fairness key and the number of pending tasks (e.g. key1 n1 key2 n2 ...)
+//
+// Time Complexity:
+// O(N) where N being the number of fairness keys of the given queue.
+var fairnessKeyStatsCmd = redis.NewScript(`
+local res = {}
+for _, fkey in ipairs(redis.call("LRANGE", KEYS[1], 0, -1)) do
+	if fkey ~= "" then
+		table.insert(res, fkey)
+		table.insert(res, redis.call("LLEN", ARGV[1] .. fkey))
+	end
+end
+return res
+`)
+
+// FairnessKeyStats returns the fairness keys of the pending tasks in the given queue,
+// along with the number of pending tasks of each key, sorted by key.
+func (r *RDB) FairnessKeyStats(qname string) ([]*FairnessKeyStat, error) {
+	var op errors.Op = "rdb.FairnessKeyStats"
+	if err := r.checkQueueExists(qname); err != nil {
+		return nil, errors.E(op, errors.CanonicalCode(err), err)
+	}
+	keys := []string{base.FairnessKeysKey(qname)}
+	argv := []interface{}{base.FairnessKeyPrefix(qname)}
+	res, err := fairnessKeyStatsCmd.Run(context.Background(), r.client, keys, argv...).Result()
+	if err != nil {
+		return nil, errors.E(op, errors.Unknown, err)
+	}
+	data, err := cast.ToSliceE(res)
+	if err != nil || len(data)%2 != 0 {
+		return nil, errors.E(op, errors.Internal, "cast error: unexpected return value from Lua script")
+	}
+	var stats []*FairnessKeyStat
+	for i := 0; i < len(data); i += 2 {
+		stats = append(stats, &FairnessKeyStat{
+			Key:     cast.ToString(data[i]),
+			Pending: cast.ToInt(data[i+1]),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Key < stats[j].Key })
+	return stats, nil
+}
+
 // Pagination specifies the page size and page number
 // for the list operation.
 type Pagination struct {
@@ -665,23 +967,77 @@ end
 return data
 `)
 
+// KEYS[1] -> asynq_learn:{<qname>}:pending
+// KEYS[2] -> asynq_learn:{<qname>}:fairness_keys
+// KEYS[3] -> asynq_learn:{<qname>}:priorities
+// ARGV[1] -> start offset
+// ARGV[2] -> stop offset
+// ARGV[3] -> task key prefix
+// ARGV[4] -> fairness key prefix
+// ARGV[5] -> priority key prefix
+//
+// Output:
+// List of msg and result pairs, oldest first.
+// Tasks of the priority lists come first from the highest priority, followed by the tasks
+// of the pending list and then the tasks of each fairness list.
+var listPendingCmd = redis.NewScript(`
+local lists = {}
+for _, priority in ipairs(redis.call("ZREVRANGE", KEYS[3], 0, -1)) do
+	table.insert(lists, ARGV[5] .. priority)
+end
+table.insert(lists, KEYS[1])
+for _, fkey in ipairs(redis.call("LRANGE", KEYS[2], 0, -1)) do
+	if fkey ~= "" then
+		table.insert(lists, ARGV[4] .. fkey)
+	end
+end
+local offset = tonumber(ARGV[1])
+local count = tonumber(ARGV[2]) - offset + 1
+local data = {}
+for _, list in ipairs(lists) do
+	if count <= 0 then
+		break
+	end
+	local n = redis.call("LLEN", list)
+	if offset >= n then
+		offset = offset - n
+	else
+		local ids = redis.call("LRANGE", list, -(offset + count), -(offset + 1))
+		for i = table.getn(ids), 1, -1 do
+			local msg, result = unpack(redis.call("HMGET", ARGV[3] .. ids[i], "msg", "result"))
+			table.insert(data, msg)
+			table.insert(data, result)
+		end
+		count = count - table.getn(ids)
+		offset = 0
+	end
+end
+return data
+`)
+
 // listMessages returns a list of TaskInfo in Redis list with the given key.
+//
+// Pending tasks are read from the priority lists, the pending list and the fairness lists of the queue.
 func (r *RDB) listMessages(qname string, state base.TaskState, pgn Pagination) ([]*base.TaskInfo, error) {
-	var key string
+	var (
+		res interface{}
+		err error
+	)
 	switch state {
 	case base.TaskStateActive:
-		key = base.ActiveKey(qname)
+		// Note: Because we use LPUSH to redis list, we need to calculate the
+		// correct range and reverse the list to get the tasks with pagination.
+		stop := -pgn.start() - 1
+		start := -pgn.stop() - 1
+		res, err = listMessagesCmd.Run(context.Background(), r.client,
+			[]string{base.ActiveKey(qname)}, start, stop, base.TaskKeyPrefix(qname)).Result()
 	case base.TaskStatePending:
-		key = base.PendingKey(qname)
+		keys := []string{base.PendingKey(qname), base.FairnessKeysKey(qname), base.PrioritiesKey(qname)}
+		res, err = listPendingCmd.Run(context.Background(), r.client, keys, pgn.start(), pgn.stop(),
+			base.TaskKeyPrefix(qname), base.FairnessKeyPrefix(qname), base.PriorityKeyPrefix(qname)).Result()
 	default:
 		panic(fmt.Sprintf("unsupported task state: %v", state))
 	}
-	// Note: Because we use LPUSH to redis list, we need to calculate the
-	// correct range and reverse the list to get the tasks with pagination.
-	stop := -pgn.start() - 1
-	start := -pgn.stop() - 1
-	res, err := listMessagesCmd.Run(context.Background(), r.client,
-		[]string{key}, start, stop, base.TaskKeyPrefix(qname)).Result()
 	if err != nil {
 		return nil, errors.E(errors.Unknown, err)
 	}
@@ -710,7 +1066,9 @@ func (r *RDB) listMessages(qname string, state base.TaskState, pgn Pagination) (
 			Result:        res,
 		})
 	}
-	reverse(infos)
+	if state == base.TaskStateActive {
+		reverse(infos)
+	}
 	return infos, nil
 
 }
@@ -802,6 +1160,23 @@ func (r *RDB) ListAggregating(qname, gname string, pgn Pagination) ([]*base.Task
 	return zs, nil
 }
 
+// ListWaiting returns all tasks from the given queue that are waiting for their dependencies to complete.
+func (r *RDB) ListWaiting(qname string, pgn Pagination) ([]*base.TaskInfo, error) {
+	var op errors.Op = "rdb.ListWaiting"
+	exists, err := r.queueExists(qname)
+	if err != nil {
+		return nil, errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "sismember", Err: err})
+	}
+	if !exists {
+		return nil, errors.E(op, errors.NotFound, &errors.QueueNotFoundError{Queue: qname})
+	}
+	zs, err := r.listZSetEntries(qname, base.TaskStateWaiting, base.WaitingKey(qname), pgn)
+	if err != nil {
+		return nil, errors.E(op, errors.CanonicalCode(err), err)
+	}
+	return zs, nil
+}
+
 // Reports whether a queue with the given name exists.
 func (r *RDB) queueExists(qname string) (bool, error) {
 	return r.client.SIsMember(context.Background(), base.AllQueues, qname).Result()
@@ -861,7 +1236,7 @@ func (r *RDB) listZSetEntries(qname string, state base.TaskState, key string, pg
 		}
 		var nextProcessAt time.Time
 		if state == base.TaskStateScheduled || state == base.TaskStateRetry {
-			nextProcessAt = time.Unix(score, 0)
+			nextProcessAt = base.ProcessAtFromScore(score)
 		}
 		var resBytes []byte
 		if len(resStr) > 0 {
@@ -977,8 +1352,7 @@ func (r *RDB) RunAllAggregatingTasks(qname, gname string) (int64, error) {
 //
 // Input:
 // KEYS[1] -> asynq_learn:{<qname>}:t:<task_id>
-// KEYS[2] -> asynq_learn:{<qname>}:pending
-// KEYS[3] -> asynq_learn:{<qname>}:groups
+// KEYS[2] -> asynq_learn:{<qname>}:groups
 // --
 // ARGV[1] -> task ID
 // ARGV[2] -> queue key prefix; asynq_learn:{<qname>}:
@@ -991,7 +1365,7 @@ func (r *RDB) RunAllAggregatingTasks(qname, gname string) (int64, error) {
 // Returns -1 if task is in active state.
 // Returns -2 if task is in pending state.
 // Returns error reply if unexpected error occurs.
-var runTaskCmd = redis.NewScript(`
+var runTaskCmd = redis.NewScript(pendingLua + `
 if redis.call("EXISTS", KEYS[1]) == 0 then
 	return 0
 end
@@ -1006,7 +1380,7 @@ elseif state == "aggregating" then
 		return redis.error_reply("internal error: task id not found in zset " .. tostring(ARGV[3] .. group))
 	end
 	if redis.call("ZCARD", ARGV[3] .. group) == 0 then
-		redis.call("SREM", KEYS[3], group)
+		redis.call("SREM", KEYS[2], group)
 	end
 else
 	local n = redis.call("ZREM", ARGV[2] .. state, ARGV[1])
@@ -1014,7 +1388,7 @@ else
 		return redis.error_reply("internal error: task id not found in zset " .. tostring(ARGV[2] .. state))
 	end
 end
-redis.call("LPUSH", KEYS[2], ARGV[1])
+pending_push(ARGV[2], ARGV[1], "LPUSH")
 redis.call("HSET", KEYS[1], "state", "pending")
 return 1
 `)
@@ -1032,7 +1406,6 @@ func (r *RDB) RunTask(qname, id string) error {
 	}
 	keys := []string{
 		base.TaskKey(qname, id),
-		base.PendingKey(qname),
 		base.AllGroups(qname),
 	}
 	argv := []interface{}{
@@ -1067,16 +1440,16 @@ func (r *RDB) RunTask(qname, id string) error {
 //
 // Input:
 // KEYS[1] -> zset which holds task ids (e.g. asynq_learn:{<qname>}:scheduled)
-// KEYS[2] -> asynq_learn:{<qname>}:pending
 // --
 // ARGV[1] -> task key prefix
+// ARGV[2] -> queue key prefix
 //
 // Output:
 // integer: number of tasks updated to pending state.
-var runAllCmd = redis.NewScript(`
+var runAllCmd = redis.NewScript(pendingLua + `
 local ids = redis.call("ZRANGE", KEYS[1], 0, -1)
 for _, id in ipairs(ids) do
-	redis.call("LPUSH", KEYS[2], id)
+	pending_push(ARGV[2], id, "LPUSH")
 	redis.call("HSET", ARGV[1] .. id, "state", "pending")
 end
 redis.call("DEL", KEYS[1])
@@ -1088,10 +1461,10 @@ func (r *RDB) runAll(zset, qname string) (int64, error) {
 	}
 	keys := []string{
 		zset,
-		base.PendingKey(qname),
 	}
 	argv := []interface{}{
 		base.TaskKeyPrefix(qname),
+		base.QueueKeyPrefix(qname),
 	}
 	res, err := runAllCmd.Run(context.Background(), r.client, keys, argv...).Result()
 	if err != nil {
@@ -1143,6 +1516,7 @@ func (r *RDB) ArchiveAllScheduledTasks(qname string) (int64, error) {
 // KEYS[1] -> asynq_learn:{<qname>}:g:<gname>
 // KEYS[2] -> asynq_learn:{<qname>}:archived
 // KEYS[3] -> asynq_learn:{<qname>}:groups
+// KEYS[4] -> asynq_learn:{<qname>}:blocked
 // -------
 // ARGV[1] -> current timestamp
 // ARGV[2] -> cutoff timestamp (e.g., 90 days ago)
@@ -1151,18 +1525,21 @@ func (r *RDB) ArchiveAllScheduledTasks(qname string) (int64, error) {
 // ARGV[5] -> group name
 //
 // Output:
-// integer: Number of tasks archived
-var archiveAllAggregatingCmd = redis.NewScript(`
+// Returns a table containing the ID and the type of each archived task (see taskEventsLua).
+var archiveAllAggregatingCmd = redis.NewScript(blockDependentsLua + taskEventsLua + `
 local ids = redis.call("ZRANGE", KEYS[1], 0, -1)
+local archived = {}
 for _, id in ipairs(ids) do
+	add_task_event(archived, ARGV[4] .. id, id)
 	redis.call("ZADD", KEYS[2], ARGV[1], id)
 	redis.call("HSET", ARGV[4] .. id, "state", "archived")
+	block_dependents(ARGV[4] .. id, KEYS[4])
 end
 redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[2])
 redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -ARGV[3])
 redis.call("DEL", KEYS[1])
 redis.call("SREM", KEYS[3], ARGV[5])
-return table.getn(ids)
+return archived
 `)
 
 // ArchiveAllAggregatingTasks archives all aggregating tasks from the given group
@@ -1177,6 +1554,7 @@ func (r *RDB) ArchiveAllAggregatingTasks(qname, gname string) (int64, error) {
 		base.GroupKey(qname, gname),
 		base.ArchivedKey(qname),
 		base.AllGroups(qname),
+		base.BlockedKey(qname),
 	}
 	now := r.clock.Now()
 	argv := []interface{}{
@@ -1190,37 +1568,104 @@ func (r *RDB) ArchiveAllAggregatingTasks(qname, gname string) (int64, error) {
 	if err != nil {
 		return 0, errors.E(op, errors.Internal, err)
 	}
-	n, ok := res.(int64)
-	if !ok {
+	if _, ok := res.([]interface{}); !ok {
 		return 0, errors.E(op, errors.Internal, fmt.Sprintf("unexpected return value from script %v", res))
 	}
-	return n, nil
+	return r.recordTaskEvents(base.TaskEventArchived, qname, res), nil
 }
 
+// fairClearLua defines the Lua functions fair_ids and fair_clear, which scripts removing all
+// pending tasks of a queue call to collect the task ids held in the fairness lists of the queue
+// and to delete the lists.
+//
+// Arguments:
+// keys   -> asynq_learn:{<qname>}:fairness_keys
+// prefix -> asynq_learn:{<qname>}:fairness:
+const fairClearLua = `
+local function fair_ids(keys, prefix)
+	local ids = {}
+	for _, fkey in ipairs(redis.call("LRANGE", keys, 0, -1)) do
+		if fkey ~= "" then
+			for _, id in ipairs(redis.call("LRANGE", prefix .. fkey, 0, -1)) do
+				table.insert(ids, id)
+			end
+		end
+	end
+	return ids
+end
+local function fair_clear(keys, prefix)
+	for _, fkey in ipairs(redis.call("LRANGE", keys, 0, -1)) do
+		redis.call("DEL", prefix .. fkey)
+	end
+	redis.call("DEL", keys)
+end
+`
+
+// priorityClearLua defines the Lua functions priority_ids and priority_clear, which scripts removing
+// all pending tasks of a queue call to collect the task ids held in the priority lists of the queue
+// and to delete the lists.
+//
+// Arguments:
+// keys   -> asynq_learn:{<qname>}:priorities
+// prefix -> asynq_learn:{<qname>}:priority:
+const priorityClearLua = `
+local function priority_ids(keys, prefix)
+	local ids = {}
+	for _, priority in ipairs(redis.call("ZRANGE", keys, 0, -1)) do
+		for _, id in ipairs(redis.call("LRANGE", prefix .. priority, 0, -1)) do
+			table.insert(ids, id)
+		end
+	end
+	return ids
+end
+local function priority_clear(keys, prefix)
+	for _, priority in ipairs(redis.call("ZRANGE", keys, 0, -1)) do
+		redis.call("DEL", prefix .. priority)
+	end
+	redis.call("DEL", keys)
+end
+`
+
 // archiveAllPendingCmd is a Lua script that moves all pending tasks from
 // the given queue to archived state.
 //
 // Input:
 // KEYS[1] -> asynq_learn:{<qname>}:pending
 // KEYS[2] -> asynq_learn:{<qname>}:archived
+// KEYS[3] -> asynq_learn:{<qname>}:fairness_keys
+// KEYS[4] -> asynq_learn:{<qname>}:priorities
+// KEYS[5] -> asynq_learn:{<qname>}:blocked
 // --
 // ARGV[1] -> current timestamp
 // ARGV[2] -> cutoff timestamp (e.g., 90 days ago)
 // ARGV[3] -> max number of tasks in archive (e.g., 100)
 // ARGV[4] -> task key prefix (asynq_learn:{<qname>}:t:)
+// ARGV[5] -> fairness key prefix
+// ARGV[6] -> priority key prefix
 //
 // Output:
-// integer: Number of tasks archived
-var archiveAllPendingCmd = redis.NewScript(`
+// Returns a table containing the ID and the type of each archived task (see taskEventsLua).
+var archiveAllPendingCmd = redis.NewScript(fairClearLua + priorityClearLua + blockDependentsLua + taskEventsLua + `
 local ids = redis.call("LRANGE", KEYS[1], 0, -1)
+for _, id in ipairs(fair_ids(KEYS[3], ARGV[5])) do
+	table.insert(ids, id)
+end
+for _, id in ipairs(priority_ids(KEYS[4], ARGV[6])) do
+	table.insert(ids, id)
+end
+local archived = {}
 for _, id in ipairs(ids) do
+	add_task_event(archived, ARGV[4] .. id, id)
 	redis.call("ZADD", KEYS[2], ARGV[1], id)
 	redis.call("HSET", ARGV[4] .. id, "state", "archived")
+	block_dependents(ARGV[4] .. id, KEYS[5])
 end
 redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[2])
 redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -ARGV[3])
 redis.call("DEL", KEYS[1])
-return table.getn(ids)`)
+fair_clear(KEYS[3], ARGV[5])
+priority_clear(KEYS[4], ARGV[6])
+return archived`)
 
 // ArchiveAllPendingTasks archives all pending tasks from the given queue and
 // returns the number of tasks moved.
@@ -1233,6 +1678,9 @@ func (r *RDB) ArchiveAllPendingTasks(qname string) (int64, error) {
 	keys := []string{
 		base.PendingKey(qname),
 		base.ArchivedKey(qname),
+		base.FairnessKeysKey(qname),
+		base.PrioritiesKey(qname),
+		base.BlockedKey(qname),
 	}
 	now := r.clock.Now()
 	argv := []interface{}{
@@ -1240,16 +1688,17 @@ func (r *RDB) ArchiveAllPendingTasks(qname string) (int64, error) {
 		now.AddDate(0, 0, -archivedExpirationInDays).Unix(),
 		maxArchiveSize,
 		base.TaskKeyPrefix(qname),
+		base.FairnessKeyPrefix(qname),
+		base.PriorityKeyPrefix(qname),
 	}
 	res, err := archiveAllPendingCmd.Run(context.Background(), r.client, keys, argv...).Result()
 	if err != nil {
 		return 0, errors.E(op, errors.Internal, err)
 	}
-	n, ok := res.(int64)
-	if !ok {
+	if _, ok := res.([]interface{}); !ok {
 		return 0, errors.E(op, errors.Internal, fmt.Sprintf("unexpected return value from script %v", res))
 	}
-	return n, nil
+	return r.recordTaskEvents(base.TaskEventArchived, qname, res), nil
 }
 
 // archiveTaskCmd is a Lua script that archives a task given a task id.
@@ -1258,6 +1707,7 @@ func (r *RDB) ArchiveAllPendingTasks(qname string) (int64, error) {
 // KEYS[1] -> task key (asynq_learn:{<qname>}:t:<task_id>)
 // KEYS[2] -> archived key (asynq_learn:{<qname>}:archived)
 // KEYS[3] -> all groups key (asynq_learn:{<qname>}:groups)
+// KEYS[4] -> blocked key (asynq_learn:{<qname>}:blocked)
 // --
 // ARGV[1] -> id of the task to archive
 // ARGV[2] -> current timestamp
@@ -1265,6 +1715,7 @@ func (r *RDB) ArchiveAllPendingTasks(qname string) (int64, error) {
 // ARGV[4] -> max number of tasks in archived state (e.g., 100)
 // ARGV[5] -> queue key prefix (asynq_learn:{<qname>}:)
 // ARGV[6] -> group key prefix (asynq_learn:{<qname>}:g:)
+// ARGV[7] -> completion pubsub channel
 //
 // Output:
 // Numeric code indicating the status:
@@ -1273,7 +1724,7 @@ func (r *RDB) ArchiveAllPendingTasks(qname string) (int64, error) {
 // Returns -1 if task is already archived.
 // Returns -2 if task is in active state.
 // Returns error reply if unexpected error occurs.
-var archiveTaskCmd = redis.NewScript(`
+var archiveTaskCmd = redis.NewScript(pendingLua + blockDependentsLua + `
 if redis.call("EXISTS", KEYS[1]) == 0 then
 	return 0
 end
@@ -1285,8 +1736,8 @@ if state == "archived" then
 	return -1
 end
 if state == "pending" then
-	if redis.call("LREM", ARGV[5] .. state, 1, ARGV[1]) == 0 then
-		return redis.error_reply("task id not found in list " .. tostring(ARGV[5] .. state))
+	if pending_remove(ARGV[5], ARGV[1]) == 0 then
+		return redis.error_reply("task id not found in pending list of " .. tostring(ARGV[5]))
 	end
 elseif state == "aggregating" then
 	if redis.call("ZREM", ARGV[6] .. group, ARGV[1]) == 0 then
@@ -1302,8 +1753,10 @@ else
 end
 redis.call("ZADD", KEYS[2], ARGV[2], ARGV[1])
 redis.call("HSET", KEYS[1], "state", "archived")
+block_dependents(KEYS[1], KEYS[4])
 redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[3])
 redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -ARGV[4])
+redis.call("PUBLISH", ARGV[7], ARGV[1])
 return 1
 `)
 
@@ -1323,6 +1776,7 @@ func (r *RDB) ArchiveTask(qname, id string) error {
 		base.TaskKey(qname, id),
 		base.ArchivedKey(qname),
 		base.AllGroups(qname),
+		base.BlockedKey(qname),
 	}
 	now := r.clock.Now()
 	argv := []interface{}{
@@ -1332,6 +1786,7 @@ func (r *RDB) ArchiveTask(qname, id string) error {
 		maxArchiveSize,
 		base.QueueKeyPrefix(qname),
 		base.GroupKeyPrefix(qname),
+		base.CompletionChannel(qname, id),
 	}
 	res, err := archiveTaskCmd.Run(context.Background(), r.client, keys, argv...).Result()
 	if err != nil {
@@ -1363,6 +1818,7 @@ func (r *RDB) ArchiveTask(qname, id string) error {
 // Input:
 // KEYS[1] -> ZSET to move task from (e.g., asynq_learn:{<qname>}:retry)
 // KEYS[2] -> asynq_learn:{<qname>}:archived
+// KEYS[3] -> asynq_learn:{<qname>}:blocked
 // --
 // ARGV[1] -> current timestamp
 // ARGV[2] -> cutoff timestamp (e.g., 90 days ago)
@@ -1370,17 +1826,20 @@ func (r *RDB) ArchiveTask(qname, id string) error {
 // ARGV[4] -> task key prefix (asynq_learn:{<qname>}:t:)
 //
 // Output:
-// integer: number of tasks archived
-var archiveAllCmd = redis.NewScript(`
+// Returns a table containing the ID and the type of each archived task (see taskEventsLua).
+var archiveAllCmd = redis.NewScript(blockDependentsLua + taskEventsLua + `
 local ids = redis.call("ZRANGE", KEYS[1], 0, -1)
+local archived = {}
 for _, id in ipairs(ids) do
+	add_task_event(archived, ARGV[4] .. id, id)
 	redis.call("ZADD", KEYS[2], ARGV[1], id)
 	redis.call("HSET", ARGV[4] .. id, "state", "archived")
+	block_dependents(ARGV[4] .. id, KEYS[3])
 end
 redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[2])
 redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -ARGV[3])
 redis.call("DEL", KEYS[1])
-return table.getn(ids)`)
+return archived`)
 
 func (r *RDB) archiveAll(src, dst, qname string) (int64, error) {
 	if err := r.checkQueueExists(qname); err != nil {
@@ -1389,6 +1848,7 @@ func (r *RDB) archiveAll(src, dst, qname string) (int64, error) {
 	keys := []string{
 		src,
 		dst,
+		base.BlockedKey(qname),
 	}
 	now := r.clock.Now()
 	argv := []interface{}{
@@ -1402,30 +1862,28 @@ func (r *RDB) archiveAll(src, dst, qname string) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	n, ok := res.(int64)
-	if !ok {
+	if _, ok := res.([]interface{}); !ok {
 		return 0, fmt.Errorf("unexpected return value from script: %v", res)
 	}
-	if n == -1 {
-		return 0, &errors.QueueNotFoundError{Queue: qname}
-	}
-	return n, nil
+	return r.recordTaskEvents(base.TaskEventArchived, qname, res), nil
 }
 
 // Input:
 // KEYS[1] -> asynq_learn:{<qname>}:t:<task_id>
 // KEYS[2] -> asynq_learn:{<qname>}:groups
+// KEYS[3] -> asynq_learn:{<qname>}:blocked
 // --
 // ARGV[1] -> task ID
 // ARGV[2] -> queue key prefix
 // ARGV[3] -> group key prefix
+// ARGV[4] -> completion pubsub channel
 //
 // Output:
 // Numeric code indicating the status:
 // Returns 1 if task is successfully deleted.
 // Returns 0 if task is not found.
 // Returns -1 if task is in active state.
-var deleteTaskCmd = redis.NewScript(`
+var deleteTaskCmd = redis.NewScript(pendingLua + blockDependentsLua + `
 if redis.call("EXISTS", KEYS[1]) == 0 then
 	return 0
 end
@@ -1434,8 +1892,8 @@ if state == "active" then
 	return -1
 end
 if state == "pending" then
-	if redis.call("LREM", ARGV[2] .. state, 0, ARGV[1]) == 0 then
-		return redis.error_reply("task is not found in list: " .. tostring(ARGV[2] .. state))
+	if pending_remove(ARGV[2], ARGV[1]) == 0 then
+		return redis.error_reply("task is not found in pending list of: " .. tostring(ARGV[2]))
 	end
 elseif state == "aggregating" then
 	if redis.call("ZREM", ARGV[3] .. group, ARGV[1]) == 0 then
@@ -1453,7 +1911,10 @@ local unique_key = redis.call("HGET", KEYS[1], "unique_key")
 if unique_key and unique_key ~= "" and redis.call("GET", unique_key) == ARGV[1] then
 	redis.call("DEL", unique_key)
 end
-return redis.call("DEL", KEYS[1])
+block_dependents(KEYS[1], KEYS[3])
+local n = redis.call("DEL", KEYS[1])
+redis.call("PUBLISH", ARGV[4], ARGV[1])
+return n
 `)
 
 // DeleteTask finds a task that matches the id from the given queue and deletes it.
@@ -1470,11 +1931,13 @@ func (r *RDB) DeleteTask(qname, id string) error {
 	keys := []string{
 		base.TaskKey(qname, id),
 		base.AllGroups(qname),
+		base.BlockedKey(qname),
 	}
 	argv := []interface{}{
 		id,
 		base.QueueKeyPrefix(qname),
 		base.GroupKeyPrefix(qname),
+		base.CompletionChannel(qname, id),
 	}
 	res, err := deleteTaskCmd.Run(context.Background(), r.client, keys, argv...).Result()
 	if err != nil {
@@ -1556,23 +2019,29 @@ func (r *RDB) DeleteAllCompletedTasks(qname string) (int64, error) {
 //
 // Input:
 // KEYS[1] -> zset holding the task ids.
+// KEYS[2] -> asynq_learn:{<qname>}:blocked
 // --
 // ARGV[1] -> task key prefix
+// ARGV[2] -> completion channel prefix
 //
 // Output:
-// integer: number of tasks deleted
-var deleteAllCmd = redis.NewScript(`
+// Returns a table containing the ID and the type of each deleted task (see taskEventsLua).
+var deleteAllCmd = redis.NewScript(blockDependentsLua + taskEventsLua + `
 local ids = redis.call("ZRANGE", KEYS[1], 0, -1)
+local deleted = {}
 for _, id in ipairs(ids) do
 	local task_key = ARGV[1] .. id
+	add_task_event(deleted, task_key, id)
 	local unique_key = redis.call("HGET", task_key, "unique_key")
 	if unique_key and unique_key ~= "" and redis.call("GET", unique_key) == id then
 		redis.call("DEL", unique_key)
 	end
+	block_dependents(task_key, KEYS[2])
 	redis.call("DEL", task_key)
+	redis.call("PUBLISH", ARGV[2] .. id, id)
 end
 redis.call("DEL", KEYS[1])
-return table.getn(ids)`)
+return deleted`)
 
 func (r *RDB) deleteAll(key, qname string) (int64, error) {
 	if err := r.checkQueueExists(qname); err != nil {
@@ -1580,17 +2049,20 @@ func (r *RDB) deleteAll(key, qname string) (int64, error) {
 	}
 	argv := []interface{}{
 		base.TaskKeyPrefix(qname),
-		qname,
+		base.CompletionChannelPrefix(qname),
 	}
-	res, err := deleteAllCmd.Run(context.Background(), r.client, []string{key}, argv...).Result()
+	keys := []string{
+		key,
+		base.BlockedKey(qname),
+	}
+	res, err := deleteAllCmd.Run(context.Background(), r.client, keys, argv...).Result()
 	if err != nil {
 		return 0, err
 	}
-	n, ok := res.(int64)
-	if !ok {
+	if _, ok := res.([]interface{}); !ok {
 		return 0, fmt.Errorf("unexpected return value from Lua script: %v", res)
 	}
-	return n, nil
+	return r.recordTaskEvents(base.TaskEventDeleted, qname, res), nil
 }
 
 // deleteAllAggregatingCmd deletes all tasks from the given group.
@@ -1598,17 +2070,26 @@ func (r *RDB) deleteAll(key, qname string) (int64, error) {
 // Input:
 // KEYS[1] -> asynq_learn:{<qname>}:g:<gname>
 // KEYS[2] -> asynq_learn:{<qname>}:groups
+// KEYS[3] -> asynq_learn:{<qname>}:blocked
 // -------
 // ARGV[1] -> task key prefix
 // ARGV[2] -> group name
-var deleteAllAggregatingCmd = redis.NewScript(`
+// ARGV[3] -> completion channel prefix
+//
+// Output:
+// Returns a table containing the ID and the type of each deleted task (see taskEventsLua).
+var deleteAllAggregatingCmd = redis.NewScript(blockDependentsLua + taskEventsLua + `
 local ids = redis.call("ZRANGE", KEYS[1], 0, -1)
+local deleted = {}
 for _, id in ipairs(ids) do
+	add_task_event(deleted, ARGV[1] .. id, id)
+	block_dependents(ARGV[1] .. id, KEYS[3])
 	redis.call("DEL", ARGV[1] .. id)
+	redis.call("PUBLISH", ARGV[3] .. id, id)
 end
 redis.call("SREM", KEYS[2], ARGV[2])
 redis.call("DEL", KEYS[1])
-return table.getn(ids)
+return deleted
 `)
 
 // DeleteAllAggregatingTasks deletes all aggregating tasks from the given group
@@ -1621,38 +2102,57 @@ func (r *RDB) DeleteAllAggregatingTasks(qname, gname string) (int64, error) {
 	keys := []string{
 		base.GroupKey(qname, gname),
 		base.AllGroups(qname),
+		base.BlockedKey(qname),
 	}
 	argv := []interface{}{
 		base.TaskKeyPrefix(qname),
 		gname,
+		base.CompletionChannelPrefix(qname),
 	}
 	res, err := deleteAllAggregatingCmd.Run(context.Background(), r.client, keys, argv...).Result()
 	if err != nil {
 		return 0, errors.E(op, errors.Unknown, err)
 	}
-	n, ok := res.(int64)
-	if !ok {
+	if _, ok := res.([]interface{}); !ok {
 		return 0, errors.E(op, errors.Internal, "command error: unexpected return value %v", res)
 	}
-	return n, nil
+	return r.recordTaskEvents(base.TaskEventDeleted, qname, res), nil
 }
 
 // deleteAllPendingCmd deletes all pending tasks from the given queue.
 //
 // Input:
 // KEYS[1] -> asynq_learn:{<qname>}:pending
+// KEYS[2] -> asynq_learn:{<qname>}:fairness_keys
+// KEYS[3] -> asynq_learn:{<qname>}:priorities
+// KEYS[4] -> asynq_learn:{<qname>}:blocked
 // --
 // ARGV[1] -> task key prefix
+// ARGV[2] -> fairness key prefix
+// ARGV[3] -> priority key prefix
+// ARGV[4] -> completion channel prefix
 //
 // Output:
-// integer: number of tasks deleted
-var deleteAllPendingCmd = redis.NewScript(`
+// Returns a table containing the ID and the type of each deleted task (see taskEventsLua).
+var deleteAllPendingCmd = redis.NewScript(fairClearLua + priorityClearLua + blockDependentsLua + taskEventsLua + `
 local ids = redis.call("LRANGE", KEYS[1], 0, -1)
+for _, id in ipairs(fair_ids(KEYS[2], ARGV[2])) do
+	table.insert(ids, id)
+end
+for _, id in ipairs(priority_ids(KEYS[3], ARGV[3])) do
+	table.insert(ids, id)
+end
+local deleted = {}
 for _, id in ipairs(ids) do
+	add_task_event(deleted, ARGV[1] .. id, id)
+	block_dependents(ARGV[1] .. id, KEYS[4])
 	redis.call("DEL", ARGV[1] .. id)
+	redis.call("PUBLISH", ARGV[4] .. id, id)
 end
 redis.call("DEL", KEYS[1])
-return table.getn(ids)`)
+fair_clear(KEYS[2], ARGV[2])
+priority_clear(KEYS[3], ARGV[3])
+return deleted`)
 
 // DeleteAllPendingTasks deletes all pending tasks from the given queue
 // and returns the number of tasks deleted.
@@ -1663,19 +2163,24 @@ func (r *RDB) DeleteAllPendingTasks(qname string) (int64, error) {
 	}
 	keys := []string{
 		base.PendingKey(qname),
+		base.FairnessKeysKey(qname),
+		base.PrioritiesKey(qname),
+		base.BlockedKey(qname),
 	}
 	argv := []interface{}{
 		base.TaskKeyPrefix(qname),
+		base.FairnessKeyPrefix(qname),
+		base.PriorityKeyPrefix(qname),
+		base.CompletionChannelPrefix(qname),
 	}
 	res, err := deleteAllPendingCmd.Run(context.Background(), r.client, keys, argv...).Result()
 	if err != nil {
 		return 0, errors.E(op, errors.Unknown, err)
 	}
-	n, ok := res.(int64)
-	if !ok {
+	if _, ok := res.([]interface{}); !ok {
 		return 0, errors.E(op, errors.Internal, "command error: unexpected return value %v", res)
 	}
-	return n, nil
+	return r.recordTaskEvents(base.TaskEventDeleted, qname, res), nil
 }
 
 // removeQueueForceCmd removes the given queue regardless of
@@ -1689,47 +2194,71 @@ func (r *RDB) DeleteAllPendingTasks(qname string) (int64, error) {
 // KEYS[4] -> asynq_learn:{<qname>}:retry
 // KEYS[5] -> asynq_learn:{<qname>}:archived
 // KEYS[6] -> asynq_learn:{<qname>}:lease
+// KEYS[7] -> asynq_learn:{<qname>}:waiting
+// KEYS[8] -> asynq_learn:{<qname>}:fairness_keys
+// KEYS[9] -> asynq_learn:{<qname>}:priorities
+// KEYS[10] -> asynq_learn:{<qname>}:ordering_keys
+// KEYS[11] -> asynq_learn:{<qname>}:expiring
+// KEYS[12] -> asynq_learn:{<qname>}:blocked
 // --
 // ARGV[1] -> task key prefix
+// ARGV[2] -> fairness key prefix
+// ARGV[3] -> priority key prefix
+// ARGV[4] -> ordering key prefix
+// ARGV[5] -> completion channel prefix
 //
 // Output:
 // Numeric code to indicate the status.
 // Returns 1 if successfully removed.
 // Returns -2 if the queue has active tasks.
-var removeQueueForceCmd = redis.NewScript(`
+var removeQueueForceCmd = redis.NewScript(fairClearLua + priorityClearLua + `
 local active = redis.call("LLEN", KEYS[2])
 if active > 0 then
     return -2
 end
+local function remove_task(id)
+	if redis.call("DEL", ARGV[1] .. id) == 1 then
+		redis.call("PUBLISH", ARGV[5] .. id, id)
+	end
+end
 for _, id in ipairs(redis.call("LRANGE", KEYS[1], 0, -1)) do
-	redis.call("DEL", ARGV[1] .. id)
+	remove_task(id)
 end
 for _, id in ipairs(redis.call("LRANGE", KEYS[2], 0, -1)) do
-	redis.call("DEL", ARGV[1] .. id)
+	remove_task(id)
 end
 for _, id in ipairs(redis.call("ZRANGE", KEYS[3], 0, -1)) do
-	redis.call("DEL", ARGV[1] .. id)
+	remove_task(id)
 end
 for _, id in ipairs(redis.call("ZRANGE", KEYS[4], 0, -1)) do
-	redis.call("DEL", ARGV[1] .. id)
+	remove_task(id)
 end
 for _, id in ipairs(redis.call("ZRANGE", KEYS[5], 0, -1)) do
-	redis.call("DEL", ARGV[1] .. id)
+	remove_task(id)
 end
 for _, id in ipairs(redis.call("LRANGE", KEYS[1], 0, -1)) do
-	redis.call("DEL", ARGV[1] .. id)
+	remove_task(id)
 end
 for _, id in ipairs(redis.call("LRANGE", KEYS[2], 0, -1)) do
-	redis.call("DEL", ARGV[1] .. id)
+	remove_task(id)
 end
 for _, id in ipairs(redis.call("ZRANGE", KEYS[3], 0, -1)) do
-	redis.call("DEL", ARGV[1] .. id)
+	remove_task(id)
 end
 for _, id in ipairs(redis.call("ZRANGE", KEYS[4], 0, -1)) do
-	redis.call("DEL", ARGV[1] .. id)
+	remove_task(id)
 end
 for _, id in ipairs(redis.call("ZRANGE", KEYS[5], 0, -1)) do
-	redis.call("DEL", ARGV[1] .. id)
+	remove_task(id)
+end
+for _, id in ipairs(redis.call("ZRANGE", KEYS[7], 0, -1)) do
+	remove_task(id)
+end
+for _, id in ipairs(fair_ids(KEYS[8], ARGV[2])) do
+	remove_task(id)
+end
+for _, id in ipairs(priority_ids(KEYS[9], ARGV[3])) do
+	remove_task(id)
 end
 redis.call("DEL", KEYS[1])
 redis.call("DEL", KEYS[2])
@@ -1737,6 +2266,15 @@ redis.call("DEL", KEYS[3])
 redis.call("DEL", KEYS[4])
 redis.call("DEL", KEYS[5])
 redis.call("DEL", KEYS[6])
+redis.call("DEL", KEYS[7])
+fair_clear(KEYS[8], ARGV[2])
+priority_clear(KEYS[9], ARGV[3])
+for _, okey in ipairs(redis.call("SMEMBERS", KEYS[10])) do
+	redis.call("DEL", ARGV[4] .. okey)
+end
+redis.call("DEL", KEYS[10])
+redis.call("DEL", KEYS[11])
+redis.call("DEL", KEYS[12])
 return 1`)
 
 // removeQueueCmd removes the given queue.
@@ -1749,14 +2287,23 @@ return 1`)
 // KEYS[4] -> asynq_learn:{<qname>}:retry
 // KEYS[5] -> asynq_learn:{<qname>}:archived
 // KEYS[6] -> asynq_learn:{<qname>}:lease
+// KEYS[7] -> asynq_learn:{<qname>}:waiting
+// KEYS[8] -> asynq_learn:{<qname>}:fairness_keys
+// KEYS[9] -> asynq_learn:{<qname>}:priorities
+// KEYS[10] -> asynq_learn:{<qname>}:ordering_keys
+// KEYS[11] -> asynq_learn:{<qname>}:expiring
+// KEYS[12] -> asynq_learn:{<qname>}:blocked
 // --
 // ARGV[1] -> task key prefix
+// ARGV[2] -> fairness key prefix
+// ARGV[3] -> priority key prefix
+// ARGV[4] -> ordering key prefix
 //
 // Output:
 // Numeric code to indicate the status
 // Returns 1 if successfully removed.
 // Returns -1 if queue is not empty
-var removeQueueCmd = redis.NewScript(`
+var removeQueueCmd = redis.NewScript(fairClearLua + priorityClearLua + `
 local ids = {}
 for _, id in ipairs(redis.call("LRANGE", KEYS[1], 0, -1)) do
 	table.insert(ids, id)
@@ -1773,6 +2320,15 @@ end
 for _, id in ipairs(redis.call("ZRANGE", KEYS[5], 0, -1)) do
 	table.insert(ids, id)
 end
+for _, id in ipairs(redis.call("ZRANGE", KEYS[7], 0, -1)) do
+	table.insert(ids, id)
+end
+for _, id in ipairs(fair_ids(KEYS[8], ARGV[2])) do
+	table.insert(ids, id)
+end
+for _, id in ipairs(priority_ids(KEYS[9], ARGV[3])) do
+	table.insert(ids, id)
+end
 if table.getn(ids) > 0 then
 	return -1
 end
@@ -1788,6 +2344,15 @@ redis.call("DEL", KEYS[3])
 redis.call("DEL", KEYS[4])
 redis.call("DEL", KEYS[5])
 redis.call("DEL", KEYS[6])
+redis.call("DEL", KEYS[7])
+fair_clear(KEYS[8], ARGV[2])
+priority_clear(KEYS[9], ARGV[3])
+for _, okey in ipairs(redis.call("SMEMBERS", KEYS[10])) do
+	redis.call("DEL", ARGV[4] .. okey)
+end
+redis.call("DEL", KEYS[10])
+redis.call("DEL", KEYS[11])
+redis.call("DEL", KEYS[12])
 return 1`)
 
 // RemoveQueue removes the specified queue.
@@ -1818,8 +2383,21 @@ func (r *RDB) RemoveQueue(qname string, force bool) error {
 		base.RetryKey(qname),
 		base.ArchivedKey(qname),
 		base.LeaseKey(qname),
+		base.WaitingKey(qname),
+		base.FairnessKeysKey(qname),
+		base.PrioritiesKey(qname),
+		base.AllOrderingKeys(qname),
+		base.ExpiringKey(qname),
+		base.BlockedKey(qname),
+	}
+	argv := []interface{}{
+		base.TaskKeyPrefix(qname),
+		base.FairnessKeyPrefix(qname),
+		base.PriorityKeyPrefix(qname),
+		base.OrderingKeyPrefix(qname),
+		base.CompletionChannelPrefix(qname),
 	}
-	res, err := script.Run(context.Background(), r.client, keys, base.TaskKeyPrefix(qname)).Result()
+	res, err := script.Run(context.Background(), r.client, keys, argv...).Result()
 	if err != nil {
 		return errors.E(op, errors.Unknown, err)
 	}
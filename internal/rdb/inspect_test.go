@@ -5581,3 +5581,142 @@ func TestUnpauseError(t *testing.T) {
 		}
 	}
 }
+
+func TestFairnessKeyStats(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	msgs := []*base.TaskMessage{
+		newFairnessMessage("tenant2"),
+		newFairnessMessage("tenant1"),
+		newFairnessMessage("tenant2"),
+		newFairnessMessage(""),
+	}
+	for _, msg := range msgs {
+		if err := r.Enqueue(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := r.FairnessKeyStats(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("FairnessKeyStats returned error: %v", err)
+	}
+	want := []*FairnessKeyStat{{Key: "tenant1", Pending: 1}, {Key: "tenant2", Pending: 2}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("FairnessKeyStats returned %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+
+	// Tasks in the fairness lists are pending tasks of the queue.
+	stats, err := r.CurrentStats(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("CurrentStats returned error: %v", err)
+	}
+	if stats.Pending != len(msgs) {
+		t.Errorf("CurrentStats reported %d pending tasks, want %d", stats.Pending, len(msgs))
+	}
+	infos, err := r.ListPending(base.DefaultQueueName, Pagination{Size: 3, Page: 1})
+	if err != nil {
+		t.Fatalf("ListPending returned error: %v", err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("ListPending returned %d tasks on the second page, want 1", len(infos))
+	}
+
+	if n, err := r.DeleteAllPendingTasks(base.DefaultQueueName); err != nil || n != int64(len(msgs)) {
+		t.Fatalf("DeleteAllPendingTasks returned (%d, %v), want (%d, nil)", n, err, len(msgs))
+	}
+	for _, msg := range msgs {
+		if n := r.client.Exists(ctx, base.TaskKey(msg.Queue, msg.ID)).Val(); n != 0 {
+			t.Errorf("task %q exists after all pending tasks are deleted", msg.ID)
+		}
+	}
+	for _, key := range []string{
+		base.FairnessKeysKey(base.DefaultQueueName),
+		base.FairnessKey(base.DefaultQueueName, "tenant1"),
+		base.FairnessKey(base.DefaultQueueName, "tenant2"),
+	} {
+		if n := r.client.Exists(ctx, key).Val(); n != 0 {
+			t.Errorf("%q exists after all pending tasks are deleted", key)
+		}
+	}
+	got, err = r.FairnessKeyStats(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("FairnessKeyStats returned error: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("FairnessKeyStats returned %v after all pending tasks are deleted, want empty", got)
+	}
+
+	if _, err := r.FairnessKeyStats("nonexistent"); !errors.IsQueueNotFound(err) {
+		t.Errorf("FairnessKeyStats(%q) returned %v, want QueueNotFoundError", "nonexistent", err)
+	}
+}
+
+func TestDeleteAllPendingTasksWithPriority(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	for _, msg := range []*base.TaskMessage{newPriorityMessage(1), newPriorityMessage(3), newPriorityMessage(0)} {
+		if err := r.Enqueue(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := r.DeleteAllPendingTasks(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("DeleteAllPendingTasks returned error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("DeleteAllPendingTasks returned %d, want 3", n)
+	}
+	for _, key := range []string{
+		base.PrioritiesKey(base.DefaultQueueName),
+		base.PriorityKey(base.DefaultQueueName, 1),
+		base.PriorityKey(base.DefaultQueueName, 3),
+	} {
+		if n := r.client.Exists(ctx, key).Val(); n != 0 {
+			t.Errorf("%q exists after all pending tasks are deleted", key)
+		}
+	}
+}
+
+func TestGetAndReleaseUniqueLock(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	h.FlushDB(t, r.client)
+	ctx := context.Background()
+	now := time.Now()
+	r.SetClock(timeutil.NewSimulatedClock(now))
+
+	msg := h.NewTaskMessage("send_email", nil)
+	msg.UniqueKey = base.UniqueByKey(msg.Queue, "user:123")
+	if err := r.EnqueueUnique(ctx, msg, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.GetUniqueLock(msg.Queue, "user:123")
+	if err != nil {
+		t.Fatalf("GetUniqueLock returned error: %v", err)
+	}
+	want := &UniqueLock{TaskID: msg.ID, ExpiresAt: now.Add(time.Hour)}
+	if diff := cmp.Diff(want, got, cmpopts.EquateApproxTime(2*time.Second)); diff != "" {
+		t.Errorf("GetUniqueLock returned %+v, want %+v; (-want,+got)\n%s", got, want, diff)
+	}
+
+	if err := r.ReleaseUniqueLock(msg.Queue, "user:123"); err != nil {
+		t.Fatalf("ReleaseUniqueLock returned error: %v", err)
+	}
+	if r.client.Exists(ctx, msg.UniqueKey).Val() != 0 {
+		t.Errorf("Uniqueness lock %q still exists", msg.UniqueKey)
+	}
+	if _, err := r.GetUniqueLock(msg.Queue, "user:123"); !errors.IsUniqueLockNotFound(err) {
+		t.Errorf("GetUniqueLock after release returned %v, want UniqueLockNotFoundError", err)
+	}
+	if err := r.ReleaseUniqueLock(msg.Queue, "user:123"); !errors.IsUniqueLockNotFound(err) {
+		t.Errorf("ReleaseUniqueLock after release returned %v, want UniqueLockNotFoundError", err)
+	}
+	if _, err := r.GetUniqueLock("nonexistent", "user:123"); !errors.IsQueueNotFound(err) {
+		t.Errorf("GetUniqueLock with nonexistent queue returned %v, want QueueNotFoundError", err)
+	}
+}
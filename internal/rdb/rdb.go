@@ -10,6 +10,9 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -22,6 +25,13 @@ import (
 
 const statsTTL = 90 * 24 * time.Hour // 90 days
 
+// chainExpiration is the duration a chain is kept after all of its steps complete.
+const chainExpiration = 7 * 24 * time.Hour // 7 days
+
+// debounceKeyExpiration is the duration a debounce key is kept after the process time of
+// the task holding it, in case the key is not released once the task is processed.
+const debounceKeyExpiration = 24 * time.Hour
+
 // LeaseDuration is the duration used to initially create a lease and to extend it thereafter.
 const LeaseDuration = 30 * time.Second
 
@@ -68,6 +78,24 @@ func (r *RDB) runScript(ctx context.Context, op errors.Op, script *redis.Script,
 	return nil
 }
 
+// runReleaseScript runs the script finishing a task in the given queue, and records the events
+// of the tasks the script moved to the pending list: the tasks released by the finished task,
+// and the next step of its chain (see doneCmd).
+func (r *RDB) runReleaseScript(ctx context.Context, op errors.Op, qname string, script *redis.Script, keys []string, args ...interface{}) error {
+	res, err := script.Run(ctx, r.client, keys, args...).Result()
+	if err != nil {
+		return errors.E(op, errors.Internal, fmt.Sprintf("redis eval error: %v", err))
+	}
+	vals, err := cast.ToSliceE(res)
+	if err != nil || len(vals) != 2 {
+		return errors.E(op, errors.Internal, fmt.Sprintf("cast error: unexpected return value from Lua script: %v", res))
+	}
+	events := r.taskEvents(base.TaskEventReleased, qname, vals[0])
+	events = append(events, r.taskEvents(base.TaskEventEnqueued, qname, vals[1])...)
+	r.recordEvents(ctx, events)
+	return nil
+}
+
 // Runs the given script with keys and args and returns the script's return value as int64.
 func (r *RDB) runScriptWithErrorCode(ctx context.Context, op errors.Op, script *redis.Script, keys []string, args ...interface{}) (int64, error) {
 	// 执行lua脚本
@@ -86,16 +114,21 @@ func (r *RDB) runScriptWithErrorCode(ctx context.Context, op errors.Op, script *
 //
 // Input:
 // KEYS[1] -> asynq_learn:{<qname>}:t:<task_id>
-// KEYS[2] -> asynq_learn:{<qname>}:pending
+// KEYS[2] -> asynq_learn:{<qname>}:expiring
 // --
 // ARGV[1] -> task message data
 // ARGV[2] -> task ID
 // ARGV[3] -> current unix time in nsec
+// ARGV[4] -> wakeup pubsub channel
+// ARGV[5] -> queue key prefix
+// ARGV[6] -> fairness key of the task (empty if none)
+// ARGV[7] -> priority of the task
+// ARGV[8] -> expiration time of the task in Unix time (zero if none)
 //
 // Output:
 // Returns 1 if successfully enqueued
 // Returns 0 if task ID already exists
-var enqueueCmd = redis.NewScript(`
+var enqueueCmd = redis.NewScript(pendingLua + `
 if redis.call("EXISTS", KEYS[1]) == 1 then
 	return 0
 end
@@ -103,7 +136,17 @@ redis.call("HSET", KEYS[1],
            "msg", ARGV[1],
            "state", "pending",
            "pending_since", ARGV[3])
-redis.call("LPUSH", KEYS[2], ARGV[2])
+if ARGV[6] ~= "" then
+	redis.call("HSET", KEYS[1], "fairness_key", ARGV[6])
+end
+if tonumber(ARGV[7]) > 0 then
+	redis.call("HSET", KEYS[1], "priority", ARGV[7])
+end
+pending_push(ARGV[5], ARGV[2], "LPUSH")
+if tonumber(ARGV[8]) > 0 then
+	redis.call("ZADD", KEYS[2], ARGV[8], ARGV[2])
+end
+redis.call("PUBLISH", ARGV[4], 1)
 return 1
 `)
 
@@ -121,13 +164,18 @@ func (r *RDB) Enqueue(ctx context.Context, msg *base.TaskMessage) error {
 	}
 	keys := []string{
 		base.TaskKey(msg.Queue, msg.ID), // 哈希 msg => 值是编码后的消息
-		base.PendingKey(msg.Queue),      // 列表 "asynq_learn:{default}:pending  队列中的值是任务ID
+		base.ExpiringKey(msg.Queue),
 	}
 	log.Println(keys)
 	argv := []interface{}{
 		encoded,
 		msg.ID,
 		r.clock.Now().UnixNano(),
+		base.WakeupChannel(msg.Queue),
+		base.QueueKeyPrefix(msg.Queue),
+		msg.FairnessKey,
+		msg.Priority,
+		msg.ExpireAt,
 	}
 	n, err := r.runScriptWithErrorCode(ctx, op, enqueueCmd, keys, argv...)
 	if err != nil {
@@ -143,18 +191,23 @@ func (r *RDB) Enqueue(ctx context.Context, msg *base.TaskMessage) error {
 //
 // KEYS[1] -> unique key
 // KEYS[2] -> asynq_learn:{<qname>}:t:<taskid>
-// KEYS[3] -> asynq_learn:{<qname>}:pending
+// KEYS[3] -> asynq_learn:{<qname>}:expiring
 // --
 // ARGV[1] -> task ID
 // ARGV[2] -> uniqueness lock TTL
 // ARGV[3] -> task message data
 // ARGV[4] -> current unix time in nsec
+// ARGV[5] -> wakeup pubsub channel
+// ARGV[6] -> queue key prefix
+// ARGV[7] -> fairness key of the task (empty if none)
+// ARGV[8] -> priority of the task
+// ARGV[9] -> expiration time of the task in Unix time (zero if none)
 //
 // Output:
 // Returns 1 if successfully enqueued
 // Returns 0 if task ID conflicts with another task
 // Returns -1 if task unique key already exists
-var enqueueUniqueCmd = redis.NewScript(`
+var enqueueUniqueCmd = redis.NewScript(pendingLua + `
 local ok = redis.call("SET", KEYS[1], ARGV[1], "NX", "EX", ARGV[2])
 if not ok then
   return -1 
@@ -167,7 +220,17 @@ redis.call("HSET", KEYS[2],
            "state", "pending",
            "pending_since", ARGV[4],
            "unique_key", KEYS[1])
-redis.call("LPUSH", KEYS[3], ARGV[1])
+if ARGV[7] ~= "" then
+	redis.call("HSET", KEYS[2], "fairness_key", ARGV[7])
+end
+if tonumber(ARGV[8]) > 0 then
+	redis.call("HSET", KEYS[2], "priority", ARGV[8])
+end
+pending_push(ARGV[6], ARGV[1], "LPUSH")
+if tonumber(ARGV[9]) > 0 then
+	redis.call("ZADD", KEYS[3], ARGV[9], ARGV[1])
+end
+redis.call("PUBLISH", ARGV[5], 1)
 return 1
 `)
 
@@ -186,13 +249,18 @@ func (r *RDB) EnqueueUnique(ctx context.Context, msg *base.TaskMessage, ttl time
 	keys := []string{
 		msg.UniqueKey,
 		base.TaskKey(msg.Queue, msg.ID),
-		base.PendingKey(msg.Queue),
+		base.ExpiringKey(msg.Queue),
 	}
 	argv := []interface{}{
 		msg.ID,
 		int(ttl.Seconds()),
 		encoded,
 		r.clock.Now().UnixNano(),
+		base.WakeupChannel(msg.Queue),
+		base.QueueKeyPrefix(msg.Queue),
+		msg.FairnessKey,
+		msg.Priority,
+		msg.ExpireAt,
 	}
 	n, err := r.runScriptWithErrorCode(ctx, op, enqueueUniqueCmd, keys, argv...)
 	if err != nil {
@@ -207,89 +275,678 @@ func (r *RDB) EnqueueUnique(ctx context.Context, msg *base.TaskMessage, ttl time
 	return nil
 }
 
+// enqueueBatchCmd enqueues multiple task messages of the same queue.
+//
+// KEYS[1] -> asynq_learn:{<qname>}:scheduled
+// KEYS[2] -> asynq_learn:{<qname>}:expiring
+// --
+// ARGV[1] -> task key prefix
+// ARGV[2] -> current unix time in nsec
+// ARGV[3] -> wakeup pubsub channel
+// ARGV[4] -> schedule pubsub channel
+// ARGV[5] -> queue key prefix
+// ARGV[6+8*i] -> task ID of the i-th message
+// ARGV[7+8*i] -> task message data of the i-th message
+// ARGV[8+8*i] -> process_at time in Unix time in msec of the i-th message (zero to enqueue to the pending list)
+// ARGV[9+8*i] -> unique key of the i-th message (empty if none)
+// ARGV[10+8*i] -> uniqueness lock TTL in seconds of the i-th message
+// ARGV[11+8*i] -> fairness key of the i-th message (empty if none)
+// ARGV[12+8*i] -> priority of the i-th message
+// ARGV[13+8*i] -> expiration time in Unix time of the i-th message (zero if none)
+//
+// Output:
+// Table with a numeric code for each message:
+// 1 if successfully enqueued, 0 if the task ID already exists,
+// -1 if the task unique key already exists
+var enqueueBatchCmd = redis.NewScript(pendingLua + `
+local codes = {}
+local pending = 0
+local nextProcessAt = 0
+local n = (table.getn(ARGV) - 5) / 8
+for i = 0, n - 1 do
+	local id = ARGV[6 + 8 * i]
+	local key = ARGV[1] .. id
+	local processAt = tonumber(ARGV[8 + 8 * i])
+	local uniqueKey = ARGV[9 + 8 * i]
+	local fairnessKey = ARGV[11 + 8 * i]
+	local priority = tonumber(ARGV[12 + 8 * i])
+	local expireAt = tonumber(ARGV[13 + 8 * i])
+	if redis.call("EXISTS", key) == 1 then
+		codes[i + 1] = 0
+	elseif uniqueKey ~= "" and not redis.call("SET", uniqueKey, id, "NX", "EX", ARGV[10 + 8 * i]) then
+		codes[i + 1] = -1
+	else
+		if uniqueKey ~= "" then
+			redis.call("HSET", key, "unique_key", uniqueKey)
+		end
+		if fairnessKey ~= "" then
+			redis.call("HSET", key, "fairness_key", fairnessKey)
+		end
+		if priority > 0 then
+			redis.call("HSET", key, "priority", priority)
+		end
+		if processAt > 0 then
+			redis.call("HSET", key,
+			           "msg", ARGV[7 + 8 * i],
+			           "state", "scheduled")
+			redis.call("ZADD", KEYS[1], processAt, id)
+			if nextProcessAt == 0 or processAt < nextProcessAt then
+				nextProcessAt = processAt
+			end
+		else
+			redis.call("HSET", key,
+			           "msg", ARGV[7 + 8 * i],
+			           "state", "pending",
+			           "pending_since", ARGV[2])
+			pending_push(ARGV[5], id, "LPUSH")
+			pending = pending + 1
+		end
+		if expireAt > 0 then
+			redis.call("ZADD", KEYS[2], expireAt, id)
+		end
+		codes[i + 1] = 1
+	end
+end
+if pending > 0 then
+	redis.call("PUBLISH", ARGV[3], pending)
+end
+if nextProcessAt > 0 then
+	redis.call("PUBLISH", ARGV[4], nextProcessAt)
+end
+return codes
+`)
+
+// maxBatchScriptSize is the maximum number of messages enqueued by one enqueueBatchCmd call,
+// so that a large batch doesn't block redis for too long.
+const maxBatchScriptSize = 1000
+
+// EnqueueBatch enqueues the given messages using a single pipeline, and returns
+// an error for each message, nil if the message was enqueued.
+// A message is added to the scheduled set if its ProcessAt is set,
+// otherwise it is added to the pending list of its queue.
+//
+// The second return value is non-nil if the batch could not be processed at all.
+func (r *RDB) EnqueueBatch(ctx context.Context, msgs []*base.BatchMessage) ([]error, error) {
+	var op errors.Op = "rdb.EnqueueBatch"
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	// Messages are grouped by queue since keys of a script call must belong to the same queue.
+	type batch struct {
+		qname string
+		idx   []int // indices of the messages in msgs
+		argv  []interface{}
+	}
+	var (
+		batches []*batch
+		qnames  []interface{}
+		open    = make(map[string]*batch)
+		now     = r.clock.Now().UnixNano()
+	)
+	for i, m := range msgs {
+		encoded, err := base.EncodeMessage(m.Msg)
+		if err != nil {
+			return nil, errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+		}
+		b, ok := open[m.Msg.Queue]
+		if !ok || len(b.idx) == maxBatchScriptSize {
+			if !ok {
+				qnames = append(qnames, m.Msg.Queue)
+			}
+			b = &batch{
+				qname: m.Msg.Queue,
+				argv: []interface{}{
+					base.TaskKeyPrefix(m.Msg.Queue),
+					now,
+					base.WakeupChannel(m.Msg.Queue),
+					base.ScheduleChannel(m.Msg.Queue),
+					base.QueueKeyPrefix(m.Msg.Queue),
+				},
+			}
+			open[m.Msg.Queue] = b
+			batches = append(batches, b)
+		}
+		var processAt int64
+		if !m.ProcessAt.IsZero() {
+			processAt = base.ProcessAtScore(m.ProcessAt)
+		}
+		var uniqueKey string
+		if m.UniqueTTL > 0 {
+			uniqueKey = m.Msg.UniqueKey
+		}
+		b.idx = append(b.idx, i)
+		b.argv = append(b.argv, m.Msg.ID, encoded, processAt, uniqueKey, int(m.UniqueTTL.Seconds()), m.Msg.FairnessKey, m.Msg.Priority, m.Msg.ExpireAt)
+	}
+	exec := func() ([]*redis.Cmd, error) {
+		var cmds []*redis.Cmd
+		_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SAdd(ctx, base.AllQueues, qnames...)
+			for _, b := range batches {
+				keys := []string{
+					base.ScheduledKey(b.qname),
+					base.ExpiringKey(b.qname),
+				}
+				cmds = append(cmds, enqueueBatchCmd.EvalSha(ctx, pipe, keys, b.argv...))
+			}
+			return nil
+		})
+		return cmds, err
+	}
+	cmds, err := exec()
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT ") {
+		// The script is not cached in redis yet; load it and run the pipeline again.
+		// None of the script calls were run since all of them failed with NOSCRIPT.
+		if err := enqueueBatchCmd.Load(ctx, r.client).Err(); err != nil {
+			return nil, errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "script load", Err: err})
+		}
+		cmds, err = exec()
+	}
+	if err != nil {
+		return nil, errors.E(op, errors.Unknown, fmt.Sprintf("redis eval error: %v", err))
+	}
+	errs := make([]error, len(msgs))
+	for i, b := range batches {
+		codes, err := cast.ToSliceE(cmds[i].Val())
+		if err != nil || len(codes) != len(b.idx) {
+			return nil, errors.E(op, errors.Internal, fmt.Sprintf("unexpected return value from Lua script: %v", cmds[i].Val()))
+		}
+		for j, code := range codes {
+			switch cast.ToInt64(code) {
+			case 0:
+				errs[b.idx[j]] = errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+			case -1:
+				errs[b.idx[j]] = errors.E(op, errors.AlreadyExists, errors.ErrDuplicateTask)
+			}
+		}
+	}
+	return errs, nil
+}
+
+// pendingLua defines the Lua functions which scripts call to add a task to, and remove a task
+// from, the pending tasks of a queue.
+//
+// Each pending task is stored in exactly one list, so that a task is popped from the head of a list
+// without searching the other lists:
+//   - a task with a priority is stored in the priority list of its priority, and the priority is
+//     added to the priorities zset while the list is not empty;
+//   - otherwise, a task with a fairness key is stored in the fairness list of its key, and the key
+//     is added to the fairness_keys list while the list is not empty;
+//   - otherwise, the task is stored in the pending list.
+//
+// The fairness keys are served in the order of the fairness_keys list, in which the empty key stands
+// for the pending list. The empty key is added along with the first key, and the fairness_keys list
+// is deleted once only the empty key is left.
+//
+// Functions:
+// pending_list(qprefix, id)        -> returns the list the task is stored in, its fairness key and its priority (nil if none)
+// pending_push(qprefix, id, cmd)   -> pushes the task to its list; cmd is "LPUSH", or "RPUSH" to push it to the head
+// pending_remove(qprefix, id)      -> removes the task from its list and returns the number of entries removed
+// fair_drop(qprefix, fkey)         -> removes the fairness key from the fairness_keys list once its list is empty
+//
+// Arguments:
+// qprefix -> asynq_learn:{<qname>}:
+// id      -> task ID
+//
+// Note: The fairness key and the priority of the task are read from the task hash,
+// so the hash must be written before the task is pushed.
+const pendingLua = `
+local function pending_list(qprefix, id)
+	local fkey, priority = unpack(redis.call("HMGET", qprefix .. "t:" .. id, "fairness_key", "priority"))
+	priority = tonumber(priority)
+	if priority and priority > 0 then
+		return qprefix .. "priority:" .. priority, nil, priority
+	end
+	if fkey and fkey ~= "" then
+		return qprefix .. "fairness:" .. fkey, fkey, nil
+	end
+	return qprefix .. "pending", nil, nil
+end
+
+local function fair_drop(qprefix, fkey)
+	local keys = qprefix .. "fairness_keys"
+	redis.call("LREM", keys, 1, fkey)
+	if redis.call("LLEN", keys) == 1 then
+		redis.call("DEL", keys)
+	end
+end
+
+local function pending_push(qprefix, id, cmd)
+	local list, fkey, priority = pending_list(qprefix, id)
+	if redis.call(cmd, list, id) > 1 then
+		return
+	end
+	if priority then
+		redis.call("ZADD", qprefix .. "priorities", priority, priority)
+	elseif fkey then
+		local keys = qprefix .. "fairness_keys"
+		if redis.call("EXISTS", keys) == 0 then
+			redis.call("LPUSH", keys, "")
+		end
+		redis.call("LPUSH", keys, fkey)
+	end
+end
+
+local function pending_remove(qprefix, id)
+	local list, fkey, priority = pending_list(qprefix, id)
+	local n = redis.call("LREM", list, 0, id)
+	if n > 0 and redis.call("EXISTS", list) == 0 then
+		if priority then
+			redis.call("ZREM", qprefix .. "priorities", priority)
+		elseif fkey then
+			fair_drop(qprefix, fkey)
+		end
+	end
+	return n
+end
+`
+
+// taskTypeLua defines the Lua function task_type, which returns the type of a task given its
+// encoded message, or nil if the message is malformed.
+// TaskMessage.type is the first field of the message, so the message starts with the field tag
+// (0x0A) followed by the varint length of the type and the type itself.
+const taskTypeLua = `
+local function task_type(msg)
+	if string.byte(msg, 1) ~= 10 then
+		return nil
+	end
+	local len, mul, pos = 0, 1, 2
+	while true do
+		local b = string.byte(msg, pos)
+		if not b then
+			return nil
+		end
+		pos = pos + 1
+		if b < 128 then
+			len = len + b * mul
+			break
+		end
+		len = len + (b - 128) * mul
+		mul = mul * 128
+	end
+	return string.sub(msg, pos, pos + len - 1)
+end
+`
+
+// taskEventsLua defines the Lua function add_task_event, which scripts call to collect the ID
+// and the type of each task whose state they change, so that the events of the tasks can be
+// recorded once the script returns (see RDB.taskEvents).
+//
+// Arguments:
+// events -> table collecting the task ID and type pairs
+// key    -> asynq_learn:{<qname>}:t:<task_id>
+// id     -> task ID
+const taskEventsLua = taskTypeLua + `
+local function add_task_event(events, key, id)
+	table.insert(events, id)
+	table.insert(events, task_type(redis.call("HGET", key, "msg") or "") or "")
+end
+`
+
 // Input:
 // KEYS[1] -> asynq_learn:{<qname>}:pending
 // KEYS[2] -> asynq_learn:{<qname>}:paused
 // KEYS[3] -> asynq_learn:{<qname>}:active
 // KEYS[4] -> asynq_learn:{<qname>}:lease
+// KEYS[5] -> asynq_learn:{<qname>}:fairness_keys
+// KEYS[6] -> asynq_learn:{<qname>}:priorities
 // --
 // ARGV[1] -> initial lease expiration Unix time
 // ARGV[2] -> task key prefix
+// ARGV[3] -> queue key prefix
+// ARGV[4] -> max number of list entries to inspect
+// ARGV[5] -> priority key prefix
+// ARGV[6:] -> excluded task types
 //
 // Output:
 // Returns nil if no processable task is found in the given queue.
-// Returns an encoded TaskMessage.
+// Returns a table containing an encoded TaskMessage and the time the task
+// became pending in Unix time in nsec (zero if unknown).
 //
 // Note: dequeueCmd checks whether a queue is paused first, before
-// calling RPOPLPUSH to pop a task from the queue.
-var dequeueCmd = redis.NewScript(`
-if redis.call("EXISTS", KEYS[2]) == 0 then
-	local id = redis.call("RPOPLPUSH", KEYS[1], KEYS[3])
-	if id then
-		local key = ARGV[2] .. id
-		redis.call("HSET", key, "state", "active")
-		redis.call("HDEL", key, "pending_since")
-		redis.call("ZADD", KEYS[4], ARGV[1], id)
-		return redis.call("HGET", key, "msg")
+// popping a task from the queue.
+//
+// Note: If the queue has tasks with priority, the oldest task of the highest priority is popped.
+//
+// Note: Otherwise, the fairness keys are served in round-robin order, and the oldest task of
+// the key is popped. The empty key stands for the pending list, which holds the tasks without
+// fairness key. If the queue has no tasks with fairness keys, the oldest task of the pending
+// list is popped. See pendingLua for how the pending tasks are stored.
+//
+// Note: If task types are excluded, the oldest task of another type is popped instead, in the same
+// order. At most ARGV[4] entries are inspected in total to find the task, and the type is read
+// from the encoded message (see taskTypeLua).
+var dequeueCmd = redis.NewScript(pendingLua + taskTypeLua + `
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	return nil
+end
+local excluded = {}
+local nexcluded = 0
+for i = 6, #ARGV do
+	excluded[ARGV[i]] = true
+	nexcluded = nexcluded + 1
+end
+local budget = tonumber(ARGV[4])
+-- take pops the oldest task of the list whose type is not excluded.
+local function take(list)
+	if nexcluded == 0 then
+		return redis.call("RPOP", list)
+	end
+	if budget <= 0 then
+		return nil
+	end
+	local ids = redis.call("LRANGE", list, -budget, -1)
+	for i = #ids, 1, -1 do
+		budget = budget - 1
+		local msg = redis.call("HGET", ARGV[2] .. ids[i], "msg")
+		if msg and not excluded[task_type(msg) or ""] then
+			redis.call("LREM", list, -1, ids[i])
+			return ids[i]
+		end
+	end
+	return nil
+end
+local id
+for _, priority in ipairs(redis.call("ZREVRANGE", KEYS[6], 0, -1)) do
+	local list = ARGV[5] .. priority
+	id = take(list)
+	if redis.call("EXISTS", list) == 0 then
+		redis.call("ZREM", KEYS[6], priority)
+	end
+	if id or (nexcluded > 0 and budget <= 0) then
+		break
+	end
+end
+local n = 0
+if not id then
+	n = redis.call("LLEN", KEYS[5])
+end
+for i = 1, n do
+	local fkey = redis.call("RPOPLPUSH", KEYS[5], KEYS[5])
+	local list = KEYS[1]
+	if fkey ~= "" then
+		list = ARGV[3] .. "fairness:" .. fkey
+	end
+	id = take(list)
+	if fkey ~= "" and redis.call("EXISTS", list) == 0 then
+		fair_drop(ARGV[3], fkey)
+	end
+	if id or (nexcluded > 0 and budget <= 0) then
+		break
 	end
 end
-return nil`)
+if not id and n == 0 then
+	id = take(KEYS[1])
+end
+if not id then
+	return nil
+end
+redis.call("LPUSH", KEYS[3], id)
+local key = ARGV[2] .. id
+local pendingSince = redis.call("HGET", key, "pending_since") or 0
+redis.call("HSET", key, "state", "active")
+redis.call("HDEL", key, "pending_since")
+redis.call("ZADD", KEYS[4], ARGV[1], id)
+return {redis.call("HGET", key, "msg"), pendingSince}`)
 
 // Dequeue queries given queues in order and pops a task message
-// off a queue if one exists and returns the message and its lease expiration time.
+// off a queue if one exists and returns the message, its lease expiration time
+// and the time the task became pending (zero if unknown).
 // Dequeue skips a queue if the queue is paused.
+// Tasks with higher priority are popped first. Among the tasks with the default priority,
+// if a queue has tasks with fairness keys, the fairness keys take turns in round-robin order.
 // If all queues are empty, ErrNoProcessableTask error is returned.
 // 按顺序取消给定队列的查询，并从队列中弹出任务消息（如果存在），并返回消息及其租约到期时间。
 // 如果队列已暂停，则取消排队将跳过队列。如果所有队列都为空，则返回 ErrNoProcessableTask 错误。
-func (r *RDB) Dequeue(qnames ...string) (msg *base.TaskMessage, leaseExpirationTime time.Time, err error) {
-	var op errors.Op = "rdb.Dequeue"
+func (r *RDB) Dequeue(qnames ...string) (msg *base.TaskMessage, leaseExpirationTime, pendingSince time.Time, err error) {
+	return r.dequeue("rdb.Dequeue", nil, qnames)
+}
+
+// maxDequeueScanSize is the maximum number of pending tasks DequeueExcept inspects
+// in each queue to find a task whose type is not excluded.
+const maxDequeueScanSize = 100
+
+// DequeueExcept is like Dequeue, but it leaves the tasks of the excluded types in the queues
+// and pops the first task of another type instead, in the same order as Dequeue.
+// At most maxDequeueScanSize pending tasks of each queue are inspected.
+func (r *RDB) DequeueExcept(excludedTypes []string, qnames ...string) (msg *base.TaskMessage, leaseExpirationTime, pendingSince time.Time, err error) {
+	return r.dequeue("rdb.DequeueExcept", excludedTypes, qnames)
+}
+
+func (r *RDB) dequeue(op errors.Op, excludedTypes []string, qnames []string) (msg *base.TaskMessage, leaseExpirationTime, pendingSince time.Time, err error) {
 	for _, qname := range qnames {
 		keys := []string{
 			base.PendingKey(qname),
 			base.PausedKey(qname),
 			base.ActiveKey(qname),
 			base.LeaseKey(qname),
+			base.FairnessKeysKey(qname),
+			base.PrioritiesKey(qname),
 		}
 		leaseExpirationTime = r.clock.Now().Add(LeaseDuration)
 		argv := []interface{}{
 			leaseExpirationTime.Unix(),
 			base.TaskKeyPrefix(qname),
+			base.QueueKeyPrefix(qname),
+			maxDequeueScanSize,
+			base.PriorityKeyPrefix(qname),
+		}
+		for _, typename := range excludedTypes {
+			argv = append(argv, typename)
 		}
 		res, err := dequeueCmd.Run(context.Background(), r.client, keys, argv...).Result()
 		// 队列不存在
 		if err == redis.Nil {
 			continue
 		} else if err != nil {
-			return nil, time.Time{}, errors.E(op, errors.Unknown, fmt.Sprintf("redis eval error: %v", err))
+			return nil, time.Time{}, time.Time{}, errors.E(op, errors.Unknown, fmt.Sprintf("redis eval error: %v", err))
+		}
+		vals, err := cast.ToSliceE(res)
+		if err != nil || len(vals) != 2 {
+			return nil, time.Time{}, time.Time{}, errors.E(op, errors.Internal, fmt.Sprintf("cast error: unexpected return value from Lua script: %v", res))
 		}
-		encoded, err := cast.ToStringE(res)
+		encoded, err := cast.ToStringE(vals[0])
 		if err != nil {
-			return nil, time.Time{}, errors.E(op, errors.Internal, fmt.Sprintf("cast error: unexpected return value from Lua script: %v", res))
+			return nil, time.Time{}, time.Time{}, errors.E(op, errors.Internal, fmt.Sprintf("cast error: unexpected return value from Lua script: %v", res))
 		}
 		if msg, err = base.DecodeMessage([]byte(encoded)); err != nil {
-			return nil, time.Time{}, errors.E(op, errors.Internal, fmt.Sprintf("cannot decode message: %v", err))
+			return nil, time.Time{}, time.Time{}, errors.E(op, errors.Internal, fmt.Sprintf("cannot decode message: %v", err))
+		}
+		if nsec := cast.ToInt64(vals[1]); nsec > 0 {
+			pendingSince = time.Unix(0, nsec)
 		}
-		return msg, leaseExpirationTime, nil
+		return msg, leaseExpirationTime, pendingSince, nil
 	}
-	return nil, time.Time{}, errors.E(op, errors.NotFound, errors.ErrNoProcessableTask)
+	return nil, time.Time{}, time.Time{}, errors.E(op, errors.NotFound, errors.ErrNoProcessableTask)
 }
 
+// orderingReleaseLua defines the Lua function ordering_release, which scripts finishing a task
+// call to move the next task with the same ordering key from the waiting set to the pending list.
+//
+// Arguments:
+// keys    -> asynq_learn:{<qname>}:ordering_keys
+// prefix  -> asynq_learn:{<qname>}:ordering:
+// okey    -> ordering key of the task (false if none)
+// id      -> ID of the finished task
+// tprefix -> task key prefix
+// waiting -> asynq_learn:{<qname>}:waiting
+// qprefix -> asynq_learn:{<qname>}:
+// now     -> current unix time in nsec
+// events  -> table collecting the tasks moved to the pending list (see taskEventsLua)
+//
+// Returns the number of tasks moved to the pending list.
+//
+// Note: Tasks which no longer exist, or are archived or completed, are removed from the head of
+// the list, so that a task deleted or archived while the key is blocked doesn't block it forever.
+const orderingReleaseLua = pendingLua + taskEventsLua + `
+local function ordering_release(keys, prefix, okey, id, tprefix, waiting, qprefix, now, events)
+	if not okey or okey == "" then
+		return 0
+	end
+	local list = prefix .. okey
+	redis.call("LREM", list, 1, id)
+	while true do
+		local head = redis.call("LINDEX", list, 0)
+		if not head then
+			redis.call("SREM", keys, okey)
+			return 0
+		end
+		local state, deps = unpack(redis.call("HMGET", tprefix .. head, "state", "pending_deps"))
+		if state == "waiting" then
+			if not deps then
+				redis.call("ZREM", waiting, head)
+				redis.call("HSET", tprefix .. head, "state", "pending", "pending_since", now)
+				pending_push(qprefix, head, "LPUSH")
+				add_task_event(events, tprefix .. head, head)
+				return 1
+			end
+			return 0
+		elseif state and state ~= "archived" and state ~= "completed" then
+			return 0
+		end
+		redis.call("LPOP", list)
+	end
+end
+`
+
+// releaseLua defines the Lua functions which scripts completing a task call to move the tasks
+// waiting for it to the pending list, in addition to ordering_release (see orderingReleaseLua).
+// Each function returns the number of tasks moved to the pending list,
+// and adds them to the given events table (see taskEventsLua).
+//
+// release_dependents(key, id, tprefix, waiting, qprefix, now, events) marks the task as completed for
+// the tasks depending on it, and moves the tasks with no remaining dependencies to the pending list.
+//
+// chain_advance(key, tprefix, qprefix, now, ttl, events) records the result of the task in its chain,
+// if any, and moves the next step of the chain to the pending list.
+// The chain expires ttl seconds after its last step completes.
+//
+// Arguments:
+// key     -> asynq_learn:{<qname>}:t:<task_id>
+// id      -> ID of the completed task
+// tprefix -> task key prefix
+// waiting -> asynq_learn:{<qname>}:waiting
+// qprefix -> asynq_learn:{<qname>}:
+// now     -> current unix time in nsec
+// ttl     -> chain expiration in seconds
+// events  -> table collecting the tasks moved to the pending list
+const releaseLua = orderingReleaseLua + `
+local function release_dependents(key, id, tprefix, waiting, qprefix, now, events)
+	local n = 0
+	for _, field in ipairs(redis.call("HKEYS", key)) do
+		if string.sub(field, 1, 10) == "dependent:" then
+			local did = string.sub(field, 11)
+			local dkey = tprefix .. did
+			if redis.call("HGET", dkey, "state") == "waiting" and
+			   redis.call("HDEL", dkey, "dep:" .. id) == 1 and
+			   redis.call("HINCRBY", dkey, "pending_deps", -1) <= 0 then
+				redis.call("ZREM", waiting, did)
+				redis.call("HDEL", dkey, "pending_deps")
+				redis.call("HSET", dkey, "state", "pending", "pending_since", now)
+				pending_push(qprefix, did, "LPUSH")
+				add_task_event(events, dkey, did)
+				n = n + 1
+			end
+			redis.call("HDEL", key, field)
+		end
+	end
+	return n
+end
+
+local function chain_advance(key, tprefix, qprefix, now, ttl, events)
+	local chain = redis.call("HGET", key, "chain")
+	if not chain or redis.call("EXISTS", chain) == 0 then
+		return 0
+	end
+	local step = tonumber(redis.call("HGET", key, "chain_step"))
+	local result = redis.call("HGET", key, "result")
+	if result then
+		redis.call("HSET", chain, "result:" .. step, result)
+	end
+	local next = step + 1
+	local id = redis.call("HGET", chain, "id:" .. next)
+	if not id then
+		redis.call("HSET", chain, "current", next)
+		redis.call("EXPIRE", chain, ttl)
+		return 0
+	end
+	if redis.call("EXISTS", tprefix .. id) == 1 then
+		redis.call("HSET", chain, "err", "task ID conflicts with another task")
+		return 0
+	end
+	redis.call("HSET", tprefix .. id,
+	           "msg", redis.call("HGET", chain, "msg:" .. next),
+	           "state", "pending",
+	           "pending_since", now,
+	           "chain", chain,
+	           "chain_step", next)
+	pending_push(qprefix, id, "LPUSH")
+	redis.call("HSET", chain, "current", next)
+	add_task_event(events, tprefix .. id, id)
+	return 1
+end
+`
+
+// blockDependentsLua defines the Lua function block_dependents, which scripts archiving or
+// deleting a task call to add the tasks waiting for it to the blocked set.
+// The janitor archives the tasks in the set, see ArchiveBlockedTasks.
+// It must be called before the task key is deleted.
+//
+// Arguments:
+// key     -> asynq_learn:{<qname>}:t:<task_id>
+// blocked -> asynq_learn:{<qname>}:blocked
+const blockDependentsLua = `
+local function block_dependents(key, blocked)
+	for _, field in ipairs(redis.call("HKEYS", key)) do
+		if string.sub(field, 1, 10) == "dependent:" then
+			redis.call("SADD", blocked, string.sub(field, 11))
+		end
+	end
+end
+`
+
 // KEYS[1] -> asynq_learn:{<qname>}:active
 // KEYS[2] -> asynq_learn:{<qname>}:lease
 // KEYS[3] -> asynq_learn:{<qname>}:t:<task_id>
 // KEYS[4] -> asynq_learn:{<qname>}:processed:<yyyy-mm-dd>
 // KEYS[5] -> asynq_learn:{<qname>}:processed
+// KEYS[6] -> asynq_learn:{<qname>}:waiting
+// KEYS[7] -> asynq_learn:{<qname>}:ordering_keys
 // -------
 // ARGV[1] -> task ID
 // ARGV[2] -> stats expiration timestamp
 // ARGV[3] -> max int64 value
-var doneCmd = redis.NewScript(`
+// ARGV[4] -> task key prefix
+// ARGV[5] -> current unix time in nsec
+// ARGV[6] -> chain expiration in seconds
+// ARGV[7] -> ordering key prefix
+// ARGV[8] -> wakeup pubsub channel
+// ARGV[9] -> queue key prefix
+//
+// Note: Tasks waiting for the given task are moved to the pending list
+// if it was the last dependency they were waiting for.
+// If the task has an ordering key, the next task with the key is moved to the pending list.
+// If the task is part of a chain, its result is recorded in the chain and
+// the next step of the chain is moved to the pending list.
+// The wakeup channel is notified if any task is moved to the pending list.
+//
+// Output:
+// Returns a table containing the tasks moved to the pending list waiting for the task,
+// and a table containing the next step of the chain moved to the pending list, if any
+// (see taskEventsLua).
+var doneCmd = redis.NewScript(releaseLua + `
 if redis.call("LREM", KEYS[1], 0, ARGV[1]) == 0 then
   return redis.error_reply("NOT FOUND")
 end
 if redis.call("ZREM", KEYS[2], ARGV[1]) == 0 then
   return redis.error_reply("NOT FOUND")
 end
+local released, enqueued = {}, {}
+local moved = release_dependents(KEYS[3], ARGV[1], ARGV[4], KEYS[6], ARGV[9], ARGV[5], released)
+moved = moved + ordering_release(KEYS[7], ARGV[7], redis.call("HGET", KEYS[3], "ordering_key"), ARGV[1], ARGV[4], KEYS[6], ARGV[9], ARGV[5], released)
+moved = moved + chain_advance(KEYS[3], ARGV[4], ARGV[9], ARGV[5], ARGV[6], enqueued)
+if moved > 0 then
+	redis.call("PUBLISH", ARGV[8], moved)
+end
 if redis.call("DEL", KEYS[3]) == 0 then
   return redis.error_reply("NOT FOUND")
 end
@@ -303,7 +960,7 @@ if tonumber(total) == tonumber(ARGV[3]) then
 else
 	redis.call("INCR", KEYS[5])
 end
-return redis.status_reply("OK")
+return {released, enqueued}
 `)
 
 // KEYS[1] -> asynq_learn:{<qname>}:active
@@ -311,18 +968,38 @@ return redis.status_reply("OK")
 // KEYS[3] -> asynq_learn:{<qname>}:t:<task_id>
 // KEYS[4] -> asynq_learn:{<qname>}:processed:<yyyy-mm-dd>
 // KEYS[5] -> asynq_learn:{<qname>}:processed
-// KEYS[6] -> unique key
+// KEYS[6] -> asynq_learn:{<qname>}:waiting
+// KEYS[7] -> asynq_learn:{<qname>}:ordering_keys
+// KEYS[8] -> unique key
 // -------
 // ARGV[1] -> task ID
 // ARGV[2] -> stats expiration timestamp
 // ARGV[3] -> max int64 value
-var doneUniqueCmd = redis.NewScript(`
+// ARGV[4] -> task key prefix
+// ARGV[5] -> current unix time in nsec
+// ARGV[6] -> chain expiration in seconds
+// ARGV[7] -> ordering key prefix
+// ARGV[8] -> wakeup pubsub channel
+// ARGV[9] -> queue key prefix
+//
+// Output:
+// Returns a table containing the tasks moved to the pending list waiting for the task,
+// and a table containing the next step of the chain moved to the pending list, if any
+// (see taskEventsLua).
+var doneUniqueCmd = redis.NewScript(releaseLua + `
 if redis.call("LREM", KEYS[1], 0, ARGV[1]) == 0 then
   return redis.error_reply("NOT FOUND")
 end
 if redis.call("ZREM", KEYS[2], ARGV[1]) == 0 then
   return redis.error_reply("NOT FOUND")
 end
+local released, enqueued = {}, {}
+local moved = release_dependents(KEYS[3], ARGV[1], ARGV[4], KEYS[6], ARGV[9], ARGV[5], released)
+moved = moved + ordering_release(KEYS[7], ARGV[7], redis.call("HGET", KEYS[3], "ordering_key"), ARGV[1], ARGV[4], KEYS[6], ARGV[9], ARGV[5], released)
+moved = moved + chain_advance(KEYS[3], ARGV[4], ARGV[9], ARGV[5], ARGV[6], enqueued)
+if moved > 0 then
+	redis.call("PUBLISH", ARGV[8], moved)
+end
 if redis.call("DEL", KEYS[3]) == 0 then
   return redis.error_reply("NOT FOUND")
 end
@@ -336,14 +1013,17 @@ if tonumber(total) == tonumber(ARGV[3]) then
 else
 	redis.call("INCR", KEYS[5])
 end
-if redis.call("GET", KEYS[6]) == ARGV[1] then
-  redis.call("DEL", KEYS[6])
+if redis.call("GET", KEYS[8]) == ARGV[1] then
+  redis.call("DEL", KEYS[8])
 end
-return redis.status_reply("OK")
+return {released, enqueued}
 `)
 
 // Done removes the task from active queue and deletes the task.
 // It removes a uniqueness lock acquired by the task, if any.
+// Tasks waiting only for this task are moved to the pending list.
+// If the task is part of a chain, the next step of the chain is moved to the pending list.
+// If the task has an ordering key, the next task with the key is moved to the pending list.
 func (r *RDB) Done(ctx context.Context, msg *base.TaskMessage) error {
 	var op errors.Op = "rdb.Done"
 	now := r.clock.Now()
@@ -354,18 +1034,26 @@ func (r *RDB) Done(ctx context.Context, msg *base.TaskMessage) error {
 		base.TaskKey(msg.Queue, msg.ID),
 		base.ProcessedKey(msg.Queue, now),
 		base.ProcessedTotalKey(msg.Queue),
+		base.WaitingKey(msg.Queue),
+		base.AllOrderingKeys(msg.Queue),
 	}
 	argv := []interface{}{
 		msg.ID,
 		expireAt.Unix(),
 		int64(math.MaxInt64),
+		base.TaskKeyPrefix(msg.Queue),
+		now.UnixNano(),
+		int64(chainExpiration.Seconds()),
+		base.OrderingKeyPrefix(msg.Queue),
+		base.WakeupChannel(msg.Queue),
+		base.QueueKeyPrefix(msg.Queue),
 	}
 	// Note: We cannot pass empty unique key when running this script in redis-cluster.
 	if len(msg.UniqueKey) > 0 {
 		keys = append(keys, msg.UniqueKey)
-		return r.runScript(ctx, op, doneUniqueCmd, keys, argv...)
+		return r.runReleaseScript(ctx, op, msg.Queue, doneUniqueCmd, keys, argv...)
 	}
-	return r.runScript(ctx, op, doneCmd, keys, argv...)
+	return r.runReleaseScript(ctx, op, msg.Queue, doneCmd, keys, argv...)
 }
 
 // KEYS[1] -> asynq_learn:{<qname>}:active
@@ -374,13 +1062,27 @@ func (r *RDB) Done(ctx context.Context, msg *base.TaskMessage) error {
 // KEYS[4] -> asynq_learn:{<qname>}:t:<task_id>
 // KEYS[5] -> asynq_learn:{<qname>}:processed:<yyyy-mm-dd>
 // KEYS[6] -> asynq_learn:{<qname>}:processed
+// KEYS[7] -> asynq_learn:{<qname>}:waiting
+// KEYS[8] -> asynq_learn:{<qname>}:ordering_keys
 //
 // ARGV[1] -> task ID
 // ARGV[2] -> stats expiration timestamp
 // ARGV[3] -> task expiration time in unix time
 // ARGV[4] -> task message data
 // ARGV[5] -> max int64 value
-var markAsCompleteCmd = redis.NewScript(`
+// ARGV[6] -> task key prefix
+// ARGV[7] -> current unix time in nsec
+// ARGV[8] -> chain expiration in seconds
+// ARGV[9] -> ordering key prefix
+// ARGV[10] -> completion pubsub channel
+// ARGV[11] -> wakeup pubsub channel
+// ARGV[12] -> queue key prefix
+//
+// Output:
+// Returns a table containing the tasks moved to the pending list waiting for the task,
+// and a table containing the next step of the chain moved to the pending list, if any
+// (see taskEventsLua).
+var markAsCompleteCmd = redis.NewScript(releaseLua + `
 if redis.call("LREM", KEYS[1], 0, ARGV[1]) == 0 then
   return redis.error_reply("NOT FOUND")
 end
@@ -391,6 +1093,13 @@ if redis.call("ZADD", KEYS[3], ARGV[3], ARGV[1]) ~= 1 then
   return redis.error_reply("INTERNAL")
 end
 redis.call("HSET", KEYS[4], "msg", ARGV[4], "state", "completed")
+local released, enqueued = {}, {}
+local moved = release_dependents(KEYS[4], ARGV[1], ARGV[6], KEYS[7], ARGV[12], ARGV[7], released)
+moved = moved + ordering_release(KEYS[8], ARGV[9], redis.call("HGET", KEYS[4], "ordering_key"), ARGV[1], ARGV[6], KEYS[7], ARGV[12], ARGV[7], released)
+moved = moved + chain_advance(KEYS[4], ARGV[6], ARGV[12], ARGV[7], ARGV[8], enqueued)
+if moved > 0 then
+	redis.call("PUBLISH", ARGV[11], moved)
+end
 local n = redis.call("INCR", KEYS[5])
 if tonumber(n) == 1 then
 	redis.call("EXPIREAT", KEYS[5], ARGV[2])
@@ -401,7 +1110,8 @@ if tonumber(total) == tonumber(ARGV[5]) then
 else
 	redis.call("INCR", KEYS[6])
 end
-return redis.status_reply("OK")
+redis.call("PUBLISH", ARGV[10], ARGV[1])
+return {released, enqueued}
 `)
 
 // KEYS[1] -> asynq_learn:{<qname>}:active
@@ -410,14 +1120,28 @@ return redis.status_reply("OK")
 // KEYS[4] -> asynq_learn:{<qname>}:t:<task_id>
 // KEYS[5] -> asynq_learn:{<qname>}:processed:<yyyy-mm-dd>
 // KEYS[6] -> asynq_learn:{<qname>}:processed
-// KEYS[7] -> asynq_learn:{<qname>}:unique:{<checksum>}
+// KEYS[7] -> asynq_learn:{<qname>}:waiting
+// KEYS[8] -> asynq_learn:{<qname>}:ordering_keys
+// KEYS[9] -> asynq_learn:{<qname>}:unique:{<checksum>}
 //
 // ARGV[1] -> task ID
 // ARGV[2] -> stats expiration timestamp
 // ARGV[3] -> task expiration time in unix time
 // ARGV[4] -> task message data
 // ARGV[5] -> max int64 value
-var markAsCompleteUniqueCmd = redis.NewScript(`
+// ARGV[6] -> task key prefix
+// ARGV[7] -> current unix time in nsec
+// ARGV[8] -> chain expiration in seconds
+// ARGV[9] -> ordering key prefix
+// ARGV[10] -> completion pubsub channel
+// ARGV[11] -> wakeup pubsub channel
+// ARGV[12] -> queue key prefix
+//
+// Output:
+// Returns a table containing the tasks moved to the pending list waiting for the task,
+// and a table containing the next step of the chain moved to the pending list, if any
+// (see taskEventsLua).
+var markAsCompleteUniqueCmd = redis.NewScript(releaseLua + `
 if redis.call("LREM", KEYS[1], 0, ARGV[1]) == 0 then
   return redis.error_reply("NOT FOUND")
 end
@@ -428,6 +1152,13 @@ if redis.call("ZADD", KEYS[3], ARGV[3], ARGV[1]) ~= 1 then
   return redis.error_reply("INTERNAL")
 end
 redis.call("HSET", KEYS[4], "msg", ARGV[4], "state", "completed")
+local released, enqueued = {}, {}
+local moved = release_dependents(KEYS[4], ARGV[1], ARGV[6], KEYS[7], ARGV[12], ARGV[7], released)
+moved = moved + ordering_release(KEYS[8], ARGV[9], redis.call("HGET", KEYS[4], "ordering_key"), ARGV[1], ARGV[6], KEYS[7], ARGV[12], ARGV[7], released)
+moved = moved + chain_advance(KEYS[4], ARGV[6], ARGV[12], ARGV[7], ARGV[8], enqueued)
+if moved > 0 then
+	redis.call("PUBLISH", ARGV[11], moved)
+end
 local n = redis.call("INCR", KEYS[5])
 if tonumber(n) == 1 then
 	redis.call("EXPIREAT", KEYS[5], ARGV[2])
@@ -438,14 +1169,18 @@ if tonumber(total) == tonumber(ARGV[5]) then
 else
 	redis.call("INCR", KEYS[6])
 end
-if redis.call("GET", KEYS[7]) == ARGV[1] then
-  redis.call("DEL", KEYS[7])
+if redis.call("GET", KEYS[9]) == ARGV[1] then
+  redis.call("DEL", KEYS[9])
 end
-return redis.status_reply("OK")
+redis.call("PUBLISH", ARGV[10], ARGV[1])
+return {released, enqueued}
 `)
 
 // MarkAsComplete removes the task from active queue to mark the task as completed.
 // It removes a uniqueness lock acquired by the task, if any.
+// Tasks waiting only for this task are moved to the pending list.
+// If the task is part of a chain, the next step of the chain is moved to the pending list.
+// If the task has an ordering key, the next task with the key is moved to the pending list.
 func (r *RDB) MarkAsComplete(ctx context.Context, msg *base.TaskMessage) error {
 	var op errors.Op = "rdb.MarkAsComplete"
 	now := r.clock.Now()
@@ -462,6 +1197,8 @@ func (r *RDB) MarkAsComplete(ctx context.Context, msg *base.TaskMessage) error {
 		base.TaskKey(msg.Queue, msg.ID),
 		base.ProcessedKey(msg.Queue, now),
 		base.ProcessedTotalKey(msg.Queue),
+		base.WaitingKey(msg.Queue),
+		base.AllOrderingKeys(msg.Queue),
 	}
 	argv := []interface{}{
 		msg.ID,
@@ -469,30 +1206,37 @@ func (r *RDB) MarkAsComplete(ctx context.Context, msg *base.TaskMessage) error {
 		now.Unix() + msg.Retention,
 		encoded,
 		int64(math.MaxInt64),
+		base.TaskKeyPrefix(msg.Queue),
+		now.UnixNano(),
+		int64(chainExpiration.Seconds()),
+		base.OrderingKeyPrefix(msg.Queue),
+		base.CompletionChannel(msg.Queue, msg.ID),
+		base.WakeupChannel(msg.Queue),
+		base.QueueKeyPrefix(msg.Queue),
 	}
 	// Note: We cannot pass empty unique key when running this script in redis-cluster.
 	if len(msg.UniqueKey) > 0 {
 		keys = append(keys, msg.UniqueKey)
-		return r.runScript(ctx, op, markAsCompleteUniqueCmd, keys, argv...)
+		return r.runReleaseScript(ctx, op, msg.Queue, markAsCompleteUniqueCmd, keys, argv...)
 	}
-	return r.runScript(ctx, op, markAsCompleteCmd, keys, argv...)
+	return r.runReleaseScript(ctx, op, msg.Queue, markAsCompleteCmd, keys, argv...)
 }
 
 // KEYS[1] -> asynq_learn:{<qname>}:active
 // KEYS[2] -> asynq_learn:{<qname>}:lease
-// KEYS[3] -> asynq_learn:{<qname>}:pending
-// KEYS[4] -> asynq_learn:{<qname>}:t:<task_id>
+// KEYS[3] -> asynq_learn:{<qname>}:t:<task_id>
 // ARGV[1] -> task ID
+// ARGV[2] -> queue key prefix
 // Note: Use RPUSH to push to the head of the queue.
-var requeueCmd = redis.NewScript(`
+var requeueCmd = redis.NewScript(pendingLua + `
 if redis.call("LREM", KEYS[1], 0, ARGV[1]) == 0 then
   return redis.error_reply("NOT FOUND")
 end
 if redis.call("ZREM", KEYS[2], ARGV[1]) == 0 then
   return redis.error_reply("NOT FOUND")
 end
-redis.call("RPUSH", KEYS[3], ARGV[1])
-redis.call("HSET", KEYS[4], "state", "pending")
+pending_push(ARGV[2], ARGV[1], "RPUSH")
+redis.call("HSET", KEYS[3], "state", "pending")
 return redis.status_reply("OK")`)
 
 // Requeue moves the task from active queue to the specified queue.
@@ -501,10 +1245,9 @@ func (r *RDB) Requeue(ctx context.Context, msg *base.TaskMessage) error {
 	keys := []string{
 		base.ActiveKey(msg.Queue),
 		base.LeaseKey(msg.Queue),
-		base.PendingKey(msg.Queue),
 		base.TaskKey(msg.Queue, msg.ID),
 	}
-	return r.runScript(ctx, op, requeueCmd, keys, msg.ID)
+	return r.runScript(ctx, op, requeueCmd, keys, msg.ID, base.QueueKeyPrefix(msg.Queue))
 }
 
 // KEYS[1] -> asynq_learn:{<qname>}:t:<task_id>
@@ -642,10 +1385,15 @@ func (r *RDB) AddToGroupUnique(ctx context.Context, msg *base.TaskMessage, group
 
 // KEYS[1] -> asynq_learn:{<qname>}:t:<task_id>
 // KEYS[2] -> asynq_learn:{<qname>}:scheduled
+// KEYS[3] -> asynq_learn:{<qname>}:expiring
 // -------
 // ARGV[1] -> task message data
-// ARGV[2] -> process_at time in Unix time
+// ARGV[2] -> process_at time in Unix time in msec
 // ARGV[3] -> task ID
+// ARGV[4] -> schedule pubsub channel
+// ARGV[5] -> fairness key of the task (empty if none)
+// ARGV[6] -> priority of the task
+// ARGV[7] -> expiration time of the task in Unix time (zero if none)
 //
 // Output:
 // Returns 1 if successfully enqueued
@@ -657,7 +1405,17 @@ end
 redis.call("HSET", KEYS[1],
            "msg", ARGV[1],
            "state", "scheduled")
+if ARGV[5] ~= "" then
+	redis.call("HSET", KEYS[1], "fairness_key", ARGV[5])
+end
+if tonumber(ARGV[6]) > 0 then
+	redis.call("HSET", KEYS[1], "priority", ARGV[6])
+end
 redis.call("ZADD", KEYS[2], ARGV[2], ARGV[3])
+if tonumber(ARGV[7]) > 0 then
+	redis.call("ZADD", KEYS[3], ARGV[7], ARGV[3])
+end
+redis.call("PUBLISH", ARGV[4], ARGV[2])
 return 1
 `)
 
@@ -674,86 +1432,819 @@ func (r *RDB) Schedule(ctx context.Context, msg *base.TaskMessage, processAt tim
 	keys := []string{
 		base.TaskKey(msg.Queue, msg.ID),
 		base.ScheduledKey(msg.Queue),
+		base.ExpiringKey(msg.Queue),
+	}
+	argv := []interface{}{
+		encoded,
+		base.ProcessAtScore(processAt),
+		msg.ID,
+		base.ScheduleChannel(msg.Queue),
+		msg.FairnessKey,
+		msg.Priority,
+		msg.ExpireAt,
+	}
+	n, err := r.runScriptWithErrorCode(ctx, op, scheduleCmd, keys, argv...)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+	}
+	return nil
+}
+
+// KEYS[1] -> unique key
+// KEYS[2] -> asynq_learn:{<qname>}:t:<task_id>
+// KEYS[3] -> asynq_learn:{<qname>}:scheduled
+// KEYS[4] -> asynq_learn:{<qname>}:expiring
+// -------
+// ARGV[1] -> task ID
+// ARGV[2] -> uniqueness lock TTL
+// ARGV[3] -> score (process_at time in Unix time in msec)
+// ARGV[4] -> task message
+// ARGV[5] -> schedule pubsub channel
+// ARGV[6] -> fairness key of the task (empty if none)
+// ARGV[7] -> priority of the task
+// ARGV[8] -> expiration time of the task in Unix time (zero if none)
+//
+// Output:
+// Returns 1 if successfully scheduled
+// Returns 0 if task ID already exists
+// Returns -1 if task unique key already exists
+var scheduleUniqueCmd = redis.NewScript(`
+local ok = redis.call("SET", KEYS[1], ARGV[1], "NX", "EX", ARGV[2])
+if not ok then
+  return -1
+end
+if redis.call("EXISTS", KEYS[2]) == 1 then
+  return 0
+end
+redis.call("HSET", KEYS[2],
+           "msg", ARGV[4],
+           "state", "scheduled",
+           "unique_key", KEYS[1])
+if ARGV[6] ~= "" then
+	redis.call("HSET", KEYS[2], "fairness_key", ARGV[6])
+end
+if tonumber(ARGV[7]) > 0 then
+	redis.call("HSET", KEYS[2], "priority", ARGV[7])
+end
+redis.call("ZADD", KEYS[3], ARGV[3], ARGV[1])
+if tonumber(ARGV[8]) > 0 then
+	redis.call("ZADD", KEYS[4], ARGV[8], ARGV[1])
+end
+redis.call("PUBLISH", ARGV[5], ARGV[3])
+return 1
+`)
+
+// ScheduleUnique adds the task to the backlog queue to be processed in the future if the uniqueness lock can be acquired.
+// It returns ErrDuplicateTask if the lock cannot be acquired.
+func (r *RDB) ScheduleUnique(ctx context.Context, msg *base.TaskMessage, processAt time.Time, ttl time.Duration) error {
+	var op errors.Op = "rdb.ScheduleUnique"
+	encoded, err := base.EncodeMessage(msg)
+	if err != nil {
+		return errors.E(op, errors.Internal, fmt.Sprintf("cannot encode task message: %v", err))
+	}
+	if err := r.client.SAdd(ctx, base.AllQueues, msg.Queue).Err(); err != nil {
+		return errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "sadd", Err: err})
+	}
+	keys := []string{
+		msg.UniqueKey,
+		base.TaskKey(msg.Queue, msg.ID),
+		base.ScheduledKey(msg.Queue),
+		base.ExpiringKey(msg.Queue),
+	}
+	argv := []interface{}{
+		msg.ID,
+		int(ttl.Seconds()),
+		base.ProcessAtScore(processAt),
+		encoded,
+		base.ScheduleChannel(msg.Queue),
+		msg.FairnessKey,
+		msg.Priority,
+		msg.ExpireAt,
+	}
+	n, err := r.runScriptWithErrorCode(ctx, op, scheduleUniqueCmd, keys, argv...)
+	if err != nil {
+		return err
+	}
+	if n == -1 {
+		return errors.E(op, errors.AlreadyExists, errors.ErrDuplicateTask)
+	}
+	if n == 0 {
+		return errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+	}
+	return nil
+}
+
+// KEYS[1] -> asynq_learn:{<qname>}:debounce:<key>
+// KEYS[2] -> asynq_learn:{<qname>}:t:<task_id>
+// KEYS[3] -> asynq_learn:{<qname>}:scheduled
+// KEYS[4] -> asynq_learn:{<qname>}:pending
+// -------
+// ARGV[1] -> task ID
+// ARGV[2] -> task message data
+// ARGV[3] -> ID of the task expected to hold the debounce key (empty if none)
+// ARGV[4] -> task message data encoded with the expected task ID
+// ARGV[5] -> score (process_at time in Unix time in msec)
+// ARGV[6] -> task key prefix
+// ARGV[7] -> schedule pubsub channel
+// ARGV[8] -> debounce key expiration in seconds
+//
+// Output:
+// Returns the ID of the surviving task if successfully scheduled
+// Returns 0 if task ID already exists
+// Returns -1 if the debounce key is held by a task other than the expected one
+var debounceCmd = redis.NewScript(`
+local id = redis.call("GET", KEYS[1])
+if id then
+	local key = ARGV[6] .. id
+	local state = redis.call("HGET", key, "state")
+	if state == "pending" or state == "scheduled" then
+		if id ~= ARGV[3] then
+			return -1
+		end
+		if state == "pending" then
+			redis.call("LREM", KEYS[4], 1, id)
+			redis.call("HDEL", key, "pending_since")
+		end
+		redis.call("HSET", key, "msg", ARGV[4], "state", "scheduled")
+		redis.call("ZADD", KEYS[3], ARGV[5], id)
+		redis.call("EXPIRE", KEYS[1], ARGV[8])
+		redis.call("PUBLISH", ARGV[7], ARGV[5])
+		return id
+	end
+end
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	return 0
+end
+redis.call("HSET", KEYS[2],
+           "msg", ARGV[2],
+           "state", "scheduled",
+           "unique_key", KEYS[1])
+redis.call("ZADD", KEYS[3], ARGV[5], ARGV[1])
+redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[8])
+redis.call("PUBLISH", ARGV[7], ARGV[5])
+return ARGV[1]
+`)
+
+// Debounce schedules the task to be processed at processAt, unless the task holding the
+// debounce key (i.e. msg.UniqueKey) is still pending or scheduled, in which case that task's
+// message is replaced with msg and its process time is pushed out to processAt.
+// It returns the ID of the surviving task.
+//
+// The debounce key is released in the same way as a uniqueness lock once the task is processed.
+// It expires debounceKeyExpiration after processAt in case it is never released.
+func (r *RDB) Debounce(ctx context.Context, msg *base.TaskMessage, processAt time.Time) (string, error) {
+	var op errors.Op = "rdb.Debounce"
+	encoded, err := base.EncodeMessage(msg)
+	if err != nil {
+		return "", errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+	}
+	if err := r.client.SAdd(ctx, base.AllQueues, msg.Queue).Err(); err != nil {
+		return "", errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "sadd", Err: err})
+	}
+	keys := []string{
+		msg.UniqueKey,
+		base.TaskKey(msg.Queue, msg.ID),
+		base.ScheduledKey(msg.Queue),
+		base.PendingKey(msg.Queue),
+	}
+	ttl := int64(math.Ceil(processAt.Add(debounceKeyExpiration).Sub(r.clock.Now()).Seconds()))
+	for {
+		// The replaced message must keep the ID of the surviving task, so encode the message
+		// with the ID currently holding the key; the script retries if the holder has changed.
+		holder, err := r.client.Get(ctx, msg.UniqueKey).Result()
+		if err != nil && err != redis.Nil {
+			return "", errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "get", Err: err})
+		}
+		var replaced string
+		if holder != "" {
+			m := *msg
+			m.ID = holder
+			b, err := base.EncodeMessage(&m)
+			if err != nil {
+				return "", errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+			}
+			replaced = string(b)
+		}
+		argv := []interface{}{
+			msg.ID,
+			encoded,
+			holder,
+			replaced,
+			base.ProcessAtScore(processAt),
+			base.TaskKeyPrefix(msg.Queue),
+			base.ScheduleChannel(msg.Queue),
+			ttl,
+		}
+		res, err := debounceCmd.Run(ctx, r.client, keys, argv...).Result()
+		if err != nil {
+			return "", errors.E(op, errors.Unknown, fmt.Sprintf("redis eval error: %v", err))
+		}
+		switch res := res.(type) {
+		case string:
+			return res, nil
+		case int64:
+			if res == 0 {
+				return "", errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+			}
+			// The debounce key has changed hands since it was read; try again.
+		default:
+			return "", errors.E(op, errors.Internal, fmt.Sprintf("unexpected return value from Lua script: %v", res))
+		}
+	}
+}
+
+// enqueueWaitingCmd enqueues a task which depends on other tasks,
+// or on the previous task with the same ordering key.
+//
+// Input:
+// KEYS[1] -> asynq_learn:{<qname>}:t:<task_id>
+// KEYS[2] -> asynq_learn:{<qname>}:waiting
+// KEYS[3] -> asynq_learn:{<qname>}:ordering_keys
+// -------
+// ARGV[1] -> task message data
+// ARGV[2] -> task ID
+// ARGV[3] -> current unix time in seconds
+// ARGV[4] -> current unix time in nsec
+// ARGV[5] -> task key prefix
+// ARGV[6] -> ordering key prefix
+// ARGV[7] -> ordering key of the task (empty if none)
+// ARGV[8] -> 1 if a dependency which does not exist is considered completed, 0 otherwise
+// ARGV[9] -> queue key prefix
+// ARGV[10:] -> IDs of the tasks the task depends on
+//
+// Output:
+// Returns {1} if all dependencies have completed and the task is enqueued to the pending list
+// Returns {2} if the task is added to the waiting set
+// Returns {0} if task ID already exists
+// Returns {-3, <id>} if the dependency with the given ID is archived
+// Returns {-4, <id>} if the dependency with the given ID does not exist
+var enqueueWaitingCmd = redis.NewScript(pendingLua + `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return {0}
+end
+local deps = {}
+local seen = {}
+for i = 10, table.getn(ARGV) do
+	local state = redis.call("HGET", ARGV[5] .. ARGV[i], "state")
+	if state == "archived" then
+		return {-3, ARGV[i]}
+	end
+	if not state and ARGV[8] ~= "1" then
+		return {-4, ARGV[i]}
+	end
+	if state and state ~= "completed" and not seen[ARGV[i]] then
+		seen[ARGV[i]] = true
+		table.insert(deps, ARGV[i])
+	end
+end
+local turn = true
+if ARGV[7] ~= "" then
+	redis.call("SADD", KEYS[3], ARGV[7])
+	turn = redis.call("RPUSH", ARGV[6] .. ARGV[7], ARGV[2]) == 1
+	redis.call("HSET", KEYS[1], "ordering_key", ARGV[7])
+end
+if table.getn(deps) == 0 and turn then
+	redis.call("HSET", KEYS[1],
+	           "msg", ARGV[1],
+	           "state", "pending",
+	           "pending_since", ARGV[4])
+	pending_push(ARGV[9], ARGV[2], "LPUSH")
+	return {1}
+end
+redis.call("HSET", KEYS[1],
+           "msg", ARGV[1],
+           "state", "waiting")
+if table.getn(deps) > 0 then
+	redis.call("HSET", KEYS[1], "pending_deps", table.getn(deps))
+end
+for _, dep in ipairs(deps) do
+	redis.call("HSET", KEYS[1], "dep:" .. dep, 1)
+	redis.call("HSET", ARGV[5] .. dep, "dependent:" .. ARGV[2], 1)
+end
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[2])
+return {2}
+`)
+
+// EnqueueWaiting adds the given task to the waiting set of the queue, where the task stays
+// until all of its dependencies complete. If all dependencies have already completed,
+// the task is added to the pending list instead.
+// A task with an ordering key stays in the waiting set until the previous tasks with the key are done.
+// It returns the state the task was put in.
+//
+// It returns an error wrapping TaskNotFoundError if a dependency does not exist, unless ignoreMissingDeps
+// is true, in which case the dependency is considered completed since a task processed without retention
+// is deleted once it completes.
+func (r *RDB) EnqueueWaiting(ctx context.Context, msg *base.TaskMessage, ignoreMissingDeps bool) (base.TaskState, error) {
+	var op errors.Op = "rdb.EnqueueWaiting"
+	encoded, err := base.EncodeMessage(msg)
+	if err != nil {
+		return 0, errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+	}
+	if err := r.client.SAdd(ctx, base.AllQueues, msg.Queue).Err(); err != nil {
+		return 0, errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "sadd", Err: err})
+	}
+	keys := []string{
+		base.TaskKey(msg.Queue, msg.ID),
+		base.WaitingKey(msg.Queue),
+		base.AllOrderingKeys(msg.Queue),
+	}
+	ignoreMissing := 0
+	if ignoreMissingDeps {
+		ignoreMissing = 1
+	}
+	now := r.clock.Now()
+	argv := []interface{}{
+		encoded,
+		msg.ID,
+		now.Unix(),
+		now.UnixNano(),
+		base.TaskKeyPrefix(msg.Queue),
+		base.OrderingKeyPrefix(msg.Queue),
+		msg.OrderingKey,
+		ignoreMissing,
+		base.QueueKeyPrefix(msg.Queue),
+	}
+	for _, id := range msg.Dependencies {
+		argv = append(argv, id)
+	}
+	res, err := enqueueWaitingCmd.Run(ctx, r.client, keys, argv...).Result()
+	if err != nil {
+		return 0, errors.E(op, errors.Unknown, fmt.Sprintf("redis eval error: %v", err))
+	}
+	return enqueueWaitingResult(op, msg.Queue, res)
+}
+
+// enqueueWaitingUniqueCmd enqueues a task which depends on other tasks
+// if the task is unique.
+//
+// Input:
+// KEYS[1] -> asynq_learn:{<qname>}:t:<task_id>
+// KEYS[2] -> asynq_learn:{<qname>}:waiting
+// KEYS[3] -> unique key
+// KEYS[4] -> asynq_learn:{<qname>}:ordering_keys
+// -------
+// ARGV[1] -> task message data
+// ARGV[2] -> task ID
+// ARGV[3] -> current unix time in seconds
+// ARGV[4] -> current unix time in nsec
+// ARGV[5] -> task key prefix
+// ARGV[6] -> uniqueness lock TTL
+// ARGV[7] -> ordering key prefix
+// ARGV[8] -> ordering key of the task (empty if none)
+// ARGV[9] -> 1 if a dependency which does not exist is considered completed, 0 otherwise
+// ARGV[10] -> queue key prefix
+// ARGV[11:] -> IDs of the tasks the task depends on
+//
+// Output:
+// Returns {1} if all dependencies have completed and the task is enqueued to the pending list
+// Returns {2} if the task is added to the waiting set
+// Returns {0} if task ID already exists
+// Returns {-1} if task unique key already exists
+// Returns {-3, <id>} if the dependency with the given ID is archived
+// Returns {-4, <id>} if the dependency with the given ID does not exist
+var enqueueWaitingUniqueCmd = redis.NewScript(pendingLua + `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return {0}
+end
+local deps = {}
+local seen = {}
+for i = 11, table.getn(ARGV) do
+	local state = redis.call("HGET", ARGV[5] .. ARGV[i], "state")
+	if state == "archived" then
+		return {-3, ARGV[i]}
+	end
+	if not state and ARGV[9] ~= "1" then
+		return {-4, ARGV[i]}
+	end
+	if state and state ~= "completed" and not seen[ARGV[i]] then
+		seen[ARGV[i]] = true
+		table.insert(deps, ARGV[i])
+	end
+end
+local ok = redis.call("SET", KEYS[3], ARGV[2], "NX", "EX", ARGV[6])
+if not ok then
+	return {-1}
+end
+local turn = true
+if ARGV[8] ~= "" then
+	redis.call("SADD", KEYS[4], ARGV[8])
+	turn = redis.call("RPUSH", ARGV[7] .. ARGV[8], ARGV[2]) == 1
+	redis.call("HSET", KEYS[1], "ordering_key", ARGV[8])
+end
+if table.getn(deps) == 0 and turn then
+	redis.call("HSET", KEYS[1],
+	           "msg", ARGV[1],
+	           "state", "pending",
+	           "pending_since", ARGV[4],
+	           "unique_key", KEYS[3])
+	pending_push(ARGV[10], ARGV[2], "LPUSH")
+	return {1}
+end
+redis.call("HSET", KEYS[1],
+           "msg", ARGV[1],
+           "state", "waiting",
+           "unique_key", KEYS[3])
+if table.getn(deps) > 0 then
+	redis.call("HSET", KEYS[1], "pending_deps", table.getn(deps))
+end
+for _, dep in ipairs(deps) do
+	redis.call("HSET", KEYS[1], "dep:" .. dep, 1)
+	redis.call("HSET", ARGV[5] .. dep, "dependent:" .. ARGV[2], 1)
+end
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[2])
+return {2}
+`)
+
+// EnqueueWaitingUnique adds the given task to the waiting set of the queue if the task's
+// uniqueness lock can be acquired. See EnqueueWaiting for how dependencies and ordering keys are handled.
+// It returns ErrDuplicateTask if the lock cannot be acquired.
+func (r *RDB) EnqueueWaitingUnique(ctx context.Context, msg *base.TaskMessage, ttl time.Duration, ignoreMissingDeps bool) (base.TaskState, error) {
+	var op errors.Op = "rdb.EnqueueWaitingUnique"
+	encoded, err := base.EncodeMessage(msg)
+	if err != nil {
+		return 0, errors.E(op, errors.Internal, fmt.Sprintf("cannot encode task message: %v", err))
+	}
+	if err := r.client.SAdd(ctx, base.AllQueues, msg.Queue).Err(); err != nil {
+		return 0, errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "sadd", Err: err})
+	}
+	keys := []string{
+		base.TaskKey(msg.Queue, msg.ID),
+		base.WaitingKey(msg.Queue),
+		msg.UniqueKey,
+		base.AllOrderingKeys(msg.Queue),
+	}
+	ignoreMissing := 0
+	if ignoreMissingDeps {
+		ignoreMissing = 1
+	}
+	now := r.clock.Now()
+	argv := []interface{}{
+		encoded,
+		msg.ID,
+		now.Unix(),
+		now.UnixNano(),
+		base.TaskKeyPrefix(msg.Queue),
+		int(ttl.Seconds()),
+		base.OrderingKeyPrefix(msg.Queue),
+		msg.OrderingKey,
+		ignoreMissing,
+		base.QueueKeyPrefix(msg.Queue),
+	}
+	for _, id := range msg.Dependencies {
+		argv = append(argv, id)
+	}
+	res, err := enqueueWaitingUniqueCmd.Run(ctx, r.client, keys, argv...).Result()
+	if err != nil {
+		return 0, errors.E(op, errors.Unknown, fmt.Sprintf("redis eval error: %v", err))
+	}
+	return enqueueWaitingResult(op, msg.Queue, res)
+}
+
+// enqueueWaitingResult converts the value returned from enqueueWaitingCmd
+// or enqueueWaitingUniqueCmd to the task state or an error.
+func enqueueWaitingResult(op errors.Op, qname string, res interface{}) (base.TaskState, error) {
+	vals, err := cast.ToSliceE(res)
+	if err != nil || len(vals) == 0 {
+		return 0, errors.E(op, errors.Internal, fmt.Sprintf("unexpected return value from Lua script: %v", res))
+	}
+	n, err := cast.ToInt64E(vals[0])
+	if err != nil {
+		return 0, errors.E(op, errors.Internal, fmt.Sprintf("unexpected return value from Lua script: %v", res))
+	}
+	var dep string
+	if len(vals) > 1 {
+		dep = cast.ToString(vals[1])
+	}
+	switch n {
+	case 1:
+		return base.TaskStatePending, nil
+	case 2:
+		return base.TaskStateWaiting, nil
+	case 0:
+		return 0, errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+	case -1:
+		return 0, errors.E(op, errors.AlreadyExists, errors.ErrDuplicateTask)
+	case -3:
+		return 0, errors.E(op, errors.FailedPrecondition, &errors.TaskAlreadyArchivedError{Queue: qname, ID: dep})
+	case -4:
+		return 0, errors.E(op, errors.NotFound, &errors.TaskNotFoundError{Queue: qname, ID: dep})
+	default:
+		return 0, errors.E(op, errors.Internal, fmt.Sprintf("unexpected return value from Lua script: %v", res))
+	}
+}
+
+// blockedByLua defines the Lua function blocked_by, which returns the ID of a dependency
+// of the waiting task that was archived or deleted, or nil if there is none.
+//
+// Arguments:
+// tprefix -> task key prefix
+// key     -> asynq_learn:{<qname>}:t:<task_id>
+//
+// Note: Completed dependencies are removed from the dep: fields of the task,
+// so a dependency which no longer exists was deleted.
+const blockedByLua = `
+local function blocked_by(tprefix, key)
+	for _, field in ipairs(redis.call("HKEYS", key)) do
+		if string.sub(field, 1, 4) == "dep:" then
+			local dep = string.sub(field, 5)
+			local state = redis.call("HGET", tprefix .. dep, "state")
+			if not state or state == "archived" then
+				return dep
+			end
+		end
+	end
+	return nil
+end
+`
+
+// listBlockedTasksCmd lists the waiting tasks in the blocked set which depend
+// on a task that was archived or deleted.
+// Tasks which are no longer waiting or blocked are removed from the set.
+//
+// Input:
+// KEYS[1] -> asynq_learn:{<qname>}:blocked
+// -------
+// ARGV[1] -> task key prefix
+// ARGV[2] -> max number of tasks to list
+//
+// Output:
+// Returns a table containing the number of tasks inspected followed by
+// the messages of the blocked tasks.
+var listBlockedTasksCmd = redis.NewScript(blockedByLua + `
+local res = {0}
+local ids = redis.call("SRANDMEMBER", KEYS[1], tonumber(ARGV[2]))
+for _, id in ipairs(ids) do
+	local key = ARGV[1] .. id
+	local msg, state = unpack(redis.call("HMGET", key, "msg", "state"))
+	if state == "waiting" and blocked_by(ARGV[1], key) then
+		table.insert(res, msg)
+	else
+		redis.call("SREM", KEYS[1], id)
+	end
+end
+res[1] = table.getn(ids)
+return res`)
+
+// KEYS[1] -> asynq_learn:{<qname>}:blocked
+// KEYS[2] -> asynq_learn:{<qname>}:waiting
+// KEYS[3] -> asynq_learn:{<qname>}:archived
+// -------
+// ARGV[1] -> current timestamp
+// ARGV[2] -> cutoff timestamp (e.g., 90 days ago)
+// ARGV[3] -> max number of tasks in archive (e.g., 100)
+// ARGV[4] -> task key prefix
+// ARGV[5] -> completion channel prefix
+// ARGV[6+3*i] -> task ID of the i-th task
+// ARGV[7+3*i] -> task message data of the i-th task read by listBlockedTasksCmd
+// ARGV[8+3*i] -> updated task message data of the i-th task
+//
+// Output:
+// Returns a table containing the ID and the type of each archived task (see taskEventsLua).
+//
+// Note: A task is archived only if it is still blocked and its message is unchanged
+// since it was listed, otherwise it is left to the next run.
+// The tasks waiting for an archived task are added to the blocked set in turn.
+var archiveBlockedTasksCmd = redis.NewScript(blockedByLua + blockDependentsLua + taskEventsLua + `
+local archived = {}
+for i = 6, table.getn(ARGV), 3 do
+	local id = ARGV[i]
+	local key = ARGV[4] .. id
+	local msg, state = unpack(redis.call("HMGET", key, "msg", "state"))
+	if msg == ARGV[i + 1] and state == "waiting" and blocked_by(ARGV[4], key) then
+		redis.call("ZREM", KEYS[2], id)
+		redis.call("ZADD", KEYS[3], ARGV[1], id)
+		redis.call("HSET", key, "msg", ARGV[i + 2], "state", "archived")
+		redis.call("SREM", KEYS[1], id)
+		block_dependents(key, KEYS[1])
+		redis.call("PUBLISH", ARGV[5] .. id, id)
+		add_task_event(archived, key, id)
+	end
+end
+if #archived > 0 then
+	redis.call("ZREMRANGEBYSCORE", KEYS[3], "-inf", ARGV[2])
+	redis.call("ZREMRANGEBYRANK", KEYS[3], 0, -ARGV[3])
+end
+return archived`)
+
+// ArchiveBlockedTasks archives the waiting tasks in the given queue which depend on a task
+// that was archived or deleted, since such tasks can never become pending.
+// Scripts archiving or deleting a task add the tasks waiting for it to the blocked set,
+// so only the tasks in the set are inspected. The tasks are archived with
+// base.BlockedErrMsg as their error message.
+func (r *RDB) ArchiveBlockedTasks(qname string) error {
+	// Note: Do this operation in fix batches to prevent long running script.
+	const batchSize = 100
+	for {
+		n, archived, err := r.archiveBlockedTasks(qname, batchSize)
+		if err != nil {
+			return err
+		}
+		// Archiving a task adds the tasks waiting for it to the blocked set,
+		// so keep going as long as tasks are archived.
+		if n < batchSize && archived == 0 {
+			return nil
+		}
+	}
+}
+
+// archiveBlockedTasks runs the lua scripts to archive blocked tasks with the specified
+// batch size. It reports the number of tasks inspected and the number of tasks archived.
+func (r *RDB) archiveBlockedTasks(qname string, batchSize int) (int, int, error) {
+	var op errors.Op = "rdb.ArchiveBlockedTasks"
+	ctx := context.Background()
+	now := r.clock.Now()
+	res, err := listBlockedTasksCmd.Run(ctx, r.client, []string{base.BlockedKey(qname)},
+		base.TaskKeyPrefix(qname), batchSize).Result()
+	if err != nil {
+		return 0, 0, errors.E(op, errors.Internal, fmt.Sprintf("redis eval error: %v", err))
+	}
+	data, err := cast.ToSliceE(res)
+	if err != nil || len(data) == 0 {
+		return 0, 0, errors.E(op, errors.Internal, fmt.Sprintf("unexpected return value from Lua script: %v", res))
+	}
+	n := cast.ToInt(data[0])
+	if len(data) == 1 {
+		return n, 0, nil
+	}
+	argv := []interface{}{
+		now.Unix(),
+		now.AddDate(0, 0, -archivedExpirationInDays).Unix(),
+		maxArchiveSize,
+		base.TaskKeyPrefix(qname),
+		base.CompletionChannelPrefix(qname),
+	}
+	for _, v := range data[1:] {
+		encoded := cast.ToString(v)
+		msg, err := base.DecodeMessage([]byte(encoded))
+		if err != nil {
+			return 0, 0, errors.E(op, errors.Internal, fmt.Sprintf("cannot decode message: %v", err))
+		}
+		msg.ErrorMsg = base.BlockedErrMsg
+		msg.LastFailedAt = now.Unix()
+		updated, err := base.EncodeMessage(msg)
+		if err != nil {
+			return 0, 0, errors.E(op, errors.Internal, fmt.Sprintf("cannot encode message: %v", err))
+		}
+		argv = append(argv, msg.ID, encoded, updated)
+	}
+	keys := []string{
+		base.BlockedKey(qname),
+		base.WaitingKey(qname),
+		base.ArchivedKey(qname),
+	}
+	res, err = archiveBlockedTasksCmd.Run(ctx, r.client, keys, argv...).Result()
+	if err != nil {
+		return 0, 0, errors.E(op, errors.Internal, fmt.Sprintf("redis eval error: %v", err))
+	}
+	events := r.taskEvents(base.TaskEventArchived, qname, res)
+	r.recordEvents(ctx, events)
+	return n, len(events), nil
+}
+
+// releaseOrderingKeysCmd moves the next task of each ordering key to the pending list
+// if the previous task with the key was deleted or archived without releasing the key.
+//
+// Input:
+// KEYS[1] -> asynq_learn:{<qname>}:ordering_keys
+// KEYS[2] -> asynq_learn:{<qname>}:waiting
+// --
+// ARGV[1] -> ordering key prefix
+// ARGV[2] -> task key prefix
+// ARGV[3] -> current unix time in nsec
+// ARGV[4] -> wakeup pubsub channel
+// ARGV[5] -> queue key prefix
+//
+// Output:
+// Returns a table containing the tasks moved to the pending list, and an empty table (see doneCmd).
+var releaseOrderingKeysCmd = redis.NewScript(orderingReleaseLua + `
+local released = {}
+local moved = 0
+for _, okey in ipairs(redis.call("SMEMBERS", KEYS[1])) do
+	moved = moved + ordering_release(KEYS[1], ARGV[1], okey, "", ARGV[2], KEYS[2], ARGV[5], ARGV[3], released)
+end
+if moved > 0 then
+	redis.call("PUBLISH", ARGV[4], moved)
+end
+return {released, {}}
+`)
+
+// ReleaseOrderingKeys moves the next task of each ordering key in the given queue to the
+// pending list, if the previous task with the key no longer exists or is archived.
+func (r *RDB) ReleaseOrderingKeys(qname string) error {
+	var op errors.Op = "rdb.ReleaseOrderingKeys"
+	keys := []string{
+		base.AllOrderingKeys(qname),
+		base.WaitingKey(qname),
 	}
 	argv := []interface{}{
-		encoded,
-		processAt.Unix(),
-		msg.ID,
-	}
-	n, err := r.runScriptWithErrorCode(ctx, op, scheduleCmd, keys, argv...)
-	if err != nil {
-		return err
-	}
-	if n == 0 {
-		return errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
+		base.OrderingKeyPrefix(qname),
+		base.TaskKeyPrefix(qname),
+		r.clock.Now().UnixNano(),
+		base.WakeupChannel(qname),
+		base.QueueKeyPrefix(qname),
 	}
-	return nil
+	return r.runReleaseScript(context.Background(), op, qname, releaseOrderingKeysCmd, keys, argv...)
 }
 
-// KEYS[1] -> unique key
-// KEYS[2] -> asynq_learn:{<qname>}:t:<task_id>
-// KEYS[3] -> asynq_learn:{<qname>}:scheduled
-// -------
-// ARGV[1] -> task ID
-// ARGV[2] -> uniqueness lock TTL
-// ARGV[3] -> score (process_at timestamp)
-// ARGV[4] -> task message
+// KEYS[1] -> asynq_learn:{<qname>}:chain:<chain_id>
+// --
+// ARGV[1] -> task key prefix
+// ARGV[2] -> current unix time in nsec
+// ARGV[3] -> queue key prefix
+// ARGV[4] -> number of steps in the chain
+// ARGV[5+2*i] -> task ID of the i-th step (zero-based)
+// ARGV[6+2*i] -> task message data of the i-th step (zero-based)
 //
 // Output:
-// Returns 1 if successfully scheduled
-// Returns 0 if task ID already exists
-// Returns -1 if task unique key already exists
-var scheduleUniqueCmd = redis.NewScript(`
-local ok = redis.call("SET", KEYS[1], ARGV[1], "NX", "EX", ARGV[2])
-if not ok then
-  return -1
+// Returns 1 if the chain is created and its first step is enqueued
+// Returns 0 if the chain ID already exists
+// Returns -1 if the task ID of any step already exists
+var enqueueChainCmd = redis.NewScript(pendingLua + `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
 end
-if redis.call("EXISTS", KEYS[2]) == 1 then
-  return 0
+local n = tonumber(ARGV[4])
+for i = 0, n - 1 do
+	if redis.call("EXISTS", ARGV[1] .. ARGV[5 + 2 * i]) == 1 then
+		return -1
+	end
 end
-redis.call("HSET", KEYS[2],
-           "msg", ARGV[4],
-           "state", "scheduled",
-           "unique_key", KEYS[1])
-redis.call("ZADD", KEYS[3], ARGV[3], ARGV[1])
+for i = 0, n - 1 do
+	redis.call("HSET", KEYS[1],
+	           "id:" .. i, ARGV[5 + 2 * i],
+	           "msg:" .. i, ARGV[6 + 2 * i])
+end
+redis.call("HSET", KEYS[1], "size", n, "current", 0)
+redis.call("HSET", ARGV[1] .. ARGV[5],
+           "msg", ARGV[6],
+           "state", "pending",
+           "pending_since", ARGV[2],
+           "chain", KEYS[1],
+           "chain_step", 0)
+pending_push(ARGV[3], ARGV[5], "LPUSH")
 return 1
 `)
 
-// ScheduleUnique adds the task to the backlog queue to be processed in the future if the uniqueness lock can be acquired.
-// It returns ErrDuplicateTask if the lock cannot be acquired.
-func (r *RDB) ScheduleUnique(ctx context.Context, msg *base.TaskMessage, processAt time.Time, ttl time.Duration) error {
-	var op errors.Op = "rdb.ScheduleUnique"
-	encoded, err := base.EncodeMessage(msg)
-	if err != nil {
-		return errors.E(op, errors.Internal, fmt.Sprintf("cannot encode task message: %v", err))
-	}
-	if err := r.client.SAdd(ctx, base.AllQueues, msg.Queue).Err(); err != nil {
+// EnqueueChain stores the given task messages as the steps of a chain and
+// adds the first step to the pending list of the queue.
+// Each of the following steps is added to the pending list when the previous step completes.
+//
+// All messages must belong to the same queue and share the same ChainID.
+func (r *RDB) EnqueueChain(ctx context.Context, msgs []*base.TaskMessage) error {
+	var op errors.Op = "rdb.EnqueueChain"
+	if len(msgs) == 0 {
+		return errors.E(op, errors.FailedPrecondition, "chain must have at least one step")
+	}
+	qname, chainID := msgs[0].Queue, msgs[0].ChainID
+	if err := r.client.SAdd(ctx, base.AllQueues, qname).Err(); err != nil {
 		return errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "sadd", Err: err})
 	}
 	keys := []string{
-		msg.UniqueKey,
-		base.TaskKey(msg.Queue, msg.ID),
-		base.ScheduledKey(msg.Queue),
+		base.ChainKey(qname, chainID),
 	}
 	argv := []interface{}{
-		msg.ID,
-		int(ttl.Seconds()),
-		processAt.Unix(),
-		encoded,
+		base.TaskKeyPrefix(qname),
+		r.clock.Now().UnixNano(),
+		base.QueueKeyPrefix(qname),
+		len(msgs),
 	}
-	n, err := r.runScriptWithErrorCode(ctx, op, scheduleUniqueCmd, keys, argv...)
+	for _, msg := range msgs {
+		encoded, err := base.EncodeMessage(msg)
+		if err != nil {
+			return errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode message: %v", err))
+		}
+		argv = append(argv, msg.ID, encoded)
+	}
+	n, err := r.runScriptWithErrorCode(ctx, op, enqueueChainCmd, keys, argv...)
 	if err != nil {
 		return err
 	}
-	if n == -1 {
-		return errors.E(op, errors.AlreadyExists, errors.ErrDuplicateTask)
-	}
-	if n == 0 {
+	if n <= 0 {
 		return errors.E(op, errors.AlreadyExists, errors.ErrTaskIdConflict)
 	}
 	return nil
 }
 
+// ReadChainResult returns the result written by the given step of the chain.
+// It returns nil if the step has not completed yet or did not write any result.
+func (r *RDB) ReadChainResult(qname, chainID string, step int) ([]byte, error) {
+	var op errors.Op = "rdb.ReadChainResult"
+	vals, err := r.client.HMGet(context.Background(), base.ChainKey(qname, chainID), "size", fmt.Sprintf("result:%d", step)).Result()
+	if err != nil {
+		return nil, errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "hmget", Err: err})
+	}
+	if vals[0] == nil {
+		return nil, errors.E(op, errors.NotFound, &errors.ChainNotFoundError{Queue: qname, ID: chainID})
+	}
+	if vals[1] == nil {
+		return nil, nil
+	}
+	return []byte(cast.ToString(vals[1])), nil
+}
+
 // KEYS[1] -> asynq_learn:{<qname>}:t:<task_id>
 // KEYS[2] -> asynq_learn:{<qname>}:active
 // KEYS[3] -> asynq_learn:{<qname>}:lease
@@ -765,10 +2256,11 @@ func (r *RDB) ScheduleUnique(ctx context.Context, msg *base.TaskMessage, process
 // -------
 // ARGV[1] -> task ID
 // ARGV[2] -> updated base.TaskMessage value
-// ARGV[3] -> retry_at UNIX timestamp
+// ARGV[3] -> retry_at time in Unix time in msec
 // ARGV[4] -> stats expiration timestamp
 // ARGV[5] -> is_failure (bool)
 // ARGV[6] -> max int64 value
+// ARGV[7] -> schedule pubsub channel
 var retryCmd = redis.NewScript(`
 if redis.call("LREM", KEYS[2], 0, ARGV[1]) == 0 then
   return redis.error_reply("NOT FOUND")
@@ -778,6 +2270,7 @@ if redis.call("ZREM", KEYS[3], ARGV[1]) == 0 then
 end
 redis.call("ZADD", KEYS[4], ARGV[3], ARGV[1])
 redis.call("HSET", KEYS[1], "msg", ARGV[2], "state", "retry")
+redis.call("PUBLISH", ARGV[7], ARGV[3])
 if tonumber(ARGV[5]) == 1 then
 	local n = redis.call("INCR", KEYS[5])
 	if tonumber(n) == 1 then
@@ -828,10 +2321,11 @@ func (r *RDB) Retry(ctx context.Context, msg *base.TaskMessage, processAt time.T
 	argv := []interface{}{
 		msg.ID,
 		encoded,
-		processAt.Unix(),
+		base.ProcessAtScore(processAt),
 		expireAt.Unix(),
 		isFailure,
 		int64(math.MaxInt64),
+		base.ScheduleChannel(msg.Queue),
 	}
 	return r.runScript(ctx, op, retryCmd, keys, argv...)
 }
@@ -849,6 +2343,9 @@ const (
 // KEYS[6] -> asynq_learn:{<qname>}:failed:<yyyy-mm-dd>
 // KEYS[7] -> asynq_learn:{<qname>}:processed
 // KEYS[8] -> asynq_learn:{<qname>}:failed
+// KEYS[9] -> asynq_learn:{<qname>}:waiting
+// KEYS[10] -> asynq_learn:{<qname>}:ordering_keys
+// KEYS[11] -> asynq_learn:{<qname>}:blocked
 // -------
 // ARGV[1] -> task ID
 // ARGV[2] -> updated base.TaskMessage value
@@ -857,7 +2354,21 @@ const (
 // ARGV[5] -> max number of tasks in archive (e.g., 100)
 // ARGV[6] -> stats expiration timestamp
 // ARGV[7] -> max int64 value
-var archiveCmd = redis.NewScript(`
+// ARGV[8] -> task key prefix
+// ARGV[9] -> ordering key prefix
+// ARGV[10] -> current unix time in nsec
+// ARGV[11] -> completion pubsub channel
+// ARGV[12] -> wakeup pubsub channel
+// ARGV[13] -> queue key prefix
+//
+// Output:
+// Returns a table containing the next task with the ordering key of the task if it was moved
+// to the pending list, and an empty table (see doneCmd).
+//
+// Note: If the task has an ordering key, the next task with the key is moved to the pending list,
+// and the wakeup channel is notified.
+// Tasks waiting for the task are added to the blocked set.
+var archiveCmd = redis.NewScript(orderingReleaseLua + blockDependentsLua + `
 if redis.call("LREM", KEYS[2], 0, ARGV[1]) == 0 then
   return redis.error_reply("NOT FOUND")
 end
@@ -868,6 +2379,11 @@ redis.call("ZADD", KEYS[4], ARGV[3], ARGV[1])
 redis.call("ZREMRANGEBYSCORE", KEYS[4], "-inf", ARGV[4])
 redis.call("ZREMRANGEBYRANK", KEYS[4], 0, -ARGV[5])
 redis.call("HSET", KEYS[1], "msg", ARGV[2], "state", "archived")
+block_dependents(KEYS[1], KEYS[11])
+local released = {}
+if ordering_release(KEYS[10], ARGV[9], redis.call("HGET", KEYS[1], "ordering_key"), ARGV[1], ARGV[8], KEYS[9], ARGV[13], ARGV[10], released) > 0 then
+	redis.call("PUBLISH", ARGV[12], 1)
+end
 local n = redis.call("INCR", KEYS[5])
 if tonumber(n) == 1 then
 	redis.call("EXPIREAT", KEYS[5], ARGV[6])
@@ -884,10 +2400,12 @@ else
   	redis.call("INCR", KEYS[7])
    	redis.call("INCR", KEYS[8])
 end
-return redis.status_reply("OK")`)
+redis.call("PUBLISH", ARGV[11], ARGV[1])
+return {released, {}}`)
 
 // Archive sends the given task to archive, attaching the error message to the task.
 // It also trims the archive by timestamp and set size.
+// If the task has an ordering key, the next task with the key is moved to the pending list.
 func (r *RDB) Archive(ctx context.Context, msg *base.TaskMessage, errMsg string) error {
 	var op errors.Op = "rdb.Archive"
 	now := r.clock.Now()
@@ -909,6 +2427,9 @@ func (r *RDB) Archive(ctx context.Context, msg *base.TaskMessage, errMsg string)
 		base.FailedKey(msg.Queue, now),
 		base.ProcessedTotalKey(msg.Queue),
 		base.FailedTotalKey(msg.Queue),
+		base.WaitingKey(msg.Queue),
+		base.AllOrderingKeys(msg.Queue),
+		base.BlockedKey(msg.Queue),
 	}
 	argv := []interface{}{
 		msg.ID,
@@ -918,8 +2439,14 @@ func (r *RDB) Archive(ctx context.Context, msg *base.TaskMessage, errMsg string)
 		maxArchiveSize,
 		expireAt.Unix(),
 		int64(math.MaxInt64),
+		base.TaskKeyPrefix(msg.Queue),
+		base.OrderingKeyPrefix(msg.Queue),
+		now.UnixNano(),
+		base.CompletionChannel(msg.Queue, msg.ID),
+		base.WakeupChannel(msg.Queue),
+		base.QueueKeyPrefix(msg.Queue),
 	}
-	return r.runScript(ctx, op, archiveCmd, keys, argv...)
+	return r.runReleaseScript(ctx, op, msg.Queue, archiveCmd, keys, argv...)
 }
 
 // ForwardIfReady checks scheduled and retry sets of the given queues
@@ -935,14 +2462,23 @@ func (r *RDB) ForwardIfReady(qnames ...string) error {
 }
 
 // KEYS[1] -> source queue (e.g. asynq_learn:{<qname>:scheduled or asynq_learn:{<qname>}:retry})
-// KEYS[2] -> asynq_learn:{<qname>}:pending
 // ARGV[1] -> current unix time in seconds
 // ARGV[2] -> task key prefix
 // ARGV[3] -> current unix time in nsec
 // ARGV[4] -> group key prefix
+// ARGV[5] -> wakeup pubsub channel
+// ARGV[6] -> current unix time in msec
+// ARGV[7] -> min score in msec (base.MinProcessAtScore)
+// ARGV[8] -> queue key prefix
 // Note: Script moves tasks up to 100 at a time to keep the runtime of script short.
-var forwardCmd = redis.NewScript(`
+// Note: Scores less than ARGV[7] were written in seconds by older versions, they are
+// compared with the current time in seconds, and the other scores with the time in msec.
+var forwardCmd = redis.NewScript(pendingLua + `
 local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 100)
+if table.getn(ids) == 0 then
+	ids = redis.call("ZRANGEBYSCORE", KEYS[1], ARGV[7], ARGV[6], "LIMIT", 0, 100)
+end
+local pending = 0
 for _, id in ipairs(ids) do
 	local taskKey = ARGV[2] .. id
 	local group = redis.call("HGET", taskKey, "group")
@@ -952,26 +2488,34 @@ for _, id in ipairs(ids) do
 		redis.call("HSET", taskKey,
 				   "state", "aggregating")
 	else
-		redis.call("LPUSH", KEYS[2], id)
+		pending_push(ARGV[8], id, "LPUSH")
 		redis.call("ZREM", KEYS[1], id)
 		redis.call("HSET", taskKey,
 				   "state", "pending",
 				   "pending_since", ARGV[3])
+		pending = pending + 1
 	end
 end
+if pending > 0 then
+	redis.call("PUBLISH", ARGV[5], pending)
+end
 return table.getn(ids)`)
 
 // forward moves tasks with a score less than the current unix time from the delayed (i.e. scheduled | retry) zset
-// to the pending list or group set.
+// of the given queue to the pending list or group set.
 // It returns the number of tasks moved.
-func (r *RDB) forward(delayedKey, pendingKey, taskKeyPrefix, groupKeyPrefix string) (int, error) {
+func (r *RDB) forward(qname, delayedKey string) (int, error) {
 	now := r.clock.Now()
-	keys := []string{delayedKey, pendingKey}
+	keys := []string{delayedKey}
 	argv := []interface{}{
 		now.Unix(),
-		taskKeyPrefix,
+		base.TaskKeyPrefix(qname),
 		now.UnixNano(),
-		groupKeyPrefix,
+		base.GroupKeyPrefix(qname),
+		base.WakeupChannel(qname),
+		base.ProcessAtScore(now),
+		int64(base.MinProcessAtScore),
+		base.QueueKeyPrefix(qname),
 	}
 	res, err := forwardCmd.Run(context.Background(), r.client, keys, argv...).Result()
 	if err != nil {
@@ -988,13 +2532,10 @@ func (r *RDB) forward(delayedKey, pendingKey, taskKeyPrefix, groupKeyPrefix stri
 // their state to "pending" or "aggregating".
 func (r *RDB) forwardAll(qname string) (err error) {
 	delayedKeys := []string{base.ScheduledKey(qname), base.RetryKey(qname)}
-	pendingKey := base.PendingKey(qname)
-	taskKeyPrefix := base.TaskKeyPrefix(qname)
-	groupKeyPrefix := base.GroupKeyPrefix(qname)
 	for _, delayedKey := range delayedKeys {
 		n := 1
 		for n != 0 {
-			n, err = r.forward(delayedKey, pendingKey, taskKeyPrefix, groupKeyPrefix)
+			n, err = r.forward(qname, delayedKey)
 			if err != nil {
 				return err
 			}
@@ -1003,6 +2544,48 @@ func (r *RDB) forwardAll(qname string) (err error) {
 	return nil
 }
 
+// NextProcessAt returns the earliest time at which a scheduled or retry task
+// of the given queues is to be processed, or zero time if there is no such task.
+func (r *RDB) NextProcessAt(qnames ...string) (time.Time, error) {
+	var op errors.Op = "rdb.NextProcessAt"
+	ctx := context.Background()
+	var cmds []*redis.ZSliceCmd
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, qname := range qnames {
+			for _, key := range []string{base.ScheduledKey(qname), base.RetryKey(qname)} {
+				// Scores written in seconds are less than the ones in msec,
+				// so the earliest task of each kind is queried separately.
+				cmds = append(cmds, pipe.ZRangeWithScores(ctx, key, 0, 0))
+				cmds = append(cmds, pipe.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+					Min:   strconv.FormatInt(base.MinProcessAtScore, 10),
+					Max:   "+inf",
+					Count: 1,
+				}))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "zrange", Err: err})
+	}
+	var next time.Time
+	for _, cmd := range cmds {
+		for _, z := range cmd.Val() {
+			if t := base.ProcessAtFromScore(int64(z.Score)); next.IsZero() || t.Before(next) {
+				next = t
+			}
+		}
+	}
+	return next, nil
+}
+
+// SubscribeSchedule subscribes to the notifications published when tasks are added
+// to the scheduled or retry set of the given queues.
+func (r *RDB) SubscribeSchedule(qnames ...string) (base.WakeupSubscription, error) {
+	var op errors.Op = "rdb.SubscribeSchedule"
+	return r.subscribeQueues(op, base.ScheduleChannel, qnames)
+}
+
 // ListGroups returns a list of all known groups in the given queue.
 func (r *RDB) ListGroups(qname string) ([]string, error) {
 	var op errors.Op = "RDB.ListGroups"
@@ -1296,6 +2879,263 @@ func (r *RDB) deleteExpiredCompletedTasks(qname string, batchSize int) (int64, e
 	return n, nil
 }
 
+// KEYS[1] -> asynq_learn:{<qname>}:expiring
+// ARGV[1] -> current time in unix time
+// ARGV[2] -> task key prefix
+// ARGV[3] -> batch size (i.e. maximum number of tasks to inspect)
+//
+// Output:
+// Returns a table whose first element is the number of tasks inspected, followed by
+// the messages of the expired tasks which are pending, scheduled or in retry.
+// Tasks in other states are removed from the expiring set.
+var listExpiredTasksCmd = redis.NewScript(`
+local res = {0}
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, tonumber(ARGV[3]))
+for _, id in ipairs(ids) do
+	local msg, state = unpack(redis.call("HMGET", ARGV[2] .. id, "msg", "state"))
+	if state == "pending" or state == "scheduled" or state == "retry" then
+		table.insert(res, msg)
+	else
+		redis.call("ZREM", KEYS[1], id)
+	end
+end
+res[1] = table.getn(ids)
+return res`)
+
+// KEYS[1] -> asynq_learn:{<qname>}:expiring
+// KEYS[2] -> asynq_learn:{<qname>}:archived
+// KEYS[3] -> asynq_learn:{<qname>}:blocked
+// -------
+// ARGV[1] -> current time in unix time
+// ARGV[2] -> cutoff timestamp (e.g., 90 days ago)
+// ARGV[3] -> max number of tasks in archive (e.g., 100)
+// ARGV[4] -> task key prefix
+// ARGV[5] -> queue key prefix
+// ARGV[6] -> completion channel prefix
+// ARGV[7+3*i] -> task ID of the i-th task
+// ARGV[8+3*i] -> task message data of the i-th task read by listExpiredTasksCmd
+// ARGV[9+3*i] -> updated task message data of the i-th task
+//
+// Output:
+// Returns a table containing the ID and the type of each archived task (see taskEventsLua).
+//
+// Note: A task is archived only if its message is unchanged since it was listed,
+// otherwise it is left to the next run.
+// Tasks waiting for an archived task are added to the blocked set.
+var archiveExpiredTasksCmd = redis.NewScript(pendingLua + blockDependentsLua + taskEventsLua + `
+local archived = {}
+for i = 7, table.getn(ARGV), 3 do
+	local id = ARGV[i]
+	local key = ARGV[4] .. id
+	local msg, state = unpack(redis.call("HMGET", key, "msg", "state"))
+	if msg == ARGV[i + 1] then
+		local removed = 0
+		if state == "pending" then
+			removed = pending_remove(ARGV[5], id)
+		elseif state == "scheduled" or state == "retry" then
+			removed = redis.call("ZREM", ARGV[5] .. state, id)
+		end
+		if removed == 1 then
+			redis.call("ZADD", KEYS[2], ARGV[1], id)
+			redis.call("HSET", key, "msg", ARGV[i + 2], "state", "archived")
+			redis.call("HDEL", key, "pending_since")
+			block_dependents(key, KEYS[3])
+			redis.call("ZREM", KEYS[1], id)
+			redis.call("PUBLISH", ARGV[6] .. id, id)
+			add_task_event(archived, key, id)
+		end
+	end
+end
+if #archived > 0 then
+	redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[2])
+	redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -ARGV[3])
+end
+return archived`)
+
+// ArchiveExpiredTasks checks for any pending, scheduled or retry tasks in the given queue whose
+// expiration time has passed, and archives them with base.ExpiredErrMsg as their error message.
+func (r *RDB) ArchiveExpiredTasks(qname string) error {
+	// Note: Do this operation in fix batches to prevent long running script.
+	const batchSize = 100
+	for {
+		n, done, err := r.archiveExpiredTasks(qname, batchSize)
+		if err != nil {
+			return err
+		}
+		// Note: Tasks whose message changed since they were listed stay in the expiring set,
+		// so stop once a batch makes no progress instead of listing them again.
+		if n < batchSize || done == 0 {
+			return nil
+		}
+	}
+}
+
+// archiveExpiredTasks runs the lua scripts to archive expired tasks with the specified
+// batch size. It reports the number of tasks inspected, and the number of tasks
+// archived or removed from the expiring set.
+func (r *RDB) archiveExpiredTasks(qname string, batchSize int) (int, int, error) {
+	var op errors.Op = "rdb.ArchiveExpiredTasks"
+	ctx := context.Background()
+	now := r.clock.Now()
+	res, err := listExpiredTasksCmd.Run(ctx, r.client, []string{base.ExpiringKey(qname)},
+		now.Unix(), base.TaskKeyPrefix(qname), batchSize).Result()
+	if err != nil {
+		return 0, 0, errors.E(op, errors.Internal, fmt.Sprintf("redis eval error: %v", err))
+	}
+	data, err := cast.ToSliceE(res)
+	if err != nil || len(data) == 0 {
+		return 0, 0, errors.E(op, errors.Internal, fmt.Sprintf("unexpected return value from Lua script: %v", res))
+	}
+	n := cast.ToInt(data[0])
+	// Tasks inspected but not returned were removed from the expiring set.
+	removed := n - (len(data) - 1)
+	if len(data) == 1 {
+		return n, removed, nil
+	}
+	cutoff := now.AddDate(0, 0, -archivedExpirationInDays)
+	argv := []interface{}{
+		now.Unix(),
+		cutoff.Unix(),
+		maxArchiveSize,
+		base.TaskKeyPrefix(qname),
+		base.QueueKeyPrefix(qname),
+		base.CompletionChannelPrefix(qname),
+	}
+	for _, v := range data[1:] {
+		encoded := cast.ToString(v)
+		msg, err := base.DecodeMessage([]byte(encoded))
+		if err != nil {
+			return 0, 0, errors.E(op, errors.Internal, fmt.Sprintf("cannot decode message: %v", err))
+		}
+		msg.ErrorMsg = base.ExpiredErrMsg
+		msg.LastFailedAt = now.Unix()
+		updated, err := base.EncodeMessage(msg)
+		if err != nil {
+			return 0, 0, errors.E(op, errors.Internal, fmt.Sprintf("cannot encode message: %v", err))
+		}
+		argv = append(argv, msg.ID, encoded, updated)
+	}
+	keys := []string{
+		base.ExpiringKey(qname),
+		base.ArchivedKey(qname),
+		base.BlockedKey(qname),
+	}
+	res, err = archiveExpiredTasksCmd.Run(ctx, r.client, keys, argv...).Result()
+	if err != nil {
+		return 0, 0, errors.E(op, errors.Internal, fmt.Sprintf("redis eval error: %v", err))
+	}
+	events := r.taskEvents(base.TaskEventArchived, qname, res)
+	r.recordEvents(ctx, events)
+	return n, removed + len(events), nil
+}
+
+// KEYS[1] -> asynq_learn:{<qname>}:ratelimit
+// -------
+// ARGV[1] -> rate limit in tokens per second
+// ARGV[2] -> bucket size (i.e. burst)
+//
+// Output:
+// Returns 0 if a token was taken from the bucket.
+// Returns the number of msec until a token is available if the bucket is empty.
+//
+// Note: The bucket is refilled lazily based on the time of the redis server so that
+// all servers share the same clock, and the key expires once the bucket would be full again.
+var takeRateLimitTokenCmd = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * limit / 1000)
+	ts = now
+end
+if tokens < 1 then
+	return math.ceil((1 - tokens) * 1000 / limit)
+end
+tokens = tokens - 1
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(ts))
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) * 1000 / limit) + 1000)
+return 0`)
+
+// KEYS[1] -> asynq_learn:{<qname>}:ratelimit
+// -------
+// ARGV[1] -> bucket size (i.e. burst)
+//
+// Note: Nothing is done if the key has expired since the bucket is full then.
+var refundRateLimitTokenCmd = redis.NewScript(`
+local tokens = tonumber(redis.call("HGET", KEYS[1], "tokens"))
+if tokens then
+	redis.call("HSET", KEYS[1], "tokens", tostring(math.min(tonumber(ARGV[1]), tokens + 1)))
+end
+return redis.status_reply("OK")`)
+
+// runRateLimitScript runs the script against the token bucket of each of the given queues
+// in a single round trip, and returns the script calls by queue name.
+func (r *RDB) runRateLimitScript(ctx context.Context, script *redis.Script, limits map[string]base.RateLimit, argv func(rl base.RateLimit) []interface{}) (map[string]*redis.Cmd, error) {
+	exec := func() (map[string]*redis.Cmd, error) {
+		cmds := make(map[string]*redis.Cmd, len(limits))
+		_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for qname, rl := range limits {
+				keys := []string{base.RateLimitKey(qname)}
+				cmds[qname] = script.EvalSha(ctx, pipe, keys, argv(rl)...)
+			}
+			return nil
+		})
+		return cmds, err
+	}
+	cmds, err := exec()
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT ") {
+		// The script is not cached in redis yet; load it and run the pipeline again.
+		// None of the script calls were run since all of them failed with NOSCRIPT.
+		if err := script.Load(ctx, r.client).Err(); err != nil {
+			return nil, &errors.RedisCommandError{Command: "script load", Err: err}
+		}
+		cmds, err = exec()
+	}
+	return cmds, err
+}
+
+// TakeRateLimitTokens takes a token from the token bucket of each of the given queues
+// which has one, and returns the duration until a token is available for the others.
+// A zero duration means a token was taken from the bucket of the queue.
+//
+// Each bucket is updated atomically using the time of the redis server, so servers
+// sharing a queue never take more tokens than the limit allows.
+func (r *RDB) TakeRateLimitTokens(limits map[string]base.RateLimit) (map[string]time.Duration, error) {
+	var op errors.Op = "rdb.TakeRateLimitTokens"
+	cmds, err := r.runRateLimitScript(context.Background(), takeRateLimitTokenCmd, limits, func(rl base.RateLimit) []interface{} {
+		return []interface{}{rl.Limit, rl.Burst}
+	})
+	if err != nil {
+		return nil, errors.E(op, errors.Unknown, fmt.Sprintf("redis eval error: %v", err))
+	}
+	res := make(map[string]time.Duration, len(limits))
+	for qname, cmd := range cmds {
+		n, err := cmd.Int64()
+		if err != nil {
+			return nil, errors.E(op, errors.Internal, fmt.Sprintf("unexpected return value from Lua script: %v", cmd.Val()))
+		}
+		res[qname] = time.Duration(n) * time.Millisecond
+	}
+	return res, nil
+}
+
+// RefundRateLimitTokens puts back a token taken from the token bucket of each of the given
+// queues. The bucket never holds more than the burst size.
+func (r *RDB) RefundRateLimitTokens(limits map[string]base.RateLimit) error {
+	var op errors.Op = "rdb.RefundRateLimitTokens"
+	_, err := r.runRateLimitScript(context.Background(), refundRateLimitTokenCmd, limits, func(rl base.RateLimit) []interface{} {
+		return []interface{}{rl.Burst}
+	})
+	if err != nil {
+		return errors.E(op, errors.Unknown, fmt.Sprintf("redis eval error: %v", err))
+	}
+	return nil
+}
+
 // KEYS[1] -> asynq_learn:{<qname>}:lease
 // ARGV[1] -> cutoff in unix time
 // ARGV[2] -> task key prefix
@@ -1464,16 +3304,268 @@ func (r *RDB) ClearSchedulerEntries(scheduelrID string) error {
 	return nil
 }
 
-// CancelationPubSub returns a pubsub for cancelation messages.
-func (r *RDB) CancelationPubSub() (*redis.PubSub, error) {
-	var op errors.Op = "rdb.CancelationPubSub"
+// SubscribeCancelation subscribes to cancelation messages.
+func (r *RDB) SubscribeCancelation() (base.CancelationSubscription, error) {
+	var op errors.Op = "rdb.SubscribeCancelation"
 	ctx := context.Background()
 	pubsub := r.client.Subscribe(ctx, base.CancelChannel)
 	_, err := pubsub.Receive(ctx)
 	if err != nil {
+		pubsub.Close()
+		return nil, errors.E(op, errors.Unknown, fmt.Sprintf("redis pubsub receive error: %v", err))
+	}
+	sub := newPubSubSubscription(pubsub, func(msg *redis.Message) string { return msg.Payload })
+	go sub.run()
+	return sub, nil
+}
+
+// SubscribeServerConfig subscribes to the configuration updates published to the given server.
+// Messages which cannot be decoded are ignored.
+func (r *RDB) SubscribeServerConfig(serverID string) (base.ServerConfigSubscription, error) {
+	var op errors.Op = "rdb.SubscribeServerConfig"
+	ctx := context.Background()
+	pubsub := r.client.Subscribe(ctx, base.ServerConfigChannel(serverID))
+	_, err := pubsub.Receive(ctx)
+	if err != nil {
+		pubsub.Close()
+		return nil, errors.E(op, errors.Unknown, fmt.Sprintf("redis pubsub receive error: %v", err))
+	}
+	inner := newPubSubSubscription(pubsub, func(msg *redis.Message) string { return msg.Payload })
+	go inner.run()
+	sub := &serverConfigSubscription{pubsubSubscription: inner, ch: make(chan *base.ServerConfigUpdate)}
+	go sub.run()
+	return sub, nil
+}
+
+// PublishServerConfig publishes the configuration update to the given server.
+// It returns the number of subscribers which received the update.
+func (r *RDB) PublishServerConfig(serverID string, update *base.ServerConfigUpdate) (int, error) {
+	var op errors.Op = "rdb.PublishServerConfig"
+	encoded, err := base.EncodeServerConfigUpdate(update)
+	if err != nil {
+		return 0, errors.E(op, errors.Unknown, fmt.Sprintf("cannot encode server config update: %v", err))
+	}
+	n, err := r.client.Publish(context.Background(), base.ServerConfigChannel(serverID), encoded).Result()
+	if err != nil {
+		return 0, errors.E(op, errors.Unknown, fmt.Sprintf("redis pubsub publish error: %v", err))
+	}
+	return int(n), nil
+}
+
+// serverConfigSubscription decodes the configuration updates received by the embedded subscription.
+type serverConfigSubscription struct {
+	*pubsubSubscription
+	ch chan *base.ServerConfigUpdate
+}
+
+func (s *serverConfigSubscription) run() {
+	defer close(s.ch)
+	for payload := range s.pubsubSubscription.Channel() {
+		update, err := base.DecodeServerConfigUpdate([]byte(payload))
+		if err != nil {
+			continue
+		}
+		select {
+		case s.ch <- update:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *serverConfigSubscription) Channel() <-chan *base.ServerConfigUpdate {
+	return s.ch
+}
+
+// SubscribeWakeup subscribes to the notifications published when tasks are added
+// to the pending list of the given queues.
+func (r *RDB) SubscribeWakeup(qnames ...string) (base.WakeupSubscription, error) {
+	var op errors.Op = "rdb.SubscribeWakeup"
+	return r.subscribeQueues(op, base.WakeupChannel, qnames)
+}
+
+// SubscribeCompletion subscribes to the notification published when the given task is completed or archived.
+func (r *RDB) SubscribeCompletion(qname, id string) (base.CompletionSubscription, error) {
+	var op errors.Op = "rdb.SubscribeCompletion"
+	ctx := context.Background()
+	pubsub := r.client.Subscribe(ctx, base.CompletionChannel(qname, id))
+	_, err := pubsub.Receive(ctx)
+	if err != nil {
+		pubsub.Close()
 		return nil, errors.E(op, errors.Unknown, fmt.Sprintf("redis pubsub receive error: %v", err))
 	}
-	return pubsub, nil
+	sub := newPubSubSubscription(pubsub, func(msg *redis.Message) string { return msg.Payload })
+	go sub.run()
+	return sub, nil
+}
+
+// maxTaskEvents is the approximate maximum number of events kept in the task event stream.
+const maxTaskEvents = 10000
+
+// RecordEvent appends the task lifecycle event to the event stream and sets ev.ID
+// to the ID of the stream entry. The stream is trimmed to approximately maxTaskEvents entries.
+func (r *RDB) RecordEvent(ev *base.TaskEvent) error {
+	var op errors.Op = "rdb.RecordEvent"
+	id, err := r.client.XAdd(context.Background(), eventArgs(ev)).Result()
+	if err != nil {
+		return errors.E(op, errors.Unknown, &errors.RedisCommandError{Command: "xadd", Err: err})
+	}
+	ev.ID = id
+	return nil
+}
+
+// eventArgs returns the arguments to append the task lifecycle event to the event stream.
+func eventArgs(ev *base.TaskEvent) *redis.XAddArgs {
+	return &redis.XAddArgs{
+		Stream: base.AllEvents,
+		MaxLen: maxTaskEvents,
+		Approx: true,
+		Values: []interface{}{
+			"kind", string(ev.Kind),
+			"task_id", ev.TaskID,
+			"queue", ev.Queue,
+			"type", ev.Type,
+			"server_id", ev.ServerID,
+			"time", ev.Time.UnixNano(),
+		},
+	}
+}
+
+// taskEvents returns the events of the given kind for the tasks in the given queue,
+// given the task ID and type pairs collected by a script (see taskEventsLua).
+func (r *RDB) taskEvents(kind base.TaskEventKind, qname string, pairs interface{}) []*base.TaskEvent {
+	vals := cast.ToSlice(pairs)
+	now := r.clock.Now()
+	events := make([]*base.TaskEvent, 0, len(vals)/2)
+	for i := 0; i+1 < len(vals); i += 2 {
+		events = append(events, &base.TaskEvent{
+			Kind:   kind,
+			TaskID: cast.ToString(vals[i]),
+			Queue:  qname,
+			Type:   cast.ToString(vals[i+1]),
+			Time:   now,
+		})
+	}
+	return events
+}
+
+// recordTaskEvents records the events of the given kind for the tasks in the given queue,
+// given the task ID and type pairs collected by a script, and returns the number of tasks.
+func (r *RDB) recordTaskEvents(kind base.TaskEventKind, qname string, pairs interface{}) int64 {
+	events := r.taskEvents(kind, qname, pairs)
+	r.recordEvents(context.Background(), events)
+	return int64(len(events))
+}
+
+// recordEvents appends the events of the tasks whose state was changed by a script
+// to the event stream, using a single round trip.
+//
+// The tasks have already changed state, so a failure to record the events is ignored;
+// the event stream is best-effort (see base.AllEvents).
+func (r *RDB) recordEvents(ctx context.Context, events []*base.TaskEvent) {
+	if len(events) == 0 {
+		return
+	}
+	r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, ev := range events {
+			pipe.XAdd(ctx, eventArgs(ev))
+		}
+		return nil
+	})
+}
+
+// subscribeQueues subscribes to the pubsub channels of the given queues, which are
+// named by channelFn, and returns a subscription delivering the names of the notified queues.
+func (r *RDB) subscribeQueues(op errors.Op, channelFn func(qname string) string, qnames []string) (base.WakeupSubscription, error) {
+	ctx := context.Background()
+	channels := make([]string, len(qnames))
+	queueOf := make(map[string]string, len(qnames))
+	for i, qname := range qnames {
+		channels[i] = channelFn(qname)
+		queueOf[channels[i]] = qname
+	}
+	pubsub := r.client.Subscribe(ctx, channels...)
+	// There is no subscription confirmation to wait for if there are no queues,
+	// e.g. when the queue patterns of a server match no queues yet.
+	if len(channels) > 0 {
+		if _, err := pubsub.Receive(ctx); err != nil {
+			pubsub.Close()
+			return nil, errors.E(op, errors.Unknown, fmt.Sprintf("redis pubsub receive error: %v", err))
+		}
+	}
+	sub := newPubSubSubscription(pubsub, func(msg *redis.Message) string { return queueOf[msg.Channel] })
+	go sub.run()
+	return sub, nil
+}
+
+// pubsubSubscription delivers values extracted from messages received over redis pubsub.
+// It is used for cancelation, wakeup, schedule and server config subscriptions.
+type pubsubSubscription struct {
+	pubsub *redis.PubSub
+	value  func(*redis.Message) string
+	ch     chan string
+	done   chan struct{}
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+func newPubSubSubscription(pubsub *redis.PubSub, value func(*redis.Message) string) *pubsubSubscription {
+	return &pubsubSubscription{
+		pubsub: pubsub,
+		value:  value,
+		ch:     make(chan string),
+		done:   make(chan struct{}),
+	}
+}
+
+// run forwards values of the messages from the redis pubsub channel until the subscription is closed.
+func (s *pubsubSubscription) run() {
+	defer close(s.ch)
+	msgs := s.pubsub.Channel()
+	for {
+		select {
+		case <-s.done:
+			return
+		case msg, ok := <-msgs:
+			if !ok {
+				s.mu.Lock()
+				if !s.closed {
+					s.err = fmt.Errorf("redis pubsub channel closed unexpectedly")
+				}
+				s.mu.Unlock()
+				return
+			}
+			select {
+			case s.ch <- s.value(msg):
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+func (s *pubsubSubscription) Channel() <-chan string {
+	return s.ch
+}
+
+func (s *pubsubSubscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *pubsubSubscription) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.mu.Unlock()
+	return s.pubsub.Close()
 }
 
 // PublishCancelation publish cancelation message to all subscribers.
@@ -8,6 +8,7 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"math"
 	"strconv"
 	"strings"
@@ -254,6 +255,77 @@ func TestEnqueueUnique(t *testing.T) {
 	}
 }
 
+func TestEnqueueBatch(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	existing := h.NewTaskMessage("existing", nil)
+	h.SeedPendingQueue(t, r.client, []*base.TaskMessage{existing}, base.DefaultQueueName)
+	// Make sure that the script is loaded on demand.
+	if err := r.client.ScriptFlush(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	m1 := h.NewTaskMessage("task1", nil)
+	m2 := h.NewTaskMessageWithQueue("task2", nil, "low")
+	m3 := h.NewTaskMessage("task3", []byte("payload"))
+	m3.UniqueKey = base.UniqueKey(m3.Queue, m3.Type, m3.Payload)
+	m4 := h.NewTaskMessage("task3", []byte("payload"))
+	m4.UniqueKey = m3.UniqueKey
+	m5 := h.NewTaskMessage("task5", nil)
+	m5.ID = existing.ID
+	processAt := time.Now().Add(time.Hour)
+	msgs := []*base.BatchMessage{
+		{Msg: m1},
+		{Msg: m2, ProcessAt: processAt},
+		{Msg: m3, UniqueTTL: time.Hour},
+		{Msg: m4, UniqueTTL: time.Hour},
+		{Msg: m5},
+	}
+
+	errs, err := r.EnqueueBatch(ctx, msgs)
+	if err != nil {
+		t.Fatalf("EnqueueBatch returned error: %v", err)
+	}
+	if len(errs) != len(msgs) {
+		t.Fatalf("EnqueueBatch returned %d errors, want %d", len(errs), len(msgs))
+	}
+	for i, want := range []error{nil, nil, nil, errors.ErrDuplicateTask, errors.ErrTaskIdConflict} {
+		if want == nil && errs[i] != nil {
+			t.Errorf("errs[%d] = %v, want nil", i, errs[i])
+		}
+		if want != nil && !errors.Is(errs[i], want) {
+			t.Errorf("errs[%d] = %v, want %v", i, errs[i], want)
+		}
+	}
+
+	wantPending := []*base.TaskMessage{existing, m1, m3}
+	if diff := cmp.Diff(wantPending, h.GetPendingMessages(t, r.client, base.DefaultQueueName), h.SortMsgOpt); diff != "" {
+		t.Errorf("mismatch found in pending list; (-want,+got)\n%s", diff)
+	}
+	wantScheduled := []base.Z{{Message: m2, Score: base.ProcessAtScore(processAt)}}
+	if diff := cmp.Diff(wantScheduled, h.GetScheduledEntries(t, r.client, "low")); diff != "" {
+		t.Errorf("mismatch found in scheduled set of queue %q; (-want,+got)\n%s", "low", diff)
+	}
+	for _, qname := range []string{base.DefaultQueueName, "low"} {
+		if !r.client.SIsMember(ctx, base.AllQueues, qname).Val() {
+			t.Errorf("%q is not a member of SET %q", qname, base.AllQueues)
+		}
+	}
+	if got := r.client.Get(ctx, m3.UniqueKey).Val(); got != m3.ID {
+		t.Errorf("unique key %q holds %q, want %q", m3.UniqueKey, got, m3.ID)
+	}
+	if got := r.client.HGet(ctx, base.TaskKey(m3.Queue, m3.ID), "unique_key").Val(); got != m3.UniqueKey {
+		t.Errorf("unique_key field of task %q is %q, want %q", m3.ID, got, m3.UniqueKey)
+	}
+	if got := r.client.HGet(ctx, base.TaskKey(m2.Queue, m2.ID), "state").Val(); got != "scheduled" {
+		t.Errorf("state field of task %q is %q, want %q", m2.ID, got, "scheduled")
+	}
+	if n := r.client.Exists(ctx, base.TaskKey(m4.Queue, m4.ID)).Val(); n != 0 {
+		t.Errorf("task key of duplicate task %q exists", m4.ID)
+	}
+}
+
 func TestEnqueueUniqueTaskIdConflictError(t *testing.T) {
 	r := setup(t)
 	defer r.Close()
@@ -405,7 +477,7 @@ func TestDequeue(t *testing.T) {
 		h.FlushDB(t, r.client) // clean up db before each test case
 		h.SeedAllPendingQueues(t, r.client, tc.pending)
 
-		gotMsg, gotExpirationTime, err := r.Dequeue(tc.qnames...)
+		gotMsg, gotExpirationTime, _, err := r.Dequeue(tc.qnames...)
 		if err != nil {
 			t.Errorf("(*RDB).Dequeue(%v) returned error %v", tc.qnames, err)
 			continue
@@ -499,7 +571,7 @@ func TestDequeueError(t *testing.T) {
 		h.FlushDB(t, r.client) // clean up db before each test case
 		h.SeedAllPendingQueues(t, r.client, tc.pending)
 
-		gotMsg, _, gotErr := r.Dequeue(tc.qnames...)
+		gotMsg, _, _, gotErr := r.Dequeue(tc.qnames...)
 		if !errors.Is(gotErr, tc.wantErr) {
 			t.Errorf("(*RDB).Dequeue(%v) returned error %v; want %v",
 				tc.qnames, gotErr, tc.wantErr)
@@ -622,7 +694,7 @@ func TestDequeueIgnoresPausedQueues(t *testing.T) {
 		}
 		h.SeedAllPendingQueues(t, r.client, tc.pending)
 
-		got, _, err := r.Dequeue(tc.qnames...)
+		got, _, _, err := r.Dequeue(tc.qnames...)
 		if !cmp.Equal(got, tc.wantMsg) || !errors.Is(err, tc.wantErr) {
 			t.Errorf("Dequeue(%v) = %v, %v; want %v, %v",
 				tc.qnames, got, err, tc.wantMsg, tc.wantErr)
@@ -644,6 +716,102 @@ func TestDequeueIgnoresPausedQueues(t *testing.T) {
 	}
 }
 
+func TestDequeueExcept(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	longType := strings.Repeat("x", 200) // type whose length is encoded in multiple bytes
+	t1 := h.NewTaskMessageWithQueue("send_email", nil, "default")
+	t2 := h.NewTaskMessageWithQueue("export_csv", nil, "default")
+	t3 := h.NewTaskMessageWithQueue(longType, nil, "default")
+	t4 := h.NewTaskMessageWithQueue("send_email", nil, "critical")
+
+	tests := []struct {
+		desc        string
+		pending     map[string][]*base.TaskMessage
+		excluded    []string
+		qnames      []string
+		wantMsg     *base.TaskMessage
+		wantErr     error
+		wantPending map[string][]*base.TaskMessage
+	}{
+		{
+			desc: "skips tasks of excluded type",
+			pending: map[string][]*base.TaskMessage{
+				"default": {t1, t2},
+			},
+			excluded: []string{"send_email"},
+			qnames:   []string{"default"},
+			wantMsg:  t2,
+			wantPending: map[string][]*base.TaskMessage{
+				"default": {t1},
+			},
+		},
+		{
+			desc: "skips tasks of long excluded type",
+			pending: map[string][]*base.TaskMessage{
+				"default": {t3, t1},
+			},
+			excluded: []string{longType},
+			qnames:   []string{"default"},
+			wantMsg:  t1,
+			wantPending: map[string][]*base.TaskMessage{
+				"default": {t3},
+			},
+		},
+		{
+			desc: "moves on to next queue",
+			pending: map[string][]*base.TaskMessage{
+				"critical": {t4},
+				"default":  {t1, t2},
+			},
+			excluded: []string{"send_email"},
+			qnames:   []string{"critical", "default"},
+			wantMsg:  t2,
+			wantPending: map[string][]*base.TaskMessage{
+				"critical": {t4},
+				"default":  {t1},
+			},
+		},
+		{
+			desc: "all tasks are excluded",
+			pending: map[string][]*base.TaskMessage{
+				"default": {t1, t3},
+			},
+			excluded: []string{"send_email", longType},
+			qnames:   []string{"default"},
+			wantMsg:  nil,
+			wantErr:  errors.ErrNoProcessableTask,
+			wantPending: map[string][]*base.TaskMessage{
+				"default": {t1, t3},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client) // clean up db before each test case
+		h.SeedAllPendingQueues(t, r.client, tc.pending)
+
+		got, _, _, err := r.DequeueExcept(tc.excluded, tc.qnames...)
+		if !cmp.Equal(got, tc.wantMsg) || !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: DequeueExcept(%v, %v) = %v, %v; want %v, %v",
+				tc.desc, tc.excluded, tc.qnames, got, err, tc.wantMsg, tc.wantErr)
+			continue
+		}
+		if got != nil {
+			gotActive := h.GetActiveMessages(t, r.client, got.Queue)
+			if diff := cmp.Diff([]*base.TaskMessage{got}, gotActive); diff != "" {
+				t.Errorf("%s: mismatch found in %q: (-want,+got):\n%s", tc.desc, base.ActiveKey(got.Queue), diff)
+			}
+		}
+		for queue, want := range tc.wantPending {
+			gotPending := h.GetPendingMessages(t, r.client, queue)
+			if diff := cmp.Diff(want, gotPending, h.SortMsgOpt); diff != "" {
+				t.Errorf("%s: mismatch found in %q: (-want,+got):\n%s", tc.desc, base.PendingKey(queue), diff)
+			}
+		}
+	}
+}
+
 func TestDone(t *testing.T) {
 	r := setup(t)
 	defer r.Close()
@@ -1432,9 +1600,9 @@ func TestSchedule(t *testing.T) {
 			t.Errorf("Redis ZSET %q member: got %v, want %v", scheduledKey, got, tc.msg.ID)
 			continue
 		}
-		if got := int64(zs[0].Score); got != tc.processAt.Unix() {
+		if got := int64(zs[0].Score); got != base.ProcessAtScore(tc.processAt) {
 			t.Errorf("Redis ZSET %q score: got %d, want %d",
-				scheduledKey, got, tc.processAt.Unix())
+				scheduledKey, got, base.ProcessAtScore(tc.processAt))
 			continue
 		}
 
@@ -1540,9 +1708,9 @@ func TestScheduleUnique(t *testing.T) {
 				scheduledKey, got, tc.msg.ID)
 			continue
 		}
-		if got := int64(zs[0].Score); got != tc.processAt.Unix() {
+		if got := int64(zs[0].Score); got != base.ProcessAtScore(tc.processAt) {
 			t.Errorf("Redis ZSET %q score: got %d, want %d",
-				scheduledKey, got, tc.processAt.Unix())
+				scheduledKey, got, base.ProcessAtScore(tc.processAt))
 			continue
 		}
 
@@ -1624,6 +1792,92 @@ func TestScheduleUniqueTaskIdConflictError(t *testing.T) {
 	}
 }
 
+func newDebounceMessage(payload string) *base.TaskMessage {
+	msg := h.NewTaskMessage("reindex", []byte(payload))
+	msg.UniqueKey = base.DebounceKey(msg.Queue, "user:123")
+	return msg
+}
+
+func TestDebounce(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	h.FlushDB(t, r.client)
+	ctx := context.Background()
+	now := time.Now()
+
+	m1 := newDebounceMessage("v1")
+	id, err := r.Debounce(ctx, m1, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Debounce returned error: %v", err)
+	}
+	if id != m1.ID {
+		t.Errorf("Debounce returned %q, want %q", id, m1.ID)
+	}
+
+	// A debounce while the task is scheduled replaces its message and pushes out its process time.
+	m2 := newDebounceMessage("v2")
+	id, err = r.Debounce(ctx, m2, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("Debounce returned error: %v", err)
+	}
+	if id != m1.ID {
+		t.Errorf("Debounce returned %q, want %q", id, m1.ID)
+	}
+	want := *m2
+	want.ID = m1.ID
+	wantScheduled := []base.Z{{Message: &want, Score: base.ProcessAtScore(now.Add(2 * time.Minute))}}
+	if diff := cmp.Diff(wantScheduled, h.GetScheduledEntries(t, r.client, base.DefaultQueueName), h.SortZSetEntryOpt); diff != "" {
+		t.Errorf("mismatch found in %q; (-want,+got)\n%s", base.ScheduledKey(base.DefaultQueueName), diff)
+	}
+	// The debounce key expires a while after the pushed out process time.
+	wantTTL := 2*time.Minute + debounceKeyExpiration
+	if ttl := r.client.TTL(ctx, m1.UniqueKey).Val(); ttl < wantTTL-5*time.Second || ttl > wantTTL {
+		t.Errorf("TTL of %q = %v, want %v", m1.UniqueKey, ttl, wantTTL)
+	}
+
+	// A debounce while the task is pending moves it back to the scheduled set.
+	r.client.ZAdd(ctx, base.ScheduledKey(base.DefaultQueueName), &redis.Z{Member: m1.ID, Score: float64(base.ProcessAtScore(now.Add(-time.Second)))})
+	if err := r.ForwardIfReady(base.DefaultQueueName); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(h.GetPendingMessages(t, r.client, base.DefaultQueueName)); n != 1 {
+		t.Fatalf("%q has %d tasks, want 1", base.PendingKey(base.DefaultQueueName), n)
+	}
+	m3 := newDebounceMessage("v3")
+	id, err = r.Debounce(ctx, m3, now.Add(3*time.Minute))
+	if err != nil {
+		t.Fatalf("Debounce returned error: %v", err)
+	}
+	if id != m1.ID {
+		t.Errorf("Debounce returned %q, want %q", id, m1.ID)
+	}
+	if n := len(h.GetPendingMessages(t, r.client, base.DefaultQueueName)); n != 0 {
+		t.Errorf("%q has %d tasks, want 0", base.PendingKey(base.DefaultQueueName), n)
+	}
+	want = *m3
+	want.ID = m1.ID
+	wantScheduled = []base.Z{{Message: &want, Score: base.ProcessAtScore(now.Add(3 * time.Minute))}}
+	if diff := cmp.Diff(wantScheduled, h.GetScheduledEntries(t, r.client, base.DefaultQueueName), h.SortZSetEntryOpt); diff != "" {
+		t.Errorf("mismatch found in %q; (-want,+got)\n%s", base.ScheduledKey(base.DefaultQueueName), diff)
+	}
+
+	// Once the task is no longer pending or scheduled, a debounce schedules a new task.
+	if err := r.DeleteTask(base.DefaultQueueName, m1.ID); err != nil {
+		t.Fatal(err)
+	}
+	m4 := newDebounceMessage("v4")
+	id, err = r.Debounce(ctx, m4, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Debounce returned error: %v", err)
+	}
+	if id != m4.ID {
+		t.Errorf("Debounce returned %q, want %q", id, m4.ID)
+	}
+	if got := r.client.Get(ctx, m4.UniqueKey).Val(); got != m4.ID {
+		t.Errorf("debounce key %q is held by %q, want %q", m4.UniqueKey, got, m4.ID)
+	}
+}
+
 func TestRetry(t *testing.T) {
 	r := setup(t)
 	defer r.Close()
@@ -1692,7 +1946,7 @@ func TestRetry(t *testing.T) {
 			},
 			wantRetry: map[string][]base.Z{
 				"default": {
-					{Message: h.TaskMessageAfterRetry(*t1, errMsg, now), Score: now.Add(5 * time.Minute).Unix()},
+					{Message: h.TaskMessageAfterRetry(*t1, errMsg, now), Score: base.ProcessAtScore(now.Add(5 * time.Minute))},
 					{Message: t3, Score: now.Add(time.Minute).Unix()},
 				},
 			},
@@ -1724,7 +1978,7 @@ func TestRetry(t *testing.T) {
 			wantRetry: map[string][]base.Z{
 				"default": {},
 				"custom": {
-					{Message: h.TaskMessageAfterRetry(*t4, errMsg, now), Score: now.Add(5 * time.Minute).Unix()},
+					{Message: h.TaskMessageAfterRetry(*t4, errMsg, now), Score: base.ProcessAtScore(now.Add(5 * time.Minute))},
 				},
 			},
 		},
@@ -1864,7 +2118,7 @@ func TestRetryWithNonFailureError(t *testing.T) {
 			wantRetry: map[string][]base.Z{
 				"default": {
 					// Task message should include the error message but without incrementing the retry count.
-					{Message: h.TaskMessageWithError(*t1, errMsg, now), Score: now.Add(5 * time.Minute).Unix()},
+					{Message: h.TaskMessageWithError(*t1, errMsg, now), Score: base.ProcessAtScore(now.Add(5 * time.Minute))},
 					{Message: t3, Score: now.Add(time.Minute).Unix()},
 				},
 			},
@@ -1897,7 +2151,7 @@ func TestRetryWithNonFailureError(t *testing.T) {
 				"default": {},
 				"custom": {
 					// Task message should include the error message but without incrementing the retry count.
-					{Message: h.TaskMessageWithError(*t4, errMsg, now), Score: now.Add(5 * time.Minute).Unix()},
+					{Message: h.TaskMessageWithError(*t4, errMsg, now), Score: base.ProcessAtScore(now.Add(5 * time.Minute))},
 				},
 			},
 		},
@@ -2174,6 +2428,42 @@ func TestArchive(t *testing.T) {
 	}
 }
 
+func TestForwardIfReadyWithMillisecondScores(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	h.FlushDB(t, r.client)
+	now := time.Now()
+	r.SetClock(timeutil.NewSimulatedClock(now))
+	t1 := h.NewTaskMessage("send_email", nil)
+	t2 := h.NewTaskMessage("generate_csv", nil)
+	t3 := h.NewTaskMessage("gen_thumbnail", nil)
+	t4 := h.NewTaskMessage("reindex", nil)
+
+	h.SeedScheduledQueue(t, r.client, []base.Z{
+		{Message: t1, Score: base.ProcessAtScore(now.Add(-100 * time.Millisecond))},
+		{Message: t2, Score: base.ProcessAtScore(now.Add(100 * time.Millisecond))},
+		// Score written in seconds before millisecond scores were introduced.
+		{Message: t3, Score: now.Add(-time.Second).Unix()},
+	}, "default")
+	h.SeedRetryQueue(t, r.client, []base.Z{
+		{Message: t4, Score: base.ProcessAtScore(now.Add(-time.Millisecond))},
+	}, "default")
+
+	// Legacy scores and millisecond scores are forwarded in separate batches.
+	for i := 0; i < 2; i++ {
+		if err := r.ForwardIfReady("default"); err != nil {
+			t.Fatalf("(*RDB).ForwardIfReady() returned an error: %v", err)
+		}
+	}
+
+	if diff := cmp.Diff([]*base.TaskMessage{t1, t3, t4}, h.GetPendingMessages(t, r.client, "default"), h.SortMsgOpt); diff != "" {
+		t.Errorf("mismatch found in pending list; (-want,+got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]*base.TaskMessage{t2}, h.GetScheduledMessages(t, r.client, "default"), h.SortMsgOpt); diff != "" {
+		t.Errorf("mismatch found in scheduled set; (-want,+got)\n%s", diff)
+	}
+}
+
 func TestForwardIfReadyWithGroup(t *testing.T) {
 	r := setup(t)
 	defer r.Close()
@@ -2478,6 +2768,72 @@ func newCompletedTask(qname, typename string, payload []byte, completedAt time.T
 	return msg
 }
 
+func TestRateLimitToken(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+
+	// The buckets are refilled based on the time of the redis server, so the waits are checked in ranges.
+	take := func(qname string, rl base.RateLimit) time.Duration {
+		t.Helper()
+		waits, err := r.TakeRateLimitTokens(map[string]base.RateLimit{qname: rl})
+		if err != nil {
+			t.Fatalf("TakeRateLimitTokens returned error: %v", err)
+		}
+		wait, ok := waits[qname]
+		if !ok {
+			t.Fatalf("TakeRateLimitTokens returned no wait for queue %q", qname)
+		}
+		return wait
+	}
+	refund := func(qname string, rl base.RateLimit) {
+		t.Helper()
+		if err := r.RefundRateLimitTokens(map[string]base.RateLimit{qname: rl}); err != nil {
+			t.Fatalf("RefundRateLimitTokens returned error: %v", err)
+		}
+	}
+
+	emails := base.RateLimit{Limit: 1, Burst: 2}
+	for i := 0; i < 2; i++ {
+		if got := take("emails", emails); got != 0 {
+			t.Fatalf("take %d: TakeRateLimitTokens returned wait of %v, want 0", i, got)
+		}
+	}
+	if got := take("emails", emails); got <= 0 || got > time.Second {
+		t.Errorf("TakeRateLimitTokens on an empty bucket returned wait of %v, want in (0, 1s]", got)
+	}
+	// A refunded token can be taken again.
+	refund("emails", emails)
+	if got := take("emails", emails); got != 0 {
+		t.Errorf("TakeRateLimitTokens after a refund returned wait of %v, want 0", got)
+	}
+	// Refunds never fill the bucket beyond burst.
+	for i := 0; i < 3; i++ {
+		refund("emails", emails)
+	}
+	for i := 0; i < 2; i++ {
+		if got := take("emails", emails); got != 0 {
+			t.Fatalf("take %d after refunds: TakeRateLimitTokens returned wait of %v, want 0", i, got)
+		}
+	}
+	if got := take("emails", emails); got == 0 {
+		t.Errorf("TakeRateLimitTokens took more than burst tokens after refunds")
+	}
+
+	// The bucket is refilled over time.
+	sms := base.RateLimit{Limit: 50, Burst: 1}
+	if got := take("sms", sms); got != 0 {
+		t.Fatalf("TakeRateLimitTokens for a new queue returned wait of %v, want 0", got)
+	}
+	got := take("sms", sms)
+	if got <= 0 || got > 20*time.Millisecond {
+		t.Fatalf("TakeRateLimitTokens on an empty bucket returned wait of %v, want in (0, 20ms]", got)
+	}
+	time.Sleep(got + 10*time.Millisecond)
+	if got := take("sms", sms); got != 0 {
+		t.Errorf("TakeRateLimitTokens after the wait returned wait of %v, want 0", got)
+	}
+}
+
 func TestDeleteExpiredCompletedTasks(t *testing.T) {
 	r := setup(t)
 	defer r.Close()
@@ -3026,16 +3382,16 @@ func TestClearServerState(t *testing.T) {
 	}
 }
 
-func TestCancelationPubSub(t *testing.T) {
+func TestSubscribeCancelation(t *testing.T) {
 	r := setup(t)
 	defer r.Close()
 
-	pubsub, err := r.CancelationPubSub()
+	sub, err := r.SubscribeCancelation()
 	if err != nil {
-		t.Fatalf("(*RDB).CancelationPubSub() returned an error: %v", err)
+		t.Fatalf("(*RDB).SubscribeCancelation() returned an error: %v", err)
 	}
 
-	cancelCh := pubsub.Channel()
+	cancelCh := sub.Channel()
 
 	var (
 		mu       sync.Mutex
@@ -3043,9 +3399,9 @@ func TestCancelationPubSub(t *testing.T) {
 	)
 
 	go func() {
-		for msg := range cancelCh {
+		for id := range cancelCh {
 			mu.Lock()
-			received = append(received, msg.Payload)
+			received = append(received, id)
 			mu.Unlock()
 		}
 	}()
@@ -3059,7 +3415,12 @@ func TestCancelationPubSub(t *testing.T) {
 	// allow for message to reach subscribers.
 	time.Sleep(time.Second)
 
-	pubsub.Close()
+	if err := sub.Close(); err != nil {
+		t.Errorf("Close() returned an error: %v", err)
+	}
+	if err := sub.Err(); err != nil {
+		t.Errorf("Err() returned %v after Close, want nil", err)
+	}
 
 	mu.Lock()
 	if diff := cmp.Diff(publish, received, h.SortStringSliceOpt); diff != "" {
@@ -3068,76 +3429,393 @@ func TestCancelationPubSub(t *testing.T) {
 	mu.Unlock()
 }
 
-func TestWriteResult(t *testing.T) {
+func TestPublishServerConfig(t *testing.T) {
 	r := setup(t)
 	defer r.Close()
 
-	tests := []struct {
-		qname  string
-		taskID string
-		data   []byte
-	}{
-		{
-			qname:  "default",
-			taskID: uuid.NewString(),
-			data:   []byte("hello"),
-		},
+	sub, err := r.SubscribeServerConfig("server1")
+	if err != nil {
+		t.Fatalf("(*RDB).SubscribeServerConfig() returned an error: %v", err)
 	}
+	defer sub.Close()
 
-	for _, tc := range tests {
-		h.FlushDB(t, r.client)
-
-		n, err := r.WriteResult(tc.qname, tc.taskID, tc.data)
-		if err != nil {
-			t.Errorf("WriteResult failed: %v", err)
-			continue
-		}
-		if n != len(tc.data) {
-			t.Errorf("WriteResult returned %d, want %d", n, len(tc.data))
+	strict := true
+	update := &base.ServerConfigUpdate{
+		Concurrency:    4,
+		Queues:         map[string]int{"critical": 2, "default": 1},
+		StrictPriority: &strict,
+	}
+	n, err := r.PublishServerConfig("server1", update)
+	if err != nil {
+		t.Fatalf("(*RDB).PublishServerConfig() returned an error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("(*RDB).PublishServerConfig() = %d, want 1", n)
+	}
+	select {
+	case got := <-sub.Channel():
+		if diff := cmp.Diff(update, got); diff != "" {
+			t.Errorf("received server config update %+v, want %+v; (-want,+got)\n%s", got, update, diff)
 		}
+	case <-time.After(time.Second):
+		t.Error("received no server config update")
+	}
 
-		taskKey := base.TaskKey(tc.qname, tc.taskID)
-		got := r.client.HGet(context.Background(), taskKey, "result").Val()
-		if got != string(tc.data) {
-			t.Errorf("`result` field under %q key is set to %q, want %q", taskKey, got, string(tc.data))
-		}
+	// Updates for other servers are not received.
+	n, err = r.PublishServerConfig("server2", update)
+	if err != nil {
+		t.Fatalf("(*RDB).PublishServerConfig() returned an error: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("(*RDB).PublishServerConfig() for a server without subscribers = %d, want 0", n)
+	}
+	select {
+	case got := <-sub.Channel():
+		t.Errorf("received server config update %+v of another server", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := sub.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if _, ok := <-sub.Channel(); ok {
+		t.Error("channel is open after Close, want closed")
 	}
 }
 
-func TestAggregationCheck(t *testing.T) {
+func TestSubscribeWakeup(t *testing.T) {
 	r := setup(t)
 	defer r.Close()
+	h.FlushDB(t, r.client)
+	now := time.Now()
+	r.SetClock(timeutil.NewSimulatedClock(now))
+
+	sub, err := r.SubscribeWakeup("default", "critical")
+	if err != nil {
+		t.Fatalf("(*RDB).SubscribeWakeup() returned an error: %v", err)
+	}
+	defer sub.Close()
+
+	recv := func(desc, want string) {
+		t.Helper()
+		select {
+		case got := <-sub.Channel():
+			if got != want {
+				t.Errorf("%s: received wakeup of queue %q, want %q", desc, got, want)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: received no wakeup, want wakeup of queue %q", desc, want)
+		}
+	}
+
+	ctx := context.Background()
+	if err := r.Enqueue(ctx, h.NewTaskMessageWithQueue("task1", nil, "default")); err != nil {
+		t.Fatal(err)
+	}
+	recv("Enqueue", "default")
+
+	msg := h.NewTaskMessageWithQueue("task2", nil, "critical")
+	msg.UniqueKey = base.UniqueKey("critical", msg.Type, nil)
+	if err := r.EnqueueUnique(ctx, msg, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	recv("EnqueueUnique", "critical")
 
+	batch := []*base.BatchMessage{
+		{Msg: h.NewTaskMessageWithQueue("task3", nil, "default")},
+		{Msg: h.NewTaskMessageWithQueue("task4", nil, "default")},
+	}
+	if _, err := r.EnqueueBatch(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	recv("EnqueueBatch", "default")
+
+	if err := r.Schedule(ctx, h.NewTaskMessageWithQueue("task5", nil, "critical"), now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ForwardIfReady("critical"); err != nil {
+		t.Fatal(err)
+	}
+	recv("ForwardIfReady", "critical")
+
+	// Completing a task notifies the queue of the tasks moved to the pending list.
+	parent := h.NewTaskMessageWithQueue("parent", nil, "default")
+	h.SeedActiveQueue(t, r.client, []*base.TaskMessage{parent}, "default")
+	h.SeedLease(t, r.client, []base.Z{{Message: parent, Score: now.Add(time.Minute).Unix()}}, "default")
+	child := h.NewTaskMessageWithQueue("child", nil, "default")
+	child.Dependencies = []string{parent.ID}
+	if _, err := r.EnqueueWaiting(ctx, child, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Done(ctx, parent); err != nil {
+		t.Fatal(err)
+	}
+	recv("Done", "default")
+
+	// Tasks enqueued to other queues are not notified.
+	if err := r.Enqueue(ctx, h.NewTaskMessageWithQueue("task6", nil, "low")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case qname := <-sub.Channel():
+		t.Errorf("received wakeup of queue %q, want no wakeup", qname)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSubscribeSchedule(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	h.FlushDB(t, r.client)
 	now := time.Now()
 	r.SetClock(timeutil.NewSimulatedClock(now))
 
+	sub, err := r.SubscribeSchedule("default", "critical")
+	if err != nil {
+		t.Fatalf("(*RDB).SubscribeSchedule() returned an error: %v", err)
+	}
+	defer sub.Close()
+
+	recv := func(desc, want string) {
+		t.Helper()
+		select {
+		case got := <-sub.Channel():
+			if got != want {
+				t.Errorf("%s: received notification of queue %q, want %q", desc, got, want)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: received no notification, want notification of queue %q", desc, want)
+		}
+	}
+
 	ctx := context.Background()
-	msg1 := h.NewTaskMessageBuilder().SetType("task1").SetGroup("mygroup").Build()
-	msg2 := h.NewTaskMessageBuilder().SetType("task2").SetGroup("mygroup").Build()
-	msg3 := h.NewTaskMessageBuilder().SetType("task3").SetGroup("mygroup").Build()
-	msg4 := h.NewTaskMessageBuilder().SetType("task4").SetGroup("mygroup").Build()
-	msg5 := h.NewTaskMessageBuilder().SetType("task5").SetGroup("mygroup").Build()
+	if err := r.Schedule(ctx, h.NewTaskMessageWithQueue("task1", nil, "default"), now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	recv("Schedule", "default")
 
-	tests := []struct {
-		desc string
-		// initial data
-		tasks     []*h.TaskSeedData
-		groups    map[string][]*redis.Z
-		allGroups map[string][]string
+	msg := h.NewTaskMessageWithQueue("task2", nil, "critical")
+	msg.UniqueKey = base.UniqueKey("critical", msg.Type, nil)
+	if err := r.ScheduleUnique(ctx, msg, now.Add(time.Minute), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	recv("ScheduleUnique", "critical")
 
-		// args
-		qname       string
-		gname       string
-		gracePeriod time.Duration
-		maxDelay    time.Duration
-		maxSize     int
+	batch := []*base.BatchMessage{{Msg: h.NewTaskMessageWithQueue("task3", nil, "default"), ProcessAt: now.Add(time.Minute)}}
+	if _, err := r.EnqueueBatch(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	recv("EnqueueBatch", "default")
 
-		// expectaions
-		shouldCreateSet    bool // whether the check should create a new aggregation set
-		wantAggregationSet []*base.TaskMessage
-		wantGroups         map[string][]redis.Z
-		shouldClearGroup   bool // whether the check should clear the group from redis
-	}{
+	active := h.NewTaskMessageWithQueue("task4", nil, "critical")
+	h.SeedActiveQueue(t, r.client, []*base.TaskMessage{active}, "critical")
+	h.SeedLease(t, r.client, []base.Z{{Message: active, Score: now.Add(time.Minute).Unix()}}, "critical")
+	if err := r.Retry(ctx, active, now.Add(time.Minute), "error", true); err != nil {
+		t.Fatal(err)
+	}
+	recv("Retry", "critical")
+
+	// Tasks scheduled to other queues are not notified.
+	if err := r.Schedule(ctx, h.NewTaskMessageWithQueue("task5", nil, "low"), now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case qname := <-sub.Channel():
+		t.Errorf("received notification of queue %q, want no notification", qname)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSubscribeCompletion(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	h.FlushDB(t, r.client)
+	ctx := context.Background()
+
+	m1 := h.NewTaskMessage("task1", nil)
+	m2 := h.NewTaskMessage("task2", nil)
+	for _, msg := range []*base.TaskMessage{m1, m2} {
+		if err := r.Enqueue(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, _, err := r.Dequeue(base.DefaultQueueName); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		desc string
+		msg  *base.TaskMessage
+		fn   func() error
+	}{
+		{"MarkAsComplete", m1, func() error { return r.MarkAsComplete(ctx, m1) }},
+		{"Archive", m2, func() error { return r.Archive(ctx, m2, "error") }},
+	}
+
+	for _, tc := range tests {
+		sub, err := r.SubscribeCompletion(tc.msg.Queue, tc.msg.ID)
+		if err != nil {
+			t.Fatalf("(*RDB).SubscribeCompletion() returned an error: %v", err)
+		}
+		if err := tc.fn(); err != nil {
+			t.Fatalf("%s returned an error: %v", tc.desc, err)
+		}
+		select {
+		case got := <-sub.Channel():
+			if got != tc.msg.ID {
+				t.Errorf("%s: received completion of task %q, want %q", tc.desc, got, tc.msg.ID)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: received no completion, want completion of task %q", tc.desc, tc.msg.ID)
+		}
+		sub.Close()
+	}
+}
+
+func TestNextProcessAt(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	now := time.Now().Truncate(time.Millisecond)
+	t1 := h.NewTaskMessage("send_email", nil)
+	t2 := h.NewTaskMessage("generate_csv", nil)
+	t3 := h.NewTaskMessageWithQueue("important_task", nil, "critical")
+
+	tests := []struct {
+		desc      string
+		scheduled map[string][]base.Z
+		retry     map[string][]base.Z
+		qnames    []string
+		want      time.Time
+	}{
+		{
+			desc:   "no tasks",
+			qnames: []string{"default"},
+			want:   time.Time{},
+		},
+		{
+			desc: "millisecond scores",
+			scheduled: map[string][]base.Z{
+				"default": {{Message: t1, Score: base.ProcessAtScore(now.Add(1500 * time.Millisecond))}},
+			},
+			retry: map[string][]base.Z{
+				"critical": {{Message: t3, Score: base.ProcessAtScore(now.Add(200 * time.Millisecond))}},
+			},
+			qnames: []string{"default", "critical"},
+			want:   now.Add(200 * time.Millisecond),
+		},
+		{
+			desc: "legacy second scores",
+			scheduled: map[string][]base.Z{
+				"default": {
+					{Message: t1, Score: base.ProcessAtScore(now.Add(time.Minute))},
+					{Message: t2, Score: now.Add(time.Hour).Unix()},
+				},
+			},
+			retry: map[string][]base.Z{
+				"critical": {{Message: t3, Score: now.Add(-time.Minute).Unix()}},
+			},
+			qnames: []string{"default"},
+			want:   now.Add(time.Minute),
+		},
+		{
+			desc: "legacy second score is earliest",
+			scheduled: map[string][]base.Z{
+				"default": {
+					{Message: t1, Score: base.ProcessAtScore(now.Add(time.Hour))},
+					{Message: t2, Score: now.Add(time.Minute).Unix()},
+				},
+			},
+			qnames: []string{"default"},
+			want:   time.Unix(now.Add(time.Minute).Unix(), 0),
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		h.SeedAllScheduledQueues(t, r.client, tc.scheduled)
+		h.SeedAllRetryQueues(t, r.client, tc.retry)
+
+		got, err := r.NextProcessAt(tc.qnames...)
+		if err != nil {
+			t.Errorf("%s: (*RDB).NextProcessAt(%v) returned an error: %v", tc.desc, tc.qnames, err)
+			continue
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("%s: (*RDB).NextProcessAt(%v) = %v, want %v", tc.desc, tc.qnames, got, tc.want)
+		}
+	}
+}
+
+func TestWriteResult(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+
+	tests := []struct {
+		qname  string
+		taskID string
+		data   []byte
+	}{
+		{
+			qname:  "default",
+			taskID: uuid.NewString(),
+			data:   []byte("hello"),
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+
+		n, err := r.WriteResult(tc.qname, tc.taskID, tc.data)
+		if err != nil {
+			t.Errorf("WriteResult failed: %v", err)
+			continue
+		}
+		if n != len(tc.data) {
+			t.Errorf("WriteResult returned %d, want %d", n, len(tc.data))
+		}
+
+		taskKey := base.TaskKey(tc.qname, tc.taskID)
+		got := r.client.HGet(context.Background(), taskKey, "result").Val()
+		if got != string(tc.data) {
+			t.Errorf("`result` field under %q key is set to %q, want %q", taskKey, got, string(tc.data))
+		}
+	}
+}
+
+func TestAggregationCheck(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+
+	now := time.Now()
+	r.SetClock(timeutil.NewSimulatedClock(now))
+
+	ctx := context.Background()
+	msg1 := h.NewTaskMessageBuilder().SetType("task1").SetGroup("mygroup").Build()
+	msg2 := h.NewTaskMessageBuilder().SetType("task2").SetGroup("mygroup").Build()
+	msg3 := h.NewTaskMessageBuilder().SetType("task3").SetGroup("mygroup").Build()
+	msg4 := h.NewTaskMessageBuilder().SetType("task4").SetGroup("mygroup").Build()
+	msg5 := h.NewTaskMessageBuilder().SetType("task5").SetGroup("mygroup").Build()
+
+	tests := []struct {
+		desc string
+		// initial data
+		tasks     []*h.TaskSeedData
+		groups    map[string][]*redis.Z
+		allGroups map[string][]string
+
+		// args
+		qname       string
+		gname       string
+		gracePeriod time.Duration
+		maxDelay    time.Duration
+		maxSize     int
+
+		// expectaions
+		shouldCreateSet    bool // whether the check should create a new aggregation set
+		wantAggregationSet []*base.TaskMessage
+		wantGroups         map[string][]redis.Z
+		shouldClearGroup   bool // whether the check should clear the group from redis
+	}{
 		{
 			desc:  "with an empty group",
 			tasks: []*h.TaskSeedData{},
@@ -3820,3 +4498,963 @@ func TestListGroups(t *testing.T) {
 		}
 	}
 }
+
+func TestEnqueueWaiting(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	pending := h.NewTaskMessage("pending", nil)
+	completed := h.NewTaskMessage("completed", nil)
+	archived := h.NewTaskMessage("archived", nil)
+	now := time.Now()
+
+	tests := []struct {
+		desc          string
+		dependencies  []string
+		ignoreMissing bool
+		wantState     base.TaskState
+		wantErr       func(error) bool // reports whether the returned error is the expected one; nil if no error is expected
+	}{
+		{
+			desc:         "waits for incomplete dependencies",
+			dependencies: []string{pending.ID, completed.ID},
+			wantState:    base.TaskStateWaiting,
+		},
+		{
+			desc:         "enqueues to pending if all dependencies have completed",
+			dependencies: []string{completed.ID},
+			wantState:    base.TaskStatePending,
+		},
+		{
+			desc:         "returns error if a dependency does not exist",
+			dependencies: []string{pending.ID, "no-such-task"},
+			wantErr:      errors.IsTaskNotFound,
+		},
+		{
+			desc:          "considers a dependency which does not exist completed if asked to",
+			dependencies:  []string{"no-such-task"},
+			ignoreMissing: true,
+			wantState:     base.TaskStatePending,
+		},
+		{
+			desc:         "returns error if a dependency is archived",
+			dependencies: []string{archived.ID},
+			wantErr:      errors.IsTaskAlreadyArchived,
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		h.SeedPendingQueue(t, r.client, []*base.TaskMessage{pending}, base.DefaultQueueName)
+		h.SeedCompletedQueue(t, r.client, []base.Z{{Message: completed, Score: now.Add(time.Hour).Unix()}}, base.DefaultQueueName)
+		h.SeedArchivedQueue(t, r.client, []base.Z{{Message: archived, Score: now.Unix()}}, base.DefaultQueueName)
+
+		msg := h.NewTaskMessage("child", nil)
+		msg.Dependencies = tc.dependencies
+		got, err := r.EnqueueWaiting(context.Background(), msg, tc.ignoreMissing)
+		if tc.wantErr != nil {
+			if !tc.wantErr(err) {
+				t.Errorf("%s; EnqueueWaiting returned unexpected error: %v", tc.desc, err)
+			}
+			if n := r.client.Exists(context.Background(), base.TaskKey(msg.Queue, msg.ID)).Val(); n != 0 {
+				t.Errorf("%s; task key exists after EnqueueWaiting returned error", tc.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s; EnqueueWaiting returned error: %v", tc.desc, err)
+			continue
+		}
+		if got != tc.wantState {
+			t.Errorf("%s; EnqueueWaiting returned state %v, want %v", tc.desc, got, tc.wantState)
+		}
+		var gotMsgs []*base.TaskMessage
+		if tc.wantState == base.TaskStateWaiting {
+			gotMsgs = h.GetWaitingMessages(t, r.client, msg.Queue)
+		} else {
+			gotMsgs = h.GetPendingMessages(t, r.client, msg.Queue)
+		}
+		var found bool
+		for _, m := range gotMsgs {
+			if m.ID == msg.ID {
+				found = true
+				if diff := cmp.Diff(msg, m); diff != "" {
+					t.Errorf("%s; persisted message mismatch; (-want,+got)\n%s", tc.desc, diff)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s; task %q is not in %v state", tc.desc, msg.ID, tc.wantState)
+		}
+	}
+}
+
+func TestEnqueueWaitingUnique(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	parent := h.NewTaskMessage("parent", nil)
+	h.SeedPendingQueue(t, r.client, []*base.TaskMessage{parent}, base.DefaultQueueName)
+
+	msg := h.NewTaskMessage("child", nil)
+	msg.UniqueKey = base.UniqueKey(msg.Queue, msg.Type, msg.Payload)
+	msg.Dependencies = []string{parent.ID}
+	state, err := r.EnqueueWaitingUnique(context.Background(), msg, time.Minute, false)
+	if err != nil {
+		t.Fatalf("EnqueueWaitingUnique returned error: %v", err)
+	}
+	if state != base.TaskStateWaiting {
+		t.Errorf("EnqueueWaitingUnique returned state %v, want %v", state, base.TaskStateWaiting)
+	}
+	if got := r.client.Get(context.Background(), msg.UniqueKey).Val(); got != msg.ID {
+		t.Errorf("unique key holds %q, want %q", got, msg.ID)
+	}
+
+	dup := h.NewTaskMessage("child", nil)
+	dup.UniqueKey = msg.UniqueKey
+	dup.Dependencies = []string{parent.ID}
+	if _, err := r.EnqueueWaitingUnique(context.Background(), dup, time.Minute, false); !errors.Is(err, errors.ErrDuplicateTask) {
+		t.Errorf("EnqueueWaitingUnique with duplicate task returned %v, want ErrDuplicateTask", err)
+	}
+}
+
+func TestCompletionReleasesWaitingTasks(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+
+	for _, markAsComplete := range []bool{false, true} {
+		h.FlushDB(t, r.client)
+		parent1 := h.NewTaskMessage("parent1", nil)
+		parent2 := h.NewTaskMessage("parent2", nil)
+		if markAsComplete {
+			parent1.Retention = 3600
+		}
+		h.SeedActiveQueue(t, r.client, []*base.TaskMessage{parent1}, base.DefaultQueueName)
+		h.SeedLease(t, r.client, []base.Z{{Message: parent1, Score: time.Now().Add(time.Minute).Unix()}}, base.DefaultQueueName)
+		h.SeedPendingQueue(t, r.client, []*base.TaskMessage{parent2}, base.DefaultQueueName)
+
+		child1 := h.NewTaskMessage("child1", nil)
+		child1.Dependencies = []string{parent1.ID}
+		child2 := h.NewTaskMessage("child2", nil)
+		child2.Dependencies = []string{parent1.ID, parent2.ID}
+		for _, msg := range []*base.TaskMessage{child1, child2} {
+			if _, err := r.EnqueueWaiting(ctx, msg, false); err != nil {
+				t.Fatalf("EnqueueWaiting returned error: %v", err)
+			}
+		}
+
+		var err error
+		if markAsComplete {
+			err = r.MarkAsComplete(ctx, parent1)
+		} else {
+			err = r.Done(ctx, parent1)
+		}
+		if err != nil {
+			t.Fatalf("completing the parent task returned error: %v", err)
+		}
+
+		wantPending := []*base.TaskMessage{parent2, child1}
+		if diff := cmp.Diff(wantPending, h.GetPendingMessages(t, r.client, base.DefaultQueueName), h.SortMsgOpt); diff != "" {
+			t.Errorf("markAsComplete=%t; mismatch found in pending list; (-want,+got)\n%s", markAsComplete, diff)
+		}
+		wantWaiting := []*base.TaskMessage{child2}
+		if diff := cmp.Diff(wantWaiting, h.GetWaitingMessages(t, r.client, base.DefaultQueueName), h.SortMsgOpt); diff != "" {
+			t.Errorf("markAsComplete=%t; mismatch found in waiting set; (-want,+got)\n%s", markAsComplete, diff)
+		}
+	}
+}
+
+func TestArchiveBlockedTasks(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	now := time.Now()
+	r.SetClock(timeutil.NewSimulatedClock(now))
+
+	deleted := h.NewTaskMessage("deleted", nil)
+	archived := h.NewTaskMessage("archived", nil)
+	retry := h.NewTaskMessage("retry", nil)
+	h.SeedPendingQueue(t, r.client, []*base.TaskMessage{deleted, archived}, base.DefaultQueueName)
+	h.SeedRetryQueue(t, r.client, []base.Z{{Message: retry, Score: now.Add(time.Minute).Unix()}}, base.DefaultQueueName)
+
+	child1 := h.NewTaskMessage("child1", nil)
+	child1.Dependencies = []string{deleted.ID}
+	child2 := h.NewTaskMessage("child2", nil)
+	child2.Dependencies = []string{retry.ID, archived.ID}
+	child3 := h.NewTaskMessage("child3", nil)
+	child3.Dependencies = []string{retry.ID}
+	grandchild := h.NewTaskMessage("grandchild", nil)
+	grandchild.Dependencies = []string{child1.ID}
+	for _, msg := range []*base.TaskMessage{child1, child2, child3, grandchild} {
+		if _, err := r.EnqueueWaiting(ctx, msg, false); err != nil {
+			t.Fatalf("EnqueueWaiting returned error: %v", err)
+		}
+	}
+	if err := r.DeleteTask(base.DefaultQueueName, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ArchiveTask(base.DefaultQueueName, archived.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.client.SMembers(ctx, base.BlockedKey(base.DefaultQueueName)).Val(); len(got) != 2 {
+		t.Errorf("blocked set = %v, want the tasks waiting for the deleted and archived tasks", got)
+	}
+
+	if err := r.ArchiveBlockedTasks(base.DefaultQueueName); err != nil {
+		t.Fatalf("ArchiveBlockedTasks returned error: %v", err)
+	}
+
+	// The task waiting for an archived child is archived in turn.
+	wantArchived := []*base.TaskMessage{
+		archived,
+		h.TaskMessageWithError(*child1, base.BlockedErrMsg, now),
+		h.TaskMessageWithError(*child2, base.BlockedErrMsg, now),
+		h.TaskMessageWithError(*grandchild, base.BlockedErrMsg, now),
+	}
+	if diff := cmp.Diff(wantArchived, h.GetArchivedMessages(t, r.client, base.DefaultQueueName), h.SortMsgOpt); diff != "" {
+		t.Errorf("mismatch found in archived set; (-want,+got)\n%s", diff)
+	}
+	wantWaiting := []*base.TaskMessage{child3}
+	if diff := cmp.Diff(wantWaiting, h.GetWaitingMessages(t, r.client, base.DefaultQueueName), h.SortMsgOpt); diff != "" {
+		t.Errorf("mismatch found in waiting set; (-want,+got)\n%s", diff)
+	}
+	if n := r.client.SCard(ctx, base.BlockedKey(base.DefaultQueueName)).Val(); n != 0 {
+		t.Errorf("blocked set has %d tasks, want 0", n)
+	}
+}
+
+func newChainMessages(chainID string, n int) []*base.TaskMessage {
+	var msgs []*base.TaskMessage
+	for i := 0; i < n; i++ {
+		msg := h.NewTaskMessage(fmt.Sprintf("step%d", i), nil)
+		msg.ChainID = chainID
+		msg.ChainStep = i
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestEnqueueChain(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	msgs := newChainMessages("chain1", 3)
+
+	if err := r.EnqueueChain(ctx, msgs); err != nil {
+		t.Fatalf("EnqueueChain returned error: %v", err)
+	}
+	wantPending := []*base.TaskMessage{msgs[0]}
+	if diff := cmp.Diff(wantPending, h.GetPendingMessages(t, r.client, base.DefaultQueueName)); diff != "" {
+		t.Errorf("mismatch found in pending list; (-want,+got)\n%s", diff)
+	}
+	for _, msg := range msgs[1:] {
+		if n := r.client.Exists(ctx, base.TaskKey(msg.Queue, msg.ID)).Val(); n != 0 {
+			t.Errorf("task key for step %d exists before the previous step completes", msg.ChainStep)
+		}
+	}
+
+	if err := r.EnqueueChain(ctx, msgs); !errors.Is(err, errors.ErrTaskIdConflict) {
+		t.Errorf("EnqueueChain with existing chain returned %v, want ErrTaskIdConflict", err)
+	}
+	conflicting := newChainMessages("chain2", 2)
+	conflicting[1].ID = msgs[0].ID
+	if err := r.EnqueueChain(ctx, conflicting); !errors.Is(err, errors.ErrTaskIdConflict) {
+		t.Errorf("EnqueueChain with conflicting task ID returned %v, want ErrTaskIdConflict", err)
+	}
+}
+
+func TestCompletionAdvancesChain(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	msgs := newChainMessages("chain1", 3)
+	msgs[1].Retention = 3600
+	if err := r.EnqueueChain(ctx, msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range msgs {
+		msg, _, _, err := r.Dequeue(base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("step %d: Dequeue returned error: %v", i, err)
+		}
+		if diff := cmp.Diff(want, msg); diff != "" {
+			t.Errorf("step %d: Dequeue returned %v, want %v; (-want,+got)\n%s", i, msg, want, diff)
+		}
+		if i > 0 {
+			got, err := r.ReadChainResult(base.DefaultQueueName, "chain1", i-1)
+			if err != nil {
+				t.Fatalf("step %d: ReadChainResult returned error: %v", i, err)
+			}
+			if wantResult := fmt.Sprintf("result%d", i-1); string(got) != wantResult {
+				t.Errorf("step %d: ReadChainResult returned %q, want %q", i, got, wantResult)
+			}
+		}
+		if _, err := r.WriteResult(base.DefaultQueueName, msg.ID, []byte(fmt.Sprintf("result%d", i))); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Retention > 0 {
+			err = r.MarkAsComplete(ctx, msg)
+		} else {
+			err = r.Done(ctx, msg)
+		}
+		if err != nil {
+			t.Fatalf("step %d: completing the task returned error: %v", i, err)
+		}
+	}
+
+	if _, _, _, err := r.Dequeue(base.DefaultQueueName); !errors.Is(err, errors.ErrNoProcessableTask) {
+		t.Errorf("Dequeue after the last step returned %v, want ErrNoProcessableTask", err)
+	}
+	info, err := r.GetChainInfo(base.DefaultQueueName, "chain1")
+	if err != nil {
+		t.Fatalf("GetChainInfo returned error: %v", err)
+	}
+	if info.Current != 3 {
+		t.Errorf("GetChainInfo returned Current=%d, want 3", info.Current)
+	}
+	wantResults := [][]byte{[]byte("result0"), []byte("result1"), []byte("result2")}
+	if diff := cmp.Diff(wantResults, info.Results); diff != "" {
+		t.Errorf("GetChainInfo returned unexpected results; (-want,+got)\n%s", diff)
+	}
+	if ttl := r.client.TTL(ctx, base.ChainKey(base.DefaultQueueName, "chain1")).Val(); ttl <= 0 || ttl > chainExpiration {
+		t.Errorf("TTL of completed chain is %v, want in (0, %v]", ttl, chainExpiration)
+	}
+}
+
+func newFairnessMessage(fkey string) *base.TaskMessage {
+	return &base.TaskMessage{
+		ID:          uuid.NewString(),
+		Type:        "send_email",
+		Queue:       base.DefaultQueueName,
+		Timeout:     1800,
+		FairnessKey: fkey,
+	}
+}
+
+func TestEnqueueWithFairnessKey(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	keyed := newFairnessMessage("tenant1")
+	unkeyed := newFairnessMessage("")
+
+	if err := r.Enqueue(ctx, unkeyed); err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	if n := r.client.Exists(ctx, base.FairnessKeysKey(base.DefaultQueueName)).Val(); n != 0 {
+		t.Errorf("fairness key rotation exists before any keyed task is enqueued")
+	}
+	if err := r.Enqueue(ctx, keyed); err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+
+	if got := r.client.HGet(ctx, base.TaskKey(keyed.Queue, keyed.ID), "fairness_key").Val(); got != "tenant1" {
+		t.Errorf("fairness_key field = %q, want %q", got, "tenant1")
+	}
+	gotIDs := r.client.LRange(ctx, base.FairnessKey(base.DefaultQueueName, "tenant1"), 0, -1).Val()
+	if diff := cmp.Diff([]string{keyed.ID}, gotIDs); diff != "" {
+		t.Errorf("mismatch found in fairness list; (-want,+got)\n%s", diff)
+	}
+	gotKeys := r.client.LRange(ctx, base.FairnessKeysKey(base.DefaultQueueName), 0, -1).Val()
+	if diff := cmp.Diff([]string{"tenant1", ""}, gotKeys); diff != "" {
+		t.Errorf("mismatch found in fairness key rotation; (-want,+got)\n%s", diff)
+	}
+	// The keyed task is stored only in its fairness list.
+	wantPending := []*base.TaskMessage{unkeyed}
+	if diff := cmp.Diff(wantPending, h.GetPendingMessages(t, r.client, base.DefaultQueueName)); diff != "" {
+		t.Errorf("mismatch found in pending list; (-want,+got)\n%s", diff)
+	}
+}
+
+func TestDequeueRoundRobinAcrossFairnessKeys(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+
+	var a []*base.TaskMessage
+	for i := 0; i < 5; i++ {
+		a = append(a, newFairnessMessage("a"))
+	}
+	b := []*base.TaskMessage{newFairnessMessage("b"), newFairnessMessage("b")}
+	unkeyed := newFairnessMessage("")
+	for _, msg := range append(append(a, b...), unkeyed) {
+		if err := r.Enqueue(ctx, msg); err != nil {
+			t.Fatalf("Enqueue returned error: %v", err)
+		}
+	}
+
+	var got []string
+	for {
+		msg, _, _, err := r.Dequeue(base.DefaultQueueName)
+		if errors.Is(err, errors.ErrNoProcessableTask) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Dequeue returned error: %v", err)
+		}
+		got = append(got, msg.ID)
+	}
+
+	// The flood of "a" tasks must not starve "b" and the unkeyed task.
+	if len(got) != 8 {
+		t.Fatalf("dequeued %d tasks, want 8", len(got))
+	}
+	first := map[string]bool{}
+	for _, id := range got[:3] {
+		first[id] = true
+	}
+	if !first[b[0].ID] || !first[unkeyed.ID] || !first[a[0].ID] {
+		t.Errorf("first three dequeued tasks %v are not one from each fairness key", got[:3])
+	}
+	var gotA []string
+	for _, id := range got {
+		for _, msg := range a {
+			if msg.ID == id {
+				gotA = append(gotA, id)
+			}
+		}
+	}
+	var wantA []string
+	for _, msg := range a {
+		wantA = append(wantA, msg.ID)
+	}
+	if diff := cmp.Diff(wantA, gotA); diff != "" {
+		t.Errorf("tasks with the same fairness key were not dequeued in FIFO order; (-want,+got)\n%s", diff)
+	}
+	for _, key := range []string{base.FairnessKeysKey(base.DefaultQueueName), base.FairnessKey(base.DefaultQueueName, "a"), base.FairnessKey(base.DefaultQueueName, "b")} {
+		if n := r.client.Exists(ctx, key).Val(); n != 0 {
+			t.Errorf("%q exists after all tasks are dequeued", key)
+		}
+	}
+}
+
+func TestDeleteTaskRemovesFairnessEntry(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	deleted := newFairnessMessage("a")
+	live := newFairnessMessage("a")
+	for _, msg := range []*base.TaskMessage{deleted, live} {
+		if err := r.Enqueue(ctx, msg); err != nil {
+			t.Fatalf("Enqueue returned error: %v", err)
+		}
+	}
+	if err := r.DeleteTask(base.DefaultQueueName, deleted.ID); err != nil {
+		t.Fatalf("DeleteTask returned error: %v", err)
+	}
+	gotIDs := r.client.LRange(ctx, base.FairnessKey(base.DefaultQueueName, "a"), 0, -1).Val()
+	if diff := cmp.Diff([]string{live.ID}, gotIDs); diff != "" {
+		t.Errorf("mismatch found in fairness list; (-want,+got)\n%s", diff)
+	}
+
+	msg, _, _, err := r.Dequeue(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("Dequeue returned error: %v", err)
+	}
+	if diff := cmp.Diff(live, msg); diff != "" {
+		t.Errorf("Dequeue returned %v, want %v; (-want,+got)\n%s", msg, live, diff)
+	}
+	if _, _, _, err := r.Dequeue(base.DefaultQueueName); !errors.Is(err, errors.ErrNoProcessableTask) {
+		t.Errorf("Dequeue returned %v, want ErrNoProcessableTask", err)
+	}
+}
+
+func TestRequeueAndForwardWithFairnessKey(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	requeued := newFairnessMessage("a")
+	scheduled := newFairnessMessage("b")
+
+	if err := r.Enqueue(ctx, requeued); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := r.Dequeue(base.DefaultQueueName); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Requeue(ctx, requeued); err != nil {
+		t.Fatalf("Requeue returned error: %v", err)
+	}
+	if err := r.Schedule(ctx, scheduled, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ForwardIfReady(base.DefaultQueueName); err != nil {
+		t.Fatalf("ForwardIfReady returned error: %v", err)
+	}
+
+	for _, msg := range []*base.TaskMessage{requeued, scheduled} {
+		got := r.client.LRange(ctx, base.FairnessKey(base.DefaultQueueName, msg.FairnessKey), 0, -1).Val()
+		if diff := cmp.Diff([]string{msg.ID}, got); diff != "" {
+			t.Errorf("mismatch found in fairness list of %q; (-want,+got)\n%s", msg.FairnessKey, diff)
+		}
+	}
+	stats, err := r.FairnessKeyStats(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("FairnessKeyStats returned error: %v", err)
+	}
+	want := []*FairnessKeyStat{{Key: "a", Pending: 1}, {Key: "b", Pending: 1}}
+	if diff := cmp.Diff(want, stats); diff != "" {
+		t.Errorf("FairnessKeyStats returned %v, want %v; (-want,+got)\n%s", stats, want, diff)
+	}
+}
+
+func newPriorityMessage(priority int) *base.TaskMessage {
+	return &base.TaskMessage{
+		ID:       uuid.NewString(),
+		Type:     "send_email",
+		Queue:    base.DefaultQueueName,
+		Timeout:  1800,
+		Priority: priority,
+	}
+}
+
+func TestDequeueByPriority(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	low1 := newPriorityMessage(0)
+	high1 := newPriorityMessage(10)
+	mid := newPriorityMessage(5)
+	low2 := newPriorityMessage(0)
+	high2 := newPriorityMessage(10)
+	keyed := newFairnessMessage("tenant1")
+	for _, msg := range []*base.TaskMessage{low1, high1, mid, low2, high2} {
+		if err := r.Enqueue(ctx, msg); err != nil {
+			t.Fatalf("Enqueue returned error: %v", err)
+		}
+	}
+	if err := r.Enqueue(ctx, keyed); err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+
+	if got := r.client.HGet(ctx, base.TaskKey(high1.Queue, high1.ID), "priority").Val(); got != "10" {
+		t.Errorf("priority field = %q, want %q", got, "10")
+	}
+	gotPriorities := r.client.ZRevRange(ctx, base.PrioritiesKey(base.DefaultQueueName), 0, -1).Val()
+	if diff := cmp.Diff([]string{"10", "5"}, gotPriorities); diff != "" {
+		t.Errorf("mismatch found in priorities; (-want,+got)\n%s", diff)
+	}
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		msg, _, _, err := r.Dequeue(base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("Dequeue returned error: %v", err)
+		}
+		got = append(got, msg.ID)
+	}
+	if diff := cmp.Diff([]string{high1.ID, high2.ID, mid.ID}, got); diff != "" {
+		t.Errorf("tasks were not dequeued in order of priority; (-want,+got)\n%s", diff)
+	}
+	if n := r.client.Exists(ctx, base.PrioritiesKey(base.DefaultQueueName)).Val(); n != 0 {
+		t.Errorf("%q exists after all tasks with priority are dequeued", base.PrioritiesKey(base.DefaultQueueName))
+	}
+	// Tasks with the default priority are dequeued in order, taking turns with the fairness keys.
+	rest := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		msg, _, _, err := r.Dequeue(base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("Dequeue returned error: %v", err)
+		}
+		rest[msg.ID] = true
+	}
+	for _, msg := range []*base.TaskMessage{low1, low2, keyed} {
+		if !rest[msg.ID] {
+			t.Errorf("task %s with the default priority was not dequeued", msg.ID)
+		}
+	}
+}
+
+func TestRequeueAndForwardWithPriority(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	requeued := newPriorityMessage(1)
+	scheduled := newPriorityMessage(2)
+	low := newPriorityMessage(0)
+
+	if err := r.Enqueue(ctx, requeued); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := r.Dequeue(base.DefaultQueueName); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Enqueue(ctx, low); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Requeue(ctx, requeued); err != nil {
+		t.Fatalf("Requeue returned error: %v", err)
+	}
+	if err := r.Schedule(ctx, scheduled, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ForwardIfReady(base.DefaultQueueName); err != nil {
+		t.Fatalf("ForwardIfReady returned error: %v", err)
+	}
+
+	for _, want := range []*base.TaskMessage{scheduled, requeued, low} {
+		got, _, _, err := r.Dequeue(base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("Dequeue returned error: %v", err)
+		}
+		if got.ID != want.ID {
+			t.Errorf("Dequeue returned task with priority %d, want task with priority %d", got.Priority, want.Priority)
+		}
+	}
+}
+
+func TestDequeueExceptByPriorityAndFairnessKey(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	excludedHigh := newPriorityMessage(10)
+	excludedHigh.Type = "generate_report"
+	high := newPriorityMessage(5)
+	low := newPriorityMessage(0)
+	excludedKeyed := newFairnessMessage("a")
+	excludedKeyed.Type = "generate_report"
+	keyed := newFairnessMessage("b")
+	for _, msg := range []*base.TaskMessage{low, excludedHigh, high, excludedKeyed, keyed} {
+		if err := r.Enqueue(ctx, msg); err != nil {
+			t.Fatalf("Enqueue returned error: %v", err)
+		}
+	}
+	// Tasks with priority are stored only in their priority lists.
+	if diff := cmp.Diff([]*base.TaskMessage{low}, h.GetPendingMessages(t, r.client, base.DefaultQueueName)); diff != "" {
+		t.Errorf("mismatch found in pending list; (-want,+got)\n%s", diff)
+	}
+
+	msg, _, _, err := r.DequeueExcept([]string{"generate_report"}, base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("DequeueExcept returned error: %v", err)
+	}
+	if msg.ID != high.ID {
+		t.Errorf("DequeueExcept returned task with priority %d, want task with priority %d", msg.Priority, high.Priority)
+	}
+	rest := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		msg, _, _, err := r.DequeueExcept([]string{"generate_report"}, base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("DequeueExcept returned error: %v", err)
+		}
+		rest[msg.ID] = true
+	}
+	if !rest[low.ID] || !rest[keyed.ID] {
+		t.Errorf("DequeueExcept did not return the tasks with the default priority")
+	}
+	if _, _, _, err := r.DequeueExcept([]string{"generate_report"}, base.DefaultQueueName); !errors.Is(err, errors.ErrNoProcessableTask) {
+		t.Errorf("DequeueExcept returned %v, want ErrNoProcessableTask", err)
+	}
+
+	// The excluded tasks are left in place and dequeued in the same order.
+	for _, want := range []*base.TaskMessage{excludedHigh, excludedKeyed} {
+		got, _, _, err := r.Dequeue(base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("Dequeue returned error: %v", err)
+		}
+		if got.ID != want.ID {
+			t.Errorf("Dequeue returned %s, want %s", got.ID, want.ID)
+		}
+	}
+}
+
+func newOrderingMessage(okey string) *base.TaskMessage {
+	return &base.TaskMessage{
+		ID:          uuid.NewString(),
+		Type:        "update_order",
+		Queue:       base.DefaultQueueName,
+		Timeout:     1800,
+		OrderingKey: okey,
+	}
+}
+
+func pendingAndWaitingIDs(t *testing.T, r *RDB) (pending, waiting []string) {
+	t.Helper()
+	for _, msg := range h.GetPendingMessages(t, r.client, base.DefaultQueueName) {
+		pending = append(pending, msg.ID)
+	}
+	for _, msg := range h.GetWaitingMessages(t, r.client, base.DefaultQueueName) {
+		waiting = append(waiting, msg.ID)
+	}
+	return pending, waiting
+}
+
+func TestEnqueueWithOrderingKey(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	a1 := newOrderingMessage("order-1")
+	a2 := newOrderingMessage("order-1")
+	b1 := newOrderingMessage("order-2")
+
+	wantStates := []base.TaskState{base.TaskStatePending, base.TaskStateWaiting, base.TaskStatePending}
+	for i, msg := range []*base.TaskMessage{a1, a2, b1} {
+		state, err := r.EnqueueWaiting(ctx, msg, false)
+		if err != nil {
+			t.Fatalf("EnqueueWaiting returned error: %v", err)
+		}
+		if state != wantStates[i] {
+			t.Errorf("EnqueueWaiting returned state %v for task %d, want %v", state, i, wantStates[i])
+		}
+	}
+
+	pending, waiting := pendingAndWaitingIDs(t, r)
+	if diff := cmp.Diff([]string{a1.ID, b1.ID}, pending, h.SortStringSliceOpt); diff != "" {
+		t.Errorf("mismatch found in pending list; (-want,+got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{a2.ID}, waiting); diff != "" {
+		t.Errorf("mismatch found in waiting set; (-want,+got)\n%s", diff)
+	}
+	gotList := r.client.LRange(ctx, base.OrderingKey(base.DefaultQueueName, "order-1"), 0, -1).Val()
+	if diff := cmp.Diff([]string{a1.ID, a2.ID}, gotList); diff != "" {
+		t.Errorf("mismatch found in %q; (-want,+got)\n%s", base.OrderingKey(base.DefaultQueueName, "order-1"), diff)
+	}
+	gotKeys := r.client.SMembers(ctx, base.AllOrderingKeys(base.DefaultQueueName)).Val()
+	if diff := cmp.Diff([]string{"order-1", "order-2"}, gotKeys, h.SortStringSliceOpt); diff != "" {
+		t.Errorf("mismatch found in %q; (-want,+got)\n%s", base.AllOrderingKeys(base.DefaultQueueName), diff)
+	}
+}
+
+func TestOrderingKeyReleasesNextTaskInOrder(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	m1 := newOrderingMessage("order-1")
+	m2 := newOrderingMessage("order-1")
+	m3 := newOrderingMessage("order-1")
+	m4 := newOrderingMessage("order-1")
+	m4.Retention = 3600
+	for _, msg := range []*base.TaskMessage{m1, m2, m3, m4} {
+		if _, err := r.EnqueueWaiting(ctx, msg, false); err != nil {
+			t.Fatalf("EnqueueWaiting returned error: %v", err)
+		}
+	}
+	dequeue := func(want *base.TaskMessage) *base.TaskMessage {
+		t.Helper()
+		msg, _, _, err := r.Dequeue(base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("Dequeue returned error: %v", err)
+		}
+		if msg.ID != want.ID {
+			t.Fatalf("Dequeue returned task %s, want %s", msg.ID, want.ID)
+		}
+		return msg
+	}
+
+	// A retried task keeps the key blocked.
+	msg := dequeue(m1)
+	if err := r.Retry(ctx, msg, time.Now().Add(-time.Second), "error", true); err != nil {
+		t.Fatalf("Retry returned error: %v", err)
+	}
+	if pending, _ := pendingAndWaitingIDs(t, r); len(pending) != 0 {
+		t.Errorf("pending list = %v after the task with the ordering key is retried, want empty", pending)
+	}
+	if err := r.ForwardIfReady(base.DefaultQueueName); err != nil {
+		t.Fatalf("ForwardIfReady returned error: %v", err)
+	}
+	msg = dequeue(m1)
+	if err := r.Done(ctx, msg); err != nil {
+		t.Fatalf("Done returned error: %v", err)
+	}
+	if err := r.Archive(ctx, dequeue(m2), "error"); err != nil {
+		t.Fatalf("Archive returned error: %v", err)
+	}
+	if err := r.Done(ctx, dequeue(m3)); err != nil {
+		t.Fatalf("Done returned error: %v", err)
+	}
+	if err := r.MarkAsComplete(ctx, dequeue(m4)); err != nil {
+		t.Fatalf("MarkAsComplete returned error: %v", err)
+	}
+
+	for _, key := range []string{base.OrderingKey(base.DefaultQueueName, "order-1"), base.AllOrderingKeys(base.DefaultQueueName)} {
+		if n := r.client.Exists(ctx, key).Val(); n != 0 {
+			t.Errorf("%q exists after all tasks with the ordering key are processed", key)
+		}
+	}
+}
+
+func TestReleaseOrderingKeys(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	m1 := newOrderingMessage("order-1")
+	m2 := newOrderingMessage("order-1")
+	m3 := newOrderingMessage("order-1")
+	for _, msg := range []*base.TaskMessage{m1, m2, m3} {
+		if _, err := r.EnqueueWaiting(ctx, msg, false); err != nil {
+			t.Fatalf("EnqueueWaiting returned error: %v", err)
+		}
+	}
+	if err := r.DeleteTask(base.DefaultQueueName, m1.ID); err != nil {
+		t.Fatalf("DeleteTask returned error: %v", err)
+	}
+	if pending, _ := pendingAndWaitingIDs(t, r); len(pending) != 0 {
+		t.Fatalf("pending list = %v before ReleaseOrderingKeys, want empty", pending)
+	}
+
+	if err := r.ReleaseOrderingKeys(base.DefaultQueueName); err != nil {
+		t.Fatalf("ReleaseOrderingKeys returned error: %v", err)
+	}
+	pending, waiting := pendingAndWaitingIDs(t, r)
+	if diff := cmp.Diff([]string{m2.ID}, pending); diff != "" {
+		t.Errorf("mismatch found in pending list; (-want,+got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{m3.ID}, waiting); diff != "" {
+		t.Errorf("mismatch found in waiting set; (-want,+got)\n%s", diff)
+	}
+}
+
+func TestArchiveExpiredTasks(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	ctx := context.Background()
+	now := time.Now()
+	clock := timeutil.NewSimulatedClock(now)
+	r.SetClock(clock)
+	newMsg := func(expireIn time.Duration) *base.TaskMessage {
+		msg := h.NewTaskMessage("warmup_cache", nil)
+		msg.ExpireAt = now.Add(expireIn).Unix()
+		return msg
+	}
+	retried, active, pending, scheduled, fresh := newMsg(10*time.Second), newMsg(10*time.Second),
+		newMsg(10*time.Second), newMsg(10*time.Second), newMsg(time.Hour)
+
+	for _, msg := range []*base.TaskMessage{retried, active} {
+		if err := r.Enqueue(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, _, err := r.Dequeue(base.DefaultQueueName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Retry(ctx, retried, now.Add(time.Hour), "error", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []*base.TaskMessage{pending, fresh} {
+		if err := r.Enqueue(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Schedule(ctx, scheduled, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if n := r.client.ZCard(ctx, base.ExpiringKey(base.DefaultQueueName)).Val(); n != 5 {
+		t.Fatalf("%q has %d tasks, want 5", base.ExpiringKey(base.DefaultQueueName), n)
+	}
+
+	clock.AdvanceTime(time.Minute)
+	if err := r.ArchiveExpiredTasks(base.DefaultQueueName); err != nil {
+		t.Fatalf("ArchiveExpiredTasks returned error: %v", err)
+	}
+
+	var gotArchived []string
+	for _, msg := range h.GetArchivedMessages(t, r.client, base.DefaultQueueName) {
+		if msg.ErrorMsg != base.ExpiredErrMsg {
+			t.Errorf("archived task %s has error message %q, want %q", msg.ID, msg.ErrorMsg, base.ExpiredErrMsg)
+		}
+		gotArchived = append(gotArchived, msg.ID)
+	}
+	if diff := cmp.Diff([]string{pending.ID, scheduled.ID, retried.ID}, gotArchived, h.SortStringSliceOpt); diff != "" {
+		t.Errorf("mismatch found in archived tasks; (-want,+got)\n%s", diff)
+	}
+	if got := h.GetPendingMessages(t, r.client, base.DefaultQueueName); len(got) != 1 || got[0].ID != fresh.ID {
+		t.Errorf("pending tasks = %v, want only the task which has not expired", got)
+	}
+	if got := h.GetActiveMessages(t, r.client, base.DefaultQueueName); len(got) != 1 || got[0].ID != active.ID {
+		t.Errorf("active tasks = %v, want the active task to be left as is", got)
+	}
+	gotExpiring := r.client.ZRange(ctx, base.ExpiringKey(base.DefaultQueueName), 0, -1).Val()
+	if diff := cmp.Diff([]string{fresh.ID}, gotExpiring); diff != "" {
+		t.Errorf("mismatch found in %q; (-want,+got)\n%s", base.ExpiringKey(base.DefaultQueueName), diff)
+	}
+}
+
+func TestRecordAndReadEvents(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	h.FlushDB(t, r.client)
+	ctx := context.Background()
+
+	last, err := r.LastEventID()
+	if err != nil {
+		t.Fatalf("(*RDB).LastEventID() returned an error: %v", err)
+	}
+	if last != "0-0" {
+		t.Errorf("(*RDB).LastEventID() = %q on an empty stream, want %q", last, "0-0")
+	}
+	now := time.Now()
+	events := []*base.TaskEvent{
+		{Kind: base.TaskEventEnqueued, TaskID: "t1", Queue: "default", Type: "send_email", Time: now},
+		{Kind: base.TaskEventStarted, TaskID: "t1", Queue: "default", Type: "send_email", ServerID: "srv1", Time: now.Add(time.Second)},
+	}
+	for _, ev := range events {
+		if err := r.RecordEvent(ev); err != nil {
+			t.Fatalf("(*RDB).RecordEvent() returned an error: %v", err)
+		}
+		if ev.ID == "" {
+			t.Errorf("(*RDB).RecordEvent() did not set the event ID")
+		}
+	}
+
+	got, err := r.ReadEvents(ctx, last, 10, -1)
+	if err != nil {
+		t.Fatalf("(*RDB).ReadEvents() returned an error: %v", err)
+	}
+	if diff := cmp.Diff(events, got); diff != "" {
+		t.Errorf("(*RDB).ReadEvents() = %v, want %v; (-want,+got)\n%s", got, events, diff)
+	}
+	if last, _ := r.LastEventID(); last != events[1].ID {
+		t.Errorf("(*RDB).LastEventID() = %q, want %q", last, events[1].ID)
+	}
+	got, err = r.ReadEvents(ctx, events[1].ID, 10, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("(*RDB).ReadEvents() returned an error: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("(*RDB).ReadEvents() after the last event = %v, want none", got)
+	}
+}
+
+func TestEventsOfBrokerOperations(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	h.FlushDB(t, r.client)
+	now := time.Now()
+	r.SetClock(timeutil.NewSimulatedClock(now))
+	ctx := context.Background()
+
+	parent := h.NewTaskMessageWithQueue("parent", nil, "default")
+	h.SeedActiveQueue(t, r.client, []*base.TaskMessage{parent}, "default")
+	h.SeedLease(t, r.client, []base.Z{{Message: parent, Score: now.Add(time.Minute).Unix()}}, "default")
+	child := h.NewTaskMessageWithQueue("child", nil, "default")
+	child.Dependencies = []string{parent.ID}
+	if _, err := r.EnqueueWaiting(ctx, child, false); err != nil {
+		t.Fatal(err)
+	}
+	last, err := r.LastEventID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Done(ctx, parent); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ArchiveAllPendingTasks("default"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.DeleteAllArchivedTasks("default"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.ReadEvents(ctx, last, 10, -1)
+	if err != nil {
+		t.Fatalf("(*RDB).ReadEvents() returned an error: %v", err)
+	}
+	var want []*base.TaskEvent
+	for _, kind := range []base.TaskEventKind{base.TaskEventReleased, base.TaskEventArchived, base.TaskEventDeleted} {
+		want = append(want, &base.TaskEvent{Kind: kind, TaskID: child.ID, Queue: "default", Type: child.Type, Time: now})
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(base.TaskEvent{}, "ID")); diff != "" {
+		t.Errorf("(*RDB).ReadEvents() = %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+}
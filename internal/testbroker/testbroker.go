@@ -11,7 +11,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/hibiken/asynq/internal/base"
 )
 
@@ -64,15 +63,33 @@ func (tb *TestBroker) EnqueueUnique(ctx context.Context, msg *base.TaskMessage,
 	return tb.real.EnqueueUnique(ctx, msg, ttl)
 }
 
-func (tb *TestBroker) Dequeue(qnames ...string) (*base.TaskMessage, time.Time, error) {
+func (tb *TestBroker) EnqueueBatch(ctx context.Context, msgs []*base.BatchMessage) ([]error, error) {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 	if tb.sleeping {
-		return nil, time.Time{}, errRedisDown
+		return nil, errRedisDown
+	}
+	return tb.real.EnqueueBatch(ctx, msgs)
+}
+
+func (tb *TestBroker) Dequeue(qnames ...string) (*base.TaskMessage, time.Time, time.Time, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, time.Time{}, time.Time{}, errRedisDown
 	}
 	return tb.real.Dequeue(qnames...)
 }
 
+func (tb *TestBroker) DequeueExcept(excludedTypes []string, qnames ...string) (*base.TaskMessage, time.Time, time.Time, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, time.Time{}, time.Time{}, errRedisDown
+	}
+	return tb.real.DequeueExcept(excludedTypes, qnames...)
+}
+
 func (tb *TestBroker) Done(ctx context.Context, msg *base.TaskMessage) error {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
@@ -118,6 +135,78 @@ func (tb *TestBroker) ScheduleUnique(ctx context.Context, msg *base.TaskMessage,
 	return tb.real.ScheduleUnique(ctx, msg, processAt, ttl)
 }
 
+func (tb *TestBroker) EnqueueWaiting(ctx context.Context, msg *base.TaskMessage, ignoreMissingDeps bool) (base.TaskState, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return 0, errRedisDown
+	}
+	return tb.real.EnqueueWaiting(ctx, msg, ignoreMissingDeps)
+}
+
+func (tb *TestBroker) EnqueueWaitingUnique(ctx context.Context, msg *base.TaskMessage, ttl time.Duration, ignoreMissingDeps bool) (base.TaskState, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return 0, errRedisDown
+	}
+	return tb.real.EnqueueWaitingUnique(ctx, msg, ttl, ignoreMissingDeps)
+}
+
+func (tb *TestBroker) ArchiveBlockedTasks(qname string) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return errRedisDown
+	}
+	return tb.real.ArchiveBlockedTasks(qname)
+}
+
+func (tb *TestBroker) ReleaseOrderingKeys(qname string) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return errRedisDown
+	}
+	return tb.real.ReleaseOrderingKeys(qname)
+}
+
+func (tb *TestBroker) ArchiveExpiredTasks(qname string) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return errRedisDown
+	}
+	return tb.real.ArchiveExpiredTasks(qname)
+}
+
+func (tb *TestBroker) Debounce(ctx context.Context, msg *base.TaskMessage, processAt time.Time) (string, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return "", errRedisDown
+	}
+	return tb.real.Debounce(ctx, msg, processAt)
+}
+
+func (tb *TestBroker) EnqueueChain(ctx context.Context, msgs []*base.TaskMessage) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return errRedisDown
+	}
+	return tb.real.EnqueueChain(ctx, msgs)
+}
+
+func (tb *TestBroker) ReadChainResult(qname, chainID string, step int) ([]byte, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, errRedisDown
+	}
+	return tb.real.ReadChainResult(qname, chainID, step)
+}
+
 func (tb *TestBroker) Retry(ctx context.Context, msg *base.TaskMessage, processAt time.Time, errMsg string, isFailure bool) error {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
@@ -154,6 +243,24 @@ func (tb *TestBroker) DeleteExpiredCompletedTasks(qname string) error {
 	return tb.real.DeleteExpiredCompletedTasks(qname)
 }
 
+func (tb *TestBroker) TakeRateLimitTokens(limits map[string]base.RateLimit) (map[string]time.Duration, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, errRedisDown
+	}
+	return tb.real.TakeRateLimitTokens(limits)
+}
+
+func (tb *TestBroker) RefundRateLimitTokens(limits map[string]base.RateLimit) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return errRedisDown
+	}
+	return tb.real.RefundRateLimitTokens(limits)
+}
+
 func (tb *TestBroker) ListLeaseExpired(cutoff time.Time, qnames ...string) ([]*base.TaskMessage, error) {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
@@ -190,13 +297,13 @@ func (tb *TestBroker) ClearServerState(host string, pid int, serverID string) er
 	return tb.real.ClearServerState(host, pid, serverID)
 }
 
-func (tb *TestBroker) CancelationPubSub() (*redis.PubSub, error) {
+func (tb *TestBroker) SubscribeCancelation() (base.CancelationSubscription, error) {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 	if tb.sleeping {
 		return nil, errRedisDown
 	}
-	return tb.real.CancelationPubSub()
+	return tb.real.SubscribeCancelation()
 }
 
 func (tb *TestBroker) PublishCancelation(id string) error {
@@ -208,6 +315,87 @@ func (tb *TestBroker) PublishCancelation(id string) error {
 	return tb.real.PublishCancelation(id)
 }
 
+func (tb *TestBroker) SubscribeServerConfig(serverID string) (base.ServerConfigSubscription, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, errRedisDown
+	}
+	return tb.real.SubscribeServerConfig(serverID)
+}
+
+func (tb *TestBroker) PublishServerConfig(serverID string, update *base.ServerConfigUpdate) (int, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return 0, errRedisDown
+	}
+	return tb.real.PublishServerConfig(serverID, update)
+}
+
+func (tb *TestBroker) SubscribeWakeup(qnames ...string) (base.WakeupSubscription, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, errRedisDown
+	}
+	return tb.real.SubscribeWakeup(qnames...)
+}
+
+func (tb *TestBroker) SubscribeSchedule(qnames ...string) (base.WakeupSubscription, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, errRedisDown
+	}
+	return tb.real.SubscribeSchedule(qnames...)
+}
+
+func (tb *TestBroker) SubscribeCompletion(qname, id string) (base.CompletionSubscription, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, errRedisDown
+	}
+	return tb.real.SubscribeCompletion(qname, id)
+}
+
+func (tb *TestBroker) GetTaskInfo(qname, id string) (*base.TaskInfo, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, errRedisDown
+	}
+	return tb.real.GetTaskInfo(qname, id)
+}
+
+func (tb *TestBroker) AllQueues() ([]string, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, errRedisDown
+	}
+	return tb.real.AllQueues()
+}
+
+func (tb *TestBroker) RecordEvent(ev *base.TaskEvent) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return errRedisDown
+	}
+	return tb.real.RecordEvent(ev)
+}
+
+func (tb *TestBroker) NextProcessAt(qnames ...string) (time.Time, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return time.Time{}, errRedisDown
+	}
+	return tb.real.NextProcessAt(qnames...)
+}
+
 func (tb *TestBroker) WriteResult(qname, id string, data []byte) (int, error) {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
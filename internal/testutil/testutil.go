@@ -29,6 +29,14 @@ func EquateInt64Approx(margin int64) cmp.Option {
 	})
 }
 
+// EquateZScoreApprox returns a Comparer option that treats the scores of
+// base.Z to be equal if they are within the given margin.
+func EquateZScoreApprox(margin int64) cmp.Option {
+	return cmp.FilterPath(func(p cmp.Path) bool {
+		return p.Last().String() == ".Score"
+	}, EquateInt64Approx(margin))
+}
+
 // SortMsgOpt is a cmp.Option to sort base.TaskMessage for comparing slice of task messages.
 var SortMsgOpt = cmp.Transformer("SortTaskMessages", func(in []*base.TaskMessage) []*base.TaskMessage {
 	out := append([]*base.TaskMessage(nil), in...) // Copy input to avoid mutating it
@@ -442,6 +450,13 @@ func GetCompletedMessages(tb testing.TB, r redis.UniversalClient, qname string)
 	return getMessagesFromZSet(tb, r, qname, base.CompletedKey, base.TaskStateCompleted)
 }
 
+// GetWaitingMessages returns all waiting task messages in the given queue.
+// It also asserts the state field of the task.
+func GetWaitingMessages(tb testing.TB, r redis.UniversalClient, qname string) []*base.TaskMessage {
+	tb.Helper()
+	return getMessagesFromZSet(tb, r, qname, base.WaitingKey, base.TaskStateWaiting)
+}
+
 // GetScheduledEntries returns all scheduled messages and its score in the given queue.
 // It also asserts the state field of the task.
 func GetScheduledEntries(tb testing.TB, r redis.UniversalClient, qname string) []base.Z {
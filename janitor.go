@@ -15,6 +15,9 @@ import (
 // A janitor is responsible for deleting expired completed tasks from the specified
 // queues. It periodically checks for any expired tasks in the completed set, and
 // deletes them.
+// It also archives waiting tasks which can never run because a task they depend on
+// was archived or deleted, releases ordering keys whose current task was archived or
+// deleted, and archives tasks which expired before being processed.
 type janitor struct {
 	logger *log.Logger
 	broker base.Broker
@@ -23,7 +26,7 @@ type janitor struct {
 	done chan struct{}
 
 	// list of queue names to check.
-	queues []string
+	queues *queueNames
 
 	// average interval between checks.
 	avgInterval time.Duration
@@ -41,7 +44,7 @@ func newJanitor(params janitorParams) *janitor {
 		logger:      params.logger,
 		broker:      params.broker,
 		done:        make(chan struct{}),
-		queues:      params.queues,
+		queues:      newQueueNames(params.queues),
 		avgInterval: params.interval,
 	}
 }
@@ -72,10 +75,22 @@ func (j *janitor) start(wg *sync.WaitGroup) {
 }
 
 func (j *janitor) exec() {
-	for _, qname := range j.queues {
+	for _, qname := range j.queues.get() {
 		if err := j.broker.DeleteExpiredCompletedTasks(qname); err != nil {
 			j.logger.Errorf("Failed to delete expired completed tasks from queue %q: %v",
 				qname, err)
 		}
+		if err := j.broker.ArchiveBlockedTasks(qname); err != nil {
+			j.logger.Errorf("Failed to archive blocked tasks from queue %q: %v",
+				qname, err)
+		}
+		if err := j.broker.ReleaseOrderingKeys(qname); err != nil {
+			j.logger.Errorf("Failed to release ordering keys of queue %q: %v",
+				qname, err)
+		}
+		if err := j.broker.ArchiveExpiredTasks(qname); err != nil {
+			j.logger.Errorf("Failed to archive expired tasks from queue %q: %v",
+				qname, err)
+		}
 	}
 }
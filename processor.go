@@ -29,19 +29,58 @@ type processor struct {
 	broker base.Broker
 	clock  timeutil.Clock
 
+	// serverID, host and pid identify the server in the task lifecycle events
+	// and in the attempts recorded in the task messages.
+	serverID string
+	host     string
+	pid      int
+
 	handler   Handler
 	baseCtxFn func() context.Context
 
+	// queueMu guards queueConfig and orderedQueues, which can be updated
+	// while the processor is running.
+	queueMu     sync.Mutex
 	queueConfig map[string]int
 
 	// orderedQueues is set only in strict-priority mode.
 	orderedQueues []string
 
+	// rateLimits maps a queue name to its rate limit.
+	rateLimits map[string]RateLimit
+
+	// throttled maps a rate limited queue name to the time until which the queue is skipped.
+	// It is accessed only by the goroutine calling exec.
+	throttled map[string]time.Time
+
+	// typeConcurrency maps a task type to the maximum number of active tasks of the type.
+	typeConcurrency map[string]int
+
+	// typeLimiter limits the number of active tasks of a type across servers, nil if not set.
+	typeLimiter TypeConcurrencyLimiter
+
+	// typeMu guards activeTypes.
+	typeMu sync.Mutex
+	// activeTypes maps a task type in typeConcurrency to the number of active tasks of the type.
+	activeTypes map[string]int
+
+	// saturated maps a task type to the time until which the tasks of the type are left
+	// in the queues, because typeLimiter had no slot for the type.
+	// It is accessed only by the goroutine calling exec.
+	saturated map[string]time.Time
+
 	retryDelayFunc RetryDelayFunc
 	isFailureFunc  func(error) bool
 
 	errHandler ErrorHandler
 
+	// observer is notified of the processing of tasks, nil if not set.
+	observer ProcessingObserver
+
+	// wakeupCh receives a value when a task is enqueued to one of the queues,
+	// nil if the wakeup notifications are disabled.
+	wakeupCh <-chan struct{}
+
 	shutdownTimeout time.Duration
 
 	// channel via which to send sync requests to syncer.
@@ -52,7 +91,7 @@ type processor struct {
 
 	// sema is a counting semaphore to ensure the number of active workers
 	// does not exceed the limit.
-	sema chan struct{}
+	sema *workerSemaphore
 
 	// channel to communicate back to the long running "processor" goroutine.
 	// once is used to send value to the channel only once.
@@ -75,6 +114,9 @@ type processor struct {
 type processorParams struct {
 	logger          *log.Logger
 	broker          base.Broker
+	serverID        string
+	host            string
+	pid             int
 	baseCtxFn       func() context.Context
 	retryDelayFunc  RetryDelayFunc
 	isFailureFunc   func(error) bool
@@ -83,7 +125,12 @@ type processorParams struct {
 	concurrency     int
 	queues          map[string]int
 	strictPriority  bool
+	rateLimits      map[string]RateLimit
+	typeConcurrency map[string]int
+	typeLimiter     TypeConcurrencyLimiter
 	errHandler      ErrorHandler
+	observer        ProcessingObserver
+	wakeupCh        <-chan struct{}
 	shutdownTimeout time.Duration
 	starting        chan<- *workerInfo
 	finished        chan<- *base.TaskMessage
@@ -99,20 +146,31 @@ func newProcessor(params processorParams) *processor {
 	return &processor{
 		logger:          params.logger,
 		broker:          params.broker,
+		serverID:        params.serverID,
+		host:            params.host,
+		pid:             params.pid,
 		baseCtxFn:       params.baseCtxFn,
 		clock:           timeutil.NewRealClock(),
 		queueConfig:     queues,
 		orderedQueues:   orderedQueues,
+		rateLimits:      params.rateLimits,
+		throttled:       make(map[string]time.Time),
+		typeConcurrency: params.typeConcurrency,
+		typeLimiter:     params.typeLimiter,
+		activeTypes:     make(map[string]int),
+		saturated:       make(map[string]time.Time),
 		retryDelayFunc:  params.retryDelayFunc,
 		isFailureFunc:   params.isFailureFunc,
 		syncRequestCh:   params.syncCh,
 		cancelations:    params.cancelations,
 		errLogLimiter:   rate.NewLimiter(rate.Every(3*time.Second), 1),
-		sema:            make(chan struct{}, params.concurrency),
+		sema:            newWorkerSemaphore(params.concurrency),
 		done:            make(chan struct{}),
 		quit:            make(chan struct{}),
 		abort:           make(chan struct{}),
 		errHandler:      params.errHandler,
+		observer:        params.observer,
+		wakeupCh:        params.wakeupCh,
 		handler:         HandlerFunc(func(ctx context.Context, t *Task) error { return fmt.Errorf("handler not set") }),
 		shutdownTimeout: params.shutdownTimeout,
 		starting:        params.starting,
@@ -141,9 +199,7 @@ func (p *processor) shutdown() {
 
 	p.logger.Info("Waiting for all workers to finish...")
 	// block until all workers have released the token
-	for i := 0; i < cap(p.sema); i++ {
-		p.sema <- struct{}{}
-	}
+	p.sema.wait()
 	p.logger.Info("All workers have finished")
 }
 
@@ -166,95 +222,174 @@ func (p *processor) start(wg *sync.WaitGroup) {
 // exec pulls a task out of the queue and starts a worker goroutine to
 // process the task.
 func (p *processor) exec() {
-	select {
-	case <-p.quit:
+	if !p.sema.acquire(p.quit) {
 		return
-	case p.sema <- struct{}{}: // acquire token
-		qnames := p.queues()
-		// 取出一个任务
-		msg, leaseExpirationTime, err := p.broker.Dequeue(qnames...)
-		switch {
-		case errors.Is(err, errors.ErrNoProcessableTask):
-			p.logger.Debug("All queues are empty")
-			// Queues are empty, this is a normal behavior.
-			// Sleep to avoid slamming redis and let scheduler move tasks into queues.
-			// Note: We are not using blocking pop operation and polling queues instead.
-			// This adds significant load to redis.
-			time.Sleep(time.Second)
-			<-p.sema // release token
-			return
-		case err != nil:
-			if p.errLogLimiter.Allow() {
-				p.logger.Errorf("Dequeue error: %v", err)
-			}
-			<-p.sema // release token
-			return
+	}
+	if !p.hasQueues() {
+		// No queues to process yet, e.g. the queue patterns match no queues.
+		p.waitForTask()
+		p.sema.release()
+		return
+	}
+	qnames, taken := p.takeRateLimitTokens(p.queues())
+	if len(qnames) == 0 {
+		// All queues have reached their rate limits.
+		time.Sleep(p.throttleDelay())
+		p.sema.release()
+		return
+	}
+	// 取出一个任务
+	var (
+		msg                               *base.TaskMessage
+		leaseExpirationTime, pendingSince time.Time
+		err                               error
+	)
+	if excluded := p.excludedTypes(); len(excluded) > 0 {
+		msg, leaseExpirationTime, pendingSince, err = p.broker.DequeueExcept(excluded, qnames...)
+	} else {
+		msg, leaseExpirationTime, pendingSince, err = p.broker.Dequeue(qnames...)
+	}
+	if err == nil {
+		p.refundRateLimitTokens(taken, msg.Queue)
+	} else {
+		p.refundRateLimitTokens(taken, "")
+	}
+	switch {
+	case errors.Is(err, errors.ErrNoProcessableTask):
+		p.logger.Debug("All queues are empty")
+		// Queues are empty, this is a normal behavior.
+		// Wait to avoid slamming redis and let scheduler move tasks into queues.
+		// Note: We are not using blocking pop operation and polling queues instead.
+		// This adds significant load to redis.
+		p.waitForTask()
+		p.sema.release()
+		return
+	case err != nil:
+		if p.errLogLimiter.Allow() {
+			p.logger.Errorf("Dequeue error: %v", err)
 		}
+		p.sema.release()
+		return
+	}
 
-		lease := base.NewLease(leaseExpirationTime)
-		deadline := p.computeDeadline(msg)
-		p.starting <- &workerInfo{msg, time.Now(), deadline, lease}
-		go func() {
-			defer func() {
-				p.finished <- msg
-				<-p.sema // release token
-			}()
-
-			ctx, cancel := asynqcontext.New(p.baseCtxFn(), msg, deadline)
-			// 添加任务ID和取消函数映射到 map中
-			p.cancelations.Add(msg.ID, cancel)
-			defer func() {
-				cancel()
-				p.cancelations.Delete(msg.ID)
-			}()
-
-			// check context before starting a worker goroutine.
-			select {
-			case <-ctx.Done():
-				// already canceled (e.g. deadline exceeded).
-				p.handleFailedMessage(ctx, lease, msg, ctx.Err())
-				return
-			default:
-			}
+	lease := base.NewLease(leaseExpirationTime)
+	if msg.ExpireAt > 0 && p.clock.Now().Unix() >= msg.ExpireAt {
+		// The task expired before the janitor archived it; archive it without processing.
+		p.logger.Warnf("Task id=%s expired before being processed", msg.ID)
+		p.recordEvent(msg, base.TaskEventArchived)
+		p.archive(lease, msg, errTaskExpired)
+		p.sema.release()
+		return
+	}
+	deadline := p.computeDeadline(msg)
+	if !p.acquireTypeSlot(msg, deadline) {
+		// Another server took the last slot for the task type after excludedTypes checked the limiter,
+		// push the task back to the head of the queue to be processed later.
+		p.requeue(lease, msg)
+		p.sema.release()
+		return
+	}
+	if p.observer != nil && !pendingSince.IsZero() {
+		p.observer.ObserveQueueWait(msg.Queue, msg.Type, p.clock.Now().Sub(pendingSince))
+	}
+	p.starting <- &workerInfo{msg, time.Now(), deadline, lease}
+	go func() {
+		defer func() {
+			p.releaseTypeSlot(msg)
+			p.finished <- msg
+			p.sema.release()
+		}()
+		p.recordEvent(msg, base.TaskEventStarted)
+		startTime := p.clock.Now()
 
-			resCh := make(chan error, 1)
-			go func() {
-				task := newTask(
-					msg.Type,
-					msg.Payload,
-					&ResultWriter{
-						id:     msg.ID,
-						qname:  msg.Queue,
-						broker: p.broker,
-						ctx:    ctx,
-					},
-				)
-				// 执行handler
-				resCh <- p.perform(ctx, task)
-			}()
+		ctx, cancel := asynqcontext.New(p.baseCtxFn(), msg, deadline)
+		// 添加任务ID和取消函数映射到 map中
+		p.cancelations.Add(msg.ID, cancel)
+		defer func() {
+			cancel()
+			p.cancelations.Delete(msg.ID)
+		}()
 
-			select {
-			case <-p.abort:
-				// time is up, push the message back to queue and quit this worker goroutine.
-				p.logger.Warnf("Quitting worker. task id=%s", msg.ID)
-				p.requeue(lease, msg)
-				return
-			case <-lease.Done():
-				cancel()
-				p.handleFailedMessage(ctx, lease, msg, ErrLeaseExpired)
-				return
-			case <-ctx.Done():
-				p.handleFailedMessage(ctx, lease, msg, ctx.Err())
-				return
-			case resErr := <-resCh:
-				if resErr != nil {
-					p.handleFailedMessage(ctx, lease, msg, resErr)
+		// check context before starting a worker goroutine.
+		select {
+		case <-ctx.Done():
+			// already canceled (e.g. deadline exceeded).
+			p.handleFailedMessage(ctx, lease, p.withAttempt(msg, startTime, ctx.Err()), ctx.Err())
+			return
+		default:
+		}
+
+		resCh := make(chan error, 1)
+		go func() {
+			task := newTask(
+				msg.Type,
+				msg.Payload,
+				msg.Headers,
+				&ResultWriter{
+					id:     msg.ID,
+					qname:  msg.Queue,
+					broker: p.broker,
+					ctx:    ctx,
+				},
+			)
+			if msg.ChainID != "" && msg.ChainStep > 0 {
+				prev, err := p.broker.ReadChainResult(msg.Queue, msg.ChainID, msg.ChainStep-1)
+				if err != nil {
+					resCh <- fmt.Errorf("could not read result of the previous task in chain %q: %v", msg.ChainID, err)
 					return
 				}
-				// 任务执行成功
-				p.handleSucceededMessage(lease, msg)
+				task.prevResult = prev
 			}
+			// 执行handler
+			start := p.clock.Now()
+			err := p.perform(ctx, task)
+			if p.observer != nil {
+				p.observer.ObserveHandlerDuration(msg.Queue, msg.Type, p.clock.Now().Sub(start))
+			}
+			resCh <- err
 		}()
+
+		select {
+		case <-p.abort:
+			// time is up, push the message back to queue and quit this worker goroutine.
+			p.logger.Warnf("Quitting worker. task id=%s", msg.ID)
+			p.observeOutcome(msg, TaskOutcomeShutdownRequeue)
+			p.requeue(lease, msg)
+			return
+		case <-lease.Done():
+			cancel()
+			p.observeOutcome(msg, TaskOutcomeLeaseExpired)
+			p.handleFailedMessage(ctx, lease, p.withAttempt(msg, startTime, ErrLeaseExpired), ErrLeaseExpired)
+			return
+		case <-ctx.Done():
+			p.handleFailedMessage(ctx, lease, p.withAttempt(msg, startTime, ctx.Err()), ctx.Err())
+			return
+		case resErr := <-resCh:
+			if resErr != nil {
+				p.handleFailedMessage(ctx, lease, p.withAttempt(msg, startTime, resErr), resErr)
+				return
+			}
+			// 任务执行成功
+			p.observeOutcome(msg, TaskOutcomeSuccess)
+			p.recordEvent(msg, base.TaskEventSucceeded)
+			p.handleSucceededMessage(lease, p.withAttempt(msg, startTime, nil))
+		}
+	}()
+}
+
+// waitForTask waits until the queues are polled again after they were found empty.
+// It returns after a second, or as soon as a task is enqueued if wakeup notifications are enabled.
+func (p *processor) waitForTask() {
+	if p.wakeupCh == nil {
+		time.Sleep(time.Second)
+		return
+	}
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case <-p.wakeupCh:
+	case <-timer.C:
+	case <-p.quit:
 	}
 }
 
@@ -325,20 +460,255 @@ func (p *processor) markAsDone(l *base.Lease, msg *base.TaskMessage) {
 // the task should not be retried and should be archived instead.
 var SkipRetry = errors.New("skip retry for the task")
 
+// RetryAfter returns an error which wraps err and makes the task retried after the delay d,
+// instead of the delay computed by Config.RetryDelayFunc.
+//
+// Use this function to return from Handler.ProcessTask when the handler knows when the task
+// can succeed, e.g. when a rate limited API responds with a Retry-After header.
+// Otherwise the error is handled as err: it counts as a failure unless Config.IsFailure reports
+// otherwise, and the task is archived once its retry count is exhausted.
+func RetryAfter(d time.Duration, err error) error {
+	return &retryAfterError{delay: d, err: err}
+}
+
+// Snooze returns an error which makes the task processed again after the delay d.
+//
+// Use this function to return from Handler.ProcessTask when the task cannot be processed yet,
+// e.g. while waiting on an external system to become ready.
+// A snoozed task is moved to the retry state, but the error is not a failure: the retried count
+// of the task is not incremented, and ErrorHandler and Config.IsFailure are not called.
+func Snooze(d time.Duration) error {
+	return &retryAfterError{delay: d, snooze: true}
+}
+
+// retryAfterError is the error returned by RetryAfter and Snooze.
+type retryAfterError struct {
+	delay  time.Duration
+	err    error // nil if snooze is true
+	snooze bool
+}
+
+func (e *retryAfterError) Error() string {
+	if e.snooze {
+		return fmt.Sprintf("snoozed for %v", e.delay)
+	}
+	if e.err == nil {
+		return fmt.Sprintf("retry after %v", e.delay)
+	}
+	return e.err.Error()
+}
+
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// isSnooze reports whether err is an error returned by Snooze.
+func isSnooze(err error) bool {
+	var rerr *retryAfterError
+	return errors.As(err, &rerr) && rerr.snooze
+}
+
+// WithErrorKind returns an error which wraps err and has the given kind.
+//
+// Use this function to return from Handler.ProcessTask to classify the error, so that a task
+// enqueued with a RetryPolicy listing the kind in NonRetryable is archived without being retried.
+// An error also has a kind if it has an ErrorKind() string method; the kind of an error is
+// the kind of the first error in its chain which has one.
+func WithErrorKind(kind string, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+// kindError is the error returned by WithErrorKind.
+type kindError struct {
+	kind string
+	err  error
+}
+
+func (e *kindError) Error() string {
+	if e.err == nil {
+		return e.kind
+	}
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error     { return e.err }
+func (e *kindError) ErrorKind() string { return e.kind }
+
+// isNonRetryable reports whether the kind of err is listed as non-retryable in the retry policy p.
+func isNonRetryable(p *base.RetryPolicy, err error) bool {
+	if p == nil || len(p.NonRetryable) == 0 {
+		return false
+	}
+	var kerr interface{ ErrorKind() string }
+	if !errors.As(err, &kerr) {
+		return false
+	}
+	kind := kerr.ErrorKind()
+	for _, k := range p.NonRetryable {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// retryPolicyDelay returns the delay before retrying a task with the retry policy p
+// which has been retried n times. r is a random number in [0, 1) used to apply the jitter.
+func retryPolicyDelay(p *base.RetryPolicy, n int, r float64) time.Duration {
+	var d float64
+	switch p.Backoff {
+	case base.BackoffLinear:
+		d = float64(p.BaseDelay) * float64(n+1)
+	case base.BackoffFixed:
+		d = float64(p.BaseDelay)
+	default:
+		d = float64(p.BaseDelay) * math.Pow(2, float64(n))
+	}
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	d *= 1 + p.Jitter*(2*r-1)
+	if d >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// errTaskExpired is the error used to archive a task which expired before being processed.
+var errTaskExpired = errors.New(base.ExpiredErrMsg)
+
 func (p *processor) handleFailedMessage(ctx context.Context, l *base.Lease, msg *base.TaskMessage, err error) {
+	if isSnooze(err) {
+		p.observeOutcome(msg, TaskOutcomeRetry)
+		p.recordEvent(msg, base.TaskEventRetried)
+		p.retry(l, msg, err, false /*isFailure*/)
+		return
+	}
+	// A canceled task is recorded as cancelled instead of failed,
+	// then retried or archived in the same way as a failed task.
+	cancelled := errors.Is(err, context.Canceled) && errors.Is(ctx.Err(), context.Canceled)
+	if cancelled {
+		p.recordEvent(msg, base.TaskEventCancelled)
+	}
 	if p.errHandler != nil {
 		p.errHandler.HandleError(ctx, NewTask(msg.Type, msg.Payload), err)
 	}
 	if !p.isFailureFunc(err) {
 		// retry the task without marking it as failed
+		p.observeOutcome(msg, TaskOutcomeRetry)
+		p.recordEvent(msg, base.TaskEventRetried)
 		p.retry(l, msg, err, false /*isFailure*/)
 		return
 	}
-	if msg.Retried >= msg.Retry || errors.Is(err, SkipRetry) {
-		p.logger.Warnf("Retry exhausted for task id=%s", msg.ID)
+	p.observeOutcome(msg, TaskOutcomeFailure)
+	if !cancelled {
+		p.recordEvent(msg, base.TaskEventFailed)
+	}
+	if reason := noRetryReason(msg, err, p.clock.Now()); reason != "" {
+		p.logger.Warnf("%s for task id=%s", reason, msg.ID)
+		p.observeOutcome(msg, TaskOutcomeArchive)
+		p.recordEvent(msg, base.TaskEventArchived)
 		p.archive(l, msg, err)
-	} else {
-		p.retry(l, msg, err, true /*isFailure*/)
+		return
+	}
+	p.observeOutcome(msg, TaskOutcomeRetry)
+	p.recordEvent(msg, base.TaskEventRetried)
+	p.retry(l, msg, err, true /*isFailure*/)
+}
+
+// noRetryReason returns the reason why the task which failed with err should be archived
+// instead of retried, or an empty string if the task should be retried.
+func noRetryReason(msg *base.TaskMessage, err error, now time.Time) string {
+	switch {
+	case msg.Retried >= msg.Retry || errors.Is(err, SkipRetry):
+		return "Retry exhausted"
+	case isNonRetryable(msg.RetryPolicy, err):
+		return "Non-retryable error"
+	case retryTimeExhausted(msg, now):
+		return "Retry time limit exceeded"
+	}
+	return ""
+}
+
+// retryTimeExhausted reports whether the max elapsed time of the task's retry policy
+// has passed since the first attempt to process the task.
+func retryTimeExhausted(msg *base.TaskMessage, now time.Time) bool {
+	if msg.RetryPolicy == nil || msg.RetryPolicy.MaxElapsed <= 0 || msg.FirstAttemptedAt == 0 {
+		return false
+	}
+	return now.Sub(time.Unix(msg.FirstAttemptedAt, 0)) >= msg.RetryPolicy.MaxElapsed
+}
+
+// retryDelay returns the delay before retrying the task which failed with err.
+// A delay requested by the error takes precedence over the retry policy of the task,
+// which takes precedence over fn.
+func retryDelay(msg *base.TaskMessage, err error, fn RetryDelayFunc) time.Duration {
+	var rerr *retryAfterError
+	switch {
+	case errors.As(err, &rerr):
+		return rerr.delay
+	case msg.RetryPolicy != nil:
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		return retryPolicyDelay(msg.RetryPolicy, msg.Retried, r.Float64())
+	default:
+		return fn(msg.Retried, err, NewTask(msg.Type, msg.Payload))
+	}
+}
+
+// observeOutcome notifies the observer, if any, of the outcome of processing the given task.
+func (p *processor) observeOutcome(msg *base.TaskMessage, outcome TaskOutcome) {
+	if p.observer != nil {
+		p.observer.ObserveOutcome(msg.Queue, msg.Type, outcome)
+	}
+}
+
+// withAttempt returns a copy of msg with the attempt which started at startTime
+// and ended with err added to its attempts, so that the attempt is stored along with the task.
+func (p *processor) withAttempt(msg *base.TaskMessage, startTime time.Time, err error) *base.TaskMessage {
+	a := &base.TaskAttempt{
+		StartTime: startTime,
+		Duration:  p.clock.Now().Sub(startTime),
+		ServerID:  p.serverID,
+		Host:      p.host,
+		PID:       p.pid,
+		Outcome:   base.AttemptSucceeded,
+	}
+	if err != nil {
+		a.ErrorMsg = err.Error()
+		switch {
+		case isSnooze(err):
+			a.Outcome = base.AttemptSnoozed
+		case errors.Is(err, ErrLeaseExpired):
+			a.Outcome = base.AttemptLeaseExpired
+		case errors.Is(err, context.Canceled):
+			a.Outcome = base.AttemptCancelled
+		case errors.Is(err, context.DeadlineExceeded):
+			a.Outcome = base.AttemptTimedOut
+		default:
+			a.Outcome = base.AttemptFailed
+		}
+		var perr *panicError
+		a.Panic = errors.As(err, &perr)
+	}
+	m := *msg
+	m.AddAttempt(a)
+	if m.FirstAttemptedAt == 0 {
+		m.FirstAttemptedAt = startTime.Unix()
+	}
+	return &m
+}
+
+// recordEvent records the lifecycle event of the given task to the event stream.
+// A failure to record the event is logged and does not affect the processing of the task.
+func (p *processor) recordEvent(msg *base.TaskMessage, kind base.TaskEventKind) {
+	err := p.broker.RecordEvent(&base.TaskEvent{
+		Kind:     kind,
+		TaskID:   msg.ID,
+		Queue:    msg.Queue,
+		Type:     msg.Type,
+		ServerID: p.serverID,
+		Time:     p.clock.Now(),
+	})
+	if err != nil && p.errLogLimiter.Allow() {
+		p.logger.Errorf("Could not record %s event of task id=%s: %v", kind, msg.ID, err)
 	}
 }
 
@@ -348,8 +718,7 @@ func (p *processor) retry(l *base.Lease, msg *base.TaskMessage, e error, isFailu
 		return
 	}
 	ctx, _ := context.WithDeadline(context.Background(), l.Deadline())
-	d := p.retryDelayFunc(msg.Retried, e, NewTask(msg.Type, msg.Payload))
-	retryAt := time.Now().Add(d)
+	retryAt := time.Now().Add(retryDelay(msg, e, p.retryDelayFunc))
 	err := p.broker.Retry(ctx, msg, retryAt, e.Error(), isFailure)
 	if err != nil {
 		errMsg := fmt.Sprintf("Could not move task id=%s from %q to %q", msg.ID, base.ActiveKey(msg.Queue), base.RetryKey(msg.Queue))
@@ -385,6 +754,193 @@ func (p *processor) archive(l *base.Lease, msg *base.TaskMessage, e error) {
 }
 
 // queues returns a list of queues to query.
+// It returns the queues in the order given by queuesByPriority,
+// skipping the rate limited queues which are throttled.
+func (p *processor) queues() []string {
+	qnames := p.queuesByPriority()
+	if len(p.throttled) == 0 {
+		return qnames
+	}
+	now := p.clock.Now()
+	res := make([]string, 0, len(qnames))
+	for _, qname := range qnames {
+		if until, ok := p.throttled[qname]; ok {
+			if now.Before(until) {
+				continue
+			}
+			delete(p.throttled, qname)
+		}
+		res = append(res, qname)
+	}
+	return res
+}
+
+// takeRateLimitTokens returns the queues in qnames to query, taking a token from the token bucket
+// of each rate limited queue in a single call. A queue is skipped and throttled if its rate limit
+// is reached, or if its token bucket cannot be checked.
+// It also returns the rate limits of the queues a token was taken for, so that the tokens of the queues
+// no task is dequeued from can be refunded.
+func (p *processor) takeRateLimitTokens(qnames []string) ([]string, map[string]base.RateLimit) {
+	if len(p.rateLimits) == 0 {
+		return qnames, nil
+	}
+	limits := make(map[string]base.RateLimit)
+	for _, qname := range qnames {
+		if rl, ok := p.rateLimits[qname]; ok {
+			limits[qname] = base.RateLimit{Limit: rl.Limit, Burst: rl.Burst}
+		}
+	}
+	if len(limits) == 0 {
+		return qnames, nil
+	}
+	waits, err := p.broker.TakeRateLimitTokens(limits)
+	if err != nil && p.errLogLimiter.Allow() {
+		p.logger.Errorf("Failed to take rate limit tokens: %v", err)
+	}
+	res := make([]string, 0, len(qnames))
+	for _, qname := range qnames {
+		if _, ok := limits[qname]; ok {
+			if err != nil {
+				continue
+			}
+			if wait := waits[qname]; wait > 0 {
+				p.throttled[qname] = p.clock.Now().Add(wait)
+				delete(limits, qname)
+				continue
+			}
+		}
+		res = append(res, qname)
+	}
+	if err != nil {
+		return res, nil
+	}
+	return res, limits
+}
+
+// refundRateLimitTokens puts back the tokens taken by takeRateLimitTokens,
+// except the one for the queue the task was dequeued from, if any.
+func (p *processor) refundRateLimitTokens(taken map[string]base.RateLimit, dequeued string) {
+	delete(taken, dequeued)
+	if len(taken) == 0 {
+		return
+	}
+	if err := p.broker.RefundRateLimitTokens(taken); err != nil {
+		p.logger.Warnf("Failed to refund rate limit tokens: %v", err)
+	}
+}
+
+// throttleDelay returns the duration until the earliest throttled queue can be queried again,
+// which is at most a second.
+func (p *processor) throttleDelay() time.Duration {
+	d := time.Second
+	now := p.clock.Now()
+	for _, until := range p.throttled {
+		if wait := until.Sub(now); wait < d {
+			d = wait
+		}
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// typeSaturationDelay is the duration for which the tasks of a type are left in the queues
+// after the TypeConcurrencyLimiter had no slot for the type.
+const typeSaturationDelay = time.Second
+
+// typeLimiterTimeout is the timeout to check the slots of the TypeConcurrencyLimiter
+// and to release a slot acquired from it.
+const typeLimiterTimeout = 5 * time.Second
+
+// excludedTypes returns the task types to leave in the queues, which are the types
+// that reached their concurrency limit and the types that have no slot in the cluster.
+func (p *processor) excludedTypes() []string {
+	p.checkTypeLimiter()
+	var types []string
+	p.typeMu.Lock()
+	for typename, n := range p.activeTypes {
+		if n >= p.typeConcurrency[typename] {
+			types = append(types, typename)
+		}
+	}
+	now := p.clock.Now()
+	for typename, until := range p.saturated {
+		if !now.Before(until) {
+			delete(p.saturated, typename)
+			continue
+		}
+		if n, ok := p.activeTypes[typename]; !ok || n < p.typeConcurrency[typename] {
+			types = append(types, typename)
+		}
+	}
+	p.typeMu.Unlock()
+	return types
+}
+
+// checkTypeLimiter marks the task types which have no slot in the TypeConcurrencyLimiter as saturated,
+// so that the tasks of these types are left in the queues instead of being dequeued and pushed back.
+func (p *processor) checkTypeLimiter() {
+	if p.typeLimiter == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(p.baseCtxFn(), typeLimiterTimeout)
+	types, err := p.typeLimiter.Saturated(ctx)
+	cancel()
+	if err != nil {
+		if p.errLogLimiter.Allow() {
+			p.logger.Errorf("Failed to check concurrency slots of task types: %v", err)
+		}
+		return
+	}
+	until := p.clock.Now().Add(typeSaturationDelay)
+	for _, typename := range types {
+		p.saturated[typename] = until
+	}
+}
+
+// acquireTypeSlot reserves a slot to process the task within the concurrency limit of its type.
+// It returns false if the TypeConcurrencyLimiter has no slot for the type, in which case
+// the tasks of the type are left in the queues for typeSaturationDelay.
+func (p *processor) acquireTypeSlot(msg *base.TaskMessage, deadline time.Time) bool {
+	if p.typeLimiter != nil {
+		ctx, cancel := asynqcontext.New(p.baseCtxFn(), msg, deadline)
+		ok, err := p.typeLimiter.Acquire(ctx, msg.Type)
+		cancel()
+		if err != nil && p.errLogLimiter.Allow() {
+			p.logger.Errorf("Failed to acquire concurrency slot for task type %q: %v", msg.Type, err)
+		}
+		if !ok || err != nil {
+			p.saturated[msg.Type] = p.clock.Now().Add(typeSaturationDelay)
+			return false
+		}
+	}
+	if _, ok := p.typeConcurrency[msg.Type]; ok {
+		p.typeMu.Lock()
+		p.activeTypes[msg.Type]++
+		p.typeMu.Unlock()
+	}
+	return true
+}
+
+// releaseTypeSlot releases the slot reserved by acquireTypeSlot once the task is processed.
+func (p *processor) releaseTypeSlot(msg *base.TaskMessage) {
+	if _, ok := p.typeConcurrency[msg.Type]; ok {
+		p.typeMu.Lock()
+		p.activeTypes[msg.Type]--
+		p.typeMu.Unlock()
+	}
+	if p.typeLimiter != nil {
+		// The task context may be done already, use a new one to release the slot.
+		ctx, cancel := asynqcontext.New(context.Background(), msg, time.Now().Add(typeLimiterTimeout))
+		defer cancel()
+		if err := p.typeLimiter.Release(ctx, msg.Type); err != nil {
+			p.logger.Warnf("Failed to release concurrency slot for task type %q: %v", msg.Type, err)
+		}
+	}
+}
+
+// queuesByPriority returns a list of queues to query.
 // Order of the queue names is based on the priority of each queue.
 // Queue names is sorted by their priority level if strict-priority is true.
 // If strict-priority is false, then the order of queue names are roughly based on
@@ -392,7 +948,9 @@ func (p *processor) archive(l *base.Lease, msg *base.TaskMessage, e error) {
 // 队列返回要查询的队列列表。
 // 队列名称的顺序基于每个队列的优先级。如果严格优先级为 true，则队列名称按其优先级排序。
 // 如果严格优先级为 false，则队列名称的顺序大致基于优先级级别，但会随机化，以避免低优先级队列匮乏
-func (p *processor) queues() []string {
+func (p *processor) queuesByPriority() []string {
+	p.queueMu.Lock()
+	defer p.queueMu.Unlock()
 	// skip the overhead of generating a list of queue names
 	// if we are processing one queue.
 	// 队列配置中只有一个队列的话，之前返回这个队列
@@ -418,6 +976,41 @@ func (p *processor) queues() []string {
 	return uniq(names, len(p.queueConfig))
 }
 
+// hasQueues reports whether the processor has any queues to process.
+func (p *processor) hasQueues() bool {
+	p.queueMu.Lock()
+	defer p.queueMu.Unlock()
+	return len(p.queueConfig) > 0
+}
+
+// setQueues replaces the queues to process and the strict-priority mode.
+// The change takes effect from the next dequeue.
+func (p *processor) setQueues(queues map[string]int, strictPriority bool) {
+	queues = normalizeQueues(queues)
+	var orderedQueues []string
+	if strictPriority {
+		orderedQueues = sortByPriority(queues)
+	}
+	p.queueMu.Lock()
+	defer p.queueMu.Unlock()
+	p.queueConfig = queues
+	p.orderedQueues = orderedQueues
+}
+
+// setConcurrency changes the maximum number of active workers.
+// When decreased, active workers are not interrupted; new tasks are not
+// processed until the number of active workers drops below the new limit.
+func (p *processor) setConcurrency(n int) {
+	p.sema.resize(n)
+}
+
+// panicError is the error returned by perform when the handler panics.
+type panicError struct {
+	msg string
+}
+
+func (e *panicError) Error() string { return e.msg }
+
 // perform calls the handler with the given task.
 // If the call returns without panic, it simply returns the value,
 // otherwise, it recovers from panic and returns an error.
@@ -436,9 +1029,13 @@ func (p *processor) perform(ctx context.Context, task *Task) (err error) {
 
 			// Include the file and line number info in the error, if runtime.Caller returned ok.
 			if ok {
-				err = fmt.Errorf("panic [%s:%d]: %v", file, line, x)
+				err = &panicError{fmt.Sprintf("panic [%s:%d]: %v", file, line, x)}
 			} else {
-				err = fmt.Errorf("panic: %v", x)
+				err = &panicError{fmt.Sprintf("panic: %v", x)}
+			}
+			if p.observer != nil {
+				qname, _ := asynqcontext.GetQueueName(ctx)
+				p.observer.ObserveOutcome(qname, task.Type(), TaskOutcomePanic)
 			}
 		}
 	}()
@@ -492,6 +1089,9 @@ func (x byPriority) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
 
 // normalizeQueues divides priority numbers by their greatest common divisor.
 func normalizeQueues(queues map[string]int) map[string]int {
+	if len(queues) == 0 {
+		return map[string]int{}
+	}
 	var xs []int
 	for _, x := range queues {
 		xs = append(xs, x)
@@ -536,3 +1136,76 @@ func (p *processor) computeDeadline(msg *base.TaskMessage) time.Time {
 	}
 	return time.Unix(msg.Deadline, 0)
 }
+
+// workerSemaphore is a counting semaphore limiting the number of active workers.
+// Unlike a buffered channel, it can be resized while tokens are held.
+type workerSemaphore struct {
+	mu   sync.Mutex
+	size int
+	used int
+	// changed is closed and replaced whenever a token is released or the size changes.
+	changed chan struct{}
+}
+
+func newWorkerSemaphore(size int) *workerSemaphore {
+	return &workerSemaphore{size: size, changed: make(chan struct{})}
+}
+
+// acquire blocks until a token is available or quit is closed.
+// It reports whether a token was acquired.
+func (s *workerSemaphore) acquire(quit <-chan struct{}) bool {
+	for {
+		select {
+		case <-quit:
+			return false
+		default:
+		}
+		s.mu.Lock()
+		if s.used < s.size {
+			s.used++
+			s.mu.Unlock()
+			return true
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-quit:
+			return false
+		case <-changed:
+		}
+	}
+}
+
+func (s *workerSemaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used--
+	s.broadcast()
+}
+
+func (s *workerSemaphore) resize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = size
+	s.broadcast()
+}
+
+// wait blocks until all tokens are released.
+func (s *workerSemaphore) wait() {
+	for {
+		s.mu.Lock()
+		if s.used == 0 {
+			s.mu.Unlock()
+			return
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		<-changed
+	}
+}
+
+// broadcast wakes up the goroutines waiting for a change. Caller must hold s.mu.
+func (s *workerSemaphore) broadcast() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
@@ -8,7 +8,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -379,10 +381,17 @@ func TestProcessorRetry(t *testing.T) {
 			wantRetry = append(wantRetry,
 				base.Z{
 					Message: h.TaskMessageAfterRetry(*msg, tc.wantErrMsg, runTime),
-					Score:   runTime.Add(tc.delay).Unix(),
+					Score:   base.ProcessAtScore(runTime.Add(tc.delay)),
 				})
 		}
-		if diff := cmp.Diff(wantRetry, gotRetry, h.SortZSetEntryOpt, cmpOpt); diff != "" {
+		// Attempts are checked in TestProcessorRecordsAttempts and FirstAttemptedAt in TestProcessorRetryPolicy.
+		ignoreAttempts := cmpopts.IgnoreFields(base.TaskMessage{}, "Attempts", "FirstAttemptedAt")
+		retryCmpOpt := cmp.Options{
+			h.EquateZScoreApprox(tc.wait.Milliseconds()), // retry scores are in milliseconds
+			cmp.FilterPath(func(p cmp.Path) bool { return p.Last().String() != ".Score" }, cmpOpt),
+			ignoreAttempts,
+		}
+		if diff := cmp.Diff(wantRetry, gotRetry, h.SortZSetEntryOpt, retryCmpOpt); diff != "" {
 			t.Errorf("%s: mismatch found in %q after running processor; (-want, +got)\n%s", tc.desc, base.RetryKey(base.DefaultQueueName), diff)
 		}
 
@@ -395,7 +404,7 @@ func TestProcessorRetry(t *testing.T) {
 					Score:   runTime.Unix(),
 				})
 		}
-		if diff := cmp.Diff(wantArchived, gotArchived, h.SortZSetEntryOpt, cmpOpt); diff != "" {
+		if diff := cmp.Diff(wantArchived, gotArchived, h.SortZSetEntryOpt, cmpOpt, ignoreAttempts); diff != "" {
 			t.Errorf("%s: mismatch found in %q after running processor; (-want, +got)\n%s", tc.desc, base.ArchivedKey(base.DefaultQueueName), diff)
 		}
 
@@ -409,6 +418,337 @@ func TestProcessorRetry(t *testing.T) {
 	}
 }
 
+func TestProcessorRetryAfterAndSnooze(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("rate_limited", nil)
+	m2 := h.NewTaskMessage("not_ready", nil)
+	m3 := h.NewTaskMessage("exhausted", nil)
+	m3.Retried = m3.Retry // m3 has reached its max retry count
+	h.FlushDB(t, r)
+	h.SeedPendingQueue(t, r, []*base.TaskMessage{m1, m2, m3}, base.DefaultQueueName)
+
+	handler := func(ctx context.Context, task *Task) error {
+		if task.Type() == "not_ready" {
+			return Snooze(5 * time.Minute)
+		}
+		return RetryAfter(10*time.Minute, fmt.Errorf("too many requests"))
+	}
+	var (
+		mu      sync.Mutex // guards handled
+		handled []string   // types of the tasks passed to the error handler
+	)
+	p := newProcessorForTest(t, rdbClient, HandlerFunc(handler))
+	p.errHandler = ErrorHandlerFunc(func(ctx context.Context, t *Task, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, t.Type())
+	})
+	p.retryDelayFunc = func(n int, e error, t *Task) time.Duration { return time.Minute }
+	runTime := time.Now()
+	p.start(&sync.WaitGroup{})
+	time.Sleep(2 * time.Second)
+	p.shutdown()
+
+	wantRetry := map[string]struct {
+		retried int
+		errMsg  string
+		delay   time.Duration
+	}{
+		m1.ID: {retried: 1, errMsg: "too many requests", delay: 10 * time.Minute},
+		m2.ID: {retried: 0, errMsg: "snoozed for 5m0s", delay: 5 * time.Minute},
+	}
+	gotRetry := h.GetRetryEntries(t, r, base.DefaultQueueName)
+	if len(gotRetry) != len(wantRetry) {
+		t.Fatalf("got %d retry tasks, want %d", len(gotRetry), len(wantRetry))
+	}
+	for _, z := range gotRetry {
+		want, ok := wantRetry[z.Message.ID]
+		if !ok {
+			t.Errorf("unexpected task %q in retry set", z.Message.Type)
+			continue
+		}
+		if z.Message.Retried != want.retried || z.Message.ErrorMsg != want.errMsg {
+			t.Errorf("task %q has Retried=%d ErrorMsg=%q, want Retried=%d ErrorMsg=%q",
+				z.Message.Type, z.Message.Retried, z.Message.ErrorMsg, want.retried, want.errMsg)
+		}
+		wantScore := base.ProcessAtScore(runTime.Add(want.delay))
+		if diff := z.Score - wantScore; diff < -1000 || diff > 3000 {
+			t.Errorf("task %q is retried at score %d, want about %d", z.Message.Type, z.Score, wantScore)
+		}
+	}
+	if got := h.GetArchivedMessages(t, r, base.DefaultQueueName); len(got) != 1 || got[0].ID != m3.ID {
+		t.Errorf("archived tasks = %v, want only task %q", got, m3.Type)
+	}
+	sort.Strings(handled)
+	if diff := cmp.Diff([]string{"exhausted", "rate_limited"}, handled); diff != "" {
+		t.Errorf("error handler was called for %v, want snoozed task to be skipped; (-want,+got)\n%s", handled, diff)
+	}
+}
+
+func TestRetryAfterError(t *testing.T) {
+	cause := fmt.Errorf("too many requests")
+	tests := []struct {
+		err        error
+		wantMsg    string
+		wantSnooze bool
+	}{
+		{RetryAfter(time.Minute, cause), "too many requests", false},
+		{RetryAfter(time.Minute, nil), "retry after 1m0s", false},
+		{Snooze(time.Minute), "snoozed for 1m0s", true},
+		{fmt.Errorf("wrapped: %w", Snooze(time.Second)), "wrapped: snoozed for 1s", true},
+	}
+	for _, tc := range tests {
+		if got := tc.err.Error(); got != tc.wantMsg {
+			t.Errorf("Error() = %q, want %q", got, tc.wantMsg)
+		}
+		if got := isSnooze(tc.err); got != tc.wantSnooze {
+			t.Errorf("isSnooze(%v) = %t, want %t", tc.err, got, tc.wantSnooze)
+		}
+	}
+	if !errors.Is(RetryAfter(time.Minute, cause), cause) {
+		t.Errorf("RetryAfter error does not wrap the given error")
+	}
+}
+
+func TestProcessorRetryPolicy(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("linear", nil)
+	m1.Retried = 2
+	m1.RetryPolicy = &base.RetryPolicy{Backoff: base.BackoffLinear, BaseDelay: 10 * time.Minute}
+	m2 := h.NewTaskMessage("non_retryable", nil)
+	m2.RetryPolicy = &base.RetryPolicy{BaseDelay: time.Minute, NonRetryable: []string{"invalid_input"}}
+	m3 := h.NewTaskMessage("max_elapsed", nil)
+	m3.RetryPolicy = &base.RetryPolicy{BaseDelay: time.Minute, MaxElapsed: time.Hour}
+	m3.FirstAttemptedAt = time.Now().Add(-2 * time.Hour).Unix()
+	m4 := h.NewTaskMessage("no_policy", nil)
+	h.FlushDB(t, r)
+	h.SeedPendingQueue(t, r, []*base.TaskMessage{m1, m2, m3, m4}, base.DefaultQueueName)
+
+	handler := func(ctx context.Context, task *Task) error {
+		return WithErrorKind("invalid_input", fmt.Errorf("bad payload"))
+	}
+	p := newProcessorForTest(t, rdbClient, HandlerFunc(handler))
+	p.retryDelayFunc = func(n int, e error, t *Task) time.Duration { return time.Minute }
+	runTime := time.Now()
+	p.start(&sync.WaitGroup{})
+	time.Sleep(2 * time.Second)
+	p.shutdown()
+
+	wantDelay := map[string]time.Duration{
+		m1.ID: 30 * time.Minute, // third retry with linear backoff
+		m4.ID: time.Minute,      // RetryDelayFunc is used without a retry policy
+	}
+	gotRetry := h.GetRetryEntries(t, r, base.DefaultQueueName)
+	if len(gotRetry) != len(wantDelay) {
+		t.Fatalf("got %d retry tasks, want %d", len(gotRetry), len(wantDelay))
+	}
+	for _, z := range gotRetry {
+		delay, ok := wantDelay[z.Message.ID]
+		if !ok {
+			t.Errorf("unexpected task %q in retry set", z.Message.Type)
+			continue
+		}
+		wantScore := base.ProcessAtScore(runTime.Add(delay))
+		if diff := z.Score - wantScore; diff < -1000 || diff > 3000 {
+			t.Errorf("task %q is retried at score %d, want about %d", z.Message.Type, z.Score, wantScore)
+		}
+		if z.Message.ID == m1.ID && z.Message.FirstAttemptedAt < runTime.Unix() {
+			t.Errorf("task %q has FirstAttemptedAt=%d, want the time of its first attempt", z.Message.Type, z.Message.FirstAttemptedAt)
+		}
+	}
+	var archived []string
+	for _, msg := range h.GetArchivedMessages(t, r, base.DefaultQueueName) {
+		archived = append(archived, msg.Type)
+	}
+	sort.Strings(archived)
+	if diff := cmp.Diff([]string{"max_elapsed", "non_retryable"}, archived); diff != "" {
+		t.Errorf("archived tasks = %v; (-want,+got)\n%s", archived, diff)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		desc   string
+		policy base.RetryPolicy
+		n      int
+		r      float64
+		want   time.Duration
+	}{
+		{
+			desc:   "exponential",
+			policy: base.RetryPolicy{Backoff: base.BackoffExponential, BaseDelay: time.Second},
+			n:      3,
+			r:      0.5,
+			want:   8 * time.Second,
+		},
+		{
+			desc:   "linear",
+			policy: base.RetryPolicy{Backoff: base.BackoffLinear, BaseDelay: time.Second},
+			n:      3,
+			r:      0.5,
+			want:   4 * time.Second,
+		},
+		{
+			desc:   "fixed",
+			policy: base.RetryPolicy{Backoff: base.BackoffFixed, BaseDelay: time.Second},
+			n:      3,
+			r:      0.5,
+			want:   time.Second,
+		},
+		{
+			desc:   "capped by max delay",
+			policy: base.RetryPolicy{Backoff: base.BackoffExponential, BaseDelay: time.Second, MaxDelay: 5 * time.Second},
+			n:      3,
+			r:      0.5,
+			want:   5 * time.Second,
+		},
+		{
+			desc:   "no overflow",
+			policy: base.RetryPolicy{Backoff: base.BackoffExponential, BaseDelay: time.Second},
+			n:      200,
+			r:      0.5,
+			want:   time.Duration(math.MaxInt64),
+		},
+		{
+			desc:   "lowest jitter",
+			policy: base.RetryPolicy{Backoff: base.BackoffFixed, BaseDelay: 10 * time.Second, Jitter: 0.2},
+			n:      0,
+			r:      0,
+			want:   8 * time.Second,
+		},
+		{
+			desc:   "highest jitter",
+			policy: base.RetryPolicy{Backoff: base.BackoffFixed, BaseDelay: 10 * time.Second, Jitter: 0.2},
+			n:      0,
+			r:      1,
+			want:   12 * time.Second,
+		},
+	}
+	for _, tc := range tests {
+		if got := retryPolicyDelay(&tc.policy, tc.n, tc.r); got != tc.want {
+			t.Errorf("%s: retryPolicyDelay(%+v, %d, %v) = %v, want %v", tc.desc, tc.policy, tc.n, tc.r, got, tc.want)
+		}
+	}
+}
+
+type kindTestError struct{}
+
+func (kindTestError) Error() string     { return "not found" }
+func (kindTestError) ErrorKind() string { return "not_found" }
+
+func TestIsNonRetryable(t *testing.T) {
+	policy := &base.RetryPolicy{BaseDelay: time.Second, NonRetryable: []string{"invalid_input", "not_found"}}
+	tests := []struct {
+		policy *base.RetryPolicy
+		err    error
+		want   bool
+	}{
+		{policy, WithErrorKind("invalid_input", fmt.Errorf("bad payload")), true},
+		{policy, fmt.Errorf("wrapped: %w", WithErrorKind("invalid_input", nil)), true},
+		{policy, kindTestError{}, true},
+		{policy, WithErrorKind("timeout", fmt.Errorf("slow")), false},
+		{policy, fmt.Errorf("bad payload"), false},
+		{nil, WithErrorKind("invalid_input", fmt.Errorf("bad payload")), false},
+	}
+	for _, tc := range tests {
+		if got := isNonRetryable(tc.policy, tc.err); got != tc.want {
+			t.Errorf("isNonRetryable(%+v, %v) = %t, want %t", tc.policy, tc.err, got, tc.want)
+		}
+	}
+	cause := fmt.Errorf("bad payload")
+	if err := WithErrorKind("invalid_input", cause); !errors.Is(err, cause) || err.Error() != "bad payload" {
+		t.Errorf("WithErrorKind error = %v, want an error wrapping %v", err, cause)
+	}
+}
+
+func TestProcessorRecordsAttempts(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	rdbClient := rdb.NewRDB(r)
+
+	prev := &base.TaskAttempt{
+		StartTime: time.Now().Add(-time.Hour).Truncate(time.Second),
+		Duration:  time.Second,
+		ServerID:  "old-server",
+		Outcome:   base.AttemptFailed,
+		ErrorMsg:  "connection refused",
+	}
+	m1 := h.NewTaskMessage("panic", nil)
+	m1.Retry = 1
+	m2 := h.NewTaskMessage("ok", nil)
+	m2.Retention = 3600
+	m2.Attempts = []*base.TaskAttempt{prev}
+	h.FlushDB(t, r)
+	h.SeedPendingQueue(t, r, []*base.TaskMessage{m1, m2}, base.DefaultQueueName)
+
+	handler := func(ctx context.Context, task *Task) error {
+		if task.Type() == "panic" {
+			panic("something went terribly wrong")
+		}
+		return nil
+	}
+	p := newProcessorForTest(t, rdbClient, HandlerFunc(handler))
+	p.serverID, p.host, p.pid = "server1", "host1", 1234
+	start := time.Now()
+	p.start(&sync.WaitGroup{})
+	time.Sleep(2 * time.Second)
+	p.shutdown()
+	end := time.Now()
+
+	gotRetry := h.GetRetryMessages(t, r, base.DefaultQueueName)
+	gotCompleted := h.GetCompletedMessages(t, r, base.DefaultQueueName)
+	if len(gotRetry) != 1 || len(gotCompleted) != 1 {
+		t.Fatalf("got %d retry and %d completed tasks, want 1 and 1", len(gotRetry), len(gotCompleted))
+	}
+	tests := []struct {
+		desc string
+		got  []*base.TaskAttempt
+		want []*base.TaskAttempt
+	}{
+		{
+			desc: "panicking task",
+			got:  gotRetry[0].Attempts,
+			want: []*base.TaskAttempt{
+				{ServerID: "server1", Host: "host1", PID: 1234, Outcome: base.AttemptFailed, Panic: true},
+			},
+		},
+		{
+			desc: "succeeded task",
+			got:  gotCompleted[0].Attempts,
+			want: []*base.TaskAttempt{
+				prev,
+				{ServerID: "server1", Host: "host1", PID: 1234, Outcome: base.AttemptSucceeded},
+			},
+		},
+	}
+	for _, tc := range tests {
+		if len(tc.got) != len(tc.want) {
+			t.Errorf("%s: got %d attempts, want %d", tc.desc, len(tc.got), len(tc.want))
+			continue
+		}
+		last := tc.got[len(tc.got)-1]
+		if last.StartTime.Before(start.Truncate(time.Second)) || last.StartTime.After(end) || last.Duration < 0 {
+			t.Errorf("%s: last attempt started at %v and took %v, want an attempt within the run", tc.desc, last.StartTime, last.Duration)
+		}
+		if tc.want[len(tc.want)-1].Panic && !strings.Contains(last.ErrorMsg, "something went terribly wrong") {
+			t.Errorf("%s: last attempt has error message %q, want the panic value", tc.desc, last.ErrorMsg)
+		}
+		ignore := cmpopts.IgnoreFields(base.TaskAttempt{}, "StartTime", "Duration", "ErrorMsg")
+		if diff := cmp.Diff(tc.want[:len(tc.want)-1], tc.got[:len(tc.got)-1]); diff != "" {
+			t.Errorf("%s: mismatch found in previous attempts; (-want,+got)\n%s", tc.desc, diff)
+		}
+		if diff := cmp.Diff(tc.want[len(tc.want)-1], last, ignore); diff != "" {
+			t.Errorf("%s: mismatch found in last attempt; (-want,+got)\n%s", tc.desc, diff)
+		}
+	}
+}
+
 func TestProcessorMarkAsComplete(t *testing.T) {
 	r := setup(t)
 	defer r.Close()
@@ -477,13 +817,49 @@ func TestProcessorMarkAsComplete(t *testing.T) {
 
 		for qname, want := range tc.wantCompleted(runTime) {
 			gotCompleted := h.GetCompletedEntries(t, r, qname)
-			if diff := cmp.Diff(want, gotCompleted, cmpopts.EquateEmpty()); diff != "" {
+			// Attempts are checked in TestProcessorRecordsAttempts and FirstAttemptedAt in TestProcessorRetryPolicy.
+			if diff := cmp.Diff(want, gotCompleted, cmpopts.EquateEmpty(), cmpopts.IgnoreFields(base.TaskMessage{}, "Attempts", "FirstAttemptedAt")); diff != "" {
 				t.Errorf("diff found in %q completed set; want=%v, got=%v\n%s", qname, want, gotCompleted, diff)
 			}
 		}
 	}
 }
 
+func TestProcessorArchivesExpiredTask(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	rdbClient := rdb.NewRDB(r)
+
+	expired := h.NewTaskMessage("expired", nil)
+	expired.ExpireAt = time.Now().Add(-time.Minute).Unix()
+	fresh := h.NewTaskMessage("fresh", nil)
+	fresh.ExpireAt = time.Now().Add(time.Hour).Unix()
+	h.SeedPendingQueue(t, r, []*base.TaskMessage{expired, fresh}, base.DefaultQueueName)
+
+	var mu sync.Mutex
+	var processed []string
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		processed = append(processed, task.Type())
+		return nil
+	}
+	p := newProcessorForTest(t, rdbClient, HandlerFunc(handler))
+	p.start(&sync.WaitGroup{})
+	time.Sleep(2 * time.Second)
+	p.shutdown()
+
+	mu.Lock()
+	if diff := cmp.Diff([]string{"fresh"}, processed); diff != "" {
+		t.Errorf("mismatch found in processed tasks; (-want, +got)\n%s", diff)
+	}
+	mu.Unlock()
+	archived := h.GetArchivedMessages(t, r, base.DefaultQueueName)
+	if len(archived) != 1 || archived[0].ID != expired.ID || archived[0].ErrorMsg != base.ExpiredErrMsg {
+		t.Errorf("archived tasks = %v, want the expired task with error message %q", archived, base.ExpiredErrMsg)
+	}
+}
+
 // Test a scenario where the worker server cannot communicate with redis due to a network failure
 // and the lease expires
 func TestProcessorWithExpiredLease(t *testing.T) {
@@ -582,6 +958,96 @@ func TestProcessorWithExpiredLease(t *testing.T) {
 	}
 }
 
+// fakeObserver records the notifications of a ProcessingObserver.
+type fakeObserver struct {
+	mu               sync.Mutex
+	queueWaits       map[string]int           // number of calls keyed by task type
+	handlerDurations map[string]int           // number of calls keyed by task type
+	outcomes         map[string][]TaskOutcome // outcomes keyed by task type
+}
+
+func newFakeObserver() *fakeObserver {
+	return &fakeObserver{
+		queueWaits:       make(map[string]int),
+		handlerDurations: make(map[string]int),
+		outcomes:         make(map[string][]TaskOutcome),
+	}
+}
+
+func (o *fakeObserver) ObserveQueueWait(queue, typename string, d time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.queueWaits[typename]++
+}
+
+func (o *fakeObserver) ObserveHandlerDuration(queue, typename string, d time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.handlerDurations[typename]++
+}
+
+func (o *fakeObserver) ObserveOutcome(queue, typename string, outcome TaskOutcome) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.outcomes[typename] = append(o.outcomes[typename], outcome)
+}
+
+func TestProcessorObserver(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	rdbClient := rdb.NewRDB(r)
+
+	msgs := []*base.TaskMessage{
+		h.NewTaskMessage("success", nil),
+		h.NewTaskMessage("failure", nil),
+		h.NewTaskMessage("skip_retry", nil),
+		h.NewTaskMessage("panic", nil),
+	}
+	for _, msg := range msgs {
+		msg.Retry = 3
+		if err := rdbClient.Enqueue(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := func(ctx context.Context, task *Task) error {
+		switch task.Type() {
+		case "failure":
+			return fmt.Errorf("something went wrong")
+		case "skip_retry":
+			return fmt.Errorf("bad input: %w", SkipRetry)
+		case "panic":
+			panic("something went terribly wrong")
+		}
+		return nil
+	}
+	observer := newFakeObserver()
+	p := newProcessorForTest(t, rdbClient, HandlerFunc(handler))
+	p.observer = observer
+
+	p.start(&sync.WaitGroup{})
+	time.Sleep(2 * time.Second) // wait for two second to allow all pending tasks to be processed.
+	p.shutdown()
+
+	wantOutcomes := map[string][]TaskOutcome{
+		"success":    {TaskOutcomeSuccess},
+		"failure":    {TaskOutcomeFailure, TaskOutcomeRetry},
+		"skip_retry": {TaskOutcomeFailure, TaskOutcomeArchive},
+		"panic":      {TaskOutcomePanic, TaskOutcomeFailure, TaskOutcomeRetry},
+	}
+	wantCounts := map[string]int{"success": 1, "failure": 1, "skip_retry": 1, "panic": 1}
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	if diff := cmp.Diff(wantOutcomes, observer.outcomes); diff != "" {
+		t.Errorf("mismatch found in observed outcomes; (-want,+got)\n%s", diff)
+	}
+	if diff := cmp.Diff(wantCounts, observer.queueWaits); diff != "" {
+		t.Errorf("mismatch found in number of observed queue waits; (-want,+got)\n%s", diff)
+	}
+	if diff := cmp.Diff(wantCounts, observer.handlerDurations); diff != "" {
+		t.Errorf("mismatch found in number of observed handler durations; (-want,+got)\n%s", diff)
+	}
+}
+
 func TestProcessorQueues(t *testing.T) {
 	sortOpt := cmp.Transformer("SortStrings", func(in []string) []string {
 		out := append([]string(nil), in...) // Copy input to avoid mutating it
@@ -622,6 +1088,281 @@ func TestProcessorQueues(t *testing.T) {
 	}
 }
 
+func TestProcessorQueuesSkipsThrottledQueues(t *testing.T) {
+	now := time.Now()
+	// Note: rdb and handler not needed for this test.
+	p := newProcessorForTest(t, nil, nil)
+	p.clock = timeutil.NewSimulatedClock(now)
+	p.queueConfig = map[string]int{"high": 6, "default": 3, "low": 1}
+	p.throttled = map[string]time.Time{
+		"high": now.Add(time.Second),
+		"low":  now.Add(-time.Second),
+	}
+
+	got := p.queues()
+	sort.Strings(got)
+	if want := []string{"default", "low"}; !cmp.Equal(want, got) {
+		t.Errorf("(*processor).queues() = %v, want %v", got, want)
+	}
+	if _, ok := p.throttled["low"]; ok {
+		t.Errorf("queue %q is still throttled after the throttle period", "low")
+	}
+	if d := p.throttleDelay(); d != time.Second {
+		t.Errorf("(*processor).throttleDelay() = %v, want %v", d, time.Second)
+	}
+}
+
+func TestProcessorSetQueues(t *testing.T) {
+	// Note: rdb and handler not needed for this test.
+	p := newProcessorForTest(t, nil, nil)
+
+	p.setQueues(map[string]int{"critical": 6, "default": 3, "low": 1}, true)
+	if got, want := p.queues(), []string{"critical", "default", "low"}; !cmp.Equal(want, got) {
+		t.Errorf("after setQueues in strict mode, (*processor).queues() = %v, want %v", got, want)
+	}
+
+	p.setQueues(map[string]int{"high": 2, "low": 2}, false)
+	got := p.queues()
+	sort.Strings(got)
+	if want := []string{"high", "low"}; !cmp.Equal(want, got) {
+		t.Errorf("after setQueues, (*processor).queues() = %v, want %v", got, want)
+	}
+	if want := map[string]int{"high": 1, "low": 1}; !cmp.Equal(want, p.queueConfig) {
+		t.Errorf("after setQueues, queue config is %v, want normalized config %v", p.queueConfig, want)
+	}
+}
+
+func TestProcessorSetConcurrency(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	rdbClient := rdb.NewRDB(r)
+
+	var msgs []*base.TaskMessage
+	for i := 0; i < 6; i++ {
+		msgs = append(msgs, h.NewTaskMessage("task", nil))
+	}
+	h.SeedPendingQueue(t, r, msgs, base.DefaultQueueName)
+
+	var (
+		mu        sync.Mutex
+		active    int
+		maxActive int
+	)
+	release := make(chan struct{})
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return nil
+	}
+	getMaxActive := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return maxActive
+	}
+	p := newProcessorForTest(t, rdbClient, HandlerFunc(handler))
+	p.setConcurrency(1)
+	p.start(&sync.WaitGroup{})
+
+	time.Sleep(300 * time.Millisecond)
+	if n := getMaxActive(); n != 1 {
+		t.Errorf("processed up to %d tasks concurrently with concurrency 1, want 1", n)
+	}
+
+	p.setConcurrency(3)
+	time.Sleep(300 * time.Millisecond)
+	if n := getMaxActive(); n != 3 {
+		t.Errorf("processed up to %d tasks concurrently after increasing concurrency to 3, want 3", n)
+	}
+	close(release)
+	p.shutdown()
+}
+
+func TestProcessorWithQueueRateLimit(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	rdbClient := rdb.NewRDB(r)
+
+	for i := 0; i < 10; i++ {
+		if err := rdbClient.Enqueue(context.Background(), h.NewTaskMessage("task", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var mu sync.Mutex
+	var n int
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		return nil
+	}
+	p := newProcessorForTest(t, rdbClient, HandlerFunc(handler))
+	p.rateLimits = map[string]RateLimit{base.DefaultQueueName: {Limit: 2, Burst: 2}}
+
+	p.start(&sync.WaitGroup{})
+	time.Sleep(2 * time.Second)
+	p.shutdown()
+
+	// Burst of two tasks followed by two tasks per second.
+	mu.Lock()
+	defer mu.Unlock()
+	if n < 4 || n > 7 {
+		t.Errorf("processed %d tasks in two seconds with rate limit of 2/s and burst of 2, want between 4 and 7", n)
+	}
+}
+
+func TestProcessorWithTypeConcurrency(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	rdbClient := rdb.NewRDB(r)
+
+	var msgs []*base.TaskMessage
+	for i := 0; i < 3; i++ {
+		msgs = append(msgs, h.NewTaskMessage("slow", nil), h.NewTaskMessage("fast", nil))
+	}
+	h.SeedPendingQueue(t, r, msgs, base.DefaultQueueName)
+
+	var mu sync.Mutex
+	active := make(map[string]int)
+	maxActive := make(map[string]int)
+	processed := make(map[string]int)
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		active[task.Type()]++
+		if active[task.Type()] > maxActive[task.Type()] {
+			maxActive[task.Type()] = active[task.Type()]
+		}
+		mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		active[task.Type()]--
+		processed[task.Type()]++
+		mu.Unlock()
+		return nil
+	}
+	p := newProcessorForTest(t, rdbClient, HandlerFunc(handler))
+	p.typeConcurrency = map[string]int{"slow": 1}
+
+	p.start(&sync.WaitGroup{})
+	time.Sleep(3 * time.Second)
+	p.shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxActive["slow"] != 1 {
+		t.Errorf("processed up to %d slow tasks concurrently, want 1", maxActive["slow"])
+	}
+	if processed["slow"] != 3 || processed["fast"] != 3 {
+		t.Errorf("processed %d slow and %d fast tasks, want 3 and 3", processed["slow"], processed["fast"])
+	}
+	if retried := h.GetRetryMessages(t, r, base.DefaultQueueName); len(retried) != 0 {
+		t.Errorf("%d tasks were retried, want tasks of limited type to be left in the queue", len(retried))
+	}
+}
+
+// fakeTypeLimiter is an in-memory TypeConcurrencyLimiter.
+type fakeTypeLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	acquired map[string]map[string]bool // task IDs holding a slot by task type
+	rejected int
+}
+
+func (l *fakeTypeLimiter) Acquire(ctx context.Context, typename string) (bool, error) {
+	id, ok := GetTaskID(ctx)
+	if !ok {
+		return false, fmt.Errorf("context is missing task ID")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.acquired[typename]) >= l.limit {
+		l.rejected++
+		return false, nil
+	}
+	if l.acquired[typename] == nil {
+		l.acquired[typename] = make(map[string]bool)
+	}
+	l.acquired[typename][id] = true
+	return true, nil
+}
+
+func (l *fakeTypeLimiter) Release(ctx context.Context, typename string) error {
+	id, ok := GetTaskID(ctx)
+	if !ok {
+		return fmt.Errorf("context is missing task ID")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.acquired[typename][id] {
+		return fmt.Errorf("no slot found for task %q", id)
+	}
+	delete(l.acquired[typename], id)
+	return nil
+}
+
+func (l *fakeTypeLimiter) Saturated(ctx context.Context) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var types []string
+	for typename, ids := range l.acquired {
+		if len(ids) >= l.limit {
+			types = append(types, typename)
+		}
+	}
+	return types, nil
+}
+
+func TestProcessorWithTypeConcurrencyLimiter(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	rdbClient := rdb.NewRDB(r)
+
+	var msgs []*base.TaskMessage
+	for i := 0; i < 3; i++ {
+		msgs = append(msgs, h.NewTaskMessage("task", nil))
+	}
+	h.SeedPendingQueue(t, r, msgs, base.DefaultQueueName)
+
+	var mu sync.Mutex
+	var processed int
+	handler := func(ctx context.Context, task *Task) error {
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		processed++
+		mu.Unlock()
+		return nil
+	}
+	limiter := &fakeTypeLimiter{limit: 1, acquired: make(map[string]map[string]bool)}
+	p := newProcessorForTest(t, rdbClient, HandlerFunc(handler))
+	p.typeLimiter = limiter
+
+	p.start(&sync.WaitGroup{})
+	time.Sleep(3 * time.Second)
+	p.shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if processed != 3 {
+		t.Errorf("processed %d tasks, want 3", processed)
+	}
+	if limiter.rejected != 0 {
+		t.Errorf("limiter rejected %d tasks, want tasks of saturated types to be left in the queue", limiter.rejected)
+	}
+	if n := len(limiter.acquired["task"]); n != 0 {
+		t.Errorf("%d slots are still acquired, want all slots released", n)
+	}
+	if retried := h.GetRetryMessages(t, r, base.DefaultQueueName); len(retried) != 0 {
+		t.Errorf("%d tasks were retried, want tasks of saturated types to be left in the queue", len(retried))
+	}
+}
+
 func TestProcessorWithStrictPriority(t *testing.T) {
 	var (
 		r = setup(t)
@@ -0,0 +1,108 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq_learn
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/log"
+)
+
+// reconfigurer applies the configuration updates published to the server,
+// e.g. with Inspector.SetServerQueues.
+type reconfigurer struct {
+	logger *log.Logger
+	broker base.Broker
+
+	// ID of the server to receive the configuration updates of.
+	serverID string
+
+	// apply applies a configuration update to the server.
+	apply func(ServerConfigUpdate) error
+
+	// channel to communicate back to the long running "reconfigurer" goroutine.
+	done chan struct{}
+
+	// time to wait before retrying to connect to redis.
+	retryTimeout time.Duration
+}
+
+type reconfigurerParams struct {
+	logger   *log.Logger
+	broker   base.Broker
+	serverID string
+	apply    func(ServerConfigUpdate) error
+}
+
+func newReconfigurer(params reconfigurerParams) *reconfigurer {
+	return &reconfigurer{
+		logger:       params.logger,
+		broker:       params.broker,
+		serverID:     params.serverID,
+		apply:        params.apply,
+		done:         make(chan struct{}),
+		retryTimeout: 5 * time.Second,
+	}
+}
+
+func (r *reconfigurer) shutdown() {
+	r.logger.Debug("Reconfigurer shutting down...")
+	// Signal the reconfigurer goroutine to stop.
+	r.done <- struct{}{}
+}
+
+func (r *reconfigurer) start(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			sub, err := r.broker.SubscribeServerConfig(r.serverID)
+			if err != nil {
+				r.logger.Errorf("cannot subscribe to server config channel: %v", err)
+			} else if !r.listen(sub) {
+				r.logger.Debug("Reconfigurer done")
+				return
+			}
+			// Subscription failed or was lost; resubscribe after waiting.
+			select {
+			case <-time.After(r.retryTimeout):
+			case <-r.done:
+				r.logger.Debug("Reconfigurer done")
+				return
+			}
+		}
+	}()
+}
+
+// listen applies each configuration update received on the subscription.
+// It reports whether the reconfigurer should resubscribe, which is the case when
+// the subscription ended before shutdown was requested.
+func (r *reconfigurer) listen(sub base.ServerConfigSubscription) (resubscribe bool) {
+	defer sub.Close()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-r.done:
+			return false
+		case update, ok := <-ch:
+			if !ok {
+				r.logger.Errorf("server config subscription closed: %v", sub.Err())
+				return true
+			}
+			cfg := ServerConfigUpdate{
+				Concurrency:    update.Concurrency,
+				Queues:         update.Queues,
+				StrictPriority: update.StrictPriority,
+			}
+			if err := r.apply(cfg); err != nil {
+				r.logger.Errorf("Failed to apply server config update: %v", err)
+				continue
+			}
+			r.logger.Info("Applied server config update")
+		}
+	}
+}
@@ -24,7 +24,7 @@ type recoverer struct {
 	done chan struct{}
 
 	// list of queues to check for deadline.
-	queues []string
+	queues *queueNames
 
 	// poll interval.
 	interval time.Duration
@@ -44,7 +44,7 @@ func newRecoverer(params recovererParams) *recoverer {
 		logger:         params.logger,
 		broker:         params.broker,
 		done:           make(chan struct{}),
-		queues:         params.queues,
+		queues:         newQueueNames(params.queues),
 		interval:       params.interval,
 		retryDelayFunc: params.retryDelayFunc,
 		isFailureFunc:  params.isFailureFunc,
@@ -89,13 +89,14 @@ func (r *recoverer) recover() {
 func (r *recoverer) recoverLeaseExpiredTasks() {
 	// Get all tasks which have expired 30 seconds ago or earlier to accommodate certain amount of clock skew.
 	cutoff := time.Now().Add(-30 * time.Second)
-	msgs, err := r.broker.ListLeaseExpired(cutoff, r.queues...)
+	msgs, err := r.broker.ListLeaseExpired(cutoff, r.queues.get()...)
 	if err != nil {
 		r.logger.Warnf("recoverer: could not list lease expired tasks: %v", err)
 		return
 	}
 	for _, msg := range msgs {
-		if msg.Retried >= msg.Retry {
+		msg = withLeaseExpiredAttempt(msg, time.Now())
+		if noRetryReason(msg, ErrLeaseExpired, time.Now()) != "" {
 			r.archive(msg, ErrLeaseExpired)
 		} else {
 			r.retry(msg, ErrLeaseExpired)
@@ -103,8 +104,22 @@ func (r *recoverer) recoverLeaseExpiredTasks() {
 	}
 }
 
+// withLeaseExpiredAttempt returns a copy of msg with an attempt recording that the lease on the task expired.
+// The recoverer does not know which server processed the task nor when it started, so those fields are left zero.
+func withLeaseExpiredAttempt(msg *base.TaskMessage, now time.Time) *base.TaskMessage {
+	m := *msg
+	m.AddAttempt(&base.TaskAttempt{
+		Outcome:  base.AttemptLeaseExpired,
+		ErrorMsg: ErrLeaseExpired.Error(),
+	})
+	if m.FirstAttemptedAt == 0 {
+		m.FirstAttemptedAt = now.Unix()
+	}
+	return &m
+}
+
 func (r *recoverer) recoverStaleAggregationSets() {
-	for _, qname := range r.queues {
+	for _, qname := range r.queues.get() {
 		if err := r.broker.ReclaimStaleAggregationSets(qname); err != nil {
 			r.logger.Warnf("recoverer: could not reclaim stale aggregation sets in queue %q: %v", qname, err)
 		}
@@ -112,15 +127,33 @@ func (r *recoverer) recoverStaleAggregationSets() {
 }
 
 func (r *recoverer) retry(msg *base.TaskMessage, err error) {
-	delay := r.retryDelayFunc(msg.Retried, err, NewTask(msg.Type, msg.Payload))
-	retryAt := time.Now().Add(delay)
+	retryAt := time.Now().Add(retryDelay(msg, err, r.retryDelayFunc))
 	if err := r.broker.Retry(context.Background(), msg, retryAt, err.Error(), r.isFailureFunc(err)); err != nil {
 		r.logger.Warnf("recoverer: could not retry lease expired task: %v", err)
+		return
 	}
+	r.recordEvent(msg, base.TaskEventRetried)
 }
 
 func (r *recoverer) archive(msg *base.TaskMessage, err error) {
 	if err := r.broker.Archive(context.Background(), msg, err.Error()); err != nil {
 		r.logger.Warnf("recoverer: could not move task to archive: %v", err)
+		return
+	}
+	r.recordEvent(msg, base.TaskEventArchived)
+}
+
+// recordEvent records the lifecycle event of the given task to the event stream.
+// The server which processed the task is unknown, so the event has no server ID.
+func (r *recoverer) recordEvent(msg *base.TaskMessage, kind base.TaskEventKind) {
+	err := r.broker.RecordEvent(&base.TaskEvent{
+		Kind:   kind,
+		TaskID: msg.ID,
+		Queue:  msg.Queue,
+		Type:   msg.Type,
+		Time:   time.Now(),
+	})
+	if err != nil {
+		r.logger.Warnf("recoverer: could not record %s event of task id=%s: %v", kind, msg.ID, err)
 	}
 }
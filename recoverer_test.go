@@ -5,11 +5,13 @@
 package asynq_learn
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/rdb"
 	h "github.com/hibiken/asynq/internal/testutil"
@@ -256,7 +258,7 @@ func TestRecoverer(t *testing.T) {
 			gotRetry := h.GetRetryMessages(t, r, qname)
 			var wantRetry []*base.TaskMessage // Note: construct message here since `LastFailedAt` is relative to each test run
 			for _, msg := range msgs {
-				wantRetry = append(wantRetry, h.TaskMessageAfterRetry(*msg, ErrLeaseExpired.Error(), runTime))
+				wantRetry = append(wantRetry, h.TaskMessageAfterRetry(*leaseExpiredMessage(msg, runTime), ErrLeaseExpired.Error(), runTime))
 			}
 			if diff := cmp.Diff(wantRetry, gotRetry, h.SortMsgOpt, cmpOpt); diff != "" {
 				t.Errorf("%s; mismatch found in %q: (-want, +got)\n%s", tc.desc, base.RetryKey(qname), diff)
@@ -266,11 +268,92 @@ func TestRecoverer(t *testing.T) {
 			gotArchived := h.GetArchivedMessages(t, r, qname)
 			var wantArchived []*base.TaskMessage
 			for _, msg := range msgs {
-				wantArchived = append(wantArchived, h.TaskMessageWithError(*msg, ErrLeaseExpired.Error(), runTime))
+				wantArchived = append(wantArchived, h.TaskMessageWithError(*leaseExpiredMessage(msg, runTime), ErrLeaseExpired.Error(), runTime))
 			}
 			if diff := cmp.Diff(wantArchived, gotArchived, h.SortMsgOpt, cmpOpt); diff != "" {
 				t.Errorf("%s; mismatch found in %q: (-want, +got)\n%s", tc.desc, base.ArchivedKey(qname), diff)
 			}
 		}
+		wantEvents := make(map[string]base.TaskEventKind)
+		for _, msgs := range tc.wantRetry {
+			for _, msg := range msgs {
+				wantEvents[msg.ID] = base.TaskEventRetried
+			}
+		}
+		for _, msgs := range tc.wantArchived {
+			for _, msg := range msgs {
+				wantEvents[msg.ID] = base.TaskEventArchived
+			}
+		}
+		events, err := rdbClient.ReadEvents(context.Background(), "0-0", 100, -1)
+		if err != nil {
+			t.Fatalf("%s; ReadEvents returned an error: %v", tc.desc, err)
+		}
+		gotEvents := make(map[string]base.TaskEventKind)
+		for _, ev := range events {
+			gotEvents[ev.TaskID] = ev.Kind
+		}
+		if diff := cmp.Diff(wantEvents, gotEvents, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%s; mismatch found in recorded events: (-want, +got)\n%s", tc.desc, diff)
+		}
+	}
+}
+
+// leaseExpiredMessage returns a copy of msg with the attempt the recoverer records
+// when it recovers the task at runTime.
+func leaseExpiredMessage(msg *base.TaskMessage, runTime time.Time) *base.TaskMessage {
+	m := *msg
+	m.Attempts = append(m.Attempts, &base.TaskAttempt{
+		Outcome:  base.AttemptLeaseExpired,
+		ErrorMsg: ErrLeaseExpired.Error(),
+	})
+	if m.FirstAttemptedAt == 0 {
+		m.FirstAttemptedAt = runTime.Unix()
+	}
+	return &m
+}
+
+func TestRecovererWithRetryPolicy(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	rdbClient := rdb.NewRDB(r)
+	now := time.Now()
+
+	delayed := h.NewTaskMessageWithQueue("task1", nil, "default")
+	delayed.RetryPolicy = &base.RetryPolicy{Backoff: base.BackoffFixed, BaseDelay: 10 * time.Minute}
+	expired := h.NewTaskMessageWithQueue("task2", nil, "default")
+	expired.RetryPolicy = &base.RetryPolicy{BaseDelay: time.Second, MaxElapsed: time.Hour}
+	expired.FirstAttemptedAt = now.Add(-2 * time.Hour).Unix()
+
+	h.FlushDB(t, r)
+	h.SeedAllActiveQueues(t, r, map[string][]*base.TaskMessage{"default": {delayed, expired}})
+	h.SeedAllLease(t, r, map[string][]base.Z{"default": {
+		{Message: delayed, Score: now.Add(-1 * time.Minute).Unix()},
+		{Message: expired, Score: now.Add(-1 * time.Minute).Unix()},
+	}})
+
+	recoverer := newRecoverer(recovererParams{
+		logger:         testLogger,
+		broker:         rdbClient,
+		queues:         []string{"default"},
+		interval:       1 * time.Second,
+		retryDelayFunc: func(n int, err error, task *Task) time.Duration { return 30 * time.Second },
+		isFailureFunc:  defaultIsFailureFunc,
+	})
+	recoverer.recover()
+
+	// The delay of the retry policy takes precedence over the retry delay func.
+	gotRetry := h.GetRetryEntries(t, r, "default")
+	if len(gotRetry) != 1 || gotRetry[0].Message.ID != delayed.ID {
+		t.Fatalf("retry entries = %v, want the task with the fixed retry delay", gotRetry)
+	}
+	want := now.Add(10 * time.Minute)
+	if got := base.ProcessAtFromScore(gotRetry[0].Score); got.Sub(want) > 2*time.Second || want.Sub(got) > 2*time.Second {
+		t.Errorf("task is retried at %v, want %v", got, want)
+	}
+	// The task whose retry time limit is exceeded is archived.
+	gotArchived := h.GetArchivedMessages(t, r, "default")
+	if len(gotArchived) != 1 || gotArchived[0].ID != expired.ID {
+		t.Errorf("archived tasks = %v, want the task whose retry time limit is exceeded", gotArchived)
 	}
 }
@@ -0,0 +1,164 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq_learn
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/log"
+)
+
+// A queueResolver is responsible for resolving the queue patterns in the server's
+// queue config against all known queues, so that the server picks up queues
+// created after it started.
+type queueResolver struct {
+	logger *log.Logger
+	broker base.Broker
+
+	// hasPatterns reports whether the queue config contains patterns to resolve.
+	hasPatterns func() bool
+
+	// update is called with the names of all known queues to resolve the patterns.
+	update func(allQueues []string)
+
+	// channel to communicate back to the long running "queueResolver" goroutine.
+	done chan struct{}
+
+	// trigger receives a value to resolve the patterns right away.
+	trigger chan struct{}
+
+	// interval between resolutions.
+	interval time.Duration
+}
+
+type queueResolverParams struct {
+	logger      *log.Logger
+	broker      base.Broker
+	hasPatterns func() bool
+	update      func(allQueues []string)
+	interval    time.Duration
+}
+
+func newQueueResolver(params queueResolverParams) *queueResolver {
+	return &queueResolver{
+		logger:      params.logger,
+		broker:      params.broker,
+		hasPatterns: params.hasPatterns,
+		update:      params.update,
+		done:        make(chan struct{}),
+		trigger:     make(chan struct{}, 1),
+		interval:    params.interval,
+	}
+}
+
+func (r *queueResolver) shutdown() {
+	r.logger.Debug("Queue resolver shutting down...")
+	// Signal the queue resolver goroutine to stop.
+	r.done <- struct{}{}
+}
+
+// resolveNow requests the queue resolver to resolve the patterns without waiting for the interval.
+func (r *queueResolver) resolveNow() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+		// The queue resolver has a pending request already.
+	}
+}
+
+// start starts the "queueResolver" goroutine.
+func (r *queueResolver) start(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.exec()
+		timer := time.NewTimer(r.interval)
+		for {
+			select {
+			case <-r.done:
+				timer.Stop()
+				r.logger.Debug("Queue resolver done")
+				return
+			case <-r.trigger:
+				r.exec()
+			case <-timer.C:
+				r.exec()
+				timer.Reset(r.interval)
+			}
+		}
+	}()
+}
+
+func (r *queueResolver) exec() {
+	if !r.hasPatterns() {
+		return
+	}
+	qnames, err := r.broker.AllQueues()
+	if err != nil {
+		r.logger.Errorf("Failed to list queues to resolve queue patterns: %v", err)
+		return
+	}
+	r.update(qnames)
+}
+
+// isQueuePattern reports whether the queue name in the queue config is a pattern.
+func isQueuePattern(name string) bool {
+	return strings.Contains(name, "*")
+}
+
+// matchQueuePattern reports whether qname matches the pattern,
+// in which '*' matches any sequence of characters.
+func matchQueuePattern(pattern, qname string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == qname
+	}
+	if !strings.HasPrefix(qname, parts[0]) {
+		return false
+	}
+	rest := qname[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	return strings.HasSuffix(rest, parts[len(parts)-1])
+}
+
+// resolveQueues returns the queues to process given the queue config, which may contain
+// patterns, and the names of all known queues.
+//
+// A queue listed by name takes its own priority; otherwise a queue matching patterns
+// takes the highest priority among the patterns.
+func resolveQueues(queueConfig map[string]int, allQueues []string) map[string]int {
+	res := make(map[string]int)
+	var patterns []string
+	for name, priority := range queueConfig {
+		if isQueuePattern(name) {
+			patterns = append(patterns, name)
+			continue
+		}
+		res[name] = priority
+	}
+	if len(patterns) == 0 {
+		return res
+	}
+	for _, qname := range allQueues {
+		if _, ok := queueConfig[qname]; ok {
+			continue
+		}
+		for _, pattern := range patterns {
+			if matchQueuePattern(pattern, qname) && queueConfig[pattern] > res[qname] {
+				res[qname] = queueConfig[pattern]
+			}
+		}
+	}
+	return res
+}
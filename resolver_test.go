@@ -0,0 +1,129 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq_learn
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/testutil"
+)
+
+func TestMatchQueuePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		qname   string
+		want    bool
+	}{
+		{"tenant:*", "tenant:42", true},
+		{"tenant:*", "tenant:", true},
+		{"tenant:*", "tenant", false},
+		{"tenant:*", "other:42", false},
+		{"*:critical", "tenant:critical", true},
+		{"*:critical", "tenant:low", false},
+		{"tenant:*:critical", "tenant:42:critical", true},
+		{"tenant:*:critical", "tenant:42:low", false},
+		{"*", "default", true},
+		{"default", "default", true},
+		{"default", "default2", false},
+	}
+	for _, tc := range tests {
+		if got := matchQueuePattern(tc.pattern, tc.qname); got != tc.want {
+			t.Errorf("matchQueuePattern(%q, %q) = %t, want %t", tc.pattern, tc.qname, got, tc.want)
+		}
+	}
+}
+
+func TestResolveQueues(t *testing.T) {
+	tests := []struct {
+		desc        string
+		queueConfig map[string]int
+		allQueues   []string
+		want        map[string]int
+	}{
+		{
+			desc:        "without patterns",
+			queueConfig: map[string]int{"critical": 6, "default": 3},
+			allQueues:   []string{"critical", "default", "low"},
+			want:        map[string]int{"critical": 6, "default": 3},
+		},
+		{
+			desc:        "with a pattern",
+			queueConfig: map[string]int{"tenant:*": 1, "default": 3},
+			allQueues:   []string{"default", "tenant:1", "tenant:2", "low"},
+			want:        map[string]int{"default": 3, "tenant:1": 1, "tenant:2": 1},
+		},
+		{
+			desc:        "queue listed by name takes its own priority",
+			queueConfig: map[string]int{"tenant:*": 1, "tenant:1": 5},
+			allQueues:   []string{"tenant:1", "tenant:2"},
+			want:        map[string]int{"tenant:1": 5, "tenant:2": 1},
+		},
+		{
+			desc:        "queue matching patterns takes the highest priority",
+			queueConfig: map[string]int{"tenant:*": 1, "*:critical": 6},
+			allQueues:   []string{"tenant:1", "tenant:critical", "other:critical"},
+			want:        map[string]int{"tenant:1": 1, "tenant:critical": 6, "other:critical": 6},
+		},
+		{
+			desc:        "pattern matching no queues",
+			queueConfig: map[string]int{"tenant:*": 1},
+			allQueues:   nil,
+			want:        map[string]int{},
+		},
+	}
+	for _, tc := range tests {
+		got := resolveQueues(tc.queueConfig, tc.allQueues)
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: resolveQueues(%v, %v) = %v, want %v; (-want,+got)\n%s",
+				tc.desc, tc.queueConfig, tc.allQueues, got, tc.want, diff)
+		}
+	}
+}
+
+func TestQueueResolver(t *testing.T) {
+	broker := NewInMemoryBroker()
+	c := NewClientWithBroker(broker)
+	defer c.Close()
+	if _, err := c.Enqueue(NewTask("send_email", nil), Queue("tenant:1")); err != nil {
+		t.Fatalf("could not enqueue a task: %v", err)
+	}
+
+	updates := make(chan []string, 10)
+	r := newQueueResolver(queueResolverParams{
+		logger:      testLogger,
+		broker:      broker.db,
+		hasPatterns: func() bool { return true },
+		update:      func(allQueues []string) { updates <- allQueues },
+		interval:    time.Hour,
+	})
+	var wg sync.WaitGroup
+	r.start(&wg)
+	defer r.shutdown()
+
+	select {
+	case got := <-updates:
+		if diff := cmp.Diff([]string{"tenant:1"}, got); diff != "" {
+			t.Errorf("first update got %v; (-want,+got)\n%s", got, diff)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("queue resolver did not resolve the patterns on start")
+	}
+
+	if _, err := c.Enqueue(NewTask("send_email", nil), Queue("tenant:2")); err != nil {
+		t.Fatalf("could not enqueue a task: %v", err)
+	}
+	r.resolveNow()
+	select {
+	case got := <-updates:
+		if diff := cmp.Diff([]string{"tenant:1", "tenant:2"}, got, h.SortStringSliceOpt); diff != "" {
+			t.Errorf("update after resolveNow got %v; (-want,+got)\n%s", got, diff)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("queue resolver did not resolve the patterns after resolveNow")
+	}
+}
@@ -10,15 +10,14 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"reflect"
 	"runtime"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/log"
-	"github.com/hibiken/asynq/internal/rdb"
 )
 
 // Server is responsible for task processing and task lifecycle management.
@@ -50,10 +49,34 @@ type Server struct {
 	syncer        *syncer
 	heartbeater   *heartbeater
 	subscriber    *subscriber
+	waker         *waker
+	reconfigurer  *reconfigurer
+	queueResolver *queueResolver
 	recoverer     *recoverer
 	healthchecker *healthchecker
 	janitor       *janitor
 	aggregator    *aggregator
+
+	// configMu guards config, the part of the configuration
+	// which can be changed with UpdateConfig.
+	configMu sync.Mutex
+	config   runtimeConfig
+}
+
+// runtimeConfig holds the configuration of a running server which can be updated.
+type runtimeConfig struct {
+	concurrency    int
+	strictPriority bool
+
+	// queues is the queue config which may contain queue patterns.
+	queues map[string]int
+
+	// resolvedQueues is the queue config with the patterns resolved against allQueues,
+	// i.e. the queues processed by the server.
+	resolvedQueues map[string]int
+
+	// allQueues is the list of all known queues last used to resolve the patterns.
+	allQueues []string
 }
 
 type serverState struct {
@@ -61,6 +84,29 @@ type serverState struct {
 	value serverStateValue
 }
 
+// queueNames holds the names of the queues a server component works on.
+// The names can be replaced while the component is running.
+type queueNames struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func newQueueNames(names []string) *queueNames {
+	return &queueNames{names: names}
+}
+
+func (q *queueNames) get() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.names
+}
+
+func (q *queueNames) set(names []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.names = names
+}
+
 type serverStateValue int
 
 const (
@@ -110,6 +156,8 @@ type Config struct {
 	// Function to calculate retry delay for a failed task.
 	//
 	// By default, it uses exponential backoff algorithm to calculate the delay.
+	// It is not called for the errors created by RetryAfter and Snooze, which carry their own delay,
+	// nor for the tasks enqueued with a RetryPolicy, whose policy takes precedence.
 	RetryDelayFunc RetryDelayFunc
 
 	// Predicate function to determine whether the error returned from Handler is a failure.
@@ -123,6 +171,18 @@ type Config struct {
 	// List of queues to process with given priority value. Keys are the names of the
 	// queues and values are associated priority value.
 	//
+	// A key containing '*' is a pattern, in which '*' matches any sequence of characters.
+	// The server periodically resolves patterns against all known queues, and processes each
+	// matching queue with the pattern's priority, so that queues created after the server
+	// started (e.g. one queue per tenant) are processed without a restart.
+	// A queue listed by name takes the priority given to the name. If several patterns
+	// match a queue, the highest priority among them is used.
+	//
+	//     Queues: map[string]int{
+	//         "critical": 6,
+	//         "tenant:*": 1,
+	//     }
+	//
 	// If set to nil or not specified, the server will process only the "default" queue.
 	//
 	// Priority is treated as follows to avoid starving low priority queues.
@@ -149,6 +209,50 @@ type Config struct {
 	// higher priorities are empty.
 	StrictPriority bool
 
+	// QueueRateLimits specifies the maximum rate at which tasks are processed from each queue.
+	// Keys are the names of the queues and values are the rate limits.
+	//
+	// A rate limit is enforced using a token bucket in redis shared by all servers processing
+	// the queue, so the limit applies to the whole cluster rather than to each server.
+	// All servers should be configured with the same rate limit for a queue.
+	// While a queue has reached its limit, the server skips the queue instead of blocking workers.
+	//
+	// Example:
+	//
+	//     QueueRateLimits: map[string]asynq_learn.RateLimit{
+	//         "emails": {Limit: 50}, // at most 50 tasks per second
+	//     }
+	//
+	// If a rate limit has a zero or negative Limit, the rate limit will be ignored.
+	QueueRateLimits map[string]RateLimit
+
+	// TypeConcurrency specifies the maximum number of tasks of a type processed concurrently
+	// by the server. Keys are the task types and values are the limits.
+	//
+	// While the number of active tasks of a type has reached its limit, the server leaves
+	// the tasks of the type in the queues and processes tasks of other types instead.
+	// Task types which are not in the map are limited only by Concurrency.
+	//
+	// If a type has a zero or negative limit, the limit will be ignored.
+	TypeConcurrency map[string]int
+
+	// TypeConcurrencyLimiter, if set, limits the number of tasks of a type processed concurrently
+	// across all servers sharing the limiter, in addition to TypeConcurrency.
+	//
+	// See rate.TypeSemaphore in the x/rate package for an implementation using redis.
+	TypeConcurrencyLimiter TypeConcurrencyLimiter
+
+	// WakeupOnEnqueue, if true, makes the server start processing a task as soon as the task
+	// is enqueued to an empty queue. The server subscribes to the notifications published by
+	// Client when it enqueues tasks, and by the server when it moves scheduled and retry tasks
+	// to their queues.
+	//
+	// By default, the server checks the queues once a second while they are empty, which adds
+	// up to a second of latency to the tasks enqueued into an idle server.
+	// The queues are still checked once a second when this option is enabled, to pick up the
+	// tasks made pending without a notification (e.g. the next task of a chain).
+	WakeupOnEnqueue bool
+
 	// ErrorHandler handles errors returned by the task handler.
 	//
 	// HandleError is invoked only if the task handler returns a non-nil error.
@@ -192,9 +296,18 @@ type Config struct {
 	// If unset or zero, the interval is set to 15 seconds.
 	HealthCheckInterval time.Duration
 
-	// DelayedTaskCheckInterval specifies the interval between checks run on 'scheduled' and 'retry'
+	// QueuePatternCheckInterval specifies the interval between resolutions of
+	// the queue patterns in Queues against all known queues.
+	//
+	// If unset or zero, the interval is set to 5 seconds.
+	QueuePatternCheckInterval time.Duration
+
+	// DelayedTaskCheckInterval specifies the maximum interval between checks run on 'scheduled' and 'retry'
 	// tasks, and forwarding them to 'pending' state if they are ready to be processed.
 	//
+	// The server also checks at the time the earliest 'scheduled' or 'retry' task becomes ready,
+	// so tasks are forwarded with millisecond precision regardless of the interval.
+	//
 	// If unset or zero, the interval is set to 5 seconds.
 	DelayedTaskCheckInterval time.Duration
 
@@ -225,6 +338,12 @@ type Config struct {
 	//
 	// If unset or nil, the group aggregation feature will be disabled on the server.
 	GroupAggregator GroupAggregator
+
+	// ProcessingObserver is notified of the processing of each task by the server,
+	// e.g. to export metrics on processing latency and outcomes.
+	//
+	// If unset or nil, no observer is notified.
+	ProcessingObserver ProcessingObserver
 }
 
 // GroupAggregator aggregates a group of tasks into one before the tasks are passed to the Handler.
@@ -247,6 +366,36 @@ func (fn GroupAggregatorFunc) Aggregate(group string, tasks []*Task) *Task {
 	return fn(group, tasks)
 }
 
+// RateLimit specifies the maximum rate at which tasks are processed from a queue.
+type RateLimit struct {
+	// Limit is the maximum number of tasks processed per second.
+	Limit float64
+
+	// Burst is the maximum number of tasks which can be processed at once
+	// after the queue has not reached its limit for a while.
+	//
+	// If zero or negative, Limit rounded up is used (i.e. the number of tasks for one second).
+	Burst int
+}
+
+// TypeConcurrencyLimiter limits the number of tasks of a type processed concurrently
+// by a group of servers.
+//
+// The context passed to Acquire and Release carries the ID and the deadline of the task,
+// which can be retrieved with GetTaskID and ctx.Deadline.
+type TypeConcurrencyLimiter interface {
+	// Acquire attempts to acquire a slot to process the task of the given type.
+	// It returns false if all the slots for the type are taken.
+	Acquire(ctx context.Context, typename string) (bool, error)
+
+	// Release releases the slot acquired for the task of the given type.
+	Release(ctx context.Context, typename string) error
+
+	// Saturated returns the task types whose slots are all taken.
+	// The server calls it before dequeuing a task and leaves the tasks of these types in the queues.
+	Saturated(ctx context.Context) ([]string, error)
+}
+
 // An ErrorHandler handles an error occurred during task processing.
 type ErrorHandler interface {
 	HandleError(ctx context.Context, task *Task, err error)
@@ -261,6 +410,73 @@ func (fn ErrorHandlerFunc) HandleError(ctx context.Context, task *Task, err erro
 	fn(ctx, task, err)
 }
 
+// A ProcessingObserver is notified of the processing of tasks by a Server.
+//
+// Methods are called from the worker goroutines, so they must be safe for concurrent use
+// and should return quickly to avoid delaying the processing of tasks.
+type ProcessingObserver interface {
+	// ObserveQueueWait is called when a task is dequeued with the duration the task
+	// was pending in the queue.
+	ObserveQueueWait(queue, typename string, d time.Duration)
+
+	// ObserveHandlerDuration is called when Handler.ProcessTask returns with the duration of the call.
+	ObserveHandlerDuration(queue, typename string, d time.Duration)
+
+	// ObserveOutcome is called for each outcome of the processing of a task.
+	//
+	// A task may have more than one outcome, e.g. a failed task reports TaskOutcomeFailure
+	// followed by either TaskOutcomeRetry or TaskOutcomeArchive.
+	ObserveOutcome(queue, typename string, outcome TaskOutcome)
+}
+
+// TaskOutcome describes an outcome of the processing of a task.
+type TaskOutcome int
+
+const (
+	// TaskOutcomeSuccess indicates that the task was processed successfully.
+	TaskOutcomeSuccess TaskOutcome = iota + 1
+
+	// TaskOutcomeFailure indicates that the processing of the task failed.
+	// Errors for which Config.IsFailure returns false are not reported as failures.
+	TaskOutcomeFailure
+
+	// TaskOutcomeRetry indicates that the task was scheduled to be retried.
+	TaskOutcomeRetry
+
+	// TaskOutcomeArchive indicates that the task was archived.
+	TaskOutcomeArchive
+
+	// TaskOutcomePanic indicates that the Handler panicked while processing the task.
+	TaskOutcomePanic
+
+	// TaskOutcomeLeaseExpired indicates that the lease of the task expired while it was processed.
+	TaskOutcomeLeaseExpired
+
+	// TaskOutcomeShutdownRequeue indicates that the task was pushed back to the queue
+	// since the server was shutting down.
+	TaskOutcomeShutdownRequeue
+)
+
+func (o TaskOutcome) String() string {
+	switch o {
+	case TaskOutcomeSuccess:
+		return "success"
+	case TaskOutcomeFailure:
+		return "failure"
+	case TaskOutcomeRetry:
+		return "retry"
+	case TaskOutcomeArchive:
+		return "archive"
+	case TaskOutcomePanic:
+		return "panic"
+	case TaskOutcomeLeaseExpired:
+		return "lease_expired"
+	case TaskOutcomeShutdownRequeue:
+		return "shutdown_requeue"
+	}
+	panic("asynq_learn: unknown task outcome")
+}
+
 // RetryDelayFunc calculates the retry delay duration for a failed task given
 // the retry count, error, and the task.
 //
@@ -391,16 +607,24 @@ const (
 
 	defaultDelayedTaskCheckInterval = 5 * time.Second
 
+	defaultQueuePatternCheckInterval = 5 * time.Second
+
 	defaultGroupGracePeriod = 1 * time.Minute
 )
 
 // NewServer returns a new Server given a redis connection option
 // and server configuration.
 func NewServer(r RedisConnOpt, cfg Config) *Server {
-	c, ok := r.MakeRedisClient().(redis.UniversalClient)
-	if !ok {
-		panic(fmt.Sprintf("asynq_learn: unsupported RedisConnOpt type %T", r))
-	}
+	return newServer(makeBroker(r), cfg)
+}
+
+// NewServerWithBroker returns a new Server given a broker option, e.g. an InMemoryBroker,
+// and server configuration.
+func NewServerWithBroker(b BrokerOpt, cfg Config) *Server {
+	return newServer(b.makeBroker(), cfg)
+}
+
+func newServer(broker broker, cfg Config) *Server {
 	baseCtxFn := cfg.BaseContext
 	if baseCtxFn == nil {
 		baseCtxFn = context.Background
@@ -429,10 +653,30 @@ func NewServer(r RedisConnOpt, cfg Config) *Server {
 	if len(queues) == 0 {
 		queues = defaultQueueConfig
 	}
+	// Queue patterns are resolved once the server starts.
+	resolvedQueues := resolveQueues(queues, nil)
 	var qnames []string
-	for q := range queues {
+	for q := range resolvedQueues {
 		qnames = append(qnames, q)
 	}
+	rateLimits := make(map[string]RateLimit)
+	for qname, rl := range cfg.QueueRateLimits {
+		// Note: Rate limits of queues not processed by the server are kept,
+		// since the queues can be added with UpdateConfig.
+		if base.ValidateQueueName(qname) != nil || rl.Limit <= 0 {
+			continue
+		}
+		if rl.Burst <= 0 {
+			rl.Burst = int(math.Ceil(rl.Limit))
+		}
+		rateLimits[qname] = rl
+	}
+	typeConcurrency := make(map[string]int)
+	for typename, n := range cfg.TypeConcurrency {
+		if n > 0 {
+			typeConcurrency[typename] = n
+		}
+	}
 	shutdownTimeout := cfg.ShutdownTimeout
 	if shutdownTimeout == 0 {
 		shutdownTimeout = defaultShutdownTimeout
@@ -456,7 +700,6 @@ func NewServer(r RedisConnOpt, cfg Config) *Server {
 	}
 	logger.SetLevel(toInternalLogLevel(loglevel))
 
-	rdb := rdb.NewRDB(c)
 	starting := make(chan *workerInfo)
 	finished := make(chan *base.TaskMessage)
 	syncCh := make(chan *syncRequest)
@@ -472,10 +715,10 @@ func NewServer(r RedisConnOpt, cfg Config) *Server {
 	// 心跳相关配置
 	heartbeater := newHeartbeater(heartbeaterParams{
 		logger:         logger,
-		broker:         rdb,
+		broker:         broker,
 		interval:       5 * time.Second,
 		concurrency:    n,
-		queues:         queues,
+		queues:         resolvedQueues,
 		strictPriority: cfg.StrictPriority,
 		state:          srvState,
 		starting:       starting,
@@ -487,34 +730,55 @@ func NewServer(r RedisConnOpt, cfg Config) *Server {
 	}
 	forwarder := newForwarder(forwarderParams{
 		logger:   logger,
-		broker:   rdb,
+		broker:   broker,
 		queues:   qnames,
 		interval: delayedTaskCheckInterval,
 	})
 	subscriber := newSubscriber(subscriberParams{
 		logger:       logger,
-		broker:       rdb,
+		broker:       broker,
 		cancelations: cancels,
 	})
+	var (
+		waker    *waker
+		wakeupCh chan struct{}
+	)
+	if cfg.WakeupOnEnqueue {
+		wakeupCh = make(chan struct{}, 1)
+		waker = newWaker(wakerParams{
+			logger:   logger,
+			broker:   broker,
+			queues:   qnames,
+			wakeupCh: wakeupCh,
+		})
+	}
 	processor := newProcessor(processorParams{
 		logger:          logger,
-		broker:          rdb,
+		broker:          broker,
+		serverID:        heartbeater.serverID,
+		host:            heartbeater.host,
+		pid:             heartbeater.pid,
 		retryDelayFunc:  delayFunc,
 		baseCtxFn:       baseCtxFn,
 		isFailureFunc:   isFailureFunc,
 		syncCh:          syncCh,
 		cancelations:    cancels,
 		concurrency:     n,
-		queues:          queues,
+		queues:          resolvedQueues,
 		strictPriority:  cfg.StrictPriority,
+		rateLimits:      rateLimits,
+		typeConcurrency: typeConcurrency,
+		typeLimiter:     cfg.TypeConcurrencyLimiter,
 		errHandler:      cfg.ErrorHandler,
+		observer:        cfg.ProcessingObserver,
+		wakeupCh:        wakeupCh,
 		shutdownTimeout: shutdownTimeout,
 		starting:        starting,
 		finished:        finished,
 	})
 	recoverer := newRecoverer(recovererParams{
 		logger:         logger,
-		broker:         rdb,
+		broker:         broker,
 		retryDelayFunc: delayFunc,
 		isFailureFunc:  isFailureFunc,
 		queues:         qnames,
@@ -522,39 +786,64 @@ func NewServer(r RedisConnOpt, cfg Config) *Server {
 	})
 	healthchecker := newHealthChecker(healthcheckerParams{
 		logger:          logger,
-		broker:          rdb,
+		broker:          broker,
 		interval:        healthcheckInterval,
 		healthcheckFunc: cfg.HealthCheckFunc,
 	})
 	janitor := newJanitor(janitorParams{
 		logger:   logger,
-		broker:   rdb,
+		broker:   broker,
 		queues:   qnames,
 		interval: 8 * time.Second,
 	})
 	aggregator := newAggregator(aggregatorParams{
 		logger:          logger,
-		broker:          rdb,
+		broker:          broker,
 		queues:          qnames,
 		gracePeriod:     groupGracePeriod,
 		maxDelay:        cfg.GroupMaxDelay,
 		maxSize:         cfg.GroupMaxSize,
 		groupAggregator: cfg.GroupAggregator,
 	})
-	return &Server{
+	srv := &Server{
 		logger:        logger,
-		broker:        rdb,
+		broker:        broker,
 		state:         srvState,
 		forwarder:     forwarder,
 		processor:     processor,
 		syncer:        syncer,
 		heartbeater:   heartbeater,
 		subscriber:    subscriber,
+		waker:         waker,
 		recoverer:     recoverer,
 		healthchecker: healthchecker,
 		janitor:       janitor,
 		aggregator:    aggregator,
+		config: runtimeConfig{
+			concurrency:    n,
+			strictPriority: cfg.StrictPriority,
+			queues:         queues,
+			resolvedQueues: resolvedQueues,
+		},
 	}
+	queuePatternCheckInterval := cfg.QueuePatternCheckInterval
+	if queuePatternCheckInterval == 0 {
+		queuePatternCheckInterval = defaultQueuePatternCheckInterval
+	}
+	srv.queueResolver = newQueueResolver(queueResolverParams{
+		logger:      logger,
+		broker:      broker,
+		hasPatterns: srv.hasQueuePatterns,
+		update:      srv.resolveQueuePatterns,
+		interval:    queuePatternCheckInterval,
+	})
+	srv.reconfigurer = newReconfigurer(reconfigurerParams{
+		logger:   logger,
+		broker:   broker,
+		serverID: heartbeater.serverID,
+		apply:    srv.UpdateConfig,
+	})
+	return srv
 }
 
 // A Handler processes tasks.
@@ -569,6 +858,11 @@ func NewServer(r RedisConnOpt, cfg Config) *Server {
 // One exception to this rule is when ProcessTask returns a SkipRetry error.
 // If the returned error is SkipRetry or an error wraps SkipRetry, retry is
 // skipped and the task will be immediately archived instead.
+// Likewise, a task enqueued with a RetryPolicy is archived when the kind of the
+// returned error (see WithErrorKind) is listed in the policy's NonRetryable.
+//
+// To control when the task is retried, return an error created by RetryAfter,
+// or by Snooze to retry the task without counting the attempt as a failure.
 type Handler interface {
 	ProcessTask(context.Context, *Task) error
 }
@@ -637,12 +931,17 @@ func (srv *Server) Start(handler Handler) error {
 	srv.healthchecker.start(&srv.wg)
 	// 订阅启动 可以在命令行中发布取消的任务ID，然后subscriber 监听到后，在map[id]cancel 中找到对应的取消函数，然后执行
 	srv.subscriber.start(&srv.wg)
+	srv.reconfigurer.start(&srv.wg)
+	srv.queueResolver.start(&srv.wg)
 	// 重试错误
 	srv.syncer.start(&srv.wg)
 	// 异常恢复
 	srv.recoverer.start(&srv.wg)
 	// 代理
 	srv.forwarder.start(&srv.wg)
+	if srv.waker != nil {
+		srv.waker.start(&srv.wg)
+	}
 	// 处理器
 	srv.processor.start(&srv.wg)
 	// 清洁
@@ -690,10 +989,15 @@ func (srv *Server) Shutdown() {
 	// processor -> syncer (via syncCh)
 	// processor -> heartbeater (via starting, finished channels)
 	srv.forwarder.shutdown()
+	if srv.waker != nil {
+		srv.waker.shutdown()
+	}
 	srv.processor.shutdown()
 	srv.recoverer.shutdown()
 	srv.syncer.shutdown()
 	srv.subscriber.shutdown()
+	srv.reconfigurer.shutdown()
+	srv.queueResolver.shutdown()
 	srv.janitor.shutdown()
 	srv.aggregator.shutdown()
 	srv.healthchecker.shutdown()
@@ -723,3 +1027,129 @@ func (srv *Server) Stop() {
 	srv.processor.stop()
 	srv.logger.Info("Processor stopped")
 }
+
+// ServerConfigUpdate specifies changes to the configuration of a running Server.
+// Zero-valued fields leave the corresponding configuration unchanged.
+type ServerConfigUpdate struct {
+	// Concurrency is the new maximum number of concurrent processing of tasks.
+	Concurrency int
+
+	// Queues is the new list of queues to process with given priority value.
+	// See Config.Queues for details.
+	Queues map[string]int
+
+	// StrictPriority, if non-nil, changes whether the queue priority is treated strictly.
+	// See Config.StrictPriority for details.
+	StrictPriority *bool
+}
+
+// UpdateConfig changes the queues, queue priorities and concurrency of the server
+// without restarting it. The new configuration is reported in ServerInfo by Inspector.Servers.
+//
+// Active workers are not interrupted. If the concurrency is decreased, the server
+// processes new tasks only after the number of active workers drops below the new limit.
+// Rate limits in Config.QueueRateLimits apply to the queues added by the update.
+//
+// UpdateConfig returns an error if the update contains an invalid queue name,
+// a non-positive queue priority or a negative concurrency.
+// If the server has already been shutdown, ErrServerClosed is returned.
+func (srv *Server) UpdateConfig(update ServerConfigUpdate) error {
+	srv.state.mu.Lock()
+	closed := srv.state.value == srvStateClosed
+	srv.state.mu.Unlock()
+	if closed {
+		return ErrServerClosed
+	}
+	if update.Concurrency < 0 {
+		return fmt.Errorf("asynq_learn: concurrency must be positive, got %d", update.Concurrency)
+	}
+	queues := make(map[string]int)
+	for qname, p := range update.Queues {
+		if err := base.ValidateQueueName(qname); err != nil {
+			return fmt.Errorf("asynq_learn: %v", err)
+		}
+		if p <= 0 {
+			return fmt.Errorf("asynq_learn: priority of queue %q must be positive, got %d", qname, p)
+		}
+		queues[qname] = p
+	}
+
+	srv.configMu.Lock()
+	defer srv.configMu.Unlock()
+	if update.Concurrency > 0 && update.Concurrency != srv.config.concurrency {
+		srv.config.concurrency = update.Concurrency
+		srv.processor.setConcurrency(update.Concurrency)
+	}
+	if len(queues) > 0 {
+		srv.config.queues = queues
+	}
+	if update.StrictPriority != nil {
+		srv.config.strictPriority = *update.StrictPriority
+	}
+	srv.applyQueues(update.StrictPriority != nil)
+	srv.heartbeater.setConfig(srv.config.concurrency, srv.config.resolvedQueues, srv.config.strictPriority)
+	srv.logger.Infof("Updated server config: concurrency=%d queues=%v strict_priority=%t",
+		srv.config.concurrency, srv.config.queues, srv.config.strictPriority)
+	if len(queues) > 0 && srv.hasQueuePatternsLocked() {
+		// Resolve the new patterns against the current list of queues.
+		srv.queueResolver.resolveNow()
+	}
+	return nil
+}
+
+// hasQueuePatterns reports whether the queue config of the server contains patterns.
+func (srv *Server) hasQueuePatterns() bool {
+	srv.configMu.Lock()
+	defer srv.configMu.Unlock()
+	return srv.hasQueuePatternsLocked()
+}
+
+// Caller must hold srv.configMu.
+func (srv *Server) hasQueuePatternsLocked() bool {
+	for name := range srv.config.queues {
+		if isQueuePattern(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveQueuePatterns resolves the queue patterns against the given list of all
+// known queues, and updates the queues processed by the server if they changed.
+func (srv *Server) resolveQueuePatterns(allQueues []string) {
+	srv.configMu.Lock()
+	defer srv.configMu.Unlock()
+	srv.config.allQueues = allQueues
+	if srv.applyQueues(false) {
+		srv.heartbeater.setConfig(srv.config.concurrency, srv.config.resolvedQueues, srv.config.strictPriority)
+		srv.logger.Infof("Resolved queue patterns: queues=%v", srv.config.resolvedQueues)
+	}
+}
+
+// applyQueues resolves the queue config and passes the resulting queues to the server
+// components. The processor is also updated if force is true, e.g. when the strict
+// priority mode changed. It reports whether the resolved queues changed.
+//
+// Caller must hold srv.configMu.
+func (srv *Server) applyQueues(force bool) (changed bool) {
+	resolved := resolveQueues(srv.config.queues, srv.config.allQueues)
+	changed = !reflect.DeepEqual(resolved, srv.config.resolvedQueues)
+	if changed {
+		srv.config.resolvedQueues = resolved
+		var qnames []string
+		for qname := range resolved {
+			qnames = append(qnames, qname)
+		}
+		srv.forwarder.setQueues(qnames)
+		srv.recoverer.queues.set(qnames)
+		srv.janitor.queues.set(qnames)
+		srv.aggregator.queues.set(qnames)
+		if srv.waker != nil {
+			srv.waker.setQueues(qnames)
+		}
+	}
+	if changed || force {
+		srv.processor.setQueues(resolved, srv.config.strictPriority)
+	}
+	return changed
+}
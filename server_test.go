@@ -6,11 +6,16 @@ package asynq_learn
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/hibiken/asynq/internal/testbroker"
 	"github.com/hibiken/asynq/internal/testutil"
@@ -53,6 +58,539 @@ func TestServer(t *testing.T) {
 	srv.Shutdown()
 }
 
+func TestServerWithInMemoryBroker(t *testing.T) {
+	// https://github.com/go-redis/redis/issues/1029
+	ignoreOpt := goleak.IgnoreTopFunction("github.com/go-redis/redis/v8/internal/pool.(*ConnPool).reaper")
+	defer goleak.VerifyNone(t, ignoreOpt)
+
+	broker := NewInMemoryBroker()
+	c := NewClientWithBroker(broker)
+	defer c.Close()
+	inspector := NewInspectorWithBroker(broker)
+	defer inspector.Close()
+	srv := NewServerWithBroker(broker, Config{
+		Concurrency: 10,
+		LogLevel:    testLogLevel,
+	})
+
+	processed := make(chan string, 1)
+	h := func(ctx context.Context, task *Task) error {
+		processed <- string(task.Payload())
+		return nil
+	}
+	if err := srv.Start(HandlerFunc(h)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Enqueue(NewTask("send_email", []byte("hello"))); err != nil {
+		t.Errorf("could not enqueue a task: %v", err)
+	}
+	if _, err := c.Enqueue(NewTask("send_email", []byte("later")), ProcessIn(1*time.Hour)); err != nil {
+		t.Errorf("could not enqueue a task: %v", err)
+	}
+
+	select {
+	case got := <-processed:
+		if got != "hello" {
+			t.Errorf("processed task with payload %q, want %q", got, "hello")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("task was not processed")
+	}
+
+	srv.Shutdown()
+
+	info, err := inspector.GetQueueInfo(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("GetQueueInfo failed: %v", err)
+	}
+	if info.Processed != 1 || info.Scheduled != 1 {
+		t.Errorf("GetQueueInfo returned Processed=%d Scheduled=%d, want Processed=1 Scheduled=1", info.Processed, info.Scheduled)
+	}
+}
+
+func TestServerUpdateConfig(t *testing.T) {
+	broker := NewInMemoryBroker()
+	c := NewClientWithBroker(broker)
+	defer c.Close()
+	inspector := NewInspectorWithBroker(broker)
+	defer inspector.Close()
+	srv := NewServerWithBroker(broker, Config{
+		Concurrency: 1,
+		Queues:      map[string]int{"default": 1},
+		LogLevel:    testLogLevel,
+	})
+
+	processed := make(chan string, 1)
+	h := func(ctx context.Context, task *Task) error {
+		processed <- string(task.Payload())
+		return nil
+	}
+	if err := srv.Start(HandlerFunc(h)); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+
+	if _, err := c.Enqueue(NewTask("send_email", []byte("critical")), Queue("critical")); err != nil {
+		t.Fatalf("could not enqueue a task: %v", err)
+	}
+	select {
+	case got := <-processed:
+		t.Fatalf("processed task %q from a queue not processed by the server", got)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	strict := true
+	err := srv.UpdateConfig(ServerConfigUpdate{
+		Concurrency:    4,
+		Queues:         map[string]int{"critical": 6, "default": 3},
+		StrictPriority: &strict,
+	})
+	if err != nil {
+		t.Fatalf("UpdateConfig returned error: %v", err)
+	}
+	select {
+	case got := <-processed:
+		if got != "critical" {
+			t.Errorf("processed task with payload %q, want %q", got, "critical")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("task in the added queue was not processed")
+	}
+
+	// The heartbeater writes the new configuration right away.
+	want := &ServerInfo{Concurrency: 4, Queues: map[string]int{"critical": 6, "default": 3}, StrictPriority: true}
+	var got *ServerInfo
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		servers, err := inspector.Servers()
+		if err != nil || len(servers) != 1 {
+			t.Fatalf("Servers() = %v, %v; want one server", servers, err)
+		}
+		got = servers[0]
+		if got.Concurrency == want.Concurrency {
+			break
+		}
+	}
+	if got.Concurrency != want.Concurrency || !cmp.Equal(got.Queues, want.Queues) || got.StrictPriority != want.StrictPriority {
+		t.Errorf("server info has Concurrency=%d Queues=%v StrictPriority=%t, want Concurrency=%d Queues=%v StrictPriority=%t",
+			got.Concurrency, got.Queues, got.StrictPriority, want.Concurrency, want.Queues, want.StrictPriority)
+	}
+}
+
+func TestServerQueuePatterns(t *testing.T) {
+	broker := NewInMemoryBroker()
+	c := NewClientWithBroker(broker)
+	defer c.Close()
+	inspector := NewInspectorWithBroker(broker)
+	defer inspector.Close()
+	srv := NewServerWithBroker(broker, Config{
+		Concurrency:               1,
+		Queues:                    map[string]int{"tenant:*": 1},
+		QueuePatternCheckInterval: 100 * time.Millisecond,
+		LogLevel:                  testLogLevel,
+	})
+
+	processed := make(chan string, 1)
+	h := func(ctx context.Context, task *Task) error {
+		processed <- string(task.Payload())
+		return nil
+	}
+	if err := srv.Start(HandlerFunc(h)); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+
+	// Queues created after the server started are picked up.
+	if _, err := c.Enqueue(NewTask("send_email", []byte("other")), Queue("other:42")); err != nil {
+		t.Fatalf("could not enqueue a task: %v", err)
+	}
+	if _, err := c.Enqueue(NewTask("send_email", []byte("tenant")), Queue("tenant:42")); err != nil {
+		t.Fatalf("could not enqueue a task: %v", err)
+	}
+	select {
+	case got := <-processed:
+		if got != "tenant" {
+			t.Errorf("processed task with payload %q, want %q", got, "tenant")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("task in the queue matching the pattern was not processed")
+	}
+	select {
+	case got := <-processed:
+		t.Fatalf("processed task %q from a queue not matching the pattern", got)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// The server reports the resolved queues.
+	want := map[string]int{"tenant:42": 1}
+	servers, err := inspector.Servers()
+	if err != nil || len(servers) != 1 {
+		t.Fatalf("Servers() = %v, %v; want one server", servers, err)
+	}
+	if diff := cmp.Diff(want, servers[0].Queues); diff != "" {
+		t.Errorf("server info has Queues=%v, want %v; (-want,+got)\n%s", servers[0].Queues, want, diff)
+	}
+}
+
+func TestServerUpdateConfigError(t *testing.T) {
+	srv := NewServerWithBroker(NewInMemoryBroker(), Config{LogLevel: testLogLevel})
+	tests := []struct {
+		desc   string
+		update ServerConfigUpdate
+	}{
+		{"negative concurrency", ServerConfigUpdate{Concurrency: -1}},
+		{"invalid queue name", ServerConfigUpdate{Queues: map[string]int{"": 1}}},
+		{"non-positive priority", ServerConfigUpdate{Queues: map[string]int{"default": 0}}},
+	}
+	for _, tc := range tests {
+		if err := srv.UpdateConfig(tc.update); err == nil {
+			t.Errorf("%s: UpdateConfig(%+v) returned nil, want error", tc.desc, tc.update)
+		}
+	}
+
+	if err := srv.Start(HandlerFunc(func(ctx context.Context, task *Task) error { return nil })); err != nil {
+		t.Fatal(err)
+	}
+	srv.Shutdown()
+	if err := srv.UpdateConfig(ServerConfigUpdate{Concurrency: 2}); err != ErrServerClosed {
+		t.Errorf("UpdateConfig after shutdown returned %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestServerPassesTaskHeaders(t *testing.T) {
+	broker := NewInMemoryBroker()
+	c := NewClientWithBroker(broker)
+	defer c.Close()
+	srv := NewServerWithBroker(broker, Config{
+		Concurrency: 10,
+		LogLevel:    testLogLevel,
+	})
+
+	headers := make(chan map[string]string, 1)
+	h := func(ctx context.Context, task *Task) error {
+		headers <- task.Headers()
+		return nil
+	}
+	if err := srv.Start(HandlerFunc(h)); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+
+	want := map[string]string{"tenant_id": "tenant1"}
+	if _, err := c.Enqueue(NewTaskWithHeaders("task", nil, want)); err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	select {
+	case got := <-headers:
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Handler got task with headers %v, want %v; (-want,+got)\n%s", got, want, diff)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("task was not processed")
+	}
+}
+
+func TestServerProcessesChain(t *testing.T) {
+	broker := NewInMemoryBroker()
+	c := NewClientWithBroker(broker)
+	defer c.Close()
+	inspector := NewInspectorWithBroker(broker)
+	defer inspector.Close()
+	srv := NewServerWithBroker(broker, Config{
+		Concurrency: 10,
+		LogLevel:    testLogLevel,
+	})
+
+	// Each step appends its own payload to the result of the previous step.
+	processed := make(chan string, 3)
+	h := func(ctx context.Context, task *Task) error {
+		res := string(task.PreviousResult()) + string(task.Payload())
+		if _, err := task.ResultWriter().Write([]byte(res)); err != nil {
+			return err
+		}
+		processed <- res
+		return nil
+	}
+	if err := srv.Start(HandlerFunc(h)); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+
+	chain, err := c.Chain(
+		NewTask("step", []byte("a")),
+		NewTask("step", []byte("b")),
+		NewTask("step", []byte("c")),
+	)
+	if err != nil {
+		t.Fatalf("Chain returned error: %v", err)
+	}
+	if chain.State != ChainStateActive || chain.CurrentStep != 0 {
+		t.Errorf("Chain returned State=%v CurrentStep=%d, want %v, 0", chain.State, chain.CurrentStep, ChainStateActive)
+	}
+
+	for _, want := range []string{"a", "ab", "abc"} {
+		select {
+		case got := <-processed:
+			if got != want {
+				t.Errorf("processed step with result %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("step with result %q was not processed", want)
+		}
+	}
+
+	// Wait for the last step to be marked as done.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := inspector.GetChainInfo(base.DefaultQueueName, chain.ID)
+		if err != nil {
+			t.Fatalf("GetChainInfo returned error: %v", err)
+		}
+		if info.State == ChainStateCompleted {
+			if got := string(info.Steps[2].Result); got != "abc" {
+				t.Errorf("result of the last step = %q, want %q", got, "abc")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("chain state = %v, want %v", info.State, ChainStateCompleted)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientEnqueueAndWait(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	for _, connOpt := range []BrokerOpt{getRedisConnOpt(t).(BrokerOpt), NewInMemoryBroker()} {
+		c := NewClientWithBroker(connOpt)
+		srv := NewServerWithBroker(connOpt, Config{
+			Concurrency: 10,
+			LogLevel:    testLogLevel,
+		})
+		h := func(ctx context.Context, task *Task) error {
+			if task.Type() == "fail" {
+				return fmt.Errorf("bad input: %w", SkipRetry)
+			}
+			_, err := task.ResultWriter().Write(append([]byte("echo:"), task.Payload()...))
+			return err
+		}
+		if err := srv.Start(HandlerFunc(h)); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		info, err := c.EnqueueAndWait(ctx, NewTask("echo", []byte("hello")), Retention(time.Hour))
+		if err != nil {
+			t.Errorf("EnqueueAndWait returned error: %v", err)
+		} else {
+			if info.State != TaskStateCompleted {
+				t.Errorf("EnqueueAndWait returned state %v, want %v", info.State, TaskStateCompleted)
+			}
+			if got, want := string(info.Result), "echo:hello"; got != want {
+				t.Errorf("EnqueueAndWait returned result %q, want %q", got, want)
+			}
+		}
+
+		info, err = c.EnqueueAndWait(ctx, NewTask("fail", nil), Retention(time.Hour))
+		if !errors.Is(err, ErrTaskArchived) {
+			t.Errorf("EnqueueAndWait returned error %v, want %v", err, ErrTaskArchived)
+		}
+		if info == nil || info.State != TaskStateArchived || !strings.Contains(info.LastErr, "bad input") {
+			t.Errorf("EnqueueAndWait returned %+v, want an archived task with the error message", info)
+		}
+		cancel()
+
+		if _, err := c.EnqueueAndWait(context.Background(), NewTask("echo", nil)); err == nil {
+			t.Errorf("EnqueueAndWait without Retention option returned nil error")
+		}
+		if _, err := c.EnqueueAndWait(context.Background(), NewTask("echo", nil), Retention(time.Hour),
+			DependsOn("parent"), ProcessIn(time.Hour)); err == nil {
+			t.Errorf("EnqueueAndWait with DependsOn and ProcessIn options returned nil error")
+		}
+
+		// A task deleted before it is processed ends the wait with ErrTaskNotFound.
+		inspector := NewInspectorWithBroker(connOpt)
+		for _, remove := range []func(qname, id string) error{
+			inspector.DeleteTask,
+			func(qname, id string) error { return inspector.DeleteQueue(qname, true) },
+		} {
+			id := uuid.NewString()
+			go func() {
+				for {
+					if _, err := inspector.GetTaskInfo("waits", id); err == nil {
+						break
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				if err := remove("waits", id); err != nil {
+					t.Errorf("failed to remove the task: %v", err)
+				}
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, err := c.EnqueueAndWait(ctx, NewTask("later", nil), Queue("waits"), TaskID(id),
+				Retention(time.Hour), ProcessIn(time.Hour))
+			if !errors.Is(err, ErrTaskNotFound) {
+				t.Errorf("EnqueueAndWait for a removed task returned error %v, want %v", err, ErrTaskNotFound)
+			}
+			cancel()
+		}
+
+		srv.Shutdown()
+		inspector.Close()
+		c.Close()
+	}
+}
+
+func TestInspectorSubscribeEvents(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	for _, connOpt := range []BrokerOpt{getRedisConnOpt(t).(BrokerOpt), NewInMemoryBroker()} {
+		c := NewClientWithBroker(connOpt)
+		inspector := NewInspectorWithBroker(connOpt)
+		srv := NewServerWithBroker(connOpt, Config{
+			Concurrency: 10,
+			Queues:      map[string]int{"events": 1},
+			LogLevel:    testLogLevel,
+		})
+		h := func(ctx context.Context, task *Task) error {
+			if task.Type() == "fail" {
+				return fmt.Errorf("bad input: %w", SkipRetry)
+			}
+			return nil
+		}
+		if err := srv.Start(HandlerFunc(h)); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		events, err := inspector.SubscribeEvents(ctx, EventFilter{Queues: []string{"events"}})
+		if err != nil {
+			t.Fatalf("SubscribeEvents returned error: %v", err)
+		}
+		t1, err := c.Enqueue(NewTask("echo", nil), Queue("events"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t2, err := c.Enqueue(NewTask("fail", nil), Queue("events"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t3, err := c.Enqueue(NewTask("later", nil), Queue("events"), ProcessIn(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := inspector.DeleteTask("events", t3.ID); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string][]TaskEventKind{
+			t1.ID: {TaskEventEnqueued, TaskEventStarted, TaskEventSucceeded},
+			t2.ID: {TaskEventEnqueued, TaskEventStarted, TaskEventFailed, TaskEventArchived},
+			t3.ID: {TaskEventScheduled, TaskEventDeleted},
+		}
+		got := make(map[string][]TaskEventKind)
+		for n := 0; n < 9; n++ {
+			ev, ok := <-events
+			if !ok {
+				t.Fatalf("event channel closed after %d events, want 9 events", n)
+			}
+			got[ev.TaskID] = append(got[ev.TaskID], ev.Kind)
+			if ev.Queue != "events" || ev.Type == "" || ev.Time.IsZero() {
+				t.Errorf("received event %+v, want an event with queue, type and time", ev)
+			}
+			// Only the events recorded by the server carry its ID.
+			byServer := ev.Kind != TaskEventEnqueued && ev.Kind != TaskEventScheduled && ev.Kind != TaskEventDeleted
+			if byServer != (ev.ServerID != "") {
+				t.Errorf("received event %+v with unexpected server ID", ev)
+			}
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("SubscribeEvents delivered %v, want %v; (-want,+got)\n%s", got, want, diff)
+		}
+
+		// Events kept in the stream can be read again with a filter.
+		failed, err := inspector.SubscribeEvents(ctx, EventFilter{After: "0", Kinds: []TaskEventKind{TaskEventFailed}})
+		if err != nil {
+			t.Fatalf("SubscribeEvents returned error: %v", err)
+		}
+		if ev := <-failed; ev == nil || ev.TaskID != t2.ID {
+			t.Errorf("SubscribeEvents with Kinds filter delivered %+v, want the failed event of task %q", ev, t2.ID)
+		}
+		cancel()
+		if _, ok := <-events; ok {
+			t.Errorf("event channel is open after the context is done")
+		}
+
+		srv.Shutdown()
+		inspector.Close()
+		c.Close()
+	}
+}
+
+func TestInspectorSubscribeEventsCancelled(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	for _, connOpt := range []BrokerOpt{getRedisConnOpt(t).(BrokerOpt), NewInMemoryBroker()} {
+		c := NewClientWithBroker(connOpt)
+		inspector := NewInspectorWithBroker(connOpt)
+		srv := NewServerWithBroker(connOpt, Config{
+			Concurrency: 10,
+			Queues:      map[string]int{"cancels": 1},
+			LogLevel:    testLogLevel,
+		})
+		started := make(chan string, 2)
+		h := func(ctx context.Context, task *Task) error {
+			id, _ := GetTaskID(ctx)
+			started <- id
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		if err := srv.Start(HandlerFunc(h)); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		events, err := inspector.SubscribeEvents(ctx, EventFilter{Queues: []string{"cancels"}})
+		if err != nil {
+			t.Fatalf("SubscribeEvents returned error: %v", err)
+		}
+		t1, err := c.Enqueue(NewTask("block", nil), Queue("cancels"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t2, err := c.Enqueue(NewTask("block", nil), Queue("cancels"), MaxRetry(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := inspector.CancelProcessing(<-started); err != nil {
+				t.Fatalf("CancelProcessing returned error: %v", err)
+			}
+		}
+		// A canceled task is recorded as cancelled instead of failed.
+		want := map[string][]TaskEventKind{
+			t1.ID: {TaskEventEnqueued, TaskEventStarted, TaskEventCancelled, TaskEventRetried},
+			t2.ID: {TaskEventEnqueued, TaskEventStarted, TaskEventCancelled, TaskEventArchived},
+		}
+		got := make(map[string][]TaskEventKind)
+		for n := 0; n < 8; n++ {
+			ev, ok := <-events
+			if !ok {
+				t.Fatalf("event channel closed after %d events: %v", n, got)
+			}
+			got[ev.TaskID] = append(got[ev.TaskID], ev.Kind)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("SubscribeEvents delivered %v, want %v; (-want,+got)\n%s", got, want, diff)
+		}
+		cancel()
+
+		srv.Shutdown()
+		inspector.Close()
+		c.Close()
+	}
+}
+
 func TestServerRun(t *testing.T) {
 	// https://github.com/go-redis/redis/issues/1029
 	ignoreOpt := goleak.IgnoreTopFunction("github.com/go-redis/redis/v8/internal/pool.(*ConnPool).reaper")
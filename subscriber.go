@@ -8,7 +8,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/log"
 )
@@ -53,40 +52,62 @@ func (s *subscriber) start(wg *sync.WaitGroup) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		var (
-			pubsub *redis.PubSub
-			err    error
-		)
-		// Try until successfully connect to Redis.
+		// Try until successfully connect to the broker.
 		// 重试的去订阅取消通道
 		for {
 			// 订阅取消通道
-			pubsub, err = s.broker.CancelationPubSub()
+			sub, err := s.broker.SubscribeCancelation()
 			if err != nil {
 				s.logger.Errorf("cannot subscribe to cancelation channel: %v", err)
-				select {
-				case <-time.After(s.retryTimeout):
+				if s.wait() {
 					continue
-				case <-s.done:
-					s.logger.Debug("Subscriber done")
-					return
 				}
+				s.logger.Debug("Subscriber done")
+				return
 			}
-			break
-		}
-		cancelCh := pubsub.Channel()
-		for {
-			select {
-			case <-s.done:
-				pubsub.Close()
+			if !s.listen(sub) {
+				s.logger.Debug("Subscriber done")
+				return
+			}
+			// Subscription was lost; resubscribe after waiting.
+			if !s.wait() {
 				s.logger.Debug("Subscriber done")
 				return
-			case msg := <-cancelCh:
-				cancel, ok := s.cancelations.Get(msg.Payload)
-				if ok {
-					cancel()
-				}
 			}
 		}
 	}()
 }
+
+// listen cancels the active tasks whose IDs are received on the subscription.
+// It reports whether the subscriber should resubscribe, which is the case when
+// the subscription ended before shutdown was requested.
+func (s *subscriber) listen(sub base.CancelationSubscription) (resubscribe bool) {
+	defer sub.Close()
+	cancelCh := sub.Channel()
+	for {
+		select {
+		case <-s.done:
+			return false
+		case id, ok := <-cancelCh:
+			if !ok {
+				s.logger.Errorf("cancelation subscription closed: %v", sub.Err())
+				return true
+			}
+			cancel, ok := s.cancelations.Get(id)
+			if ok {
+				cancel()
+			}
+		}
+	}
+}
+
+// wait waits for the retry timeout and reports whether the subscriber
+// should keep running.
+func (s *subscriber) wait() bool {
+	select {
+	case <-time.After(s.retryTimeout):
+		return true
+	case <-s.done:
+		return false
+	}
+}
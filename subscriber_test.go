@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/memdb"
 	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/hibiken/asynq/internal/testbroker"
 )
@@ -122,3 +123,32 @@ func TestSubscriberWithRedisDown(t *testing.T) {
 	}
 	mu.Unlock()
 }
+
+func TestSubscriberWithInMemoryBroker(t *testing.T) {
+	db := memdb.NewMemDB()
+	cancelations := base.NewCancelations()
+	subscriber := newSubscriber(subscriberParams{
+		logger:       testLogger,
+		broker:       db,
+		cancelations: cancelations,
+	})
+	var wg sync.WaitGroup
+	subscriber.start(&wg)
+	defer subscriber.shutdown()
+
+	time.Sleep(100 * time.Millisecond) // allow subscriber to subscribe.
+
+	const id = "test"
+	called := make(chan struct{})
+	cancelations.Add(id, func() { close(called) })
+
+	if err := db.PublishCancelation(id); err != nil {
+		t.Fatalf("could not publish cancelation message: %v", err)
+	}
+
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Errorf("cancel function was not called")
+	}
+}
@@ -12,7 +12,7 @@ import (
 	"time"
 
 	"github.com/gdamore/tcell/v2"
-	"github.com/hibiken/asynq"
+	asynq "github.com/hibiken/asynq"
 )
 
 // viewType is an enum for dashboard views.
@@ -8,7 +8,7 @@ import (
 	"sort"
 
 	"github.com/gdamore/tcell/v2"
-	"github.com/hibiken/asynq"
+	asynq "github.com/hibiken/asynq"
 )
 
 type fetcher interface {
@@ -10,7 +10,7 @@ import (
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/google/go-cmp/cmp"
-	"github.com/hibiken/asynq"
+	asynq "github.com/hibiken/asynq"
 )
 
 func makeKeyEventHandler(t *testing.T, state *State) *keyEventHandler {
@@ -0,0 +1,98 @@
+// Copyright 2022 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/MakeNowJust/heredoc/v2"
+	asynq "github.com/hibiken/asynq"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(eventsCmd)
+	eventsCmd.AddCommand(eventsTailCmd)
+	eventsTailCmd.Flags().StringSliceP("queue", "q", nil, "only show events of tasks in the queues")
+	eventsTailCmd.Flags().StringSliceP("kind", "k", nil, "only show events of the kinds (e.g. started,failed)")
+	eventsTailCmd.Flags().StringSliceP("type", "t", nil, "only show events of tasks of the types")
+	eventsTailCmd.Flags().String("after", "", `only show events after the event ID ("0" to start from the oldest event kept)`)
+}
+
+var eventsCmd = &cobra.Command{
+	Use:   "events <command> [flags]",
+	Short: "Manage task lifecycle events",
+	Example: heredoc.Doc(`
+		$ asynq_learn events tail
+		$ asynq_learn events tail --queue=myqueue --kind=failed,archived`),
+}
+
+var eventsTailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "Follow task lifecycle events",
+	Long: heredoc.Doc(`
+		Tail prints task lifecycle events as they are recorded, until interrupted.
+
+		Each line shows the event ID, time, kind, queue, task type, task ID and
+		the ID of the server which processed the task, if any.`),
+	Args: cobra.NoArgs,
+	Run:  eventsTail,
+	Example: heredoc.Doc(`
+		$ asynq_learn events tail
+		$ asynq_learn events tail --queue=critical,default
+		$ asynq_learn events tail --kind=failed,archived --type=email:send
+		$ asynq_learn events tail --after=0`),
+}
+
+func eventsTail(cmd *cobra.Command, args []string) {
+	var filter asynq.EventFilter
+	queues, err := cmd.Flags().GetStringSlice("queue")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	kinds, err := cmd.Flags().GetStringSlice("kind")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	types, err := cmd.Flags().GetStringSlice("type")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	after, err := cmd.Flags().GetString("after")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	filter.Queues = queues
+	for _, k := range kinds {
+		filter.Kinds = append(filter.Kinds, asynq.TaskEventKind(k))
+	}
+	filter.Types = types
+	filter.After = after
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	inspector := createInspector()
+	events, err := inspector.SubscribeEvents(ctx, filter)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for ev := range events {
+		serverID := ev.ServerID
+		if serverID == "" {
+			serverID = "-"
+		}
+		fmt.Printf("%s  %s  %-9s  %s  %s  %s  %s\n",
+			ev.ID, ev.Time.Format(time.RFC3339Nano), ev.Kind, ev.Queue, ev.Type, ev.TaskID, serverID)
+	}
+}
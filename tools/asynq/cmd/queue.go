@@ -11,7 +11,7 @@ import (
 
 	"github.com/MakeNowJust/heredoc/v2"
 	"github.com/fatih/color"
-	"github.com/hibiken/asynq"
+	asynq "github.com/hibiken/asynq"
 	"github.com/hibiken/asynq/internal/errors"
 	"github.com/spf13/cobra"
 )
@@ -121,13 +121,13 @@ func queueList(cmd *cobra.Command, args []string) {
 		if useRedisCluster {
 			keyslot, err := inspector.ClusterKeySlot(qname)
 			if err != nil {
-				fmt.Errorf("error: Could not get cluster keyslot for %q\n", qname)
+				fmt.Printf("error: Could not get cluster keyslot for %q\n", qname)
 				continue
 			}
 			q.keyslot = keyslot
 			nodes, err := inspector.ClusterNodes(qname)
 			if err != nil {
-				fmt.Errorf("error: Could not get cluster nodes for %q\n", qname)
+				fmt.Printf("error: Could not get cluster nodes for %q\n", qname)
 				continue
 			}
 			q.nodes = nodes
@@ -174,9 +174,9 @@ func printQueueInfo(info *asynq.QueueInfo) {
 	fmt.Printf("Paused: %t\n\n", info.Paused)
 	bold.Println("Task Count by State")
 	printTable(
-		[]string{"active", "pending", "aggregating", "scheduled", "retry", "archived", "completed"},
+		[]string{"active", "pending", "aggregating", "waiting", "scheduled", "retry", "archived", "completed"},
 		func(w io.Writer, tmpl string) {
-			fmt.Fprintf(w, tmpl, info.Active, info.Pending, info.Aggregating, info.Scheduled, info.Retry, info.Archived, info.Completed)
+			fmt.Fprintf(w, tmpl, info.Active, info.Pending, info.Aggregating, info.Waiting, info.Scheduled, info.Retry, info.Archived, info.Completed)
 		},
 	)
 	fmt.Println()
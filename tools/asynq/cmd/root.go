@@ -17,7 +17,7 @@ import (
 	"github.com/MakeNowJust/heredoc/v2"
 	"github.com/fatih/color"
 	"github.com/go-redis/redis/v8"
-	"github.com/hibiken/asynq"
+	asynq "github.com/hibiken/asynq"
 	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
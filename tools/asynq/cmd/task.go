@@ -8,11 +8,13 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/MakeNowJust/heredoc/v2"
 	"github.com/fatih/color"
-	"github.com/hibiken/asynq"
+	asynq "github.com/hibiken/asynq"
 	"github.com/spf13/cobra"
 )
 
@@ -20,7 +22,7 @@ func init() {
 	rootCmd.AddCommand(taskCmd)
 	taskCmd.AddCommand(taskListCmd)
 	taskListCmd.Flags().StringP("queue", "q", "", "queue to inspect (required)")
-	taskListCmd.Flags().StringP("state", "s", "", "state of the tasks; one of { active | pending | aggregating | scheduled | retry | archived | completed } (required)")
+	taskListCmd.Flags().StringP("state", "s", "", "state of the tasks; one of { active | pending | aggregating | waiting | scheduled | retry | archived | completed } (required)")
 	taskListCmd.Flags().Int("page", 1, "page number")
 	taskListCmd.Flags().Int("size", 30, "page size")
 	taskListCmd.Flags().StringP("group", "g", "", "group to inspect (required for listing aggregating tasks)")
@@ -101,7 +103,8 @@ var taskListCmd = &cobra.Command{
 	Example: heredoc.Doc(`
 		$ asynq_learn task list --queue=myqueue --state=pending
 		$ asynq_learn task list --queue=myqueue --state=aggregating --group=mygroup
-		$ asynq_learn task list --queue=myqueue --state=scheduled --page=2`),
+		$ asynq_learn task list --queue=myqueue --state=scheduled --page=2
+		$ asynq_learn task list --queue=myqueue --state=waiting`),
 	Run: taskList,
 }
 
@@ -216,6 +219,8 @@ func taskList(cmd *cobra.Command, args []string) {
 		listArchivedTasks(qname, pageNum, pageSize)
 	case "completed":
 		listCompletedTasks(qname, pageNum, pageSize)
+	case "waiting":
+		listWaitingTasks(qname, pageNum, pageSize)
 	case "aggregating":
 		group, err := cmd.Flags().GetString("group")
 		if err != nil {
@@ -266,10 +271,10 @@ func listPendingTasks(qname string, pageNum, pageSize int) {
 		return
 	}
 	printTable(
-		[]string{"ID", "Type", "Payload"},
+		[]string{"ID", "Type", "Payload", "Priority"},
 		func(w io.Writer, tmpl string) {
 			for _, t := range tasks {
-				fmt.Fprintf(w, tmpl, t.ID, t.Type, sprintBytes(t.Payload))
+				fmt.Fprintf(w, tmpl, t.ID, t.Type, sprintBytes(t.Payload), t.Priority)
 			}
 		},
 	)
@@ -390,6 +395,27 @@ func listAggregatingTasks(qname, group string, pageNum, pageSize int) {
 	)
 }
 
+func listWaitingTasks(qname string, pageNum, pageSize int) {
+	i := createInspector()
+	tasks, err := i.ListWaitingTasks(qname, asynq.PageSize(pageSize), asynq.Page(pageNum))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(tasks) == 0 {
+		fmt.Printf("No waiting tasks in %q queue\n", qname)
+		return
+	}
+	printTable(
+		[]string{"ID", "Type", "Payload", "Depends On", "Ordering Key"},
+		func(w io.Writer, tmpl string) {
+			for _, t := range tasks {
+				fmt.Fprintf(w, tmpl, t.ID, t.Type, sprintBytes(t.Payload), strings.Join(t.Dependencies, ", "), t.OrderingKey)
+			}
+		},
+	)
+}
+
 func taskCancel(cmd *cobra.Command, args []string) {
 	i := createInspector()
 	for _, id := range args {
@@ -430,14 +456,74 @@ func printTaskInfo(info *asynq.TaskInfo) {
 	fmt.Printf("Type:    %s\n", info.Type)
 	fmt.Printf("State:   %v\n", info.State)
 	fmt.Printf("Retried: %d/%d\n", info.Retried, info.MaxRetry)
+	if info.Priority > 0 {
+		fmt.Printf("Priority: %d\n", info.Priority)
+	}
+	if info.OrderingKey != "" {
+		fmt.Printf("Ordering key: %s\n", info.OrderingKey)
+	}
 	fmt.Println()
 	fmt.Printf("Next process time: %s\n", formatNextProcessAt(info.NextProcessAt))
+	if !info.ExpireAt.IsZero() {
+		fmt.Printf("Expires at:        %s\n", info.ExpireAt.Format(time.UnixDate))
+	}
+	if len(info.Headers) != 0 {
+		fmt.Println()
+		bold.Println("Headers")
+		keys := make([]string, 0, len(info.Headers))
+		for k := range info.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s: %s\n", k, info.Headers[k])
+		}
+	}
+	if p := info.RetryPolicy; p != nil {
+		fmt.Println()
+		bold.Println("Retry Policy")
+		fmt.Printf("Backoff:       %v\n", p.Backoff)
+		fmt.Printf("Base delay:    %v\n", p.BaseDelay)
+		if p.MaxDelay > 0 {
+			fmt.Printf("Max delay:     %v\n", p.MaxDelay)
+		}
+		if p.Jitter > 0 {
+			fmt.Printf("Jitter:        %v\n", p.Jitter)
+		}
+		if p.MaxElapsed > 0 {
+			fmt.Printf("Max elapsed:   %v\n", p.MaxElapsed)
+		}
+		if len(p.NonRetryable) != 0 {
+			fmt.Printf("Non-retryable: %s\n", strings.Join(p.NonRetryable, ", "))
+		}
+	}
 	if len(info.LastErr) != 0 {
 		fmt.Println()
 		bold.Println("Last Failure")
 		fmt.Printf("Failed at:     %s\n", formatPastTime(info.LastFailedAt))
 		fmt.Printf("Error message: %s\n", info.LastErr)
 	}
+	if len(info.Attempts) != 0 {
+		fmt.Println()
+		bold.Println("Attempts")
+		printTable(
+			[]string{"Started At", "Duration", "Server", "Outcome", "Panic", "Error"},
+			func(w io.Writer, tmpl string) {
+				for _, a := range info.Attempts {
+					// The server and start time of an attempt whose lease expired are unknown.
+					startedAt, server := "n/a", "n/a"
+					if !a.StartTime.IsZero() {
+						startedAt = a.StartTime.Format(time.UnixDate)
+					}
+					if a.ServerID != "" {
+						server = fmt.Sprintf("%s (%s:%d)", a.ServerID, a.Host, a.PID)
+					}
+					fmt.Fprintf(w, tmpl, startedAt, a.Duration.Round(time.Millisecond),
+						server, a.Outcome, a.Panic, a.ErrorMsg)
+				}
+			},
+		)
+	}
 }
 
 func formatNextProcessAt(processAt time.Time) string {
@@ -53,3 +53,10 @@ require (
 	gopkg.in/ini.v1 v1.51.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
+
+// The tools are built against the modules in this repository,
+// since they use APIs which are not in a tagged release yet.
+replace (
+	github.com/hibiken/asynq => ../
+	github.com/hibiken/asynq/x => ../x
+)
@@ -0,0 +1,141 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq_learn
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/log"
+)
+
+// waker wakes up the processor waiting for tasks when a task is enqueued
+// to one of the queues processed by the server.
+type waker struct {
+	logger *log.Logger
+	broker base.Broker
+
+	// queues to receive the wakeup notifications of.
+	queues *queueNames
+
+	// queuesChanged receives a value when the list of queues is replaced.
+	queuesChanged chan struct{}
+
+	// channel to wake up the processor, it should be buffered.
+	wakeupCh chan<- struct{}
+
+	// channel to communicate back to the long running "waker" goroutine.
+	done chan struct{}
+
+	// time to wait before retrying to connect to redis.
+	retryTimeout time.Duration
+}
+
+type wakerParams struct {
+	logger   *log.Logger
+	broker   base.Broker
+	queues   []string
+	wakeupCh chan<- struct{}
+}
+
+func newWaker(params wakerParams) *waker {
+	return &waker{
+		logger:        params.logger,
+		broker:        params.broker,
+		queues:        newQueueNames(params.queues),
+		queuesChanged: make(chan struct{}, 1),
+		wakeupCh:      params.wakeupCh,
+		done:          make(chan struct{}),
+		retryTimeout:  5 * time.Second,
+	}
+}
+
+func (w *waker) shutdown() {
+	w.logger.Debug("Waker shutting down...")
+	// Signal the waker goroutine to stop.
+	w.done <- struct{}{}
+}
+
+// setQueues replaces the queues to receive the wakeup notifications of.
+func (w *waker) setQueues(qnames []string) {
+	w.queues.set(qnames)
+	select {
+	case w.queuesChanged <- struct{}{}:
+	default:
+		// The waker has a pending change already.
+	}
+}
+
+func (w *waker) start(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// Try until successfully connect to the broker.
+		for {
+			sub, err := w.broker.SubscribeWakeup(w.queues.get()...)
+			if err != nil {
+				w.logger.Errorf("cannot subscribe to wakeup channels: %v", err)
+				if w.wait() {
+					continue
+				}
+				w.logger.Debug("Waker done")
+				return
+			}
+			resubscribe, changed := w.listen(sub)
+			if !resubscribe {
+				w.logger.Debug("Waker done")
+				return
+			}
+			if changed {
+				// Resubscribe to the new list of queues right away.
+				continue
+			}
+			// Subscription was lost; resubscribe after waiting.
+			if !w.wait() {
+				w.logger.Debug("Waker done")
+				return
+			}
+		}
+	}()
+}
+
+// listen wakes up the processor for each notification received on the subscription.
+// It reports whether the waker should resubscribe, which is the case when
+// the subscription ended before shutdown was requested, and whether it ended
+// because the list of queues was changed.
+func (w *waker) listen(sub base.WakeupSubscription) (resubscribe, changed bool) {
+	defer sub.Close()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-w.done:
+			return false, false
+		case <-w.queuesChanged:
+			return true, true
+		case _, ok := <-ch:
+			if !ok {
+				w.logger.Errorf("wakeup subscription closed: %v", sub.Err())
+				return true, false
+			}
+			select {
+			case w.wakeupCh <- struct{}{}:
+			default:
+				// The processor has a pending wakeup already.
+			}
+		}
+	}
+}
+
+// wait waits for the retry timeout and reports whether the waker
+// should keep running.
+func (w *waker) wait() bool {
+	select {
+	case <-time.After(w.retryTimeout):
+		return true
+	case <-w.done:
+		return false
+	}
+}
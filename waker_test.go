@@ -0,0 +1,96 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq_learn
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+	h "github.com/hibiken/asynq/internal/testutil"
+)
+
+func TestWaker(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	rdbClient := rdb.NewRDB(r)
+
+	wakeupCh := make(chan struct{}, 1)
+	w := newWaker(wakerParams{
+		logger:   testLogger,
+		broker:   rdbClient,
+		queues:   []string{base.DefaultQueueName},
+		wakeupCh: wakeupCh,
+	})
+	var wg sync.WaitGroup
+	w.start(&wg)
+	defer w.shutdown()
+
+	// wait for waker to establish connection to pubsub channel
+	time.Sleep(time.Second)
+
+	if err := rdbClient.Enqueue(context.Background(), h.NewTaskMessageWithQueue("task", nil, "low")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-wakeupCh:
+		t.Errorf("waker woke up processor for a task enqueued to another queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := rdbClient.Enqueue(context.Background(), h.NewTaskMessage("task", nil)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-wakeupCh:
+	case <-time.After(time.Second):
+		t.Errorf("waker did not wake up processor for a task enqueued to %q", base.DefaultQueueName)
+	}
+}
+
+func TestProcessorWithWakeup(t *testing.T) {
+	r := setup(t)
+	defer r.Close()
+	rdbClient := rdb.NewRDB(r)
+
+	wakeupCh := make(chan struct{}, 1)
+	w := newWaker(wakerParams{
+		logger:   testLogger,
+		broker:   rdbClient,
+		queues:   []string{base.DefaultQueueName},
+		wakeupCh: wakeupCh,
+	})
+	processedCh := make(chan time.Time, 1)
+	handler := func(ctx context.Context, task *Task) error {
+		processedCh <- time.Now()
+		return nil
+	}
+	p := newProcessorForTest(t, rdbClient, HandlerFunc(handler))
+	p.wakeupCh = wakeupCh
+
+	var wg sync.WaitGroup
+	w.start(&wg)
+	p.start(&wg)
+	// wait for the processor to find the queues empty.
+	time.Sleep(1500 * time.Millisecond)
+
+	enqueuedAt := time.Now()
+	if err := rdbClient.Enqueue(context.Background(), h.NewTaskMessage("task", nil)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case processedAt := <-processedCh:
+		if d := processedAt.Sub(enqueuedAt); d > 200*time.Millisecond {
+			t.Errorf("task was processed %v after it was enqueued, want it to be processed immediately", d)
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("task was not processed")
+	}
+	w.shutdown()
+	p.shutdown()
+}
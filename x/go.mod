@@ -7,4 +7,11 @@ require (
 	github.com/google/uuid v1.3.0
 	github.com/hibiken/asynq v0.21.0
 	github.com/prometheus/client_golang v1.11.0
+	go.opentelemetry.io/otel v1.10.0
+	go.opentelemetry.io/otel/sdk v1.10.0
+	go.opentelemetry.io/otel/trace v1.10.0
 )
+
+// The packages are built against the core module of this repository,
+// since they use APIs which are not in a tagged release yet.
+replace github.com/hibiken/asynq => ../
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"time"
+
+	asynq "github.com/hibiken/asynq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ProcessingMetricsCollector gathers metrics of the tasks processed by a Server.
+// It implements prometheus.Collector and asynq.ProcessingObserver interfaces.
+//
+// Unlike QueueMetricsCollector, metrics are reported by the server processing the tasks
+// instead of being read from redis, so each server exports the metrics of its own tasks.
+// Set the collector as Config.ProcessingObserver of the server to collect the metrics.
+type ProcessingMetricsCollector struct {
+	handlerDuration *prometheus.HistogramVec
+	queueWait       *prometheus.HistogramVec
+	outcomes        *prometheus.CounterVec
+}
+
+var _ asynq.ProcessingObserver = (*ProcessingMetricsCollector)(nil)
+
+// ProcessingMetricsOpts specifies the options for ProcessingMetricsCollector.
+type ProcessingMetricsOpts struct {
+	// HandlerDurationBuckets specifies the buckets of the handler duration histogram in seconds.
+	//
+	// If unset, prometheus.DefBuckets is used.
+	HandlerDurationBuckets []float64
+
+	// QueueWaitBuckets specifies the buckets of the queue wait time histogram in seconds.
+	//
+	// If unset, exponential buckets from 10ms to about 45min are used.
+	QueueWaitBuckets []float64
+}
+
+// NewProcessingMetricsCollector returns a collector that exports metrics about the processing of tasks.
+func NewProcessingMetricsCollector(opts ProcessingMetricsOpts) *ProcessingMetricsCollector {
+	handlerBuckets := opts.HandlerDurationBuckets
+	if len(handlerBuckets) == 0 {
+		handlerBuckets = prometheus.DefBuckets
+	}
+	waitBuckets := opts.QueueWaitBuckets
+	if len(waitBuckets) == 0 {
+		waitBuckets = prometheus.ExponentialBuckets(0.01, 4, 10)
+	}
+	return &ProcessingMetricsCollector{
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "task_handler_duration_seconds",
+			Help:      "Number of seconds Handler.ProcessTask took to process a task; broken down by queue and task type.",
+			Buckets:   handlerBuckets,
+		}, []string{"queue", "task_type"}),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "task_queue_wait_seconds",
+			Help:      "Number of seconds a task waited in pending state before being dequeued; broken down by queue and task type.",
+			Buckets:   waitBuckets,
+		}, []string{"queue", "task_type"}),
+		outcomes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "task_outcomes_total",
+			Help:      "Number of outcomes of processed tasks; broken down by queue, task type and outcome.",
+		}, []string{"queue", "task_type", "outcome"}),
+	}
+}
+
+func (pmc *ProcessingMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	pmc.handlerDuration.Describe(ch)
+	pmc.queueWait.Describe(ch)
+	pmc.outcomes.Describe(ch)
+}
+
+func (pmc *ProcessingMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	pmc.handlerDuration.Collect(ch)
+	pmc.queueWait.Collect(ch)
+	pmc.outcomes.Collect(ch)
+}
+
+// ObserveQueueWait records the time the task waited in the queue.
+func (pmc *ProcessingMetricsCollector) ObserveQueueWait(queue, typename string, d time.Duration) {
+	pmc.queueWait.WithLabelValues(queue, typename).Observe(d.Seconds())
+}
+
+// ObserveHandlerDuration records the time the Handler took to process the task.
+func (pmc *ProcessingMetricsCollector) ObserveHandlerDuration(queue, typename string, d time.Duration) {
+	pmc.handlerDuration.WithLabelValues(queue, typename).Observe(d.Seconds())
+}
+
+// ObserveOutcome counts the outcome of processing the task.
+func (pmc *ProcessingMetricsCollector) ObserveOutcome(queue, typename string, outcome asynq.TaskOutcome) {
+	pmc.outcomes.WithLabelValues(queue, typename, outcome.String()).Inc()
+}
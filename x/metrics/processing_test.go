@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	asynq "github.com/hibiken/asynq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProcessingMetricsCollector(t *testing.T) {
+	pmc := NewProcessingMetricsCollector(ProcessingMetricsOpts{})
+
+	pmc.ObserveQueueWait("default", "email:send", 2*time.Second)
+	pmc.ObserveHandlerDuration("default", "email:send", 300*time.Millisecond)
+	pmc.ObserveOutcome("default", "email:send", asynq.TaskOutcomeSuccess)
+	pmc.ObserveOutcome("default", "email:send", asynq.TaskOutcomeRetry)
+	pmc.ObserveOutcome("default", "email:send", asynq.TaskOutcomeRetry)
+
+	if n := testutil.CollectAndCount(pmc); n != 4 {
+		t.Errorf("collector exported %d metrics, want 4", n)
+	}
+	tests := []struct {
+		outcome asynq.TaskOutcome
+		want    float64
+	}{
+		{asynq.TaskOutcomeSuccess, 1},
+		{asynq.TaskOutcomeRetry, 2},
+		{asynq.TaskOutcomeArchive, 0},
+	}
+	for _, tc := range tests {
+		got := testutil.ToFloat64(pmc.outcomes.WithLabelValues("default", "email:send", tc.outcome.String()))
+		if got != tc.want {
+			t.Errorf("outcome %q counted %v times, want %v", tc.outcome, got, tc.want)
+		}
+	}
+}
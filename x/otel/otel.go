@@ -0,0 +1,189 @@
+// Package otel provides OpenTelemetry tracing for asynq tasks.
+//
+// Client injects W3C trace context into the headers of the tasks it enqueues, and
+// the middleware returned by NewMiddleware starts a span for each task processed by
+// a Handler, so that a trace can be followed from the producer into the worker.
+package otel
+
+import (
+	"context"
+	"errors"
+
+	asynq "github.com/hibiken/asynq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer used to create spans.
+const instrumentationName = "github.com/hibiken/asynq/x/otel"
+
+// Attribute keys recorded on spans.
+const (
+	QueueKey      = attribute.Key("asynq.queue")
+	TaskIDKey     = attribute.Key("asynq.task.id")
+	TaskTypeKey   = attribute.Key("asynq.task.type")
+	RetryCountKey = attribute.Key("asynq.task.retry_count")
+	MaxRetryKey   = attribute.Key("asynq.task.max_retry")
+	OutcomeKey    = attribute.Key("asynq.outcome")
+)
+
+// Outcomes of a task processed by a Handler, recorded with OutcomeKey.
+const (
+	OutcomeSuccess   = "success"    // task was processed successfully
+	OutcomeRetry     = "retry"      // task failed and will be retried
+	OutcomeArchived  = "archived"   // task failed and exhausted its retries
+	OutcomeSkipRetry = "skip_retry" // task failed with SkipRetry and will be archived
+)
+
+type config struct {
+	tp         trace.TracerProvider
+	propagator propagation.TextMapPropagator
+}
+
+// Option configures the tracing.
+type Option func(*config)
+
+// WithTracerProvider specifies the tracer provider used to create spans.
+// If not specified, the global tracer provider is used.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) { c.tp = tp }
+}
+
+// WithPropagator specifies the propagator used to inject and extract trace context.
+// If not specified, W3C trace context propagator is used.
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(c *config) { c.propagator = p }
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		tp:         otel.GetTracerProvider(),
+		propagator: propagation.TraceContext{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Inject returns an asynq.Option which carries the trace context in ctx as task headers.
+//
+// It can be used with any method enqueueing tasks, e.g. asynq.Client.EnqueueBatch or
+// asynq.Scheduler.Register, when the tasks are not enqueued through Client.
+func Inject(ctx context.Context, opts ...Option) asynq.Option {
+	c := newConfig(opts)
+	carrier := make(propagation.MapCarrier)
+	c.propagator.Inject(ctx, carrier)
+	return asynq.Headers(carrier)
+}
+
+// Client wraps asynq.Client to create a producer span for each enqueue operation
+// and inject its trace context into the task headers.
+type Client struct {
+	client     *asynq.Client
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewClient returns a new Client which enqueues tasks using the given client.
+func NewClient(client *asynq.Client, opts ...Option) *Client {
+	c := newConfig(opts)
+	return &Client{
+		client:     client,
+		tracer:     c.tp.Tracer(instrumentationName),
+		propagator: c.propagator,
+	}
+}
+
+// Enqueue enqueues the given task to a queue.
+//
+// See asynq.Client.Enqueue for details.
+func (c *Client) Enqueue(task *asynq.Task, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	return c.EnqueueContext(context.Background(), task, opts...)
+}
+
+// EnqueueContext enqueues the given task to a queue in a producer span,
+// which is a child of the span in ctx if any.
+//
+// See asynq.Client.EnqueueContext for details.
+func (c *Client) EnqueueContext(ctx context.Context, task *asynq.Task, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	ctx, span := c.tracer.Start(ctx, task.Type()+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(TaskTypeKey.String(task.Type())))
+	defer span.End()
+
+	carrier := make(propagation.MapCarrier)
+	c.propagator.Inject(ctx, carrier)
+	// Trace context is appended last so that it takes precedence over the headers in opts.
+	info, err := c.client.EnqueueContext(ctx, task, append(opts, asynq.Headers(carrier))...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(QueueKey.String(info.Queue), TaskIDKey.String(info.ID))
+	return info, nil
+}
+
+// Close closes the underlying asynq.Client.
+func (c *Client) Close() error {
+	return c.client.Close()
+}
+
+// NewMiddleware returns a middleware which starts a consumer span for each
+// invocation of Handler.ProcessTask.
+//
+// The span is a child of, and linked to, the producer span whose trace context is
+// carried in the task headers. It records the queue, task type, retry count and
+// the outcome of the processing.
+func NewMiddleware(opts ...Option) asynq.MiddlewareFunc {
+	c := newConfig(opts)
+	tracer := c.tp.Tracer(instrumentationName)
+	return func(h asynq.Handler) asynq.Handler {
+		return asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+			producerCtx := c.propagator.Extract(ctx, propagation.MapCarrier(task.Headers()))
+			attrs := []attribute.KeyValue{TaskTypeKey.String(task.Type())}
+			if qname, ok := asynq.GetQueueName(ctx); ok {
+				attrs = append(attrs, QueueKey.String(qname))
+			}
+			if id, ok := asynq.GetTaskID(ctx); ok {
+				attrs = append(attrs, TaskIDKey.String(id))
+			}
+			retried, _ := asynq.GetRetryCount(ctx)
+			maxRetry, hasMaxRetry := asynq.GetMaxRetry(ctx)
+			attrs = append(attrs, RetryCountKey.Int(retried))
+			if hasMaxRetry {
+				attrs = append(attrs, MaxRetryKey.Int(maxRetry))
+			}
+
+			startOpts := []trace.SpanStartOption{
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(attrs...),
+			}
+			if trace.SpanContextFromContext(producerCtx).IsValid() {
+				startOpts = append(startOpts, trace.WithLinks(trace.LinkFromContext(producerCtx)))
+			}
+			ctx, span := tracer.Start(producerCtx, task.Type()+" process", startOpts...)
+			defer span.End()
+
+			err := h.ProcessTask(ctx, task)
+			switch {
+			case err == nil:
+				span.SetAttributes(OutcomeKey.String(OutcomeSuccess))
+				return nil
+			case errors.Is(err, asynq.SkipRetry):
+				span.SetAttributes(OutcomeKey.String(OutcomeSkipRetry))
+			case hasMaxRetry && retried >= maxRetry:
+				span.SetAttributes(OutcomeKey.String(OutcomeArchived))
+			default:
+				span.SetAttributes(OutcomeKey.String(OutcomeRetry))
+			}
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		})
+	}
+}
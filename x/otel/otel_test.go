@@ -0,0 +1,70 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	asynq "github.com/hibiken/asynq"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		desc        string
+		err         error
+		wantOutcome string
+	}{
+		{"success", nil, OutcomeSuccess},
+		{"failure", errors.New("failed"), OutcomeRetry},
+		{"skip retry", fmt.Errorf("bad input: %w", asynq.SkipRetry), OutcomeSkipRetry},
+	}
+
+	for _, tc := range tests {
+		sr := tracetest.NewSpanRecorder()
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+		// Inject the trace context of a producer span into the task headers.
+		ctx, producer := tp.Tracer("test").Start(context.Background(), "producer")
+		headers := Inject(ctx).Value().(map[string]string)
+		producer.End()
+
+		task := asynq.NewTaskWithHeaders("send_email", nil, headers)
+		h := NewMiddleware(WithTracerProvider(tp))(asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+			if !trace.SpanContextFromContext(ctx).IsValid() {
+				t.Errorf("%s: Handler got context without span", tc.desc)
+			}
+			return tc.err
+		}))
+		if err := h.ProcessTask(context.Background(), task); err != tc.err {
+			t.Errorf("%s: ProcessTask returned %v, want %v", tc.desc, err, tc.err)
+		}
+
+		spans := sr.Ended()
+		if len(spans) != 2 {
+			t.Fatalf("%s: got %d ended spans, want 2", tc.desc, len(spans))
+		}
+		span := spans[1]
+		if span.Name() != "send_email process" || span.SpanKind() != trace.SpanKindConsumer {
+			t.Errorf("%s: got span %q of kind %v, want %q of kind %v",
+				tc.desc, span.Name(), span.SpanKind(), "send_email process", trace.SpanKindConsumer)
+		}
+		if span.Parent().SpanID() != producer.SpanContext().SpanID() {
+			t.Errorf("%s: span parent is %v, want the producer span %v", tc.desc, span.Parent().SpanID(), producer.SpanContext().SpanID())
+		}
+		if links := span.Links(); len(links) != 1 || links[0].SpanContext.SpanID() != producer.SpanContext().SpanID() {
+			t.Errorf("%s: span links are %v, want a link to the producer span", tc.desc, links)
+		}
+		attrs := attribute.NewSet(span.Attributes()...)
+		if v, _ := attrs.Value(OutcomeKey); v.AsString() != tc.wantOutcome {
+			t.Errorf("%s: span has outcome %q, want %q", tc.desc, v.AsString(), tc.wantOutcome)
+		}
+		if v, _ := attrs.Value(TaskTypeKey); v.AsString() != "send_email" {
+			t.Errorf("%s: span has task type %q, want %q", tc.desc, v.AsString(), "send_email")
+		}
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/hibiken/asynq"
+	asynq "github.com/hibiken/asynq"
 	"github.com/hibiken/asynq/x/rate"
 )
 
@@ -0,0 +1,110 @@
+package rate
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	asynq "github.com/hibiken/asynq"
+)
+
+// NewTypeSemaphore creates a TypeSemaphore with the given maximum number of tokens for each task type.
+// Task types which are not in limits are not limited.
+func NewTypeSemaphore(rco asynq.RedisConnOpt, limits map[string]int) *TypeSemaphore {
+	rc, ok := rco.MakeRedisClient().(redis.UniversalClient)
+	if !ok {
+		panic(fmt.Sprintf("rate.NewTypeSemaphore: unsupported RedisConnOpt type %T", rco))
+	}
+
+	semas := make(map[string]*Semaphore, len(limits))
+	for typename, maxTokens := range limits {
+		if len(strings.TrimSpace(typename)) == 0 {
+			panic("rate.NewTypeSemaphore: task type should not be empty")
+		}
+		if maxTokens < 1 {
+			panic(fmt.Sprintf("rate.NewTypeSemaphore: maxTokens of task type %q cannot be less than 1", typename))
+		}
+		semas[typename] = &Semaphore{
+			rc:        rc,
+			scope:     typeScope(typename),
+			maxTokens: maxTokens,
+		}
+	}
+
+	return &TypeSemaphore{
+		rc:    rc,
+		semas: semas,
+	}
+}
+
+// TypeSemaphore is a set of distributed counting semaphores, one for each limited task type,
+// which can be used to limit the number of tasks of a type processed across multiple asynq_learn servers.
+//
+// TypeSemaphore implements asynq_learn.TypeConcurrencyLimiter, set it as Config.TypeConcurrencyLimiter
+// of the servers so that the tasks of a type are left in the queue while all tokens of the type are taken.
+type TypeSemaphore struct {
+	rc    redis.UniversalClient
+	semas map[string]*Semaphore
+}
+
+var _ asynq.TypeConcurrencyLimiter = (*TypeSemaphore)(nil)
+
+// Acquire attempts to acquire a token from the semaphore of the given task type.
+// It always succeeds if the task type is not limited.
+//
+// See Semaphore.Acquire for the requirements on the context.
+func (ts *TypeSemaphore) Acquire(ctx context.Context, typename string) (bool, error) {
+	s, ok := ts.semas[typename]
+	if !ok {
+		return true, nil
+	}
+	return s.Acquire(ctx)
+}
+
+// Release will release the token on the semaphore of the given task type.
+func (ts *TypeSemaphore) Release(ctx context.Context, typename string) error {
+	s, ok := ts.semas[typename]
+	if !ok {
+		return nil
+	}
+	return s.Release(ctx)
+}
+
+// Saturated returns the limited task types whose tokens are all taken.
+// The token counts of all the types are read in a single pipelined call.
+func (ts *TypeSemaphore) Saturated(ctx context.Context) ([]string, error) {
+	if len(ts.semas) == 0 {
+		return nil, nil
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	counts := make(map[string]*redis.IntCmd, len(ts.semas))
+	_, err := ts.rc.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for typename, s := range ts.semas {
+			// Tokens whose deadline has passed are not counted, see acquireCmd.
+			counts[typename] = pipe.ZCount(ctx, semaphoreKey(s.scope), now, "+inf")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("redis command failed: %v", err)
+	}
+	var types []string
+	for typename, cmd := range counts {
+		if cmd.Val() >= int64(ts.semas[typename].maxTokens) {
+			types = append(types, typename)
+		}
+	}
+	return types, nil
+}
+
+// Close closes the connection to redis.
+func (ts *TypeSemaphore) Close() error {
+	return ts.rc.Close()
+}
+
+func typeScope(typename string) string {
+	return "type:" + typename
+}
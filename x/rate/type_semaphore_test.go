@@ -0,0 +1,76 @@
+package rate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq/internal/base"
+	asynqcontext "github.com/hibiken/asynq/internal/context"
+)
+
+func TestTypeSemaphore(t *testing.T) {
+	opt := getRedisConnOpt(t)
+	rc := opt.MakeRedisClient().(redis.UniversalClient)
+	defer rc.Close()
+	if err := rc.Del(context.Background(), semaphoreKey(typeScope("send_email"))).Err(); err != nil {
+		t.Fatalf("redis.UniversalClient.Del() got error %v", err)
+	}
+
+	sema := NewTypeSemaphore(opt, map[string]int{"send_email": 1})
+	defer sema.Close()
+
+	newCtx := func(typename string) (context.Context, context.CancelFunc) {
+		msg := &base.TaskMessage{ID: uuid.NewString(), Type: typename, Queue: "default"}
+		return asynqcontext.New(context.Background(), msg, time.Now().Add(time.Minute))
+	}
+
+	ctx1, cancel1 := newCtx("send_email")
+	defer cancel1()
+	ctx2, cancel2 := newCtx("send_email")
+	defer cancel2()
+	ctx3, cancel3 := newCtx("export_csv")
+	defer cancel3()
+
+	tests := []struct {
+		desc     string
+		ctx      context.Context
+		typename string
+		want     bool
+	}{
+		{"first task of limited type", ctx1, "send_email", true},
+		{"second task of limited type", ctx2, "send_email", false},
+		{"task of unlimited type", ctx3, "export_csv", true},
+	}
+	for _, tc := range tests {
+		got, err := sema.Acquire(tc.ctx, tc.typename)
+		if err != nil {
+			t.Fatalf("%s;\nTypeSemaphore.Acquire() got error %v", tc.desc, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s;\nTypeSemaphore.Acquire() returned %v, want %v", tc.desc, got, tc.want)
+		}
+	}
+
+	if got, err := sema.Saturated(context.Background()); err != nil || len(got) != 1 || got[0] != "send_email" {
+		t.Errorf("TypeSemaphore.Saturated() returned %v, %v; want [send_email], nil", got, err)
+	}
+
+	if err := sema.Release(ctx1, "send_email"); err != nil {
+		t.Errorf("TypeSemaphore.Release() got error %v", err)
+	}
+	if err := sema.Release(ctx3, "export_csv"); err != nil {
+		t.Errorf("TypeSemaphore.Release() of unlimited type got error %v", err)
+	}
+	if got, err := sema.Acquire(ctx2, "send_email"); err != nil || !got {
+		t.Errorf("TypeSemaphore.Acquire() after release returned %v, %v; want true, nil", got, err)
+	}
+	if err := sema.Release(ctx2, "send_email"); err != nil {
+		t.Errorf("TypeSemaphore.Release() got error %v", err)
+	}
+	if got, err := sema.Saturated(context.Background()); err != nil || len(got) != 0 {
+		t.Errorf("TypeSemaphore.Saturated() after release returned %v, %v; want no types, nil", got, err)
+	}
+}